@@ -0,0 +1,92 @@
+package autosqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// attachedName is the schema name the old database is attached as for copies
+// that run entirely inside SQLite.
+const attachedName = "autosqlite_old"
+
+// attachDatabase attaches the database file filename to a connection of db as
+// attachedName. ATTACH applies to a single connection, so the copy must use the
+// returned one; release detaches the file and returns the connection to the
+// pool.
+func attachDatabase(ctx context.Context, db *sql.DB, filename string) (conn *sql.Conn, release func(), err error) {
+	conn, err = db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+attachedName, filename); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to attach old database: %w", err)
+	}
+	return conn, func() {
+		conn.ExecContext(ctx, "DETACH DATABASE "+attachedName)
+		conn.Close()
+	}, nil
+}
+
+// copiesAttached reports whether a table whose old database is the file
+// oldFile, and whose new columns are filled in by backfills, is copied by
+// writeAttached rather than row by row through Go. That needs a file to attach,
+// and rows that can be copied without looking at each one: OnConflict has to
+// see every conflicting row, and a Backfills expression could refer to other
+// tables, which would be those of the new database once both are attached.
+func (opts *Options) copiesAttached(oldFile string, backfills map[string]string) bool {
+	return !isMemoryDatabase(oldFile) && opts.OnConflict == nil && len(backfills) == 0
+}
+
+// writeAttached copies the rows of the old table into the new table with a
+// single INSERT ... SELECT on newDB, with the old database file oldFile
+// attached. Values go straight from one file to the other inside SQLite, so
+// however large they are, they are never held in memory in Go. It returns the
+// number of rows copied and the number dropped because of a conflict, as write
+// does. Once ctx is done, SQLite is interrupted and the copy rolled back.
+func (c *tableCopy) writeAttached(ctx context.Context, newDB *sql.DB, oldFile string, policy ConflictPolicy) (copied, skipped int64, err error) {
+	conn, release, err := attachDatabase(ctx, newDB, oldFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer release()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// Rows lost to IGNORE or REPLACE show up as a shortfall in the row count
+	countQuery := "SELECT COUNT(*) FROM main." + c.newTable
+	var before, read int64
+	if policy != ConflictAbort {
+		if err := tx.QueryRowContext(ctx, countQuery).Scan(&before); err != nil {
+			return 0, 0, err
+		}
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+attachedName+"."+c.oldTable).Scan(&read); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf("%s INTO main.%s (%s) SELECT %s FROM %s.%s", policy.insertVerb(),
+		c.newTable, strings.Join(c.insertColumns, ", "), strings.Join(c.selectColumns, ", "), attachedName, c.oldTable))
+	if err != nil {
+		return 0, 0, err
+	}
+	if copied, err = res.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	if policy != ConflictAbort {
+		var after int64
+		if err := tx.QueryRowContext(ctx, countQuery).Scan(&after); err != nil {
+			return 0, 0, err
+		}
+		copied = after - before
+		skipped = max(read-copied, 0)
+	}
+	return copied, skipped, tx.Commit()
+}
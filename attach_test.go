@@ -0,0 +1,84 @@
+package autosqlite
+
+import (
+	"bytes"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCopyLargeBlobs(t *testing.T) {
+	const blobs, size = 5, 4 << 20
+
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.db")
+	db, err := Open(`CREATE TABLE media (id INTEGER PRIMARY KEY, name TEXT, data BLOB);`, oldPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	for i := 0; i < blobs; i++ {
+		if _, err := db.Exec("INSERT INTO media (name, data) VALUES (?, zeroblob(?))", "clip", size); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if _, err := db.Exec("UPDATE media SET data = randomblob(?) WHERE id = 1", size); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	var first []byte
+	if err := db.QueryRow("SELECT data FROM media WHERE id = 1").Scan(&first); err != nil {
+		t.Fatalf("failed to read blob: %v", err)
+	}
+	db.Close()
+
+	// The blobs are copied inside SQLite, so hardly any Go memory is allocated
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	newDB, err := MigrateToNewFile(`CREATE TABLE media (id INTEGER PRIMARY KEY, name TEXT, data BLOB, mime TEXT);`,
+		oldPath, filepath.Join(dir, "new.db"))
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer newDB.Close()
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size {
+		t.Errorf("expected the blobs not to be buffered in Go, but %d bytes were allocated", allocated)
+	}
+
+	var count int
+	var copied []byte
+	if err := newDB.QueryRow("SELECT COUNT(*) FROM media WHERE length(data) >= ?", size).Scan(&count); err != nil || count != blobs {
+		t.Errorf("expected %d blobs, got %d, %v", blobs, count, err)
+	}
+	if err := newDB.QueryRow("SELECT data FROM media WHERE id = 1").Scan(&copied); err != nil || !bytes.Equal(copied, first) {
+		t.Errorf("expected the blob to be copied exactly, got %d bytes, %v", len(copied), err)
+	}
+}
+
+func TestCopyAttachedConflicts(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (email) VALUES ('a'), ('b'), ('a')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	var report MigrationReport
+	opts := DefaultOptions()
+	opts.ConflictPolicy = ConflictReplace
+	opts.Report = &report
+	db, err = OpenWithOptions(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE);`, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+	if report.Stats.RowsCopied != 2 || report.Stats.RowsSkipped != 1 {
+		t.Errorf("expected 2 rows copied and 1 skipped, got %+v", report.Stats)
+	}
+	var id int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = 'a'").Scan(&id); err != nil || id != 3 {
+		t.Errorf("expected the last 'a' to be kept, got %d, %v", id, err)
+	}
+}
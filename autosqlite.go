@@ -35,14 +35,21 @@
 package autosqlite
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gofrs/flock"
 	_ "github.com/mattn/go-sqlite3"
@@ -52,7 +59,12 @@ import (
 type SchemaVersion struct {
 	Version   int    // Numeric version (optional, for explicit versioning)
 	Hash      string // SHA256 hash of the schema
-	Timestamp string // When this version was applied
+	Timestamp string // When this version was applied, in RFC 3339 format (see AppliedAt)
+	SchemaSQL string // Schema text as applied (only filled in by AppliedSchemas)
+
+	// Description is the text of the schema's "-- autosqlite:description"
+	// directive, if it had one (only filled in by AppliedSchemas)
+	Description string
 }
 
 // ColumnInfo represents detailed information about a database column
@@ -66,6 +78,59 @@ type ColumnInfo struct {
 
 const versionTableName = "_autosqlite_version"
 
+// ErrBackupExists is returned by Migrate when the backup file already exists and
+// Options.BackupMode is BackupFail.
+var ErrBackupExists = errors.New("backup file already exists")
+
+// ErrVersionMismatch is returned by Migrate when the database is not at
+// Options.ExpectedFromVersion.
+var ErrVersionMismatch = errors.New("database is not at the expected schema version")
+
+// ErrReadOnly is returned when Options.ReadOnly is set and the database would
+// have to be created or migrated.
+var ErrReadOnly = errors.New("database is read-only")
+
+// ErrConstraintViolation is returned when the new schema adds a NOT NULL column
+// without a DEFAULT to a table that has rows, since none of them could be copied.
+var ErrConstraintViolation = errors.New("new NOT NULL column has no DEFAULT")
+
+// ErrEmptySchema is returned by Migrate when the new schema defines no tables
+// but the database has some, which would drop them all, unless
+// Options.AllowEmptySchema is set. A schema file that is empty by mistake, for
+// example because embedding it failed, is then an error rather than data loss.
+var ErrEmptySchema = errors.New("schema defines no tables")
+
+// Phases of a migration, as reported in MigrationError.Phase.
+const (
+	PhaseBackup        = "backup"         // Backing up the database before migrating
+	PhaseSchema        = "schema"         // Executing the new schema
+	PhaseDataCopy      = "data copy"      // Copying data from the old database
+	PhasePostMigrate   = "post-migrate"   // Running Options.PostMigrate steps
+	PhaseAnalyze       = "analyze"        // Running ANALYZE on the new database
+	PhaseReplace       = "replace"        // Renaming the new database over the old one
+	PhaseVersionRecord = "version record" // Copying or recording schema versions
+)
+
+// MigrationError is returned when a step of a migration fails. It says which
+// phase failed and, for the data copy, which table was being copied, so that a
+// caller can decide for example to retry only transient phases.
+type MigrationError struct {
+	Phase string // One of the Phase constants
+	Table string // Table being migrated, or "" if the failure isn't specific to a table
+	Err   error  // Underlying error
+}
+
+func (e *MigrationError) Error() string {
+	if e.Table != "" {
+		return fmt.Sprintf("migration failed during %s of table %s: %v", e.Phase, e.Table, e.Err)
+	}
+	return fmt.Sprintf("migration failed during %s: %v", e.Phase, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
 // extractFilenameFromConnectionString extracts the filename part from a SQLite connection string,
 // removing any query parameters. For example, "foo.db?_busy_timeout=1000" becomes "foo.db".
 func extractFilenameFromConnectionString(connectionString string) string {
@@ -77,34 +142,15 @@ func extractFilenameFromConnectionString(connectionString string) string {
 	return connectionString
 }
 
-// openTemporaryDB creates a temporary SQLite database file, immediately unlinks it,
-// and returns a database handle. This ensures all connections to the same handle
-// share the same database, unlike :memory: which creates separate databases per connection.
+// openTemporaryDB returns a handle to an empty in-memory database that lives
+// until the handle is closed. Nothing is written to disk.
 func openTemporaryDB() (*sql.DB, error) {
-	// Create a temporary file
-	tmpFile, err := os.CreateTemp("", "autosqlite_*.db")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temporary file: %w", err)
-	}
-
-	// Close the file handle so SQLite can use it
-	tmpFile.Close()
-
-	// Get the file path
-	tmpPath := tmpFile.Name()
-
-	// Open the database using the unlinked file
-	db, err := sql.Open("sqlite3", tmpPath)
+	db, err := sql.Open("sqlite3", ":memory:")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open temporary database: %w", err)
 	}
-
-	// Immediately unlink the file so it gets cleaned up when the process exits
-	// SQLite will still be able to use it as long as we keep the handle open
-	if err := os.Remove(tmpPath); err != nil {
-		return nil, fmt.Errorf("failed to unlink temporary file: %w", err)
-	}
-
+	// Every connection to :memory: is a separate database, so keep to one
+	db.SetMaxOpenConns(1)
 	return db, nil
 }
 
@@ -113,70 +159,267 @@ func openTemporaryDB() (*sql.DB, error) {
 // the database is opened as-is. If the schema has changed, a migration is performed and
 // the previous database file is backed up with a ".backup" extension.
 //
+// Creation and migration both hold a lock on dbPath + ".migration.lock", so several
+// processes can safely call Open on the same database at the same time.
+//
 // The dbPath parameter can include SQLite query parameters (e.g., "foo.db?_busy_timeout=1000").
 // File operations will use only the filename part, while database connections will use the full string.
 //
 // Returns a *sql.DB handle or an error.
 func Open(schema, dbPath string) (*sql.DB, error) {
+	return OpenWithOptions(schema, dbPath, nil)
+}
+
+// OpenWithOptions is like Open but takes Options controlling the migration.
+// A nil opts is the same as DefaultOptions().
+func OpenWithOptions(schema, dbPath string, opts *Options) (*sql.DB, error) {
+	db, _, err := OpenWithResultOptions(schema, dbPath, opts)
+	return db, err
+}
+
+// Result says what Open did to the database.
+type Result int
+
+const (
+	Created   Result = iota // The database did not exist and was created
+	Migrated                // The database was migrated to a new schema
+	Unchanged               // The database already had the schema and was opened as-is
+)
+
+// String returns the name of r, e.g. "created".
+func (r Result) String() string {
+	switch r {
+	case Created:
+		return "created"
+	case Migrated:
+		return "migrated"
+	case Unchanged:
+		return "unchanged"
+	}
+	return fmt.Sprintf("Result(%d)", int(r))
+}
+
+// OpenWithResult is like Open but also reports whether the database was created,
+// migrated or opened unchanged, for example to run first-run setup only when
+// the database is new.
+func OpenWithResult(schema, dbPath string) (*sql.DB, Result, error) {
+	return OpenWithResultOptions(schema, dbPath, nil)
+}
+
+// OpenWithResultOptions is like OpenWithResult but takes Options controlling the
+// migration. A nil opts is the same as DefaultOptions().
+func OpenWithResultOptions(schema, dbPath string, opts *Options) (*sql.DB, Result, error) {
+	opts = resolveOptions(opts)
+	opts.Report.reset()
+	db, result, err := openWithResult(schema, dbPath, opts)
+	if err != nil || result == Migrated {
+		// A migration records its versions itself
+		return db, result, err
+	}
+	from := -1
+	if result == Created {
+		from = 0
+	}
+	if err := opts.Report.setVersions(db, from); err != nil {
+		db.Close()
+		return nil, 0, err
+	}
+	return db, result, nil
+}
+
+// openWithResult does the work of OpenWithResultOptions.
+func openWithResult(schema, dbPath string, opts *Options) (*sql.DB, Result, error) {
+	if opts.ReadOnly {
+		return openReadOnly(schema, dbPath, opts)
+	}
+
 	// Extract filename for file operations
 	filename := extractFilenameFromConnectionString(dbPath)
 
 	if _, err := os.Stat(filename); err == nil {
-		if SchemasEqual(schema, dbPath) {
-			db, err := sql.Open("sqlite3", dbPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to open existing database: %w", err)
-			}
-			return db, nil
+		return openExisting(schema, dbPath, opts)
+	}
+
+	dbDir := filepath.Dir(filename)
+	if !opts.NoCreateDirs {
+		mode := opts.DirMode
+		if mode == 0 {
+			mode = 0755
+		}
+		if err := os.MkdirAll(dbDir, mode); err != nil {
+			return nil, 0, fmt.Errorf("failed to create database directory: %w", err)
 		}
+	} else if info, err := os.Stat(dbDir); err != nil {
+		return nil, 0, fmt.Errorf("database directory must already exist: %w", err)
+	} else if !info.IsDir() {
+		return nil, 0, fmt.Errorf("database directory %s is not a directory", dbDir)
+	}
 
-		// Check if this would be a backward migration
-		db, err := sql.Open("sqlite3", dbPath)
+	// Hold the migration lock while creating the database, so that two processes
+	// starting at the same time don't both create it and execute the schema
+	if !isMemoryDatabase(filename) {
+		unlock, err := acquireMigrationLock(filename)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open database for version check: %w", err)
+			return nil, 0, err
 		}
-		defer db.Close()
+		defer unlock()
 
-		isForward, err := isForwardMigration(db, schema)
-		if err != nil {
-			return nil, fmt.Errorf("failed to check migration direction: %w", err)
+		// Another process may have created the database while we waited
+		if _, err := os.Stat(filename); err == nil {
+			unlock()
+			return openExisting(schema, dbPath, opts)
 		}
+	}
+
+	db, err := createDatabase(schema, dbPath, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return db, Created, nil
+}
+
+// openReadOnly opens the database at dbPath read-only, provided it already has
+// the schema.
+func openReadOnly(schema, dbPath string, opts *Options) (*sql.DB, Result, error) {
+	filename := extractFilenameFromConnectionString(dbPath)
+	if _, err := os.Stat(filename); err != nil {
+		return nil, 0, fmt.Errorf("%w: cannot create database: %w", ErrReadOnly, err)
+	}
+	if !SchemasEqualWithOptions(schema, dbPath, opts) {
+		return nil, 0, fmt.Errorf("%w: %s needs to be migrated", ErrReadOnly, filename)
+	}
+
+	db, err := openDB(readOnlyDSN(dbPath), opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open existing database: %w", err)
+	}
+	return db, Unchanged, nil
+}
 
-		if !isForward {
-			return nil, fmt.Errorf("backward migration detected: this is not allowed to prevent data loss. If you need to downgrade, clear out the _autosqlite_version table")
+// readOnlyDSN returns a connection string that opens dbPath with mode=ro, so that
+// SQLite never writes to the database or creates a journal next to it.
+func readOnlyDSN(dbPath string) string {
+	if strings.HasPrefix(dbPath, "file:") {
+		if strings.Contains(dbPath, "?") {
+			return dbPath + "&mode=ro"
 		}
+		return dbPath + "?mode=ro"
+	}
 
-		return Migrate(schema, dbPath)
+	// Query parameters are only passed on for URI filenames, in which
+	// '%', '?' and '#' in the path itself must be escaped
+	filename, query, _ := strings.Cut(dbPath, "?")
+	filename = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(filename)
+	if query != "" {
+		return "file:" + filename + "?" + query + "&mode=ro"
 	}
+	return "file:" + filename + "?mode=ro"
+}
 
-	dbDir := filepath.Dir(filename)
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create database directory: %w", err)
+// openExisting opens the existing database at dbPath, migrating it to schema if
+// the schema has changed.
+func openExisting(schema, dbPath string, opts *Options) (*sql.DB, Result, error) {
+	if SchemasEqualWithOptions(schema, dbPath, opts) {
+		db, err := openDB(dbPath, opts)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to open existing database: %w", err)
+		}
+		// A version table written by an older version of this package may lack
+		// columns that later writes need
+		if err := retry(opts.retryPolicy(), isBusy, func() error {
+			exists, err := versionTableExists(db)
+			if err != nil || !exists {
+				return err
+			}
+			return upgradeVersionTable(db)
+		}); err != nil {
+			db.Close()
+			return nil, 0, fmt.Errorf("failed to upgrade version table: %w", err)
+		}
+		return db, Unchanged, nil
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	// Check if this would be a backward migration
+	db, err := openDB(dbPath, opts)
 	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open database for version check: %w", err)
+	}
+	defer db.Close()
+
+	if err := checkEmptySchema(db, schema, opts); err != nil {
+		return nil, 0, err
+	}
+	isForward, err := isForwardMigration(db, schema)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to check migration direction: %w", err)
+	}
+
+	if !isForward && !opts.AllowBackward {
+		return nil, 0, fmt.Errorf("backward migration detected: this is not allowed to prevent data loss. If you need to downgrade, clear the version history with ClearVersionHistory")
+	}
+
+	return migrate(schema, dbPath, opts)
+}
+
+// createDatabase creates a new database at dbPath with the given schema and
+// records it as version 1. If that fails, the new file is removed, so that it
+// isn't taken for an existing database, and no plaintext copy of a database
+// meant to be encrypted is left behind. The caller holds the migration lock.
+func createDatabase(schema, dbPath string, opts *Options) (*sql.DB, error) {
+	// Create the file ourselves so it never exists with wider permissions;
+	// SQLite is happy to use an empty file as a new database
+	filename := extractFilenameFromConnectionString(dbPath)
+	if opts.FileMode != 0 && !isMemoryDatabase(filename) {
+		if err := createFileWithMode(filename, opts.FileMode); err != nil {
+			return nil, fmt.Errorf("failed to create database file: %w", err)
+		}
+	}
+	discard := func(db *sql.DB) {
+		if db != nil {
+			db.Close()
+		}
+		if !isMemoryDatabase(filename) {
+			removeDatabaseFiles(filename)
+		}
+	}
+
+	db, err := openDB(dbPath, opts)
+	if err != nil {
+		discard(nil)
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	if isMemoryDatabase(filename) {
+		// Every connection to :memory: is a separate database
+		db.SetMaxOpenConns(1)
+	}
 
-	if err := db.Ping(); err != nil {
-		db.Close()
+	// Slow or network filesystems can fail a first ping spuriously
+	if err := retry(opts.retryPolicy(), anyError, db.Ping); err != nil {
+		discard(db)
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	if _, err := db.Exec(schema); err != nil {
-		db.Close()
+	if err := retry(opts.retryPolicy(), isBusy, func() error {
+		return execSchema(db, schema)
+	}); err != nil {
+		discard(db)
 		return nil, fmt.Errorf("failed to execute schema: %w", err)
 	}
+	if err := checkEncrypted(filename, opts); err != nil {
+		discard(db)
+		return nil, err
+	}
 
 	// Record the initial schema version
 	version := &SchemaVersion{
-		Version: 1,
-		Hash:    calculateSchemaHash(schema),
+		Version:     1,
+		Hash:        calculateSchemaHash(schema),
+		Timestamp:   opts.timestamp(),
+		Description: schemaDescription(schema),
 	}
 
-	if err := recordSchemaVersion(db, version, schema); err != nil {
-		db.Close()
+	if err := recordSchemaVersion(db, version, schema, opts.SchemaStorage); err != nil {
+		discard(db)
 		return nil, fmt.Errorf("failed to record schema version: %w", err)
 	}
 
@@ -192,68 +435,479 @@ func Open(schema, dbPath string) (*sql.DB, error) {
 //
 // Returns a *sql.DB handle or an error.
 func Migrate(schema, dbPath string) (*sql.DB, error) {
+	return MigrateWithOptions(schema, dbPath, nil)
+}
+
+// MigrateWithOptions is like Migrate but takes Options controlling the migration.
+// A nil opts is the same as DefaultOptions().
+func MigrateWithOptions(schema, dbPath string, opts *Options) (*sql.DB, error) {
+	db, _, err := migrate(schema, dbPath, resolveOptions(opts))
+	return db, err
+}
+
+// MigrateContext is like MigrateWithOptions, but stops once ctx is done: between
+// phases, or within the backup, the schema or the data copy, interrupting SQLite
+// in the middle of a statement such as a table's INSERT ... SELECT if need be.
+// The migration then fails with a *MigrationError for the phase it stopped in,
+// wrapping ErrMigrationTimeout and context.DeadlineExceeded if the deadline
+// passed, or context.Canceled. The database is left as it was, with the partly
+// migrated copy removed. Once the new database has been moved into place the
+// migration is finished, and ctx no longer matters. A nil opts is the same as
+// DefaultOptions().
+func MigrateContext(ctx context.Context, schema, dbPath string, opts *Options) (*sql.DB, error) {
+	withContext := *resolveOptions(opts)
+	withContext.ctx = ctx
+	db, _, err := migrate(schema, dbPath, &withContext)
+	return db, cancelledMigration(ctx, err)
+}
+
+// MigratePreview performs the migration Migrate would perform, but stops before
+// replacing the database: the migrated database is left at previewPath and db is
+// a handle to it, so the result can be inspected first; it already has the new
+// schema version recorded. Call commit to move it over the database, or discard
+// to delete it.
+// Either one closes db, and the migration lock is held until one of them is
+// called. The backup is made as by Migrate, and is kept if the preview is
+// discarded.
+//
+// If the database already has the schema there is nothing to migrate:
+// previewPath is the database itself, and commit and discard just close db.
+// MigratePreview can't be used with Options.RebuildInPlace.
+func MigratePreview(schema, dbPath string) (previewPath string, db *sql.DB, commit func() error, discard func() error, err error) {
+	return MigratePreviewWithOptions(schema, dbPath, nil)
+}
+
+// MigratePreviewWithOptions is like MigratePreview but takes Options controlling
+// the migration. A nil opts is the same as DefaultOptions().
+func MigratePreviewWithOptions(schema, dbPath string, opts *Options) (previewPath string, db *sql.DB, commit func() error, discard func() error, err error) {
+	opts = resolveOptions(opts)
+	if opts.RebuildInPlace {
+		return "", nil, nil, nil, errors.New("MigratePreview is not supported with RebuildInPlace")
+	}
+	m, db, err := stageMigration(schema, dbPath, opts)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	if m == nil {
+		closeDB := func() error { return db.Close() }
+		return extractFilenameFromConnectionString(dbPath), db, closeDB, closeDB, nil
+	}
+
+	done := false
+	finish := func(keep bool) error {
+		if done {
+			return errors.New("migration preview already committed or discarded")
+		}
+		done = true
+		defer m.unlock()
+		db.Close()
+
+		if !keep {
+			if err := os.Remove(m.newDbPath); err != nil {
+				return fmt.Errorf("failed to remove preview database: %w", err)
+			}
+			return nil
+		}
+		migrated, err := m.commit()
+		if err != nil {
+			return err
+		}
+		return migrated.Close()
+	}
+	commit = func() error { return finish(true) }
+	discard = func() error { return finish(false) }
+	return m.newDbPath, db, commit, discard, nil
+}
+
+// migrate does the work of MigrateWithOptions. The Result is Unchanged if another
+// process finished the same migration while we waited for the lock.
+func migrate(schema, dbPath string, opts *Options) (*sql.DB, Result, error) {
+	opts.Report.reset()
+	start := time.Now()
+	m, db, err := stageMigration(schema, dbPath, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if m == nil {
+		if err := opts.Report.setVersions(db, -1); err != nil {
+			db.Close()
+			return nil, 0, err
+		}
+		return db, Unchanged, nil
+	}
+	defer m.unlock()
+	db.Close()
+
+	db, err = m.commit()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := opts.Report.setVersions(db, m.oldVersion); err != nil {
+		db.Close()
+		return nil, 0, err
+	}
+	if opts.Report != nil {
+		opts.Report.Stats.Duration = time.Since(start)
+	}
+	return db, Migrated, nil
+}
+
+// stagedMigration is a migration whose result has been written to newDbPath but
+// not yet moved over the database. With Options.RebuildInPlace nothing is written
+// to newDbPath, and the database is rebuilt by commit instead. It holds the
+// migration lock until unlock.
+type stagedMigration struct {
+	schema     string
+	dbPath     string
+	filename   string
+	newDbPath  string
+	backupPath string
+	baseline   string // Existing schema to record as version 1, if the database had no history
+	oldVersion int    // Version the database was at before the migration
+	opts       *Options
+	unlock     func()
+}
+
+// stageMigration takes the migration lock, backs up the database at dbPath and
+// migrates it into a new file next to it, returning the staged migration and a
+// handle to the new file. If the database already has the schema, it returns a
+// nil *stagedMigration and a handle to the database itself, without the lock.
+func stageMigration(schema, dbPath string, opts *Options) (*stagedMigration, *sql.DB, error) {
+	if opts.ReadOnly {
+		return nil, nil, fmt.Errorf("%w: cannot migrate", ErrReadOnly)
+	}
+
 	// Extract filename for file operations
 	filename := extractFilenameFromConnectionString(dbPath)
 
 	backupPath := filename + ".backup"
 	newDbPath := filename + ".tmp"
 
-	// Lock using the database path, not the tmp path
-	lockPath := filename + ".migration.lock"
-	tmpLock := flock.New(lockPath)
-	if err := tmpLock.Lock(); err != nil {
-		return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+	unlock, err := acquireMigrationLock(filename)
+	if err != nil {
+		return nil, nil, err
 	}
+	// Keep the lock only if the migration is staged successfully
+	staged := false
 	defer func() {
-		tmpLock.Unlock()
-		os.Remove(lockPath) // Clean up lock file
+		if !staged {
+			unlock()
+		}
 	}()
 
 	// Re-check schema after acquiring the lock
-	if SchemasEqual(schema, dbPath) {
-		db, err := sql.Open("sqlite3", dbPath)
+	if SchemasEqualWithOptions(schema, dbPath, opts) {
+		db, err := openDB(dbPath, opts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open existing database: %w", err)
+			return nil, nil, fmt.Errorf("failed to open existing database: %w", err)
 		}
-		return db, nil
+		return nil, db, nil
 	}
 
 	// Re-check for backward migration after acquiring the lock
-	dbCheck, err := sql.Open("sqlite3", dbPath)
+	dbCheck, err := openDB(dbPath, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database for version check after lock: %w", err)
+		return nil, nil, fmt.Errorf("failed to open database for version check after lock: %w", err)
 	}
 	defer dbCheck.Close()
+	if err := checkEmptySchema(dbCheck, schema, opts); err != nil {
+		return nil, nil, err
+	}
 	isForward, err := isForwardMigration(dbCheck, schema)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check migration direction after lock: %w", err)
+		return nil, nil, fmt.Errorf("failed to check migration direction after lock: %w", err)
+	}
+	if !isForward && !opts.AllowBackward {
+		return nil, nil, fmt.Errorf("backward migration detected after lock: this is not allowed to prevent data loss. If you need to downgrade, clear the version history with ClearVersionHistory")
+	}
+
+	fromVersion, err := getCurrentSchemaVersion(dbCheck)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get current schema version: %w", err)
+	}
+
+	if err := checkExpectedVersion(fromVersion, opts); err != nil {
+		return nil, nil, err
+	}
+
+	if opts.MarkMigrating {
+		unmark, err := markMigrating(filename, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The marker goes when the lock is released
+		release := unlock
+		unlock = func() {
+			unmark()
+			release()
+		}
+	}
+
+	// A database created outside autosqlite has no version history. Adopt it by
+	// recording its existing schema as version 1, so that the history shows what
+	// the first migration started from.
+	var baseline string
+	if fromVersion == nil {
+		baseline, err = DumpSchema(dbCheck)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read existing schema: %w", err)
+		}
+	}
+
+	// The backup and the migrated database must not be more readable than the original
+	mode := opts.FileMode
+	if mode == 0 {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to stat database: %w", err)
+		}
+		mode = info.Mode().Perm()
+	}
+
+	if err := opts.interrupted(PhaseBackup); err != nil {
+		return nil, nil, err
+	}
+	// VACUUM INTO reads the WAL too, but with the WAL folded into the database
+	// file, that file is a complete copy on its own for as long as it is the
+	// database; a reader holding the WAL back is no reason to stop here
+	if _, err := checkpointWAL(dbCheck); err != nil {
+		return nil, nil, &MigrationError{Phase: PhaseBackup, Err: err}
+	}
+	if err := backupDatabase(dbPath, backupPath, mode, opts); err != nil {
+		return nil, nil, &MigrationError{Phase: PhaseBackup, Err: err}
+	}
+
+	var db *sql.DB
+	if opts.RebuildInPlace {
+		// The tables are rebuilt by commit; until then db is the unmigrated database
+		db, err = openDB(dbPath, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open database: %w", err)
+		}
+	} else {
+		if opts.TempDir != "" {
+			// Databases in different directories may share TempDir, so the
+			// name must be unique
+			f, err := os.CreateTemp(opts.TempDir, filepath.Base(filename)+".*.tmp")
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to create new database file: %w", err)
+			}
+			f.Close()
+			newDbPath = f.Name()
+			if err := os.Chmod(newDbPath, mode); err != nil {
+				removeDatabaseFiles(newDbPath)
+				return nil, nil, fmt.Errorf("failed to create new database file: %w", err)
+			}
+		} else {
+			removeDatabaseFiles(newDbPath) // Leftover from an interrupted migration
+			if err := createFileWithMode(newDbPath, mode); err != nil {
+				removeDatabaseFiles(newDbPath)
+				return nil, nil, fmt.Errorf("failed to create new database file: %w", err)
+			}
+		}
+
+		db, err = MigrateToNewFileWithOptions(schema, dbPath, newDbPath, opts)
+		if err != nil {
+			removeDatabaseFiles(newDbPath)
+			return nil, nil, err
+		}
+
+		// Record the new version before the file is moved into place, so that
+		// the database never has the new schema without its version, even if
+		// the process dies right after the rename
+		if err := opts.interrupted(PhaseVersionRecord); err != nil {
+			db.Close()
+			removeDatabaseFiles(newDbPath)
+			return nil, nil, err
+		}
+		if err := recordMigration(db, schema, baseline, opts); err != nil {
+			db.Close()
+			removeDatabaseFiles(newDbPath)
+			return nil, nil, err
+		}
+	}
+	if opts.Report != nil {
+		if info, err := os.Stat(backupPath); err == nil {
+			opts.Report.Stats.BackupBytes = info.Size()
+		}
 	}
-	if !isForward {
-		return nil, fmt.Errorf("backward migration detected after lock: this is not allowed to prevent data loss. If you need to downgrade, clear out the _autosqlite_version table")
+
+	oldVersion := 0
+	if fromVersion != nil {
+		oldVersion = fromVersion.Version
+	} else if baseline != "" {
+		oldVersion = 1
+	}
+
+	staged = true
+	return &stagedMigration{
+		schema:     schema,
+		dbPath:     dbPath,
+		filename:   filename,
+		newDbPath:  newDbPath,
+		backupPath: backupPath,
+		baseline:   baseline,
+		oldVersion: oldVersion,
+		opts:       opts,
+		unlock:     unlock,
+	}, db, nil
+}
+
+// commit moves the migrated database over the old one, or with RebuildInPlace
+// rebuilds the database and records the new schema version. The handle to the
+// new file must be closed first.
+func (m *stagedMigration) commit() (*sql.DB, error) {
+	schema, dbPath, baseline, opts := m.schema, m.dbPath, m.baseline, m.opts
+
+	if opts.PreservePrevious != "" {
+		if err := preserveBackup(m.backupPath, m.filename, opts.PreservePrevious, m.oldVersion, opts); err != nil {
+			if !opts.RebuildInPlace {
+				removeDatabaseFiles(m.newDbPath)
+			}
+			return nil, &MigrationError{Phase: PhaseBackup, Err: fmt.Errorf("failed to preserve previous database: %w", err)}
+		}
+	}
+
+	var db *sql.DB
+	var err error
+	if opts.RebuildInPlace {
+		if err := opts.interrupted(PhaseDataCopy); err != nil {
+			return nil, err
+		}
+		db, err = openDB(dbPath, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		if err := rebuildInPlace(db, schema, baseline, opts); err != nil {
+			db.Close()
+			return nil, err
+		}
+	} else {
+		if err := opts.interrupted(PhaseReplace); err != nil {
+			removeDatabaseFiles(m.newDbPath)
+			return nil, err
+		}
+		if err := checkpointFiles(m.newDbPath, dbPath, opts); err != nil {
+			removeDatabaseFiles(m.newDbPath)
+			return nil, &MigrationError{Phase: PhaseReplace, Err: err}
+		}
+		if err := replaceFile(m.newDbPath, m.filename); err != nil {
+			removeDatabaseFiles(m.newDbPath)
+			return nil, &MigrationError{Phase: PhaseReplace, Err: err}
+		}
+
+		// The new version was recorded when the migration was staged
+		db, err = openDB(dbPath, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open migrated database: %w", err)
+		}
 	}
+	return db, nil
+}
 
-	if err := copyFile(filename, backupPath); err != nil {
-		return nil, fmt.Errorf("failed to create backup: %w", err)
+// checkpointFiles prepares the migrated database at newDbPath to be renamed
+// over the database at dbPath when they are in WAL mode. Only the main file is
+// renamed, so everything in the new database's WAL must be in it first, and the
+// old database's WAL is emptied, so that SQLite doesn't take the leftover
+// frames of the old file for part of the new one.
+func checkpointFiles(newDbPath, dbPath string, opts *Options) error {
+	newDB, err := openDB(newDbPath, opts)
+	if err != nil {
+		return fmt.Errorf("failed to open new database: %w", err)
+	}
+	complete, err := checkpointWAL(newDB)
+	newDB.Close()
+	if err != nil {
+		return fmt.Errorf("new database: %w", err)
+	}
+	if !complete {
+		return errors.New("failed to checkpoint WAL of new database: it is still in use")
 	}
 
-	db, err := MigrateToNewFile(schema, dbPath, newDbPath)
+	oldDB, err := openDB(dbPath, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to migrate to new file: %w", err)
+		return fmt.Errorf("failed to open database: %w", err)
 	}
-	db.Close()
+	defer oldDB.Close()
+	if _, err := checkpointWAL(oldDB); err != nil {
+		return fmt.Errorf("old database: %w", err)
+	}
+	return nil
+}
 
-	if err := os.Rename(newDbPath, filename); err != nil {
-		return nil, fmt.Errorf("failed to rename new database: %w", err)
+// checkpointWAL copies the content of db's WAL into the database file and
+// truncates the WAL, with PRAGMA wal_checkpoint(TRUNCATE). complete is false if
+// a reader on another connection kept part of the WAL from being copied. For a
+// database that isn't in WAL mode it does nothing and reports complete.
+func checkpointWAL(db *sql.DB) (complete bool, err error) {
+	var busy, walFrames, checkpointed int
+	if err := db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &walFrames, &checkpointed); err != nil {
+		return false, fmt.Errorf("failed to checkpoint WAL: %w", err)
 	}
+	return busy == 0, nil
+}
 
-	// Open the migrated database and record the new schema version
-	db, err = sql.Open("sqlite3", dbPath)
+// checkEmptySchema returns ErrEmptySchema if schema defines no tables while db
+// has tables a migration would drop, unless opts.AllowEmptySchema is set.
+// Tables in opts.SkipTables are kept by a migration, so they don't count.
+func checkEmptySchema(db queryer, schema string, opts *Options) error {
+	if opts.AllowEmptySchema {
+		return nil
+	}
+	for _, stmt := range splitStatements(schema) {
+		if typ, _, ok := createdObject(stmt.tokens); ok && typ == "table" {
+			return nil
+		}
+	}
+	tables, err := listTables(db)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open migrated database: %w", err)
+		return fmt.Errorf("failed to list tables: %w", err)
+	}
+	tables = slices.DeleteFunc(tables, opts.skips)
+	if len(tables) > 0 {
+		return fmt.Errorf("%w: migrating would drop the database's %d tables, including %s; set AllowEmptySchema if that is intended", ErrEmptySchema, len(tables), tables[0])
+	}
+	return nil
+}
+
+// checkExpectedVersion returns ErrVersionMismatch if opts.ExpectedFromVersion is
+// set and fromVersion, the database's current version, is not it.
+func checkExpectedVersion(fromVersion *SchemaVersion, opts *Options) error {
+	if opts.ExpectedFromVersion == 0 {
+		return nil
+	}
+	current := 0
+	if fromVersion != nil {
+		current = fromVersion.Version
+	}
+	if current != opts.ExpectedFromVersion {
+		return fmt.Errorf("%w: database is at version %d, expected %d", ErrVersionMismatch, current, opts.ExpectedFromVersion)
+	}
+	return nil
+}
+
+// recordMigration records schema as the next version of the migrated database db.
+// If baseline is not empty, the database had no version history and baseline, its
+// schema before the migration, is recorded as version 1 first.
+func recordMigration(db dbtx, schema, baseline string, opts *Options) error {
+	if baseline != "" {
+		adopted := &SchemaVersion{
+			Version:   1,
+			Hash:      calculateSchemaHash(baseline),
+			Timestamp: opts.timestamp(),
+		}
+		if err := retry(opts.retryPolicy(), isBusy, func() error {
+			return recordSchemaVersion(db, adopted, baseline, opts.SchemaStorage)
+		}); err != nil {
+			return &MigrationError{Phase: PhaseVersionRecord, Err: fmt.Errorf("failed to record existing schema version: %w", err)}
+		}
 	}
 
 	// Get current version to increment it
 	currentVersion, err := getCurrentSchemaVersion(db)
+	if err != nil {
+		return &MigrationError{Phase: PhaseVersionRecord, Err: fmt.Errorf("failed to get current schema version: %w", err)}
+	}
 	nextVersion := 1
 	if currentVersion != nil {
 		nextVersion = currentVersion.Version + 1
@@ -261,210 +915,1047 @@ func Migrate(schema, dbPath string) (*sql.DB, error) {
 
 	// Record the new schema version
 	version := &SchemaVersion{
-		Version: nextVersion,
-		Hash:    calculateSchemaHash(schema),
+		Version:     nextVersion,
+		Hash:        calculateSchemaHash(schema),
+		Timestamp:   opts.timestamp(),
+		Description: schemaDescription(schema),
 	}
 
-	if err := recordSchemaVersion(db, version, schema); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to record schema version: %w", err)
+	// Other connections to the database may briefly hold a write lock
+	if err := retry(opts.retryPolicy(), isBusy, func() error {
+		return recordSchemaVersion(db, version, schema, opts.SchemaStorage)
+	}); err != nil {
+		return &MigrationError{Phase: PhaseVersionRecord, Err: err}
 	}
+	return nil
+}
 
-	return db, nil
+// migrationLockPath returns the path of the lock file for the database file filename.
+func migrationLockPath(filename string) string {
+	return filename + ".migration.lock"
+}
+
+// acquireMigrationLock takes the exclusive lock that serializes creation and migration
+// of the database file filename, waiting for it if necessary. The returned function
+// removes the lock file and releases the lock; it is safe to call more than once.
+//
+// Since the lock file is removed, a process waiting on it may get the lock only
+// after the file is gone and another process has locked a new one in its place.
+// The lock is therefore only taken once the file locked is still the one at the
+// lock path, and otherwise tried again on the new file.
+func acquireMigrationLock(filename string) (func(), error) {
+	// Lock using the database path, not the tmp path
+	lockPath := migrationLockPath(filename)
+	for {
+		// Holding the file open keeps its identity from being reused, so that
+		// the file locked below can be told apart from any later lock file
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		lock := flock.New(lockPath)
+		if err := lock.Lock(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+
+		opened, err := f.Stat()
+		if err == nil {
+			var current os.FileInfo
+			current, err = os.Stat(lockPath)
+			if err == nil && os.SameFile(opened, current) {
+				released := false
+				return func() {
+					if released {
+						return
+					}
+					released = true
+					// Remove the file before unlocking it, so that a process
+					// that gets the lock next sees it is gone
+					os.Remove(lockPath)
+					lock.Unlock()
+					f.Close()
+				}, nil
+			}
+		}
+		lock.Unlock()
+		f.Close()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+	}
+}
+
+// databaseFile returns the file of db's main database, which is empty for an
+// in-memory database.
+func databaseFile(db *sql.DB) (string, error) {
+	var seq int
+	var name, filename string
+	if err := db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &filename); err != nil {
+		return "", fmt.Errorf("failed to find database file: %w", err)
+	}
+	return filename, nil
+}
+
+// isMemoryDatabase reports whether filename refers to an in-memory database,
+// which has no file to lock, back up or replace.
+func isMemoryDatabase(filename string) bool {
+	return filename == "" || filename == ":memory:" || strings.HasPrefix(filename, "file::memory:")
 }
 
 // MigrateToNewFile migrates an existing SQLite database at oldDbPath to the provided schema,
 // writing the result to newDbPath. It migrates data for common columns and tables.
+// Tables are copied one at a time, sorted by name, so the order is the same on
+// every run.
 //
 // Returns a *sql.DB handle to the new database or an error.
 func MigrateToNewFile(schema, oldDbPath string, newDbPath string) (*sql.DB, error) {
-	oldDB, err := sql.Open("sqlite3", oldDbPath)
+	return MigrateToNewFileWithOptions(schema, oldDbPath, newDbPath, nil)
+}
+
+// MigrateToNewFileWithOptions is like MigrateToNewFile but takes Options controlling
+// the migration. A nil opts is the same as DefaultOptions().
+func MigrateToNewFileWithOptions(schema, oldDbPath string, newDbPath string, opts *Options) (*sql.DB, error) {
+	opts = resolveOptions(opts)
+	opts.Report.reset()
+
+	oldDB, err := openDB(oldDbPath, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open existing database: %w", err)
 	}
 	defer oldDB.Close()
+	if err := checkEmptySchema(oldDB, schema, opts); err != nil {
+		return nil, err
+	}
 
-	newDB, err := sql.Open("sqlite3", newDbPath)
+	newDSN := newDbPath
+	if opts.Synchronous != "" {
+		if newDSN, err = synchronousDSN(newDbPath, opts.Synchronous); err != nil {
+			return nil, err
+		}
+	}
+	newDB, err := openDB(newDSN, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temporary database: %w", err)
 	}
 
-	if _, err := newDB.Exec(schema); err != nil {
+	if err := opts.interrupted(PhaseSchema); err != nil {
 		newDB.Close()
-		os.Remove(newDbPath)
-		return nil, fmt.Errorf("failed to execute new schema: %w", err)
+		removeDatabaseFiles(newDbPath)
+		return nil, err
 	}
-
-	// Copy _autosqlite_version table if it exists
+	if err := execSchemaContext(opts.migrationContext(), newDB, schema); err != nil {
+		newDB.Close()
+		removeDatabaseFiles(newDbPath)
+		return nil, &MigrationError{Phase: PhaseSchema, Err: err}
+	}
+	if err := checkEncrypted(extractFilenameFromConnectionString(newDbPath), opts); err != nil {
+		newDB.Close()
+		removeDatabaseFiles(newDbPath)
+		return nil, &MigrationError{Phase: PhaseSchema, Err: err}
+	}
+	if err := carryOverSkippedTables(oldDB, newDB, opts); err != nil {
+		newDB.Close()
+		removeDatabaseFiles(newDbPath)
+		return nil, &MigrationError{Phase: PhaseSchema, Err: err}
+	}
+
+	// Copy _autosqlite_version table if it exists
 	row := oldDB.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name=?", versionTableName)
 	var tableName string
 	if err := row.Scan(&tableName); err == nil && tableName == versionTableName {
 		// Create the version table in the new DB
 		if err := createVersionTable(newDB); err != nil {
 			newDB.Close()
-			os.Remove(newDbPath)
-			return nil, fmt.Errorf("failed to create version table in new DB: %w", err)
+			removeDatabaseFiles(newDbPath)
+			return nil, &MigrationError{Phase: PhaseVersionRecord, Err: fmt.Errorf("failed to create version table in new DB: %w", err)}
 		}
 		// Copy all rows
-		rows, err := oldDB.Query("SELECT version, hash, timestamp, schema_sql FROM " + versionTableName)
+		columns, err := versionColumns(oldDB, "version", "hash", "timestamp", "schema_sql", "description", "fingerprint")
+		if err != nil {
+			newDB.Close()
+			removeDatabaseFiles(newDbPath)
+			return nil, &MigrationError{Phase: PhaseVersionRecord, Err: err}
+		}
+		rows, err := oldDB.Query("SELECT " + columns + " FROM " + versionTableName)
 		if err != nil {
 			newDB.Close()
-			os.Remove(newDbPath)
-			return nil, fmt.Errorf("failed to query version table: %w", err)
+			removeDatabaseFiles(newDbPath)
+			return nil, &MigrationError{Phase: PhaseVersionRecord, Err: fmt.Errorf("failed to query version table: %w", err)}
 		}
 		defer rows.Close()
 		for rows.Next() {
 			var version int
-			var hash, ts, schemaSQL string
-			if err := rows.Scan(&version, &hash, &ts, &schemaSQL); err != nil {
+			var hash, ts string
+			var schemaSQL interface{} // TEXT, compressed BLOB or NULL, copied as-is
+			var description, fingerprint sql.NullString
+			if err := rows.Scan(&version, &hash, &ts, &schemaSQL, &description, &fingerprint); err != nil {
 				newDB.Close()
-				os.Remove(newDbPath)
-				return nil, fmt.Errorf("failed to scan version row: %w", err)
+				removeDatabaseFiles(newDbPath)
+				return nil, &MigrationError{Phase: PhaseVersionRecord, Err: fmt.Errorf("failed to scan version row: %w", err)}
 			}
-			_, err := newDB.Exec("INSERT INTO "+versionTableName+" (version, hash, timestamp, schema_sql) VALUES (?, ?, ?, ?)", version, hash, ts, schemaSQL)
+			_, err := newDB.Exec("INSERT INTO "+versionTableName+" (version, hash, timestamp, schema_sql, description, fingerprint) VALUES (?, ?, ?, ?, ?, ?)", version, hash, ts, schemaSQL, description, fingerprint)
 			if err != nil {
 				newDB.Close()
-				os.Remove(newDbPath)
-				return nil, fmt.Errorf("failed to insert version row: %w", err)
+				removeDatabaseFiles(newDbPath)
+				return nil, &MigrationError{Phase: PhaseVersionRecord, Err: fmt.Errorf("failed to insert version row: %w", err)}
 			}
 		}
 	}
 
+	if err := opts.interrupted(PhaseDataCopy); err != nil {
+		newDB.Close()
+		removeDatabaseFiles(newDbPath)
+		return nil, err
+	}
+	if err := migrateData(oldDB, newDB, opts); err != nil {
+		newDB.Close()
+		removeDatabaseFiles(newDbPath)
+		return nil, err
+	}
+
+	if err := copyHeaderPragmas(oldDB, newDB, schema, opts); err != nil {
+		newDB.Close()
+		removeDatabaseFiles(newDbPath)
+		return nil, &MigrationError{Phase: PhaseDataCopy, Err: err}
+	}
+
+	if err := opts.interrupted(PhasePostMigrate); err != nil {
+		newDB.Close()
+		removeDatabaseFiles(newDbPath)
+		return nil, err
+	}
+	if len(opts.PostMigrate) > 0 {
+		if err := runPostMigrateTx(newDB, opts); err != nil {
+			newDB.Close()
+			removeDatabaseFiles(newDbPath)
+			return nil, err
+		}
+	}
+
+	if err := checkMigratedForeignKeys(oldDB, newDB, opts); err != nil {
+		newDB.Close()
+		removeDatabaseFiles(newDbPath)
+		return nil, err
+	}
+
+	if opts.RunAnalyze {
+		if err := opts.interrupted(PhaseAnalyze); err != nil {
+			newDB.Close()
+			removeDatabaseFiles(newDbPath)
+			return nil, err
+		}
+		if _, err := newDB.ExecContext(opts.migrationContext(), "ANALYZE"); err != nil {
+			newDB.Close()
+			removeDatabaseFiles(newDbPath)
+			return nil, &MigrationError{Phase: PhaseAnalyze, Err: err}
+		}
+	}
+
+	if opts.Synchronous != "" {
+		// The copy may not be on disk yet; flush it, and hand back a
+		// connection with the DSN's own synchronous level
+		newDB.Close()
+		if err := syncDatabaseFiles(extractFilenameFromConnectionString(newDbPath)); err != nil {
+			removeDatabaseFiles(newDbPath)
+			return nil, fmt.Errorf("failed to sync new database: %w", err)
+		}
+		if newDB, err = openDB(newDbPath, opts); err != nil {
+			removeDatabaseFiles(newDbPath)
+			return nil, fmt.Errorf("failed to reopen new database: %w", err)
+		}
+	}
+
+	return newDB, nil
+}
+
+// copyHeaderPragmas copies the user_version and application_id stored in the
+// database header from oldDB to newDB, as selected by opts. A value that schema
+// declares itself is not copied.
+func copyHeaderPragmas(oldDB, newDB *sql.DB, schema string, opts *Options) error {
+	declared, _ := splitSchemaPragmas(schema)
+	var pragmas []string
+	if !opts.ResetUserVersion && !declaresPragma(declared, "user_version") {
+		pragmas = append(pragmas, "user_version")
+	}
+	if !opts.ResetApplicationID && !declaresPragma(declared, "application_id") {
+		pragmas = append(pragmas, "application_id")
+	}
+	for _, pragma := range pragmas {
+		var value int32
+		if err := oldDB.QueryRow("PRAGMA " + pragma).Scan(&value); err != nil {
+			return fmt.Errorf("failed to read %s: %w", pragma, err)
+		}
+		if value == 0 {
+			continue
+		}
+		if _, err := newDB.Exec(fmt.Sprintf("PRAGMA %s = %d", pragma, value)); err != nil {
+			return fmt.Errorf("failed to set %s: %w", pragma, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDataBetween copies data from oldDB into newDB, which must already have the
+// new schema applied. Tables present in both databases are migrated with MigrateTable,
+// copying only their common columns. The version table is not copied.
+//
+// Unlike MigrateToNewFile this works on already-open handles, so either database can
+// be in-memory.
+func MigrateDataBetween(oldDB, newDB *sql.DB) error {
+	return MigrateDataBetweenWithOptions(oldDB, newDB, nil)
+}
+
+// MigrateDataBetweenWithOptions is like MigrateDataBetween but takes Options controlling
+// the migration. A nil opts is the same as DefaultOptions().
+func MigrateDataBetweenWithOptions(oldDB, newDB *sql.DB, opts *Options) error {
+	opts = resolveOptions(opts)
+	opts.Report.reset()
+	return migrateData(oldDB, newDB, opts)
+}
+
+// migrateData copies the data of every common table from oldDB into newDB, one
+// table at a time in the order of GetTables on newDB. With opts.ParallelTables
+// the tables are read concurrently but still written in that order.
+func migrateData(oldDB, newDB *sql.DB, opts *Options) error {
 	oldTables, err := GetTables(oldDB)
 	if err != nil {
-		newDB.Close()
-		os.Remove(newDbPath)
-		return nil, fmt.Errorf("failed to get tables from old database: %w", err)
+		return &MigrationError{Phase: PhaseDataCopy, Err: fmt.Errorf("failed to get tables from old database: %w", err)}
 	}
 
 	newTables, err := GetTables(newDB)
 	if err != nil {
-		newDB.Close()
-		os.Remove(newDbPath)
-		return nil, fmt.Errorf("failed to get tables from new database: %w", err)
+		return &MigrationError{Phase: PhaseDataCopy, Err: fmt.Errorf("failed to get tables from new database: %w", err)}
 	}
 
+	sources, err := tableSources(oldTables, newTables, opts.TableRenames)
+	if err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Err: err}
+	}
+
+	if n := readParallelism(oldDB, opts); n > 1 {
+		err = copyTablesParallel(oldDB, newDB, newTables, sources, n, opts)
+	} else {
+		err = copyTables(oldDB, newDB, newTables, sources, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := copySequences(oldDB, newDB, sources); err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Err: fmt.Errorf("failed to copy AUTOINCREMENT sequences: %w", err)}
+	}
+	return nil
+}
+
+// copyTables copies the data of each table in newTables from its table in sources,
+// one after another, for migrateData.
+func copyTables(oldDB, newDB *sql.DB, newTables []string, sources map[string]string, opts *Options) error {
+	oldFile, err := databaseFile(oldDB)
+	if err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Err: err}
+	}
+
+	outcomes := &tableOutcomes{opts: opts}
 	for _, tableName := range newTables {
-		if slices.Contains(oldTables, tableName) {
-			if err := MigrateTable(oldDB, newDB, tableName); err != nil {
-				newDB.Close()
-				os.Remove(newDbPath)
-				return nil, fmt.Errorf("failed to migrate table %s: %w", tableName, err)
+		oldName, ok := sources[tableName]
+		if !ok {
+			continue
+		}
+		start := time.Now()
+		var copied, skipped int64
+		var err error
+		if opts.skips(tableName) {
+			copied, err = copySkippedTable(oldDB, newDB, oldFile, oldName, tableName, opts)
+		} else if err = checkRowidChanges(oldDB, newDB, oldName, tableName, opts); err == nil {
+			copied, skipped, err = copyTable(oldDB, newDB, oldFile, oldName, tableName, opts)
+		}
+		opts.tableCopied(tableName, start, copied, err)
+		if err != nil {
+			if err := outcomes.fail(tableName, err); err != nil {
+				return err
 			}
+			continue
+		}
+		outcomes.succeeded++
+		if opts.Report != nil {
+			opts.Report.Stats.TablesCopied++
+			opts.Report.Stats.RowsCopied += copied
+			opts.Report.Stats.RowsSkipped += skipped
 		}
 	}
+	return outcomes.done()
+}
 
-	return newDB, nil
+// tableSources maps each table in newTables to the table in oldTables its data should
+// be copied from. Tables are matched by name, case-insensitively as SQLite does,
+// unless they appear in renames, in which case the renamed old table is used instead.
+// Tables with no source are left out.
+func tableSources(oldTables, newTables []string, renames []TableRename) (map[string]string, error) {
+	// Find the name a table is stored under in tables
+	lookup := func(tables []string, name string) (string, bool) {
+		i := slices.IndexFunc(tables, func(t string) bool { return sameName(t, name) })
+		if i == -1 {
+			return "", false
+		}
+		return tables[i], true
+	}
+
+	sources := make(map[string]string)
+	renamedFrom := make(map[string]bool)
+	for _, rename := range renames {
+		from, ok := lookup(oldTables, rename.From)
+		if !ok {
+			return nil, fmt.Errorf("table rename %s -> %s: table %s does not exist in the old database", rename.From, rename.To, rename.From)
+		}
+		to, ok := lookup(newTables, rename.To)
+		if !ok {
+			return nil, fmt.Errorf("table rename %s -> %s: table %s does not exist in the new schema", rename.From, rename.To, rename.To)
+		}
+		if _, ok := sources[to]; ok {
+			return nil, fmt.Errorf("table rename %s -> %s: table %s is the target of more than one rename", rename.From, rename.To, rename.To)
+		}
+		sources[to] = from
+		renamedFrom[foldName(from)] = true
+	}
+
+	for _, tableName := range newTables {
+		if _, ok := sources[tableName]; ok {
+			continue
+		}
+		// A table that was renamed away has already given its data to its new name
+		if oldName, ok := lookup(oldTables, tableName); ok && !renamedFrom[foldName(oldName)] {
+			sources[tableName] = oldName
+		}
+	}
+	return sources, nil
+}
+
+// copySequences carries the AUTOINCREMENT counters in sqlite_sequence over from oldDB
+// to newDB. sources maps new table names to the old tables their data came from.
+// Copying rows only advances a counter to the largest id present, so without this,
+// ids of rows deleted before the migration could be reused.
+func copySequences(oldDB, newDB *sql.DB, sources map[string]string) error {
+	targets := make(map[string]string)
+	for newName, oldName := range sources {
+		targets[oldName] = newName
+	}
+
+	for _, db := range []*sql.DB{oldDB, newDB} {
+		var name string
+		err := db.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name='sqlite_sequence'").Scan(&name)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err := oldDB.Query("SELECT name, seq FROM sqlite_sequence")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var seq int64
+		if err := rows.Scan(&name, &seq); err != nil {
+			return err
+		}
+		target, ok := targets[name]
+		if !ok {
+			continue
+		}
+		res, err := newDB.Exec("UPDATE sqlite_sequence SET seq = MAX(seq, ?) WHERE name = ?", seq, target)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			if _, err := newDB.Exec("INSERT INTO sqlite_sequence (name, seq) VALUES (?, ?)", target, seq); err != nil {
+				return err
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// CompactTo writes a compacted copy of the database at dbPath to destPath using
+// VACUUM INTO. The schema, data and version history are copied exactly, so no
+// migration takes place and no new schema version is recorded; the copy simply
+// omits the free pages of the original. destPath must not already exist.
+//
+// The dbPath parameter can include SQLite query parameters (e.g., "foo.db?_busy_timeout=1000").
+func CompactTo(dbPath, destPath string) error {
+	return CompactToWithOptions(dbPath, destPath, nil)
+}
+
+// CompactToWithOptions is like CompactTo but takes Options; only EncryptionKey
+// applies, and the copy is encrypted with the same key. A nil opts is the same
+// as DefaultOptions().
+func CompactToWithOptions(dbPath, destPath string, opts *Options) error {
+	opts = resolveOptions(opts)
+	db, err := openDB(dbPath, opts)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(opts.migrationContext(), "VACUUM INTO ?", destPath); err != nil {
+		return fmt.Errorf("failed to compact database: %w", err)
+	}
+	return checkEncrypted(destPath, opts)
 }
 
 // SchemasEqual compares the provided schema with the existing database schema at dbPath.
 // Returns true if the schemas are equivalent (same tables, columns, triggers, indexes, and views).
 func SchemasEqual(schema, dbPath string) bool {
-	db, err := sql.Open("sqlite3", dbPath)
+	return SchemasEqualWithOptions(schema, dbPath, nil)
+}
+
+// SchemasEqualWithOptions is like SchemasEqual but takes Options controlling the
+// comparison. A nil opts is the same as DefaultOptions().
+func SchemasEqualWithOptions(schema, dbPath string, opts *Options) bool {
+	opts = resolveOptions(opts)
+	if opts.compiled != nil && opts.compiled.schema == schema {
+		return opts.compiled.Equals(dbPath)
+	}
+
+	if opts.ReadOnly {
+		dbPath = readOnlyDSN(dbPath)
+	}
+	db, err := openDB(dbPath, opts)
 	if err != nil {
 		return false
 	}
-	defer db.Close()
+	defer db.Close()
+	equal, err := schemaMatches(db, schema, opts)
+	return err == nil && equal
+}
+
+// schemaMatches reports whether db has schema, for SchemasEqual.
+func schemaMatches(db *sql.DB, schema string, opts *Options) (bool, error) {
+	// Usually the schema is the one last applied and nothing has changed since
+	if matchesRecordedVersion(db, schema) {
+		return true, nil
+	}
+
+	dbSchema, err := getFullSchema(db, opts)
+	if err != nil {
+		return false, fmt.Errorf("failed to read database schema: %w", err)
+	}
+
+	tempDB, err := openTemporaryDB()
+	if err != nil {
+		return false, err
+	}
+	defer tempDB.Close()
+
+	if err := checkSchemaStatements(schema); err != nil {
+		return false, err
+	}
+	if _, err := tempDB.Exec(schema); err != nil {
+		return false, fmt.Errorf("failed to execute schema: %w", err)
+	}
+
+	tempSchema, err := getFullSchema(tempDB, opts)
+	if err != nil {
+		return false, fmt.Errorf("failed to read schema: %w", err)
+	}
+	return slices.Equal(dbSchema, tempSchema), nil
+}
+
+// MigrationNeeded reports whether Open would migrate the database at dbPath to
+// schema, for example to decide whether to schedule a maintenance window. It
+// opens the database read-only and takes no lock. When schema is the one last
+// recorded in the version history and the database hasn't changed since, that
+// is all it reads; otherwise, as for a schema that differs only in comments or
+// a database with no version history, the schemas are compared in full. A
+// database that doesn't exist yet would be created rather than migrated, so
+// needs no migration.
+func MigrationNeeded(schema, dbPath string) (bool, error) {
+	return MigrationNeededWithOptions(schema, dbPath, nil)
+}
+
+// MigrationNeededWithOptions is like MigrationNeeded but takes Options
+// controlling the comparison, such as IgnoreColumnOrder and SkipTables. A nil
+// opts is the same as DefaultOptions().
+func MigrationNeededWithOptions(schema, dbPath string, opts *Options) (bool, error) {
+	opts = resolveOptions(opts)
+	filename := extractFilenameFromConnectionString(dbPath)
+	if isMemoryDatabase(filename) {
+		return false, nil
+	}
+	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check database: %w", err)
+	}
+
+	db, err := openDB(readOnlyDSN(dbPath), opts)
+	if err != nil {
+		return false, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	equal, err := schemaMatches(db, schema, opts)
+	if err != nil {
+		return false, err
+	}
+	return !equal, nil
+}
+
+// SchemasEqualStrings reports whether schemas a and b define the same database,
+// without needing a database file. Both are built in scratch in-memory databases
+// and compared the same way SchemasEqual compares a schema with a database, so
+// changes that only affect comments, whitespace or the case of keywords compare
+// equal. It returns an error if either schema fails to execute.
+func SchemasEqualStrings(a, b string) (bool, error) {
+	return SchemasEqualStringsWithOptions(a, b, nil)
+}
+
+// SchemasEqualStringsWithOptions is like SchemasEqualStrings but takes Options
+// controlling the comparison. A nil opts is the same as DefaultOptions().
+func SchemasEqualStringsWithOptions(a, b string, opts *Options) (bool, error) {
+	opts = resolveOptions(opts)
+
+	schemaA, err := canonicalSchema(a, opts)
+	if err != nil {
+		return false, fmt.Errorf("failed to build first schema: %w", err)
+	}
+	schemaB, err := canonicalSchema(b, opts)
+	if err != nil {
+		return false, fmt.Errorf("failed to build second schema: %w", err)
+	}
+	return slices.Equal(schemaA, schemaB), nil
+}
+
+// canonicalSchema executes schema in an in-memory database and returns its
+// getFullSchema form.
+func canonicalSchema(schema string, opts *Options) ([]string, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open in-memory database: %w", err)
+	}
+	defer db.Close()
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	if err := execSchema(db, schema); err != nil {
+		return nil, err
+	}
+	return getFullSchema(db, opts)
+}
+
+// execSchema executes schema on db in a single transaction, so that a failure
+// part way through leaves nothing behind and the schema can safely be retried.
+// Persistent PRAGMAs declared in the schema are set first, outside it.
+func execSchema(db *sql.DB, schema string) error {
+	return execSchemaContext(context.Background(), db, schema)
+}
+
+// execSchemaContext is execSchema, stopped once ctx is done.
+func execSchemaContext(ctx context.Context, db *sql.DB, schema string) error {
+	if err := checkSchemaStatements(schema); err != nil {
+		return err
+	}
+	pragmas, body := splitSchemaPragmas(schema)
+
+	// PRAGMAs such as page_size must be set on the connection that creates the
+	// tables, before the transaction
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := applyPragmas(ctx, conn, pragmas); err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		tx.Rollback()
+		if ctx.Err() != nil {
+			return err
+		}
+		return locateSchemaError(schema, err)
+	}
+	return tx.Commit()
+}
+
+// DumpSchema returns the SQL that creates the tables, indexes, triggers and views
+// of db, in the order they were created. SQLite's internal objects, the shadow
+// tables of virtual tables, which creating the virtual table creates, and the
+// _autosqlite_version table are left out. Statements are separated by ";\n".
+func DumpSchema(db *sql.DB) (string, error) {
+	return dumpSchema(db)
+}
+
+// dumpSchema is DumpSchema for a database or transaction.
+func dumpSchema(db queryer) (string, error) {
+	rows, err := db.Query(`SELECT sql FROM sqlite_master WHERE type IN ('table','index','trigger','view') AND name NOT LIKE 'sqlite_%' AND tbl_name != ? AND sql IS NOT NULL
+		AND name NOT IN (`+shadowTablesQuery+`) ORDER BY rowid`, versionTableName)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var statements []string
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			return "", err
+		}
+		statements = append(statements, stmt+";")
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(statements, "\n"), nil
+}
+
+// getFullSchema returns a sorted, normalized list of all schema SQL statements for tables, indexes, triggers, and views.
+// The _autosqlite_version table is excluded, so changes to its definition never count as a schema change.
+// With opts.IgnoreColumnOrder, the column definitions of each table are sorted.
+func getFullSchema(db queryer, opts *Options) ([]string, error) {
+	entries, err := schemaEntries(db, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema []string
+	for _, e := range entries {
+		schema = append(schema, fmt.Sprintf("%s|%s|%s", e.object.Type, e.object.Name, e.sql))
+	}
+	return schema, nil
+}
+
+// GetTables returns a list of user table names in the database, sorted by name. SQLite's
+// internal sqlite_% tables (sqlite_sequence, sqlite_stat1, ...) and _autosqlite_version
+// are excluded, as are the shadow tables that virtual tables such as FTS5 tables keep
+// their data in (docs_data, docs_idx, ...): the virtual table itself is listed instead.
+func GetTables(db *sql.DB) ([]string, error) {
+	return listTables(db)
+}
+
+// listTables is GetTables for a database or transaction.
+func listTables(db queryer) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' AND name NOT IN (" + shadowTablesQuery + ") ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, err
+		}
+		if tableName == versionTableName {
+			continue
+		}
+		tables = append(tables, tableName)
+	}
+	return tables, rows.Err()
+}
+
+// MigrateTable migrates data from old table to new table, copying only common columns.
+// When migrating to a NOT NULL column with a DEFAULT value, NULL values from the old table
+// are automatically replaced with the DEFAULT value using SQL's COALESCE function.
+// Returns an error if migration fails.
+func MigrateTable(oldDB, newDB *sql.DB, tableName string) error {
+	_, _, err := migrateTable(context.Background(), oldDB, newDB, tableName, tableName, ConflictAbort, nil, nil, MatchByName)
+	return err
+}
+
+// migrateTable copies the common columns of oldTable in oldDB into newTable in newDB,
+// resolving constraint conflicts according to policy. New columns named in backfills
+// are filled in with the value of their expression for each old row, and columns
+// are paired as matching says. It returns the
+// number of rows copied and the number of rows dropped because of a conflict.
+// The copy stops with ctx's error once ctx is done.
+func migrateTable(ctx context.Context, oldDB, newDB *sql.DB, oldTable, newTable string, policy ConflictPolicy, onConflict ConflictFunc, backfills map[string]string, matching MatchStrategy) (copied, skipped int64, err error) {
+	plan, err := planTableCopy(oldDB, newDB, oldTable, newTable, backfills, matching)
+	if err != nil || plan == nil {
+		return 0, 0, err
+	}
+	return plan.write(ctx, newDB, policy, onConflict, func(insert func([]any) error) error {
+		return plan.read(ctx, oldDB, insert)
+	})
+}
+
+// copyTable copies oldTable in oldDB, whose file is oldFile, into newTable in
+// newDB as migrateTable does, with the options in opts. Where opts.copiesAttached
+// allows, the rows are copied by writeAttached, so that large BLOB and TEXT
+// values don't pass through Go; otherwise each row is read into memory in turn.
+func copyTable(oldDB, newDB *sql.DB, oldFile, oldTable, newTable string, opts *Options) (copied, skipped int64, err error) {
+	backfills := opts.backfillsFor(newTable)
+	if !opts.copiesAttached(oldFile, backfills) {
+		return migrateTable(opts.migrationContext(), oldDB, newDB, oldTable, newTable, opts.ConflictPolicy, opts.OnConflict, backfills, opts.matchStrategyFor(newTable))
+	}
+	plan, err := planTableCopy(oldDB, newDB, oldTable, newTable, backfills, opts.matchStrategyFor(newTable))
+	if err != nil || plan == nil {
+		return 0, 0, err
+	}
+	return plan.writeAttached(opts.migrationContext(), newDB, oldFile, opts.ConflictPolicy)
+}
+
+// tableCopy is how the rows of a table are copied, as worked out by planTableCopy.
+type tableCopy struct {
+	oldTable, newTable string
+	selectColumns      []string // Expressions for the values to insert, on oldTable
+	insertColumns      []string // Columns of newTable the values go in
+}
+
+// planTableCopy works out how to copy oldTable in oldDB into newTable in newDB, as
+// described for migrateTable. It returns nil if the tables have no columns in
+// common, so there is nothing to copy.
+func planTableCopy(oldDB, newDB *sql.DB, oldTable, newTable string, backfills map[string]string, matching MatchStrategy) (*tableCopy, error) {
+	oldColumns, err := GetColumnInfo(oldDB, oldTable)
+	if err != nil {
+		return nil, err
+	}
+
+	newColumns, err := GetColumnInfo(newDB, newTable)
+	if err != nil {
+		return nil, err
+	}
+
+	oldVirtual, err := getVirtualTable(oldDB, oldTable)
+	if err != nil {
+		return nil, err
+	}
+	newVirtual, err := getVirtualTable(newDB, newTable)
+	if err != nil {
+		return nil, err
+	}
+	if oldVirtual.isContentless() {
+		return nil, nil // Nothing can be read back to copy
+	}
+
+	commonColumns, sourceColumns, matchedColumns := matchColumns(oldColumns, newColumns, matching)
+	// R*Tree coordinates are copied by position, whatever their names
+	if len(commonColumns) == 0 && !(oldVirtual.isRtree() && newVirtual.isRtree()) {
+		return nil, nil // No common columns, skip migration
+	}
+	if err := checkAddedNotNullColumns(oldDB, oldTable, matchedColumns, newColumns, backfills); err != nil {
+		return nil, err
+	}
+
+	selectColumns := selectExpressions(commonColumns, sourceColumns, newColumns)
+	backfilled, err := backfillExpressions(oldDB, oldTable, matchedColumns, newColumns, backfills)
+	if err != nil {
+		return nil, err
+	}
+	// Backfilled columns are inserted after the common ones
+	insertColumns := slices.Clone(commonColumns)
+	for _, col := range newColumns {
+		if expr, ok := backfilled[foldName(col.Name)]; ok {
+			insertColumns = append(insertColumns, col.Name)
+			selectColumns = append(selectColumns, expr)
+		}
+	}
+	insertColumns, selectColumns, err = virtualCopyColumns(oldVirtual, newVirtual, insertColumns, selectColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tableCopy{
+		oldTable:      oldTable,
+		newTable:      newTable,
+		selectColumns: selectColumns,
+		insertColumns: insertColumns,
+	}, nil
+}
+
+// read runs the copy's query on oldDB and calls fn with the values of each row,
+// until ctx is done.
+func (c *tableCopy) read(ctx context.Context, oldDB *sql.DB, fn func(values []any) error) error {
+	rows, err := oldDB.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(c.selectColumns, ", "), c.oldTable))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		values := make([]interface{}, len(c.insertColumns))
+		valuePtrs := make([]interface{}, len(c.insertColumns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return err
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
 
-	dbSchema, err := getFullSchema(db)
-	if err != nil {
-		return false
+// write inserts rows into the new table in a single transaction on newDB,
+// resolving conflicts according to policy. If onConflict is not nil, it is
+// called for each row that breaks a constraint before the policy is applied.
+// produce is called once and must call insert with the values of each row. It
+// returns the number of rows copied and the number dropped because of a
+// conflict. Once ctx is done, inserting fails and the transaction is rolled back.
+func (c *tableCopy) write(ctx context.Context, newDB *sql.DB, policy ConflictPolicy, onConflict ConflictFunc, produce func(insert func([]any) error) error) (copied, skipped int64, err error) {
+	placeholders := make([]string, len(c.insertColumns))
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+	insertQuery := func(verb string) string {
+		return fmt.Sprintf("%s INTO %s (%s) VALUES (%s)",
+			verb, c.newTable, strings.Join(c.insertColumns, ", "), strings.Join(placeholders, ", "))
 	}
 
-	tempDB, err := openTemporaryDB()
+	tx, err := newDB.BeginTx(ctx, nil)
 	if err != nil {
-		return false
+		return 0, 0, err
 	}
-	defer tempDB.Close()
 
-	// Always create the _autosqlite_version table in the temp DB
-	if err := createVersionTable(tempDB); err != nil {
-		return false
+	// Rows lost to IGNORE or REPLACE show up as a shortfall in the row count
+	countQuery := "SELECT COUNT(*) FROM " + c.newTable
+	var before int64
+	if policy != ConflictAbort {
+		if err := tx.QueryRow(countQuery).Scan(&before); err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
 	}
 
-	if _, err := tempDB.Exec(schema); err != nil {
-		return false
+	// To see which rows conflict, they are inserted with a plain INSERT, which
+	// fails just that statement without ending the transaction, and the policy
+	// is applied afterwards
+	verb := policy.insertVerb()
+	if onConflict != nil {
+		verb = ConflictAbort.insertVerb()
 	}
-
-	tempSchema, err := getFullSchema(tempDB)
+	stmt, err := tx.Prepare(insertQuery(verb))
 	if err != nil {
-		return false
-	}
-
-	if len(dbSchema) != len(tempSchema) {
-		return false
+		tx.Rollback()
+		return 0, 0, err
 	}
-	for i := range dbSchema {
-		if dbSchema[i] != tempSchema[i] {
-			return false
+	defer stmt.Close()
+	var replaceStmt *sql.Stmt
+	if onConflict != nil && policy == ConflictReplace {
+		if replaceStmt, err = tx.Prepare(insertQuery(policy.insertVerb())); err != nil {
+			tx.Rollback()
+			return 0, 0, err
 		}
+		defer replaceStmt.Close()
 	}
-	return true
-}
 
-// getFullSchema returns a sorted, normalized list of all schema SQL statements for tables, indexes, triggers, and views.
-func getFullSchema(db *sql.DB) ([]string, error) {
-	rows, err := db.Query(`SELECT type, name, sql FROM sqlite_master WHERE type IN ('table','index','trigger','view') AND name NOT LIKE 'sqlite_%' ORDER BY type, name`)
+	var read int64
+	err = produce(func(values []any) error {
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			if onConflict == nil || !isConstraintError(err) {
+				return err
+			}
+			onConflict(c.newTable, conflictRow(c.insertColumns, values), err)
+			switch policy {
+			case ConflictAbort:
+				return err
+			case ConflictReplace:
+				if _, err := replaceStmt.ExecContext(ctx, values...); err != nil {
+					return err
+				}
+			}
+		}
+		read++
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		tx.Rollback()
+		return 0, 0, err
 	}
-	defer rows.Close()
 
-	var schema []string
-	for rows.Next() {
-		var typ, name, sqlStmt string
-		if err := rows.Scan(&typ, &name, &sqlStmt); err != nil {
-			return nil, err
+	if policy != ConflictAbort {
+		var after int64
+		if err := tx.QueryRow(countQuery).Scan(&after); err != nil {
+			tx.Rollback()
+			return 0, 0, err
 		}
-		// Normalize whitespace
-		sqlStmt = strings.TrimSpace(sqlStmt)
-		schema = append(schema, fmt.Sprintf("%s|%s|%s", typ, name, sqlStmt))
+		skipped = max(read-(after-before), 0)
 	}
-	return schema, rows.Err()
-}
 
-// GetTables returns a list of user table names in the database (ignores _autosqlite_version).
-func GetTables(db *sql.DB) ([]string, error) {
-	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table'")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	return read - skipped, skipped, tx.Commit()
+}
 
-	var tables []string
-	for rows.Next() {
-		var tableName string
-		if err := rows.Scan(&tableName); err != nil {
-			return nil, err
+// checkAddedNotNullColumns returns ErrConstraintViolation if newColumns adds a
+// NOT NULL column without a DEFAULT or backfill to oldTable and oldTable has rows
+// to copy. Columns that alias the rowid are filled in by SQLite and are fine.
+func checkAddedNotNullColumns(db queryer, oldTable string, oldColumns, newColumns []ColumnInfo, backfills map[string]string) error {
+	var added []string
+	for _, col := range newColumns {
+		if !col.NotNull || col.DefaultValue.Valid || (col.PrimaryKey && strings.EqualFold(col.Type, "INTEGER")) {
+			continue
 		}
-		if tableName == versionTableName {
+		if _, ok := backfills[foldName(col.Name)]; ok {
 			continue
 		}
-		tables = append(tables, tableName)
+		if !slices.ContainsFunc(oldColumns, func(old ColumnInfo) bool { return sameName(old.Name, col.Name) }) {
+			added = append(added, col.Name)
+		}
+	}
+	if len(added) == 0 {
+		return nil
 	}
-	return tables, rows.Err()
-}
 
-// MigrateTable migrates data from old table to new table, copying only common columns.
-// When migrating to a NOT NULL column with a DEFAULT value, NULL values from the old table
-// are automatically replaced with the DEFAULT value using SQL's COALESCE function.
-// Returns an error if migration fails.
-func MigrateTable(oldDB, newDB *sql.DB, tableName string) error {
-	oldColumns, err := GetColumnInfo(oldDB, tableName)
+	rows, err := db.Query("SELECT 1 FROM " + oldTable + " LIMIT 1")
 	if err != nil {
 		return err
 	}
-
-	newColumns, err := GetColumnInfo(newDB, tableName)
-	if err != nil {
+	hasRows := rows.Next()
+	rows.Close()
+	if err := rows.Err(); err != nil {
 		return err
 	}
+	if !hasRows {
+		return nil
+	}
+	return fmt.Errorf("%w: column %s of table %s would be NULL in every copied row", ErrConstraintViolation, strings.Join(added, ", "), oldTable)
+}
+
+// backfillExpressions checks the backfill expressions for newColumns and returns
+// the SELECT expression for each, keyed by column name. Each backfilled column must
+// be new, and its expression must be valid against oldTable, which also ensures it
+// only refers to columns oldTable has.
+func backfillExpressions(db queryer, oldTable string, oldColumns, newColumns []ColumnInfo, backfills map[string]string) (map[string]string, error) {
+	if len(backfills) == 0 {
+		return nil, nil
+	}
+	expressions := make(map[string]string)
+	for column, expr := range backfills {
+		if !slices.ContainsFunc(newColumns, func(col ColumnInfo) bool { return sameName(col.Name, column) }) {
+			return nil, fmt.Errorf("backfill column %s is not in the new table", column)
+		}
+		if slices.ContainsFunc(oldColumns, func(col ColumnInfo) bool { return sameName(col.Name, column) }) {
+			return nil, fmt.Errorf("backfill column %s already exists in table %s", column, oldTable)
+		}
 
-	commonColumns := FindCommonColumns(oldColumns, newColumns)
-	if len(commonColumns) == 0 {
-		return nil // No common columns, skip migration
+		selectExpr := fmt.Sprintf("(%s) AS %s", expr, column)
+		rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s LIMIT 0", selectExpr, oldTable))
+		if err != nil {
+			return nil, fmt.Errorf("invalid backfill for column %s: %w", column, err)
+		}
+		rows.Close()
+		expressions[column] = selectExpr
 	}
+	return expressions, nil
+}
 
+// selectExpressions returns the SELECT expressions that read commonColumns from
+// an old table for insertion into a table with newColumns. NULLs in columns that
+// are NOT NULL with a DEFAULT in the new table are replaced by the default.
+func selectExpressions(commonColumns, sourceColumns []string, newColumns []ColumnInfo) []string {
 	// Create a map of column info for quick lookup
 	newColumnMap := make(map[string]ColumnInfo)
 	for _, col := range newColumns {
@@ -473,61 +1964,20 @@ func MigrateTable(oldDB, newDB *sql.DB, tableName string) error {
 
 	// Build the SELECT query with COALESCE for NOT NULL columns with DEFAULT values
 	var selectColumns []string
-	for _, colName := range commonColumns {
+	for i, colName := range commonColumns {
 		newCol := newColumnMap[colName]
-		if newCol.NotNull && newCol.DefaultValue.Valid {
+		source := sourceColumns[i]
+		switch {
+		case newCol.NotNull && newCol.DefaultValue.Valid:
 			// For NOT NULL columns with DEFAULT, use COALESCE to replace NULL with DEFAULT
-			selectColumns = append(selectColumns, fmt.Sprintf("COALESCE(%s, %s) as %s", colName, newCol.DefaultValue.String, colName))
-		} else {
+			selectColumns = append(selectColumns, fmt.Sprintf("COALESCE(%s, %s) as %s", source, newCol.DefaultValue.String, colName))
+		case source != colName:
+			selectColumns = append(selectColumns, fmt.Sprintf("%s AS %s", source, colName))
+		default:
 			selectColumns = append(selectColumns, colName)
 		}
 	}
-
-	selectQuery := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selectColumns, ", "), tableName)
-	rows, err := oldDB.Query(selectQuery)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
-
-	placeholders := make([]string, len(commonColumns))
-	for i := range placeholders {
-		placeholders[i] = "?"
-	}
-	insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		tableName, strings.Join(commonColumns, ", "), strings.Join(placeholders, ", "))
-
-	tx, err := newDB.Begin()
-	if err != nil {
-		return err
-	}
-
-	stmt, err := tx.Prepare(insertQuery)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	defer stmt.Close()
-
-	for rows.Next() {
-		values := make([]interface{}, len(commonColumns))
-		valuePtrs := make([]interface{}, len(commonColumns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		if err := rows.Scan(valuePtrs...); err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		if _, err := stmt.Exec(values...); err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-
-	return tx.Commit()
+	return selectColumns
 }
 
 // GetColumns returns a list of column names for a table.
@@ -548,6 +1998,24 @@ func GetColumns(db *sql.DB, tableName string) ([]string, error) {
 // This includes column names, types, constraints, and default values.
 // Returns an error if the table does not exist or if there's a database error.
 func GetColumnInfo(db *sql.DB, tableName string) ([]ColumnInfo, error) {
+	return columnInfo(db, tableName)
+}
+
+// queryer is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// dbtx is implemented by both *sql.DB and *sql.Tx, so that the version table can
+// be written as part of a larger transaction.
+type dbtx interface {
+	queryer
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// columnInfo is GetColumnInfo for any queryer, e.g. a transaction.
+func columnInfo(db queryer, tableName string) ([]ColumnInfo, error) {
 	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", tableName))
 	if err != nil {
 		return nil, err
@@ -574,38 +2042,283 @@ func GetColumnInfo(db *sql.DB, tableName string) ([]ColumnInfo, error) {
 	return columns, rows.Err()
 }
 
-// FindCommonColumns returns columns that exist in both old and new tables.
+// FindCommonColumns returns columns that exist in both old and new tables. Column
+// names are matched case-insensitively, as SQLite does, and returned as spelled in
+// the new table.
 func FindCommonColumns(oldColumns, newColumns []ColumnInfo) []string {
 	oldSet := make(map[string]bool)
 	for _, col := range oldColumns {
-		oldSet[col.Name] = true
+		oldSet[foldName(col.Name)] = true
 	}
 
 	var common []string
 	for _, col := range newColumns {
-		if oldSet[col.Name] {
+		if oldSet[foldName(col.Name)] {
 			common = append(common, col.Name)
 		}
 	}
 	return common
 }
 
-// copyFile copies a file from src to dst using io.Copy.
+// backupDatabase writes a transactionally consistent copy of the database at dbPath
+// to backupPath using VACUUM INTO. Unlike a plain byte copy this is safe while other
+// connections are writing, and it includes any content still held in a WAL file.
+// opts.BackupMode decides what happens to an existing file at backupPath. The
+// backup is created empty with mode before VACUUM INTO fills it, so that it is
+// never more readable than that, even while it is being written.
+func backupDatabase(dbPath, backupPath string, mode os.FileMode, opts *Options) error {
+	if _, err := os.Stat(backupPath); err == nil {
+		switch opts.BackupMode {
+		case BackupFail:
+			return fmt.Errorf("%w: %s", ErrBackupExists, backupPath)
+		case BackupRotate:
+			if err := rotateBackups(backupPath); err != nil {
+				return err
+			}
+		default:
+			if err := os.Remove(backupPath); err != nil {
+				return err
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := createFileWithMode(backupPath, mode); err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	// A writer holding the database lock makes VACUUM INTO fail with SQLITE_BUSY
+	if err := retry(opts.retryPolicy(), isBusy, func() error {
+		// VACUUM INTO needs the file empty; keep it, with its mode, rather than
+		// removing the partial output of an earlier attempt
+		if err := os.Truncate(backupPath, 0); err != nil {
+			return err
+		}
+		return CompactToWithOptions(dbPath, backupPath, opts)
+	}); err != nil {
+		// A partial backup, e.g. when the disk is full, must not pass for a good one
+		os.Remove(backupPath)
+		return err
+	}
+
+	if !opts.SkipBackupVerify {
+		if err := verifyBackup(dbPath, backupPath, opts); err != nil {
+			os.Remove(backupPath)
+			return fmt.Errorf("backup verification failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// verifyBackup checks that the backup at backupPath is a sound copy of the database
+// at dbPath: it must pass SQLite's integrity check and have the same schema. Data
+// isn't compared, since other connections may have written to the database since
+// the backup was taken.
+func verifyBackup(dbPath, backupPath string, opts *Options) error {
+	backup, err := openDB(readOnlyDSN(backupPath), opts)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer backup.Close()
+
+	if err := checkIntegrity(backup, !opts.FullIntegrityCheck); err != nil {
+		return err
+	}
+
+	db, err := openDB(dbPath, opts)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	dbSchema, err := getFullSchema(db, opts)
+	if err != nil {
+		return fmt.Errorf("failed to read database schema: %w", err)
+	}
+	backupSchema, err := getFullSchema(backup, opts)
+	if err != nil {
+		return fmt.Errorf("failed to read backup schema: %w", err)
+	}
+	if !slices.Equal(dbSchema, backupSchema) {
+		return errors.New("backup schema differs from the database")
+	}
+	return nil
+}
+
+// createFileWithMode creates an empty file at path with exactly the permissions
+// mode, regardless of the umask. It fails if the file already exists.
+func createFileWithMode(path string, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
+
+// removeDatabaseFiles removes the database file at path together with any
+// journal, WAL and shared-memory files SQLite left next to it. A stale journal
+// must not outlive its database, or SQLite would roll it back into the next
+// file created at path.
+func removeDatabaseFiles(path string) {
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
+
+// preserveBackup keeps a permanent copy of the backup at backupPath, named by
+// expanding "{version}" in template to version. A relative name is taken to be
+// in the same directory as the database file filename. An existing file is
+// never overwritten.
+func preserveBackup(backupPath, filename, template string, version int, opts *Options) error {
+	path := strings.ReplaceAll(template, "{version}", strconv.Itoa(version))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(filename), path)
+	}
+
+	// The backup is always replaced by a new file rather than rewritten, so a
+	// hard link is a cheap copy that later migrations won't disturb. A backup
+	// with a WAL, which a hard link would leave behind, is copied instead.
+	if !hasWAL(backupPath) {
+		err := os.Link(backupPath, path)
+		if err == nil || errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	} else if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s: %w", path, fs.ErrExist)
+	}
+	// Not every filesystem supports hard links
+	return copyDatabase(backupPath, path, opts)
+}
+
+// replaceFile atomically replaces dst with src by renaming it. If they are on
+// different filesystems, for example because of Options.TempDir, src is first
+// copied next to dst and the copy renamed instead, so dst is still never seen
+// half-written. The copy gets a unique name in dst's directory and is synced,
+// as is the directory after the rename, before src is removed.
+func replaceFile(src, dst string) error {
+	err := rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	dir := filepath.Dir(dst)
+	f, err := os.CreateTemp(dir, filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file next to the database: %w", err)
+	}
+	tmp := f.Name()
+	f.Close()
+	os.Remove(tmp)
+
+	if err := copyFile(src, tmp); err != nil {
+		return fmt.Errorf("failed to copy new database next to the old one: %w", err)
+	}
+	if err := rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// dst has been replaced by now, and not every platform can sync a directory
+	syncDir(dir)
+	removeDatabaseFiles(src)
+	return nil
+}
+
+// rename is os.Rename, replaced in tests to simulate renames across filesystems.
+var rename = os.Rename
+
+// syncDir flushes the directory entries of dir to disk, so that a rename into
+// it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// copyDatabase copies the database file src, opened with opts, to the new file
+// dst. Transactions committed in WAL mode may not have reached the database
+// file yet, so a WAL that src has is first checkpointed into it, and the file
+// alone then holds every committed transaction; copying it with its WAL
+// instead could catch the two at different moments. Any WAL or shared-memory
+// file left at dst from an earlier database is removed. Nothing must be
+// writing to src.
+func copyDatabase(src, dst string, opts *Options) error {
+	if hasWAL(src) {
+		db, err := openDB(src, opts)
+		if err != nil {
+			return fmt.Errorf("failed to open database to checkpoint: %w", err)
+		}
+		complete, err := checkpointWAL(db)
+		db.Close()
+		if err != nil {
+			return err
+		}
+		if !complete {
+			return errors.New("failed to checkpoint WAL: it is still in use")
+		}
+	}
+	os.Remove(dst + "-wal")
+	os.Remove(dst + "-shm")
+	return copyFile(src, dst)
+}
+
+// hasWAL reports whether the database file path has a WAL that isn't empty.
+func hasWAL(path string) bool {
+	info, err := os.Stat(path + "-wal")
+	return err == nil && info.Size() > 0
+}
+
+// copyFile copies src to the new file dst, which gets the permissions of src.
 func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+	in, err := os.Open(src)
 	if err != nil {
 		return err
 	}
-	defer sourceFile.Close()
+	defer in.Close()
 
-	destFile, err := os.Create(dst)
+	info, err := in.Stat()
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
 
-	_, err = destFile.ReadFrom(sourceFile)
-	return err
+// rotateBackups moves backupPath to backupPath.1, shifting any existing numbered
+// backups up by one first so that none are overwritten.
+func rotateBackups(backupPath string) error {
+	last := 0
+	for {
+		if _, err := os.Stat(fmt.Sprintf("%s.%d", backupPath, last+1)); err != nil {
+			break
+		}
+		last++
+	}
+
+	for n := last; n >= 1; n-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", backupPath, n), fmt.Sprintf("%s.%d", backupPath, n+1)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(backupPath, backupPath+".1")
 }
 
 // calculateSchemaHash returns a SHA256 hash of the normalized schema
@@ -633,55 +2346,159 @@ func normalizeSchema(schema string) string {
 }
 
 // getCurrentSchemaVersion retrieves the current schema version from the database
-func getCurrentSchemaVersion(db *sql.DB) (*SchemaVersion, error) {
-	// Check if version table exists
-	row := db.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name=?", versionTableName)
-	var tableName string
-	if err := row.Scan(&tableName); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // No version table means no version tracking
-		}
+func getCurrentSchemaVersion(db dbtx) (*SchemaVersion, error) {
+	// No version table means no version tracking
+	exists, err := versionTableExists(db)
+	if err != nil || !exists {
 		return nil, err
 	}
 
 	// Get current version (order by version DESC, not timestamp)
-	row = db.QueryRow("SELECT version, hash, timestamp FROM " + versionTableName + " ORDER BY version DESC LIMIT 1")
+	row := db.QueryRow("SELECT version, hash, timestamp FROM " + versionTableName + " ORDER BY version DESC LIMIT 1")
 	var version SchemaVersion
 	if err := row.Scan(&version.Version, &version.Hash, &version.Timestamp); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// The table exists but nothing is recorded yet, e.g. while another
+			// process is still creating the database
+			return nil, nil
+		}
 		return nil, err
 	}
 
 	return &version, nil
 }
 
-// createVersionTable creates the version tracking table
-func createVersionTable(db *sql.DB) error {
-	createTableSQL := fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %s (
-			version INTEGER,
-			hash TEXT NOT NULL,
-			timestamp TEXT NOT NULL,
-			schema_sql TEXT
-		)`, versionTableName)
+// currentVersionNumber returns the schema version recorded in db, or 0 if it has
+// no version history.
+func currentVersionNumber(db *sql.DB) (int, error) {
+	version, err := getCurrentSchemaVersion(db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get current schema version: %w", err)
+	}
+	if version == nil {
+		return 0, nil
+	}
+	return version.Version, nil
+}
 
-	_, err := db.Exec(createTableSQL)
-	return err
+// versionTableColumns are the columns of the version table, with their
+// definitions. Columns added in later versions of this package go at the end,
+// and must be nullable or have a DEFAULT so that upgradeVersionTable can add
+// them to existing tables.
+var versionTableColumns = []struct{ name, definition string }{
+	{"version", "INTEGER"},
+	{"hash", "TEXT NOT NULL"},
+	{"timestamp", "TEXT NOT NULL"},
+	{"schema_sql", "TEXT"},
+	{"description", "TEXT"},
+	{"fingerprint", "TEXT"},
+}
+
+// createVersionTable creates the version tracking table, or brings one created
+// by an older version of this package up to date.
+func createVersionTable(db dbtx) error {
+	var defs []string
+	for _, col := range versionTableColumns {
+		defs = append(defs, col.name+" "+col.definition)
+	}
+	createTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", versionTableName, strings.Join(defs, ",\n\t"))
+
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return err
+	}
+	return upgradeVersionTable(db)
+}
+
+// upgradeVersionTable adds any columns of versionTableColumns that the existing
+// version table of db lacks, so that rows can be written with the current set.
+func upgradeVersionTable(db dbtx) error {
+	present, err := versionTableColumnSet(db)
+	if err != nil {
+		return err
+	}
+	for _, col := range versionTableColumns {
+		if present[col.name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", versionTableName, col.name, col.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s to version table: %w", col.name, err)
+		}
+	}
+	return nil
+}
+
+// versionTableColumnSet returns the names of the columns the version table of
+// db has.
+func versionTableColumnSet(db dbtx) (map[string]bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", versionTableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect version table: %w", err)
+	}
+	defer rows.Close()
+
+	present := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to inspect version table: %w", err)
+		}
+		present[name] = true
+	}
+	return present, rows.Err()
 }
 
-// recordSchemaVersion records the current schema version in the database
-func recordSchemaVersion(db *sql.DB, version *SchemaVersion, schemaSQL string) error {
+// versionColumns returns a select list for the named columns of the version
+// table of db, with NULL in place of any that an older table doesn't have yet.
+func versionColumns(db dbtx, names ...string) (string, error) {
+	present, err := versionTableColumnSet(db)
+	if err != nil {
+		return "", err
+	}
+	var exprs []string
+	for _, name := range names {
+		if present[name] {
+			exprs = append(exprs, name)
+		} else {
+			exprs = append(exprs, "NULL")
+		}
+	}
+	return strings.Join(exprs, ", "), nil
+}
+
+// recordSchemaVersion records the current schema version in the database,
+// storing the schema text as selected by storage. version.Timestamp is stored
+// as-is. The row also gets the fingerprint of the database's schema as it is
+// now, for SchemasEqual to check cheaply that it hasn't changed since.
+func recordSchemaVersion(db dbtx, version *SchemaVersion, schemaSQL string, storage SchemaStorage) error {
 	if err := createVersionTable(db); err != nil {
 		return err
 	}
 
-	insertSQL := fmt.Sprintf("INSERT INTO %s (version, hash, timestamp, schema_sql) VALUES (?, ?, datetime('now'), ?)", versionTableName)
-	_, err := db.Exec(insertSQL, version.Version, version.Hash, schemaSQL)
+	storedSQL, err := encodeSchemaSQL(schemaSQL, storage)
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := schemaFingerprint(db)
+	if err != nil {
+		return err
+	}
+
+	var description interface{}
+	if version.Description != "" {
+		description = version.Description
+	}
+	insertSQL := fmt.Sprintf("INSERT INTO %s (version, hash, timestamp, schema_sql, description, fingerprint) VALUES (?, ?, ?, ?, ?, ?)", versionTableName)
+	_, err = db.Exec(insertSQL, version.Version, version.Hash, version.Timestamp, storedSQL, description, fingerprint)
 	return err
 }
 
 // isForwardMigration checks if the new schema represents a forward migration
-// Returns true if migration is allowed, false if it would be a backward migration
-func isForwardMigration(db *sql.DB, newSchema string) (bool, error) {
+// Returns true if migration is allowed, false if it would be a backward migration.
+// Re-applying the current schema is allowed, for example to repair a database
+// whose schema was changed by hand. A schema counts as backward only if the
+// latest version it was applied at is older than the current version.
+func isForwardMigration(db dbtx, newSchema string) (bool, error) {
 	currentVersion, err := getCurrentSchemaVersion(db)
 	if err != nil {
 		return false, err
@@ -697,13 +2514,13 @@ func isForwardMigration(db *sql.DB, newSchema string) (bool, error) {
 		return true, nil
 	}
 
-	row := db.QueryRow("SELECT COUNT(*) FROM "+versionTableName+" WHERE hash = ?", newHash)
-	var count int
-	if err := row.Scan(&count); err != nil {
+	row := db.QueryRow("SELECT MAX(version) FROM "+versionTableName+" WHERE hash = ?", newHash)
+	var latest sql.NullInt64
+	if err := row.Scan(&latest); err != nil {
 		return false, err
 	}
 
-	if count > 0 {
+	if latest.Valid && latest.Int64 < int64(currentVersion.Version) {
 		return false, nil
 	}
 
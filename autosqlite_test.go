@@ -1,11 +1,17 @@
 package autosqlite
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -344,6 +350,9 @@ func TestGetTables(t *testing.T) {
 	if len(tables) != 2 {
 		t.Fatalf("expected 2 tables, got %d", len(tables))
 	}
+	if !slices.IsSorted(tables) {
+		t.Errorf("expected tables sorted by name, got %v", tables)
+	}
 
 	expected := []string{"users", "posts"}
 	for _, table := range expected {
@@ -456,6 +465,62 @@ func TestEmptySchema(t *testing.T) {
 	}
 }
 
+func TestEmptySchemaRefusedForPopulatedDatabase(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	// A schema with only comments defines no tables either
+	for _, schema := range []string{"", "-- nothing here\n"} {
+		if _, err := Open(schema, dbPath); !errors.Is(err, ErrEmptySchema) {
+			t.Fatalf("expected ErrEmptySchema from Open, got %v", err)
+		}
+		if _, err := Migrate(schema, dbPath); !errors.Is(err, ErrEmptySchema) {
+			t.Fatalf("expected ErrEmptySchema from Migrate, got %v", err)
+		}
+	}
+	if _, err := MigrateToNewFile("", dbPath, tempDBPath(t)); !errors.Is(err, ErrEmptySchema) {
+		t.Fatalf("expected ErrEmptySchema from MigrateToNewFile, got %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".backup"); !os.IsNotExist(err) {
+		t.Errorf("expected no backup to be made, got %v", err)
+	}
+
+	db, err = Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("expected the data to be kept, got %d rows, %v", count, err)
+	}
+	if _, err := MigrateDB("", db); !errors.Is(err, ErrEmptySchema) {
+		t.Fatalf("expected ErrEmptySchema from MigrateDB, got %v", err)
+	}
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.AllowEmptySchema = true
+	db, err = OpenWithOptions("", dbPath, opts)
+	if err != nil {
+		t.Fatalf("expected AllowEmptySchema to allow the migration: %v", err)
+	}
+	defer db.Close()
+	tables, err := GetTables(db)
+	if err != nil {
+		t.Fatalf("GetTables failed: %v", err)
+	}
+	if len(tables) != 0 {
+		t.Errorf("expected no tables, got %v", tables)
+	}
+}
+
 func TestNonExistentDatabasePath(t *testing.T) {
 	// Test with non-existent database path
 	_, err := Open(schemaV1, "/non/existent/path/db.sqlite")
@@ -959,28 +1024,89 @@ func DISABLED_TestCheckConstraintViolation(t *testing.T) {
 	t.Logf("Check constraint addition succeeded (but constraint may be violated)")
 }
 
-func DISABLED_TestCircularDependency(t *testing.T) {
-	dbPath := tempDBPath(t)
+func TestCircularDependency(t *testing.T) {
+	// Tables that refer to each other can't be copied in an order that
+	// satisfies their foreign keys, even with them enforced
+	dbPath := tempDBPath(t) + "?_foreign_keys=on"
 
-	// Create database with circular foreign key references
 	schemaV1 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, manager_id INTEGER);
 	CREATE TABLE managers (id INTEGER PRIMARY KEY, name TEXT, user_id INTEGER);`
 	db, err := Open(schemaV1, dbPath)
 	if err != nil {
 		t.Fatalf("failed to create db: %v", err)
 	}
+	if _, err := db.Exec(`INSERT INTO users VALUES (1, 'alice', 1), (2, 'bob', 1);
+		INSERT INTO managers VALUES (1, 'alice', 1)`); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
 	db.Close()
 
-	// Try to add circular foreign key constraints (currently succeeds)
 	schemaV2 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, manager_id INTEGER, FOREIGN KEY (manager_id) REFERENCES managers(id));
 	CREATE TABLE managers (id INTEGER PRIMARY KEY, name TEXT, user_id INTEGER, FOREIGN KEY (user_id) REFERENCES users(id));`
-	db2, err := Open(schemaV2, dbPath)
+	db, err = Open(schemaV2, dbPath)
 	if err != nil {
-		t.Fatalf("circular dependency addition failed: %v", err)
+		t.Fatalf("circular dependency migration failed: %v", err)
+	}
+	defer db.Close()
+
+	var users, managers int
+	if err := db.QueryRow("SELECT (SELECT COUNT(*) FROM users), (SELECT COUNT(*) FROM managers)").Scan(&users, &managers); err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	if users != 2 || managers != 1 {
+		t.Errorf("expected 2 users and 1 manager after migration, got %d and %d", users, managers)
+	}
+}
+
+func TestForeignKeyViolationsAfterMigration(t *testing.T) {
+	schemaV1 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, manager_id INTEGER);
+	CREATE TABLE managers (id INTEGER PRIMARY KEY, name TEXT, user_id INTEGER);`
+	schemaV2 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, manager_id INTEGER, FOREIGN KEY (manager_id) REFERENCES managers(id));
+	CREATE TABLE managers (id INTEGER PRIMARY KEY, name TEXT, user_id INTEGER, FOREIGN KEY (user_id) REFERENCES users(id));`
+	create := func(t *testing.T, dbPath string) {
+		db, err := Open(schemaV1, dbPath)
+		if err != nil {
+			t.Fatalf("failed to create db: %v", err)
+		}
+		// Manager 7 doesn't exist
+		if _, err := db.Exec(`INSERT INTO users VALUES (1, 'alice', 1), (2, 'bob', 7), (3, 'carol', 7);
+			INSERT INTO managers VALUES (1, 'alice', 1)`); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		db.Close()
 	}
-	defer db2.Close()
 
-	t.Logf("Circular dependency addition succeeded (but may cause issues)")
+	t.Run("reported", func(t *testing.T) {
+		dbPath := tempDBPath(t)
+		create(t, dbPath)
+		opts := DefaultOptions()
+		opts.Report = &MigrationReport{}
+		db, err := MigrateWithOptions(schemaV2, dbPath, opts)
+		if err != nil {
+			t.Fatalf("migration failed: %v", err)
+		}
+		db.Close()
+		want := []ConstraintViolation{{Table: "users", Constraint: "FOREIGN KEY", Columns: []string{"manager_id"}, Detail: "REFERENCES managers", Rows: 2}}
+		if fmt.Sprint(opts.Report.ForeignKeyViolations) != fmt.Sprint(want) {
+			t.Errorf("expected violations %v, got %v", want, opts.Report.ForeignKeyViolations)
+		}
+	})
+
+	t.Run("enforced", func(t *testing.T) {
+		dbPath := tempDBPath(t)
+		create(t, dbPath)
+		_, err := Migrate(schemaV2, dbPath+"?_foreign_keys=on")
+		var merr *MigrationError
+		if !errors.Is(err, ErrForeignKeyViolation) || !errors.As(err, &merr) || merr.Table != "users" {
+			t.Fatalf("expected a foreign key violation in users, got %v", err)
+		}
+		if !SchemasEqual(schemaV1, dbPath) {
+			t.Error("expected the database to keep its old schema")
+		}
+		if _, err := os.Stat(dbPath + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("expected the new database to be removed, got %v", err)
+		}
+	})
 }
 
 func DISABLED_TestViewNotPreserved(t *testing.T) {
@@ -1189,6 +1315,1921 @@ func TestLockFileCleanup(t *testing.T) {
 	}
 }
 
+func TestMigrationLockExclusive(t *testing.T) {
+	// The lock file is removed on every release, so waiters and newcomers
+	// race for old and new files; only one may ever hold the lock
+	filename := tempDBPath(t)
+	var holders, maxHolders int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				unlock, err := acquireMigrationLock(filename)
+				if err != nil {
+					t.Errorf("failed to acquire lock: %v", err)
+					return
+				}
+				n := atomic.AddInt32(&holders, 1)
+				for {
+					max := atomic.LoadInt32(&maxHolders)
+					if n <= max || atomic.CompareAndSwapInt32(&maxHolders, max, n) {
+						break
+					}
+				}
+				time.Sleep(100 * time.Microsecond)
+				atomic.AddInt32(&holders, -1)
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if maxHolders != 1 {
+		t.Errorf("expected the lock to have one holder at a time, got %d", maxHolders)
+	}
+}
+
+func TestBackupDuringConcurrentWrites(t *testing.T) {
+	dbPath := tempDBPath(t)
+	dsn := dbPath + "?_busy_timeout=5000&_journal_mode=WAL"
+
+	db, err := Open(schemaV1, dsn)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+
+	// Keep writing from another goroutine while backups are taken
+	stop := make(chan struct{})
+	writeErr := make(chan error, 1)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				writeErr <- nil
+				return
+			default:
+			}
+			if _, err := db.Exec("INSERT INTO users (name) VALUES (?)", fmt.Sprintf("user%d", i)); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+	}()
+
+	backupPath := dbPath + ".backup"
+	for i := 0; i < 5; i++ {
+		if err := backupDatabase(dsn, backupPath, 0644, DefaultOptions()); err != nil {
+			close(stop)
+			t.Fatalf("backup %d failed: %v", i, err)
+		}
+
+		backup, err := sql.Open("sqlite3", backupPath)
+		if err != nil {
+			close(stop)
+			t.Fatalf("failed to open backup: %v", err)
+		}
+		var result string
+		if err := backup.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil || result != "ok" {
+			backup.Close()
+			close(stop)
+			t.Fatalf("backup %d failed integrity check: %s %v", i, result, err)
+		}
+		var count int
+		if err := backup.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+			backup.Close()
+			close(stop)
+			t.Fatalf("failed to query backup: %v", err)
+		}
+		backup.Close()
+	}
+
+	close(stop)
+	if err := <-writeErr; err != nil {
+		t.Fatalf("concurrent write failed: %v", err)
+	}
+}
+
+func TestCompactTo(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	for i := 0; i < 2000; i++ {
+		if _, err := db.Exec("INSERT INTO users (name) VALUES (?)", strings.Repeat("x", 200)); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if _, err := db.Exec("DELETE FROM users WHERE id > 10"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	db.Close()
+
+	destPath := filepath.Join(t.TempDir(), "compact.db")
+	if err := CompactTo(dbPath, destPath); err != nil {
+		t.Fatalf("CompactTo failed: %v", err)
+	}
+
+	oldInfo, err := os.Stat(dbPath)
+	if err != nil {
+		t.Fatalf("failed to stat original: %v", err)
+	}
+	newInfo, err := os.Stat(destPath)
+	if err != nil {
+		t.Fatalf("failed to stat compacted copy: %v", err)
+	}
+	if newInfo.Size() >= oldInfo.Size() {
+		t.Fatalf("compacted copy is not smaller: %d >= %d", newInfo.Size(), oldInfo.Size())
+	}
+
+	// The copy should need no migration and keep its data
+	if !SchemasEqual(schemaV1, destPath) {
+		t.Fatalf("compacted copy has a different schema")
+	}
+	db2, err := Open(schemaV1, destPath)
+	if err != nil {
+		t.Fatalf("failed to open compacted copy: %v", err)
+	}
+	defer db2.Close()
+	var count int
+	if err := db2.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 10 {
+		t.Fatalf("expected 10 rows in compacted copy, got %d: %v", count, err)
+	}
+
+	// Refuse to overwrite an existing file
+	if err := CompactTo(dbPath, destPath); err == nil {
+		t.Fatalf("CompactTo should fail when destination exists")
+	}
+}
+
+func TestMigrationPreservesAutoincrementSequence(t *testing.T) {
+	dbPath := tempDBPath(t)
+	schemaV1 := `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);`
+	schemaV2 := `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, email TEXT);`
+
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO users (name) VALUES ('alice'), ('bob'), ('carol')")
+	if err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	// Deleting the newest row leaves the sequence ahead of the largest id
+	_, err = db.Exec("DELETE FROM users WHERE name = 'carol'")
+	if err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	db.Close()
+
+	db2, err := Open(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db2.Close()
+
+	var count int
+	if err := db2.QueryRow("SELECT COUNT(*) FROM sqlite_sequence WHERE name = 'users'").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("expected exactly one sqlite_sequence row for users, got %d: %v", count, err)
+	}
+
+	res, err := db2.Exec("INSERT INTO users (name) VALUES ('dave')")
+	if err != nil {
+		t.Fatalf("failed to insert after migration: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatalf("failed to get inserted id: %v", err)
+	}
+	if id != 4 {
+		t.Fatalf("expected AUTOINCREMENT to continue at 4, got %d", id)
+	}
+}
+
+func TestMigrationRunAnalyze(t *testing.T) {
+	dbPath := tempDBPath(t)
+	schemaV1 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT); CREATE INDEX idx_users_name ON users(name);`
+	schemaV2 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT); CREATE INDEX idx_users_name ON users(name);`
+
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO users (name) VALUES ('alice'), ('bob')")
+	if err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.RunAnalyze = true
+	db2, err := OpenWithOptions(schemaV2, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db2.Close()
+
+	var count int
+	if err := db2.QueryRow("SELECT COUNT(*) FROM sqlite_stat1 WHERE tbl = 'users'").Scan(&count); err != nil {
+		t.Fatalf("sqlite_stat1 not populated after migration: %v", err)
+	}
+	if count == 0 {
+		t.Fatalf("expected statistics for users after migration")
+	}
+
+	// Internal tables must not be reported as user tables
+	tables, err := GetTables(db2)
+	if err != nil {
+		t.Fatalf("GetTables failed: %v", err)
+	}
+	if len(tables) != 1 || tables[0] != "users" {
+		t.Fatalf("expected only users table, got %v", tables)
+	}
+
+	// The statistics table must not make the schema look different
+	if !SchemasEqual(schemaV2, dbPath) {
+		t.Fatalf("schema should be equal after analyze")
+	}
+}
+
+func TestRunAnalyzeInPlace(t *testing.T) {
+	dbPath := tempDBPath(t)
+	const schemaV1 = `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT); CREATE INDEX idx_users_name ON users(name);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT); CREATE INDEX idx_posts_title ON posts(title);`
+	const schemaV2 = `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT); CREATE INDEX idx_users_name ON users(name);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT); CREATE INDEX idx_posts_title ON posts(title);`
+
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice'), ('bob'); INSERT INTO posts (title) VALUES ('hello'); ANALYZE posts"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.RunAnalyze = true
+	if _, err := MigrateDBWithOptions(schemaV2, db, opts); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	for _, table := range []string{"users", "posts"} {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_stat1 WHERE tbl = ?", table).Scan(&count); err != nil || count == 0 {
+			t.Errorf("expected statistics for %s after migration, got %d, %v", table, count, err)
+		}
+	}
+}
+
+func TestGetTablesExcludesInternalTables(t *testing.T) {
+	dbPath := tempDBPath(t)
+	schemaV1 := `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);
+	CREATE TABLE posts (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT);`
+	schemaV2 := `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, email TEXT);
+	CREATE TABLE posts (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT);`
+
+	// AUTOINCREMENT creates sqlite_sequence
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO users (name) VALUES ('alice'); INSERT INTO posts (title) VALUES ('hello')")
+	if err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	tables, err := GetTables(db)
+	if err != nil {
+		t.Fatalf("GetTables failed: %v", err)
+	}
+	for _, table := range tables {
+		if strings.HasPrefix(table, "sqlite_") {
+			t.Fatalf("GetTables returned internal table %s", table)
+		}
+	}
+	if len(tables) != 2 {
+		t.Fatalf("expected 2 user tables, got %v", tables)
+	}
+	db.Close()
+
+	db2, err := Open(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db2.Close()
+
+	// sqlite_sequence must hold exactly one row per table, not copies of the old rows
+	var count int
+	if err := db2.QueryRow("SELECT COUNT(*) FROM sqlite_sequence").Scan(&count); err != nil {
+		t.Fatalf("failed to query sqlite_sequence: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 sqlite_sequence rows, got %d", count)
+	}
+}
+
+func TestTableRename(t *testing.T) {
+	dbPath := tempDBPath(t)
+	schemaV1 := `CREATE TABLE orders (id INTEGER PRIMARY KEY, item TEXT);`
+	schemaV2 := `CREATE TABLE purchases (id INTEGER PRIMARY KEY, item TEXT, quantity INTEGER);`
+
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO orders (item) VALUES ('widget'), ('gadget')")
+	if err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	// Renaming a table that doesn't exist must be rejected
+	opts := DefaultOptions()
+	opts.TableRenames = []TableRename{{From: "missing", To: "purchases"}}
+	if _, err := OpenWithOptions(schemaV2, dbPath, opts); err == nil {
+		t.Fatalf("rename of a non-existent table should fail")
+	}
+	opts.TableRenames = []TableRename{{From: "orders", To: "missing"}}
+	if _, err := OpenWithOptions(schemaV2, dbPath, opts); err == nil {
+		t.Fatalf("rename to a table missing from the new schema should fail")
+	}
+
+	opts.TableRenames = []TableRename{{From: "orders", To: "purchases"}}
+	db2, err := OpenWithOptions(schemaV2, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db2.Close()
+
+	var count int
+	if err := db2.QueryRow("SELECT COUNT(*) FROM purchases").Scan(&count); err != nil || count != 2 {
+		t.Fatalf("expected 2 rows in purchases, got %d: %v", count, err)
+	}
+	var item string
+	if err := db2.QueryRow("SELECT item FROM purchases WHERE id=2").Scan(&item); err != nil || item != "gadget" {
+		t.Fatalf("renamed table data not preserved: %v", err)
+	}
+}
+
+func TestSchemasEqualIgnoresVersionTable(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+
+	// The database has a version table that the schema doesn't mention
+	var name string
+	if err := db.QueryRow("SELECT name FROM sqlite_master WHERE name = ?", versionTableName).Scan(&name); err != nil {
+		t.Fatalf("version table not created: %v", err)
+	}
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Fatalf("schema should be equal despite version table")
+	}
+
+	// A version table with a different definition must not trigger a migration either
+	_, err = db.Exec("ALTER TABLE " + versionTableName + " ADD COLUMN extra TEXT")
+	if err != nil {
+		t.Fatalf("failed to alter version table: %v", err)
+	}
+	_, err = db.Exec("CREATE INDEX idx_version_hash ON " + versionTableName + "(hash)")
+	if err != nil {
+		t.Fatalf("failed to index version table: %v", err)
+	}
+	db.Close()
+
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Fatalf("schema should be equal despite version table drift")
+	}
+}
+
+func TestMigrateDataBetweenInMemory(t *testing.T) {
+	// A programmatically built in-memory source; one connection so it stays a single database
+	oldDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open source db: %v", err)
+	}
+	defer oldDB.Close()
+	oldDB.SetMaxOpenConns(1)
+	if _, err := oldDB.Exec(schemaV1WithPosts); err != nil {
+		t.Fatalf("failed to create source schema: %v", err)
+	}
+	_, err = oldDB.Exec("INSERT INTO users (name) VALUES ('alice'); INSERT INTO posts (title) VALUES ('hello')")
+	if err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	newDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open destination db: %v", err)
+	}
+	defer newDB.Close()
+	newDB.SetMaxOpenConns(1)
+	if _, err := newDB.Exec(schemaV2); err != nil {
+		t.Fatalf("failed to create destination schema: %v", err)
+	}
+
+	if err := MigrateDataBetween(oldDB, newDB); err != nil {
+		t.Fatalf("MigrateDataBetween failed: %v", err)
+	}
+
+	var name string
+	if err := newDB.QueryRow("SELECT name FROM users WHERE id=1").Scan(&name); err != nil || name != "alice" {
+		t.Fatalf("data not copied: %v", err)
+	}
+	tables, err := GetTables(newDB)
+	if err != nil {
+		t.Fatalf("GetTables failed: %v", err)
+	}
+	if len(tables) != 1 {
+		t.Fatalf("posts table should not be created in destination, got %v", tables)
+	}
+}
+
+func TestBackupModes(t *testing.T) {
+	schemaV3 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, age INTEGER);`
+
+	t.Run("Fail", func(t *testing.T) {
+		dbPath := tempDBPath(t)
+		db, err := Open(schemaV1, dbPath)
+		if err != nil {
+			t.Fatalf("failed to create db: %v", err)
+		}
+		db.Close()
+		db, err = Open(schemaV2, dbPath)
+		if err != nil {
+			t.Fatalf("first migration failed: %v", err)
+		}
+		db.Close()
+
+		opts := DefaultOptions()
+		opts.BackupMode = BackupFail
+		_, err = OpenWithOptions(schemaV3, dbPath, opts)
+		if !errors.Is(err, ErrBackupExists) {
+			t.Fatalf("expected ErrBackupExists, got %v", err)
+		}
+
+		// Neither the database nor the old backup may have been touched
+		if !SchemasEqual(schemaV2, dbPath) {
+			t.Fatalf("database changed despite failed migration")
+		}
+		if !SchemasEqual(schemaV1, dbPath+".backup") {
+			t.Fatalf("existing backup was overwritten")
+		}
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		dbPath := tempDBPath(t)
+		opts := DefaultOptions()
+		opts.BackupMode = BackupRotate
+		for _, schema := range []string{schemaV1, schemaV2, schemaV3} {
+			db, err := OpenWithOptions(schema, dbPath, opts)
+			if err != nil {
+				t.Fatalf("migration failed: %v", err)
+			}
+			db.Close()
+		}
+
+		// Newest backup first
+		if !SchemasEqual(schemaV2, dbPath+".backup") {
+			t.Fatalf(".backup should hold the previous schema")
+		}
+		if !SchemasEqual(schemaV1, dbPath+".backup.1") {
+			t.Fatalf(".backup.1 should hold the original schema")
+		}
+		if _, err := os.Stat(dbPath + ".backup.2"); err == nil {
+			t.Fatalf("unexpected .backup.2")
+		}
+	})
+}
+
+func TestSchemasEqualNormalizesDefaults(t *testing.T) {
+	dbPath := tempDBPath(t)
+	schemaV1 := `CREATE TABLE events (
+		id INTEGER PRIMARY KEY,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		count INTEGER DEFAULT 0
+	);`
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	equivalent := []string{
+		`CREATE TABLE events (id INTEGER PRIMARY KEY, created_at DATETIME DEFAULT current_timestamp, count INTEGER DEFAULT 0);`,
+		`create table events (id integer primary key, created_at DATETIME default Current_Timestamp, count INTEGER default (0));`,
+		`CREATE TABLE events (
+			id INTEGER PRIMARY KEY, -- the id
+			created_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
+			count INTEGER DEFAULT ((0))
+		);`,
+	}
+	for _, schema := range equivalent {
+		if !SchemasEqual(schema, dbPath) {
+			t.Fatalf("schema should be equal: %s", schema)
+		}
+	}
+
+	different := []string{
+		`CREATE TABLE events (id INTEGER PRIMARY KEY, created_at DATETIME DEFAULT CURRENT_DATE, count INTEGER DEFAULT 0);`,
+		`CREATE TABLE events (id INTEGER PRIMARY KEY, created_at DATETIME DEFAULT CURRENT_TIMESTAMP, count INTEGER DEFAULT (0 + 1));`,
+		`CREATE TABLE events (id INTEGER PRIMARY KEY, created_at DATETIME DEFAULT 'CURRENT_TIMESTAMP', count INTEGER DEFAULT 0);`,
+	}
+	for _, schema := range different {
+		if SchemasEqual(schema, dbPath) {
+			t.Fatalf("schema should differ: %s", schema)
+		}
+	}
+}
+
+func TestSchemasEqualIgnoresIfNotExists(t *testing.T) {
+	plain := `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		CREATE UNIQUE INDEX idx_users_email ON users (email);
+		CREATE VIEW user_emails AS SELECT email FROM users;
+		CREATE TRIGGER users_guard BEFORE DELETE ON users BEGIN SELECT RAISE(ABORT, 'no'); END;
+	`
+	idempotent := `
+		CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY, email TEXT);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+		CREATE VIEW if not exists user_emails AS SELECT email FROM users;
+		CREATE TRIGGER IF  NOT  EXISTS users_guard BEFORE DELETE ON users BEGIN SELECT RAISE(ABORT, 'no'); END;
+	`
+
+	for _, schemas := range [][2]string{{plain, idempotent}, {idempotent, plain}} {
+		dbPath := tempDBPath(t)
+		db, err := Open(schemas[0], dbPath)
+		if err != nil {
+			t.Fatalf("failed to create db: %v", err)
+		}
+		db.Close()
+		if !SchemasEqual(schemas[1], dbPath) {
+			t.Errorf("schema should be equal: %s", schemas[1])
+		}
+		db, result, err := OpenWithResult(schemas[1], dbPath)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		db.Close()
+		if result != Unchanged {
+			t.Errorf("expected the database to be opened unchanged, got %s", result)
+		}
+	}
+
+	if canonicalSQL("CREATE TABLE IF NOT EXISTS t (a)") != canonicalSQL("CREATE TABLE t (a)") {
+		t.Error("expected IF NOT EXISTS to be normalized out")
+	}
+	if canonicalSQL("DROP TABLE IF EXISTS t") != canonicalSQL("DROP TABLE t") {
+		t.Error("expected IF EXISTS to be normalized out")
+	}
+	if canonicalSQL("CREATE TABLE t (a CHECK (a IF NOT EXISTS))") == canonicalSQL("CREATE TABLE t (a CHECK (a))") {
+		t.Error("expected IF NOT EXISTS outside the statement header to be kept")
+	}
+}
+
+func TestSchemasEqualStrings(t *testing.T) {
+	reformatted := `-- users of the app
+	create table users (
+		id integer primary key,
+		name TEXT
+	);`
+	equal, err := SchemasEqualStrings(schemaV1, reformatted)
+	if err != nil {
+		t.Fatalf("comparison failed: %v", err)
+	}
+	if !equal {
+		t.Error("schemas differing only in formatting should be equal")
+	}
+
+	equal, err = SchemasEqualStrings(schemaV1, schemaV2)
+	if err != nil {
+		t.Fatalf("comparison failed: %v", err)
+	}
+	if equal {
+		t.Error("schemas with different columns should differ")
+	}
+
+	if _, err := SchemasEqualStrings(schemaV1, "CREATE TABLE broken ("); err == nil {
+		t.Error("expected an error for an invalid schema")
+	}
+}
+
+func TestIgnoreColumnOrder(t *testing.T) {
+	dbPath := tempDBPath(t)
+	schemaV1 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT DEFAULT (lower('X')), UNIQUE (name, email));`
+	reordered := `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT DEFAULT (lower('X')), name TEXT, UNIQUE (name, email));`
+	changed := `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, name TEXT, UNIQUE (name, email));`
+
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	// Strict by default
+	if SchemasEqual(reordered, dbPath) {
+		t.Fatalf("reordered columns should differ by default")
+	}
+
+	opts := DefaultOptions()
+	opts.IgnoreColumnOrder = true
+	if !SchemasEqualWithOptions(reordered, dbPath, opts) {
+		t.Fatalf("reordered columns should be equal with IgnoreColumnOrder")
+	}
+	if SchemasEqualWithOptions(changed, dbPath, opts) {
+		t.Fatalf("changed column definition should still differ")
+	}
+
+	// Opening with the reordered schema must not migrate
+	db, err = OpenWithOptions(reordered, dbPath, opts)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	db.Close()
+	if _, err := os.Stat(dbPath + ".backup"); err == nil {
+		t.Fatalf("reordered schema should not have caused a migration")
+	}
+}
+
+func TestConcurrentCreation(t *testing.T) {
+	const numGoroutines = 20
+	const numIterations = 10
+
+	for iter := 0; iter < numIterations; iter++ {
+		dbPath := filepath.Join(t.TempDir(), "sub", "test.db")
+
+		start := make(chan struct{})
+		results := make(chan error, numGoroutines)
+		for i := 0; i < numGoroutines; i++ {
+			go func() {
+				<-start
+				db, err := Open(schemaV1, dbPath)
+				if err == nil {
+					db.Close()
+				}
+				results <- err
+			}()
+		}
+		close(start) // Start all processes at the same time
+
+		for i := 0; i < numGoroutines; i++ {
+			if err := <-results; err != nil {
+				t.Fatalf("[%d] concurrent creation failed: %v", iter, err)
+			}
+		}
+
+		// The schema must have been executed and recorded exactly once
+		db, err := sql.Open("sqlite3", dbPath)
+		if err != nil {
+			t.Fatalf("[%d] failed to open db: %v", iter, err)
+		}
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + versionTableName).Scan(&count); err != nil {
+			db.Close()
+			t.Fatalf("[%d] failed to count versions: %v", iter, err)
+		}
+		db.Close()
+		if count != 1 {
+			t.Fatalf("[%d] expected 1 version row, got %d", iter, count)
+		}
+		if _, err := os.Stat(dbPath + ".backup"); err == nil {
+			t.Fatalf("[%d] concurrent creation should not have migrated", iter)
+		}
+	}
+}
+
+func TestCreateDirsOption(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	dbPath := filepath.Join(dir, "test.db")
+
+	opts := DefaultOptions()
+	opts.NoCreateDirs = true
+	if _, err := OpenWithOptions(schemaV1, dbPath, opts); err == nil {
+		t.Fatalf("should fail when the directory doesn't exist and NoCreateDirs is set")
+	}
+	if _, err := os.Stat(dir); err == nil {
+		t.Fatalf("directory should not have been created")
+	}
+
+	opts.NoCreateDirs = false
+	opts.DirMode = 0700
+	db, err := OpenWithOptions(schemaV1, dbPath, opts)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("directory not created: %v", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Fatalf("expected directory mode 0700, got %o", info.Mode().Perm())
+	}
+
+	// An existing directory is fine with NoCreateDirs
+	opts.NoCreateDirs = true
+	db, err = OpenWithOptions(schemaV1, filepath.Join(dir, "other.db"), opts)
+	if err != nil {
+		t.Fatalf("failed to create db in existing directory: %v", err)
+	}
+	db.Close()
+
+	// Zero Options create directories, and a zero DirMode means the default,
+	// not mode 0000
+	zeroDir := filepath.Join(t.TempDir(), "zero", "mode")
+	db, err = OpenWithOptions(schemaV1, filepath.Join(zeroDir, "test.db"), &Options{})
+	if err != nil {
+		t.Fatalf("failed to create db with zero DirMode: %v", err)
+	}
+	db.Close()
+	if info, err := os.Stat(zeroDir); err != nil || info.Mode().Perm()&0700 != 0700 {
+		t.Fatalf("expected a zero DirMode to create a usable directory, got %v, %v", info, err)
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	dbPath := tempDBPath(t)
+	opts := DefaultOptions()
+	opts.FileMode = 0600
+
+	db, err := OpenWithOptions(schemaV1, dbPath, opts)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+	checkMode := func(path string, want os.FileMode) {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", path, err)
+		}
+		if info.Mode().Perm() != want {
+			t.Fatalf("%s: expected mode %o, got %o", path, want, info.Mode().Perm())
+		}
+	}
+	checkMode(dbPath, 0600)
+
+	db, err = OpenWithOptions(schemaV2, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	db.Close()
+	checkMode(dbPath, 0600)
+	checkMode(dbPath+".backup", 0600)
+
+	// Without FileMode, a migration keeps the mode of the existing database
+	schemaV3 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, age INTEGER);`
+	if err := os.Chmod(dbPath, 0640); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+	db, err = Open(schemaV3, dbPath)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	db.Close()
+	checkMode(dbPath, 0640)
+	checkMode(dbPath+".backup", 0640)
+}
+
+func TestBackupCreatedWithMode(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	// The backup must never exist with a wider mode than requested, so it is
+	// created with the mode before VACUUM INTO writes the data into it
+	backupPath := dbPath + ".backup"
+	if err := backupDatabase(dbPath, backupPath, 0600, DefaultOptions()); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		t.Fatalf("failed to stat backup: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected backup mode 600, got %o", info.Mode().Perm())
+	}
+	backup, err := sql.Open("sqlite3", backupPath)
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer backup.Close()
+	if ok, err := schemaMatches(backup, schemaV1, DefaultOptions()); err != nil || !ok {
+		t.Fatalf("backup does not hold the schema: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestOpenWithResult(t *testing.T) {
+	dbPath := tempDBPath(t)
+
+	steps := []struct {
+		schema string
+		want   Result
+	}{
+		{schemaV1, Created},
+		{schemaV1, Unchanged},
+		{schemaV2, Migrated},
+		{schemaV2, Unchanged},
+	}
+	for i, step := range steps {
+		db, result, err := OpenWithResult(step.schema, dbPath)
+		if err != nil {
+			t.Fatalf("step %d: open failed: %v", i, err)
+		}
+		db.Close()
+		if result != step.want {
+			t.Errorf("step %d: expected %v, got %v", i, step.want, result)
+		}
+	}
+}
+
+func TestExpectedFromVersion(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	// The database is at version 1, so expecting version 2 must fail
+	opts := DefaultOptions()
+	opts.ExpectedFromVersion = 2
+	_, err = OpenWithOptions(schemaV2, dbPath, opts)
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".backup"); !os.IsNotExist(err) {
+		t.Errorf("expected no backup after refused migration, got %v", err)
+	}
+
+	opts.ExpectedFromVersion = 1
+	db, err = OpenWithOptions(schemaV2, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration from expected version failed: %v", err)
+	}
+	defer db.Close()
+	version, err := getCurrentSchemaVersion(db)
+	if err != nil {
+		t.Fatalf("failed to get version: %v", err)
+	}
+	if version.Version != 2 {
+		t.Errorf("expected version 2, got %d", version.Version)
+	}
+}
+
+func TestReapplyCurrentVersusDowngrade(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+
+	// Someone changes the schema by hand; opening with the current schema
+	// again is a re-apply, not a downgrade
+	if _, err := db.Exec("CREATE TABLE scratch (x INTEGER)"); err != nil {
+		t.Fatalf("failed to alter schema: %v", err)
+	}
+	db.Close()
+	db, result, err := OpenWithResult(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("re-applying the current schema failed: %v", err)
+	}
+	if result != Migrated {
+		t.Errorf("expected %v, got %v", Migrated, result)
+	}
+	tables, err := GetTables(db)
+	if err != nil {
+		t.Fatalf("failed to get tables: %v", err)
+	}
+	if len(tables) != 1 || tables[0] != "users" {
+		t.Errorf("expected only users table after re-apply, got %v", tables)
+	}
+	db.Close()
+
+	// Two versions recorded at the same number (e.g. by concurrent tools):
+	// the other one is not older than the current version
+	db, err = sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO "+versionTableName+" (version, hash, timestamp) VALUES (2, ?, datetime('now'))", calculateSchemaHash(schemaV2)); err != nil {
+		t.Fatalf("failed to insert version: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO "+versionTableName+" (version, hash, timestamp) VALUES (2, ?, datetime('now'))", calculateSchemaHash(schemaV1)); err != nil {
+		t.Fatalf("failed to insert version: %v", err)
+	}
+	if forward, err := isForwardMigration(db, schemaV2); err != nil || !forward {
+		t.Errorf("expected schema at the current version number to be forward, got %v, %v", forward, err)
+	}
+
+	// A schema last applied at an older version is a true downgrade
+	if _, err := db.Exec("INSERT INTO "+versionTableName+" (version, hash, timestamp) VALUES (3, ?, datetime('now'))", calculateSchemaHash("CREATE TABLE other (id INTEGER);")); err != nil {
+		t.Fatalf("failed to insert version: %v", err)
+	}
+	if forward, err := isForwardMigration(db, schemaV2); err != nil || forward {
+		t.Errorf("expected downgrade to be detected, got %v, %v", forward, err)
+	}
+	db.Close()
+}
+
+func TestAdoptUnversionedDatabase(t *testing.T) {
+	dbPath := tempDBPath(t)
+
+	// A database created without autosqlite
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if _, err := db.Exec(schemaV1); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	existing, err := DumpSchema(db)
+	if err != nil {
+		t.Fatalf("DumpSchema failed: %v", err)
+	}
+	db.Close()
+	if existing != "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);" {
+		t.Errorf("unexpected dumped schema: %q", existing)
+	}
+
+	db, err = Open(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM users").Scan(&name); err != nil || name != "alice" {
+		t.Errorf("expected data to be preserved, got %q, %v", name, err)
+	}
+
+	applied, err := AppliedSchemas(db)
+	if err != nil {
+		t.Fatalf("AppliedSchemas failed: %v", err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(applied))
+	}
+	if applied[0].Version != 1 || applied[0].Hash != calculateSchemaHash(existing) || applied[0].SchemaSQL != existing {
+		t.Errorf("expected existing schema recorded as version 1, got %+v", applied[0])
+	}
+	if applied[1].Version != 2 || applied[1].Hash != calculateSchemaHash(schemaV2) {
+		t.Errorf("expected new schema recorded as version 2, got %+v", applied[1])
+	}
+}
+
+func TestMigrationError(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('al')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	checkPhase := func(err error, phase, table string) {
+		t.Helper()
+		var merr *MigrationError
+		if !errors.As(err, &merr) {
+			t.Fatalf("expected a MigrationError, got %v", err)
+		}
+		if merr.Phase != phase || merr.Table != table {
+			t.Errorf("expected phase %q table %q, got phase %q table %q", phase, table, merr.Phase, merr.Table)
+		}
+	}
+
+	_, err = Migrate(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, oops);;; CREATE TABLE (`, dbPath)
+	checkPhase(err, PhaseSchema, "")
+
+	// Existing data violates the new CHECK constraint
+	_, err = Migrate(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT CHECK (length(name) > 5));`, dbPath)
+	checkPhase(err, PhaseDataCopy, "users")
+
+	opts := DefaultOptions()
+	opts.BackupMode = BackupFail
+	_, err = MigrateWithOptions(schemaV2, dbPath, opts)
+	checkPhase(err, PhaseBackup, "")
+	if !errors.Is(err, ErrBackupExists) {
+		t.Errorf("expected MigrationError to wrap ErrBackupExists, got %v", err)
+	}
+}
+
+func TestSchemaErrorReportsStatement(t *testing.T) {
+	schema := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER);
+CREATE INDEX idx_posts_user ON posts(usr_id);`
+
+	_, err := Open(schema, tempDBPath(t))
+	var serr *StatementError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected a StatementError, got %v", err)
+	}
+	if serr.Index != 3 || serr.Line != 3 || !strings.Contains(serr.SQL, "idx_posts_user") {
+		t.Errorf("unexpected statement error: %+v", serr)
+	}
+	if !strings.Contains(err.Error(), "CREATE INDEX idx_posts_user ON posts(usr_id)") {
+		t.Errorf("expected error to include the failing statement, got %v", err)
+	}
+
+	// Migrations report the statement too
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+	_, err = Open(schema, dbPath)
+	if !errors.As(err, &serr) || serr.Index != 3 {
+		t.Errorf("expected a StatementError for statement 3, got %v", err)
+	}
+}
+
+func TestMigratePreview(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	// Discarding leaves the database untouched
+	previewPath, preview, _, discard, err := MigratePreview(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("MigratePreview failed: %v", err)
+	}
+	var count int
+	if err := preview.QueryRow("SELECT COUNT(*) FROM users WHERE email IS NULL").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("expected migrated data in preview, got %d, %v", count, err)
+	}
+	if _, err := os.Stat(dbPath + ".migration.lock"); err != nil {
+		t.Errorf("expected migration lock to be held during preview: %v", err)
+	}
+	if err := discard(); err != nil {
+		t.Fatalf("discard failed: %v", err)
+	}
+	if _, err := os.Stat(previewPath); !os.IsNotExist(err) {
+		t.Errorf("expected preview file to be removed, got %v", err)
+	}
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Errorf("expected database to keep its schema after discard")
+	}
+
+	// Committing replaces the database and records the version
+	_, preview, commit, _, err := MigratePreview(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("MigratePreview failed: %v", err)
+	}
+	if err := commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	if err := commit(); err == nil {
+		t.Errorf("expected second commit to fail")
+	}
+	if !SchemasEqual(schemaV2, dbPath) {
+		t.Errorf("expected database to have the new schema after commit")
+	}
+	db, result, err := OpenWithResult(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	if result != Unchanged {
+		t.Errorf("expected %v after commit, got %v", Unchanged, result)
+	}
+	version, err := getCurrentSchemaVersion(db)
+	if err != nil || version.Version != 2 {
+		t.Errorf("expected version 2 after commit, got %v, %v", version, err)
+	}
+}
+
+func TestPreservePrevious(t *testing.T) {
+	dbPath := tempDBPath(t)
+	dir := filepath.Dir(dbPath)
+	opts := DefaultOptions()
+	opts.PreservePrevious = "test.v{version}.db"
+
+	schemaV3 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, age INTEGER);`
+	for _, schema := range []string{schemaV1, schemaV2, schemaV3} {
+		db, err := OpenWithOptions(schema, dbPath, opts)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		db.Close()
+	}
+
+	for version, schema := range map[int]string{1: schemaV1, 2: schemaV2} {
+		path := filepath.Join(dir, fmt.Sprintf("test.v%d.db", version))
+		if !SchemasEqual(schema, path) {
+			t.Errorf("expected %s to hold the version %d schema", path, version)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test.v3.db")); !os.IsNotExist(err) {
+		t.Errorf("expected no file for the current version, got %v", err)
+	}
+
+	// An existing preserved file is never overwritten
+	if err := os.WriteFile(filepath.Join(dir, "test.v3.db"), []byte("keep"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := OpenWithOptions(schemaV1WithPosts, dbPath, opts); err == nil {
+		t.Errorf("expected migration to fail when the preserved file exists")
+	}
+	if !SchemasEqual(schemaV3, dbPath) {
+		t.Errorf("expected database to be unchanged after failed migration")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dbPath := tempDBPath(t)
+	opts := DefaultOptions()
+	opts.ReadOnly = true
+
+	if _, err := OpenWithOptions(schemaV1, dbPath, opts); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly for a missing database, got %v", err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("expected read-only Open not to create the database")
+	}
+
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	db, result, err := OpenWithResultOptions(schemaV1, dbPath+"?_busy_timeout=100", opts)
+	if err != nil {
+		t.Fatalf("read-only open failed: %v", err)
+	}
+	if result != Unchanged {
+		t.Errorf("expected %v, got %v", Unchanged, result)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Errorf("failed to read: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err == nil {
+		t.Errorf("expected write to a read-only database to fail")
+	}
+	db.Close()
+
+	if _, err := OpenWithOptions(schemaV2, dbPath, opts); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly when a migration is needed, got %v", err)
+	}
+	if _, err := MigrateWithOptions(schemaV2, dbPath, opts); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly from Migrate, got %v", err)
+	}
+	for _, suffix := range []string{".backup", ".migration.lock", "-journal"} {
+		if _, err := os.Stat(dbPath + suffix); !os.IsNotExist(err) {
+			t.Errorf("expected no %s file, got %v", suffix, err)
+		}
+	}
+}
+
+func TestMigrationReport(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1WithPosts, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	for _, stmt := range []string{
+		"INSERT INTO users (name) VALUES ('alice'), ('bob')",
+		"INSERT INTO posts (title) VALUES ('hello')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	var report MigrationReport
+	opts := DefaultOptions()
+	opts.Report = &report
+	db, err = OpenWithOptions(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT); CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT);`, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	db.Close()
+
+	stats := report.Stats
+	if stats.TablesCopied != 2 || stats.RowsCopied != 3 {
+		t.Errorf("expected 2 tables and 3 rows copied, got %d and %d", stats.TablesCopied, stats.RowsCopied)
+	}
+	info, err := os.Stat(dbPath + ".backup")
+	if err != nil {
+		t.Fatalf("failed to stat backup: %v", err)
+	}
+	if stats.BackupBytes != info.Size() {
+		t.Errorf("expected BackupBytes %d, got %d", info.Size(), stats.BackupBytes)
+	}
+	if stats.Duration <= 0 {
+		t.Errorf("expected a positive duration, got %v", stats.Duration)
+	}
+
+	// Opening without a migration resets the report
+	db, err = OpenWithOptions(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT); CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT);`, dbPath, opts)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	db.Close()
+	if report.Stats != (Stats{}) {
+		t.Errorf("expected empty stats when nothing was migrated, got %+v", report.Stats)
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	uniqueSchema := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT UNIQUE);`
+
+	setup := func() string {
+		dbPath := tempDBPath(t)
+		db, err := Open(schemaV1, dbPath)
+		if err != nil {
+			t.Fatalf("failed to create db: %v", err)
+		}
+		defer db.Close()
+		if _, err := db.Exec("INSERT INTO users (id, name) VALUES (1, 'alice'), (2, 'alice'), (3, 'bob')"); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		return dbPath
+	}
+
+	if _, err := Open(uniqueSchema, setup()); err == nil {
+		t.Errorf("expected the default policy to abort on the duplicate")
+	}
+
+	tests := []struct {
+		policy  ConflictPolicy
+		aliceID int
+	}{
+		{ConflictIgnore, 1},
+		{ConflictReplace, 2},
+	}
+	for _, tt := range tests {
+		var report MigrationReport
+		opts := DefaultOptions()
+		opts.ConflictPolicy = tt.policy
+		opts.Report = &report
+		db, err := OpenWithOptions(uniqueSchema, setup(), opts)
+		if err != nil {
+			t.Fatalf("policy %d: migration failed: %v", tt.policy, err)
+		}
+		var count, aliceID int
+		db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+		db.QueryRow("SELECT id FROM users WHERE name = 'alice'").Scan(&aliceID)
+		db.Close()
+		if count != 2 || aliceID != tt.aliceID {
+			t.Errorf("policy %d: expected 2 rows with alice at id %d, got %d rows and id %d", tt.policy, tt.aliceID, count, aliceID)
+		}
+		if report.Stats.RowsCopied != 2 || report.Stats.RowsSkipped != 1 {
+			t.Errorf("policy %d: expected 2 rows copied and 1 skipped, got %+v", tt.policy, report.Stats)
+		}
+	}
+}
+
+func TestAddedNotNullColumnWithoutDefault(t *testing.T) {
+	strictSchema := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT NOT NULL);`
+
+	// An empty table can gain the column
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+	db, err = Open(strictSchema, dbPath)
+	if err != nil {
+		t.Fatalf("migration of empty table failed: %v", err)
+	}
+	db.Close()
+
+	// With rows to copy, the column is named in the error
+	dbPath = tempDBPath(t)
+	db, err = Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice'), (NULL)"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+	_, err = Open(strictSchema, dbPath)
+	if !errors.Is(err, ErrConstraintViolation) || !strings.Contains(err.Error(), "email") {
+		t.Errorf("expected ErrConstraintViolation naming email, got %v", err)
+	}
+
+	// NULLs in an existing column are a different failure
+	if _, err := Open(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);`, dbPath); err == nil || errors.Is(err, ErrConstraintViolation) {
+		t.Errorf("expected a plain NOT NULL failure for an existing column, got %v", err)
+	}
+}
+
+func TestBackfills(t *testing.T) {
+	slugSchema := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, slug TEXT NOT NULL);`
+
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('Alice Smith'), ('Bob')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	// An expression that refers to a column the old table doesn't have
+	opts := DefaultOptions()
+	opts.Backfills = map[string]string{"users.slug": "lower(title)"}
+	if _, err := OpenWithOptions(slugSchema, dbPath, opts); err == nil || !strings.Contains(err.Error(), "slug") {
+		t.Errorf("expected an invalid backfill error, got %v", err)
+	}
+
+	opts.Backfills = map[string]string{"users.slug": "lower(replace(name, ' ', '-'))"}
+	db, err = OpenWithOptions(slugSchema, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration with backfill failed: %v", err)
+	}
+	defer db.Close()
+
+	var slugs []string
+	rows, err := db.Query("SELECT slug FROM users ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			t.Fatalf("failed to scan: %v", err)
+		}
+		slugs = append(slugs, slug)
+	}
+	if want := []string{"alice-smith", "bob"}; !slices.Equal(slugs, want) {
+		t.Errorf("expected slugs %v, got %v", want, slugs)
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1WithPosts, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		if _, err := db.Exec("INSERT INTO posts (title) VALUES (?)", strings.Repeat("x", 200)); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	db.Close()
+
+	backupPath := dbPath + ".backup"
+	if err := CompactTo(dbPath, backupPath); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	if err := verifyBackup(dbPath, backupPath, DefaultOptions()); err != nil {
+		t.Fatalf("expected a good backup to verify, got %v", err)
+	}
+
+	// A backup cut short, as on a full disk
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		t.Fatalf("failed to stat backup: %v", err)
+	}
+	if err := os.Truncate(backupPath, info.Size()/2); err != nil {
+		t.Fatalf("failed to truncate backup: %v", err)
+	}
+	if err := verifyBackup(dbPath, backupPath, DefaultOptions()); err == nil {
+		t.Errorf("expected a truncated backup to fail verification")
+	}
+
+	// A backup with a different schema
+	os.Remove(backupPath)
+	other, err := Open(schemaV1, backupPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	other.Close()
+	if err := verifyBackup(dbPath, backupPath, DefaultOptions()); err == nil {
+		t.Errorf("expected a backup with a different schema to fail verification")
+	}
+}
+
+func TestCaseInsensitiveMatching(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(`CREATE TABLE Users (ID INTEGER PRIMARY KEY, Name TEXT);`, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	db, err = Open(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+
+	var id int
+	var name string
+	if err := db.QueryRow("SELECT id, name FROM users").Scan(&id, &name); err != nil || id != 1 || name != "alice" {
+		t.Errorf("expected data to survive a change of case, got %d %q, %v", id, name, err)
+	}
+}
+
+func TestCrashAfterRename(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	// Stage a migration and move the new file into place the way commit does,
+	// as if the process died straight after the rename
+	previewPath, preview, _, discard, err := MigratePreview(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("MigratePreview failed: %v", err)
+	}
+	preview.Close()
+	if err := os.Rename(previewPath, dbPath); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	discard() // Only releases the lock now; the preview file is gone
+
+	db, result, err := OpenWithResult(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	if result != Unchanged {
+		t.Errorf("expected the renamed database to need no migration, got %v", result)
+	}
+	version, err := getCurrentSchemaVersion(db)
+	if err != nil || version == nil || version.Version != 2 || version.Hash != calculateSchemaHash(schemaV2) {
+		t.Errorf("expected version 2 to be recorded already, got %+v, %v", version, err)
+	}
+}
+
+func TestTempDir(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	scratch := t.TempDir()
+	opts := DefaultOptions()
+	opts.TempDir = scratch
+	previewPath, preview, commit, _, err := MigratePreviewWithOptions(schemaV2, dbPath, opts)
+	if err != nil {
+		t.Fatalf("MigratePreview failed: %v", err)
+	}
+	if filepath.Dir(previewPath) != scratch {
+		t.Errorf("expected the new database to be built in %s, got %s", scratch, previewPath)
+	}
+	if _, err := os.Stat(dbPath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be built next to the database, got %v", err)
+	}
+	preview.Close()
+	if err := commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	db, err = Open(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	var name string
+	if err := db.QueryRow("SELECT name FROM users").Scan(&name); err != nil || name != "alice" {
+		t.Errorf("expected data to be migrated, got %q, %v", name, err)
+	}
+	if entries, _ := os.ReadDir(scratch); len(entries) != 0 {
+		t.Errorf("expected the scratch directory to be empty, got %v", entries)
+	}
+}
+
+func TestCrossDeviceRename(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	// Fail the first rename as if the new database were on another filesystem
+	scratch := t.TempDir()
+	failed := false
+	rename = func(src, dst string) error {
+		if !failed && filepath.Dir(src) == scratch {
+			failed = true
+			return &os.LinkError{Op: "rename", Old: src, New: dst, Err: syscall.EXDEV}
+		}
+		return os.Rename(src, dst)
+	}
+	defer func() { rename = os.Rename }()
+
+	opts := DefaultOptions()
+	opts.TempDir = scratch
+	db, err = OpenWithOptions(schemaV2, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+	if !failed {
+		t.Fatal("expected the rename across filesystems to be attempted")
+	}
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM users").Scan(&name); err != nil || name != "alice" {
+		t.Errorf("expected data to be migrated, got %q, %v", name, err)
+	}
+	if entries, _ := os.ReadDir(scratch); len(entries) != 0 {
+		t.Errorf("expected the scratch directory to be empty, got %v", entries)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(dbPath))
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Errorf("expected no temporary file next to the database, found %s", e.Name())
+		}
+	}
+}
+
+func TestPreserveUserVersion(t *testing.T) {
+	for _, preserve := range []bool{true, false} {
+		t.Run(fmt.Sprint(preserve), func(t *testing.T) {
+			dbPath := tempDBPath(t)
+			db, err := Open(schemaV1, dbPath)
+			if err != nil {
+				t.Fatalf("failed to create db: %v", err)
+			}
+			if _, err := db.Exec("PRAGMA user_version = 42; PRAGMA application_id = 1234"); err != nil {
+				t.Fatalf("failed to set pragmas: %v", err)
+			}
+			db.Close()
+
+			// Zero Options preserve both
+			opts := &Options{ResetUserVersion: !preserve, ResetApplicationID: !preserve}
+			db, err = OpenWithOptions(schemaV2, dbPath, opts)
+			if err != nil {
+				t.Fatalf("migration failed: %v", err)
+			}
+			defer db.Close()
+
+			want := map[string]int{"user_version": 42, "application_id": 1234}
+			for pragma, value := range want {
+				if !preserve {
+					value = 0
+				}
+				var got int
+				if err := db.QueryRow("PRAGMA " + pragma).Scan(&got); err != nil {
+					t.Fatalf("failed to read %s: %v", pragma, err)
+				}
+				if got != value {
+					t.Errorf("expected %s %d, got %d", pragma, value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowBackward(t *testing.T) {
+	dbPath := tempDBPath(t)
+	for _, schema := range []string{schemaV1, schemaV2} {
+		db, err := Open(schema, dbPath)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		db.Close()
+	}
+
+	opts := DefaultOptions()
+	opts.AllowBackward = true
+	db, err := OpenWithOptions(schemaV1, dbPath, opts)
+	if err != nil {
+		t.Fatalf("backward migration with AllowBackward failed: %v", err)
+	}
+	defer db.Close()
+
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Error("expected the database to have the old schema")
+	}
+	if _, err := os.Stat(dbPath + ".backup"); err != nil {
+		t.Errorf("expected a backup of the database: %v", err)
+	}
+	versions, err := AppliedSchemas(db)
+	if err != nil || len(versions) != 3 || versions[2].SchemaSQL != schemaV1 {
+		t.Fatalf("expected the downgrade to be recorded as version 3, got %+v: %v", versions, err)
+	}
+}
+
+func TestSchemasEqualFastPath(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+
+	if !matchesRecordedVersion(db, schemaV1) {
+		t.Error("expected the recorded version to match the schema it was created with")
+	}
+	if matchesRecordedVersion(db, schemaV2) {
+		t.Error("expected a different schema not to match")
+	}
+
+	// A change by hand is noticed, and the full comparison decides
+	if _, err := db.Exec("CREATE INDEX idx_users_name ON users(name)"); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	if matchesRecordedVersion(db, schemaV1) {
+		t.Error("expected a changed database not to match the recorded version")
+	}
+	if SchemasEqual(schemaV1, dbPath) {
+		t.Error("expected the changed database to differ from the schema")
+	}
+}
+
+func BenchmarkSchemasEqual(b *testing.B) {
+	dbPath := filepath.Join(b.TempDir(), "bench.db")
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		b.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+
+	b.Run("recorded", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !SchemasEqual(schemaV1, dbPath) {
+				b.Fatal("expected schemas to be equal")
+			}
+		}
+	})
+
+	// The same schema spelled differently has a different hash, so it takes
+	// the full comparison
+	reformatted := strings.ToLower(schemaV1)
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !SchemasEqual(reformatted, dbPath) {
+				b.Fatal("expected schemas to be equal")
+			}
+		}
+	})
+}
+
+func TestMigrateContextInterruptsCopy(t *testing.T) {
+	// The trigger makes inserting the one row take far longer than the test
+	// waits, so the copy only ends early if the statement is interrupted
+	slowSchema := schemaV2 + `
+CREATE TRIGGER slow_insert AFTER INSERT ON users BEGIN
+	SELECT count(*) FROM (WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000000) SELECT x FROM c);
+END;`
+
+	for name, opts := range map[string]*Options{
+		"attached":   DefaultOptions(),
+		"row by row": {OnConflict: func(string, map[string]interface{}, error) {}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dbPath := tempDBPath(t)
+			db, err := Open(schemaV1, dbPath)
+			if err != nil {
+				t.Fatalf("failed to create db: %v", err)
+			}
+			if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+			db.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err = MigrateContext(ctx, slowSchema, dbPath, opts)
+			var merr *MigrationError
+			if !errors.Is(err, ErrMigrationTimeout) || !errors.As(err, &merr) || merr.Phase != PhaseDataCopy || merr.Table != "users" {
+				t.Fatalf("expected the copy of users to time out, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("migration took %v to stop after the deadline", elapsed)
+			}
+
+			if _, err := os.Stat(dbPath + ".tmp"); !os.IsNotExist(err) {
+				t.Errorf("expected the partly migrated database to be removed, got %v", err)
+			}
+			if !SchemasEqual(schemaV1, dbPath) {
+				t.Error("expected the database to keep its old schema")
+			}
+		})
+	}
+}
+
+func TestMigrateContextCancelled(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts := DefaultOptions()
+	opts.BestEffort = true
+	if _, err := MigrateContext(ctx, schemaV2, dbPath, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled migration to fail even with BestEffort, got %v", err)
+	}
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Error("expected the database to keep its old schema")
+	}
+
+	db, err = MigrateContext(context.Background(), schemaV2, dbPath, nil)
+	if err != nil {
+		t.Fatalf("MigrateContext failed: %v", err)
+	}
+	db.Close()
+	if !SchemasEqual(schemaV2, dbPath) {
+		t.Error("expected the database to be migrated")
+	}
+}
+
+func TestMigrateWALDatabase(t *testing.T) {
+	dbPath := tempDBPath(t)
+	dsn := dbPath + "?_journal_mode=WAL"
+	db, err := Open(schemaV1, dsn)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	// With the connection left open, the rows stay in the WAL
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice'), ('bob')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if info, err := os.Stat(dbPath + "-wal"); err != nil || info.Size() == 0 {
+		t.Fatalf("expected the writes to be in the WAL, got %v", err)
+	}
+
+	migrated, err := Migrate(schemaV2, dsn)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	defer migrated.Close()
+	db.Close()
+
+	// The backup and the migrated database file must each hold all the rows
+	// without a WAL next to them, and the old database's WAL must not have
+	// been taken for the new file's
+	for _, path := range []string{dbPath + ".backup", dbPath} {
+		alone := filepath.Join(t.TempDir(), "alone.db")
+		if err := copyFile(path, alone); err != nil {
+			t.Fatalf("failed to copy %s: %v", path, err)
+		}
+		check, err := sql.Open("sqlite3", alone)
+		if err != nil {
+			t.Fatalf("failed to open copy of %s: %v", path, err)
+		}
+		var count int
+		if err := check.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 2 {
+			t.Errorf("%s: expected 2 rows without the WAL, got %d, %v", path, count, err)
+		}
+		check.Close()
+	}
+
+	var count int
+	if err := migrated.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 2 {
+		t.Errorf("expected 2 rows in the migrated database, got %d, %v", count, err)
+	}
+	if err := checkIntegrity(migrated, false); err != nil {
+		t.Errorf("migrated database is damaged: %v", err)
+	}
+	if !SchemasEqual(schemaV2, dsn) {
+		t.Error("expected the migrated database to have the new schema")
+	}
+}
+
+func TestReportVersions(t *testing.T) {
+	dbPath := tempDBPath(t)
+	opts := DefaultOptions()
+	opts.Report = &MigrationReport{}
+
+	steps := []struct {
+		name     string
+		open     func() (*sql.DB, error)
+		from, to int
+	}{
+		{"create", func() (*sql.DB, error) { return OpenWithOptions(schemaV1, dbPath, opts) }, 0, 1},
+		{"unchanged", func() (*sql.DB, error) { return OpenWithOptions(schemaV1, dbPath, opts) }, 1, 1},
+		{"migrate", func() (*sql.DB, error) { return MigrateWithOptions(schemaV2, dbPath, opts) }, 1, 2},
+		{"open migrated", func() (*sql.DB, error) { return OpenWithOptions(schemaV2, dbPath, opts) }, 2, 2},
+		{"migrate again", func() (*sql.DB, error) { return OpenWithOptions(schemaV1WithPosts, dbPath, opts) }, 2, 3},
+	}
+	for _, step := range steps {
+		db, err := step.open()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		db.Close()
+		if opts.Report.FromVersion != step.from || opts.Report.ToVersion != step.to {
+			t.Errorf("%s: expected versions %d -> %d, got %d -> %d", step.name, step.from, step.to, opts.Report.FromVersion, opts.Report.ToVersion)
+		}
+		if opts.Report.VersionChanged() != (step.from != step.to) {
+			t.Errorf("%s: unexpected VersionChanged %v", step.name, opts.Report.VersionChanged())
+		}
+	}
+}
+
+func TestMigrationNeeded(t *testing.T) {
+	dbPath := tempDBPath(t)
+	if needed, err := MigrationNeeded(schemaV1, dbPath); err != nil || needed {
+		t.Errorf("expected a missing database to need no migration, got %v, %v", needed, err)
+	}
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	for _, tt := range []struct {
+		schema string
+		want   bool
+	}{
+		{schemaV1, false},
+		{"-- Users of the app\n" + schemaV1, false},
+		{schemaV1 + " -- Users of the app", false},
+		{schemaV2, true},
+	} {
+		if needed, err := MigrationNeeded(tt.schema, dbPath); err != nil || needed != tt.want {
+			t.Errorf("MigrationNeeded(%q) = %v, %v, want %v", tt.schema, needed, err, tt.want)
+		}
+	}
+	if _, err := MigrationNeeded("CREATE TABLE (", dbPath); err == nil {
+		t.Error("expected an invalid schema to fail")
+	}
+
+	// A database without version history is compared in full
+	legacyPath := tempDBPath(t)
+	legacy, err := sql.Open("sqlite3", legacyPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if _, err := legacy.Exec(schemaV1); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	legacy.Close()
+	if needed, err := MigrationNeeded(schemaV1, legacyPath); err != nil || needed {
+		t.Errorf("expected the legacy database to need no migration, got %v, %v", needed, err)
+	}
+	if needed, err := MigrationNeeded(schemaV2, legacyPath); err != nil || !needed {
+		t.Errorf("expected the legacy database to need a migration, got %v, %v", needed, err)
+	}
+}
+
+func TestTemporaryDBLeavesNoFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	db, err := openTemporaryDB()
+	if err != nil {
+		t.Fatalf("failed to open temporary db: %v", err)
+	}
+	if _, err := db.Exec(schemaV1); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'users'").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("schema not visible on the handle: count=%d err=%v", count, err)
+	}
+	db.Close()
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to read temp dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("temporary db left %d files behind", len(entries))
+	}
+}
+
 func tempDBPath(t *testing.T) string {
 	dir := t.TempDir()
 	return filepath.Join(dir, "test.db")
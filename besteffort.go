@@ -0,0 +1,51 @@
+package autosqlite
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoTablesCopied is returned by a migration with Options.BestEffort set when
+// the data of every table failed to copy.
+var ErrNoTablesCopied = errors.New("no table could be copied")
+
+// TableFailure records a table whose data couldn't be copied by a migration
+// with Options.BestEffort set. The table is left empty in the new database.
+type TableFailure struct {
+	Table string // Table in the new schema
+	Err   error  // Why its data couldn't be copied
+}
+
+// tableOutcomes counts the tables a migration has copied and failed to copy,
+// to carry on past failures for Options.BestEffort.
+type tableOutcomes struct {
+	opts      *Options
+	succeeded int
+	firstErr  error // The first failure, if every table fails
+}
+
+// fail handles a failure to copy the data of table. With opts.BestEffort the
+// failure is recorded in opts.Report and nil returned, so that the migration
+// carries on; otherwise the MigrationError to fail the migration with is
+// returned. A migration that has been cancelled fails however the table did.
+func (o *tableOutcomes) fail(table string, err error) error {
+	if !o.opts.BestEffort || o.opts.migrationContext().Err() != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Table: table, Err: err}
+	}
+	if o.firstErr == nil {
+		o.firstErr = &MigrationError{Phase: PhaseDataCopy, Table: table, Err: err}
+	}
+	if o.opts.Report != nil {
+		o.opts.Report.TableFailures = append(o.opts.Report.TableFailures, TableFailure{Table: table, Err: err})
+	}
+	return nil
+}
+
+// done returns the error for the migration once every table has been copied
+// or failed: ErrNoTablesCopied if tables failed and none succeeded.
+func (o *tableOutcomes) done() error {
+	if o.firstErr != nil && o.succeeded == 0 {
+		return fmt.Errorf("%w: %w", ErrNoTablesCopied, o.firstErr)
+	}
+	return nil
+}
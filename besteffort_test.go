@@ -0,0 +1,87 @@
+package autosqlite
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBestEffort(t *testing.T) {
+	const oldSchema = `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER);
+		CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT);`
+	// The duplicate emails keep users from being copied
+	const newSchema = `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE);
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER, total INTEGER);
+		CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT);`
+
+	for _, parallel := range []int{1, 2} {
+		for _, rowByRow := range []bool{false, true} {
+			t.Run(fmt.Sprintf("parallel %d row by row %v", parallel, rowByRow), func(t *testing.T) {
+				dbPath := tempDBPath(t)
+				db, err := Open(oldSchema, dbPath)
+				if err != nil {
+					t.Fatalf("failed to create db: %v", err)
+				}
+				if _, err := db.Exec(`INSERT INTO users (email) VALUES ('a'), ('a'), ('b');
+					INSERT INTO orders (user_id) VALUES (1), (2);
+					INSERT INTO notes (body) VALUES ('x')`); err != nil {
+					t.Fatalf("failed to insert: %v", err)
+				}
+				db.Close()
+
+				var report MigrationReport
+				opts := DefaultOptions()
+				opts.BestEffort = true
+				opts.ParallelTables = parallel
+				opts.Report = &report
+				if rowByRow {
+					opts.OnConflict = func(string, map[string]interface{}, error) {}
+				}
+				db, err = OpenWithOptions(newSchema, dbPath, opts)
+				if err != nil {
+					t.Fatalf("migration failed: %v", err)
+				}
+				defer db.Close()
+
+				if len(report.TableFailures) != 1 || report.TableFailures[0].Table != "users" || !isConstraintError(report.TableFailures[0].Err) {
+					t.Errorf("expected users to fail with a constraint error, got %+v", report.TableFailures)
+				}
+				if report.Stats.TablesCopied != 2 || report.Stats.RowsCopied != 3 {
+					t.Errorf("expected 2 tables and 3 rows copied, got %+v", report.Stats)
+				}
+				for table, want := range map[string]int{"users": 0, "orders": 2, "notes": 1} {
+					var count int
+					if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil || count != want {
+						t.Errorf("expected %d rows in %s, got %d, %v", want, table, count, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestBestEffortNothingCopied(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (email) VALUES ('a'), ('a')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.BestEffort = true
+	_, err = OpenWithOptions(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE);`, dbPath, opts)
+	var migrationErr *MigrationError
+	if !errors.Is(err, ErrNoTablesCopied) || !errors.As(err, &migrationErr) || migrationErr.Table != "users" {
+		t.Errorf("expected ErrNoTablesCopied for users, got %v", err)
+	}
+
+	opts.RebuildInPlace = true
+	if _, err := OpenWithOptions(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, name TEXT);`, dbPath, opts); err == nil {
+		t.Error("expected BestEffort to be rejected with RebuildInPlace")
+	}
+}
@@ -0,0 +1,85 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// SchemaBuilder assembles a schema from fragments, for example one per plugin
+// of a modular application. Fragments are concatenated in the order they were
+// added, so a fragment may refer to objects created by earlier fragments.
+type SchemaBuilder struct {
+	fragments []string
+}
+
+// NewSchemaBuilder returns an empty SchemaBuilder.
+func NewSchemaBuilder() *SchemaBuilder {
+	return &SchemaBuilder{}
+}
+
+// Add appends a schema fragment containing one or more SQL statements.
+func (b *SchemaBuilder) Add(sql string) *SchemaBuilder {
+	b.fragments = append(b.fragments, sql)
+	return b
+}
+
+// Build returns the combined schema. It returns an error if two statements
+// create objects with the same name, since the resulting schema would depend
+// on which definition happened to win. Tables, views and indexes share one
+// namespace, as they do in SQLite, and triggers have their own.
+func (b *SchemaBuilder) Build() (string, error) {
+	type definition struct {
+		typ, name string
+		fragment  int
+	}
+	defined := make(map[string]definition) // Namespace and folded name -> first definition
+	var parts []string
+
+	for i, fragment := range b.fragments {
+		for _, stmt := range splitStatements(fragment) {
+			typ, name, ok := createdObject(stmt.tokens)
+			if !ok {
+				continue
+			}
+			key := "table " + foldName(name)
+			if typ == "trigger" {
+				key = "trigger " + foldName(name)
+			}
+			prev, exists := defined[key]
+			switch {
+			case !exists:
+				defined[key] = definition{typ: typ, name: name, fragment: i}
+			case prev.fragment == i:
+				return "", fmt.Errorf("%s %s is defined twice in schema fragment %d", typ, name, i+1)
+			case prev.typ != typ:
+				return "", fmt.Errorf("%s %s in schema fragment %d has the same name as %s %s in fragment %d", typ, name, i+1, prev.typ, prev.name, prev.fragment+1)
+			default:
+				return "", fmt.Errorf("%s %s is defined in both schema fragment %d and fragment %d", typ, name, prev.fragment+1, i+1)
+			}
+		}
+
+		fragment = strings.TrimSpace(fragment)
+		tokens := tokenize(fragment)
+		if fragment == "" {
+			continue
+		}
+		// Terminate the last statement on a line of its own, in case the
+		// fragment ends in a comment
+		if len(tokens) > 0 && tokens[len(tokens)-1].text != ";" {
+			fragment += "\n;"
+		}
+		parts = append(parts, fragment)
+	}
+
+	return strings.Join(parts, "\n"), nil
+}
+
+// OpenBuilder builds the schema from b and opens dbPath with it, as Open does.
+func OpenBuilder(b *SchemaBuilder, dbPath string) (*sql.DB, error) {
+	schema, err := b.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build schema: %w", err)
+	}
+	return Open(schema, dbPath)
+}
@@ -0,0 +1,136 @@
+package autosqlite
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSchemaBuilder(t *testing.T) {
+	b := NewSchemaBuilder()
+	b.Add(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)`)
+	b.Add(`CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER, title TEXT);
+	CREATE TRIGGER posts_cleanup AFTER DELETE ON users BEGIN
+	  DELETE FROM posts WHERE user_id = old.id;
+	END;`)
+
+	schema, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	// Fragments are kept in the order they were added
+	if strings.Index(schema, "users") > strings.Index(schema, "posts") {
+		t.Fatalf("fragments out of order: %s", schema)
+	}
+
+	dbPath := tempDBPath(t)
+	db, err := OpenBuilder(b, dbPath)
+	if err != nil {
+		t.Fatalf("OpenBuilder failed: %v", err)
+	}
+	defer db.Close()
+
+	tables, err := GetTables(db)
+	if err != nil {
+		t.Fatalf("GetTables failed: %v", err)
+	}
+	if len(tables) != 2 {
+		t.Fatalf("expected 2 tables, got %v", tables)
+	}
+}
+
+func TestSchemaBuilderDuplicateTable(t *testing.T) {
+	b := NewSchemaBuilder()
+	b.Add(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	b.Add(`CREATE TABLE IF NOT EXISTS "Users" (id INTEGER PRIMARY KEY, email TEXT);`)
+
+	if _, err := b.Build(); err == nil || !strings.Contains(strings.ToLower(err.Error()), "table users") {
+		t.Fatalf("expected duplicate table error, got %v", err)
+	}
+	if _, err := OpenBuilder(b, tempDBPath(t)); err == nil {
+		t.Fatalf("OpenBuilder should fail on duplicate tables")
+	}
+}
+
+func TestSchemaBuilderSharedNamespace(t *testing.T) {
+	b := NewSchemaBuilder()
+	b.Add(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	b.Add(`CREATE VIEW USERS AS SELECT 1;`)
+	if _, err := b.Build(); err == nil || !strings.Contains(err.Error(), "same name as table users") {
+		t.Fatalf("expected a view named like a table to be rejected, got %v", err)
+	}
+
+	// A trigger may share a table's name
+	b = NewSchemaBuilder()
+	b.Add(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	b.Add(`CREATE TRIGGER users AFTER INSERT ON users BEGIN SELECT 1; END;`)
+	if _, err := b.Build(); err != nil {
+		t.Fatalf("expected a trigger named like a table to be allowed, got %v", err)
+	}
+
+	b = NewSchemaBuilder()
+	b.Add(`CREATE TABLE users (id INTEGER PRIMARY KEY); CREATE INDEX users ON users(id);`)
+	if _, err := b.Build(); err == nil || !strings.Contains(err.Error(), "twice in schema fragment 1") {
+		t.Fatalf("expected a duplicate within a fragment to be reported as such, got %v", err)
+	}
+}
+
+func TestSchemaBuilderTrailingComment(t *testing.T) {
+	b := NewSchemaBuilder()
+	b.Add("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT) -- people")
+	b.Add("-- Only a comment")
+	b.Add("CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT)")
+
+	dbPath := tempDBPath(t)
+	db, err := OpenBuilder(b, dbPath)
+	if err != nil {
+		t.Fatalf("OpenBuilder failed: %v", err)
+	}
+	defer db.Close()
+	tables, err := GetTables(db)
+	if err != nil || len(tables) != 2 {
+		t.Fatalf("expected 2 tables, got %v, %v", tables, err)
+	}
+}
+
+func TestSchemaBuilderStatementOrder(t *testing.T) {
+	b := NewSchemaBuilder()
+	b.Add(`CREATE TRIGGER posts_cleanup AFTER DELETE ON users BEGIN
+	  DELETE FROM posts WHERE user_id = old.id;
+	END;`)
+	b.Add(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	b.Add(`CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER, title TEXT);`)
+	schema, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	_, err = ValidateSchema(schema)
+	var serr *StatementError
+	if !errors.As(err, &serr) || serr.Index != 1 || !errors.Is(err, ErrStatementOrder) || !strings.Contains(err.Error(), "statement 2") {
+		t.Fatalf("expected ErrStatementOrder for statement 1 naming statement 2, got %v", err)
+	}
+	if _, err := OpenBuilder(b, tempDBPath(t)); !errors.Is(err, ErrStatementOrder) {
+		t.Errorf("expected OpenBuilder to fail with ErrStatementOrder, got %v", err)
+	}
+
+	// A trigger body may refer to a table created by a later fragment
+	b = NewSchemaBuilder()
+	b.Add(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+	CREATE TRIGGER posts_cleanup AFTER DELETE ON users BEGIN
+	  DELETE FROM posts WHERE user_id = old.id;
+	END;`)
+	b.Add(`CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER, title TEXT);`)
+	db, err := OpenBuilder(b, tempDBPath(t))
+	if err != nil {
+		t.Fatalf("OpenBuilder failed: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO users (id) VALUES (1); INSERT INTO posts (user_id) VALUES (1); DELETE FROM users"); err != nil {
+		t.Fatalf("failed to use trigger: %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&count); err != nil || count != 0 {
+		t.Errorf("expected the trigger to delete the post, got %d, %v", count, err)
+	}
+}
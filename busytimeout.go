@@ -0,0 +1,32 @@
+package autosqlite
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// withBusyTimeout returns dsn with its busy timeout set to timeout. The driver
+// sets it with sqlite3_busy_timeout, as PRAGMA busy_timeout does, but as soon
+// as the connection is open, since opening it already reads the database.
+func withBusyTimeout(dsn string, timeout time.Duration) string {
+	// _timeout is the driver's other name for _busy_timeout
+	return withDSNParam(dsn, "_busy_timeout", strconv.FormatInt(timeout.Milliseconds(), 10), "_timeout")
+}
+
+// withDSNParam returns dsn with the query parameter key set to value, in place
+// of any key or aliases it already has.
+func withDSNParam(dsn, key, value string, aliases ...string) string {
+	path, query, _ := strings.Cut(dsn, "?")
+	var params []string
+	for _, param := range strings.Split(query, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if param == "" || name == key || slices.Contains(aliases, name) {
+			continue
+		}
+		params = append(params, param)
+	}
+	params = append(params, key+"="+value)
+	return path + "?" + strings.Join(params, "&")
+}
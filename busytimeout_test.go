@@ -0,0 +1,96 @@
+package autosqlite
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestBusyTimeout(t *testing.T) {
+	dbPath := tempDBPath(t)
+	opts := DefaultOptions()
+	opts.BusyTimeout = 1234 * time.Millisecond
+	db, err := OpenWithOptions(schemaV1, dbPath+"?_busy_timeout=10", opts)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+
+	// Every connection of the pool gets the timeout, not just the first
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("failed to get connection: %v", err)
+		}
+		defer conn.Close()
+		var timeout int
+		if err := conn.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&timeout); err != nil || timeout != 1234 {
+			t.Errorf("connection %d: expected busy_timeout 1234, got %d, %v", i, timeout, err)
+		}
+	}
+}
+
+func TestWithBusyTimeout(t *testing.T) {
+	tests := []struct{ dsn, want string }{
+		{"app.db", "app.db?_busy_timeout=250"},
+		{"app.db?_busy_timeout=10&_foreign_keys=on", "app.db?_foreign_keys=on&_busy_timeout=250"},
+		{"file:app.db?_timeout=10&mode=ro", "file:app.db?mode=ro&_busy_timeout=250"},
+	}
+	for _, tt := range tests {
+		if got := withBusyTimeout(tt.dsn, 250*time.Millisecond); got != tt.want {
+			t.Errorf("withBusyTimeout(%q) = %q, want %q", tt.dsn, got, tt.want)
+		}
+	}
+}
+
+func TestBusyTimeoutDuringMigration(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	// lock holds an exclusive lock on the database for hold
+	lock := func(hold time.Duration) {
+		t.Helper()
+		locker, err := sql.Open("sqlite3", dbPath)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		locker.SetMaxOpenConns(1)
+		if _, err := locker.Exec("BEGIN EXCLUSIVE"); err != nil {
+			t.Fatalf("failed to lock db: %v", err)
+		}
+		go func() {
+			time.Sleep(hold)
+			locker.Exec("ROLLBACK")
+			locker.Close()
+		}()
+	}
+
+	// A short timeout gives up on the lock...
+	opts := DefaultOptions()
+	opts.Retry = &RetryPolicy{MaxAttempts: 1}
+	opts.BusyTimeout = time.Millisecond
+	lock(500 * time.Millisecond)
+	if db, err := OpenWithOptions(schemaV2, dbPath, opts); err == nil {
+		db.Close()
+		t.Fatal("expected the migration to fail while the database is locked")
+	}
+	time.Sleep(600 * time.Millisecond)
+
+	// ...and a long one waits for it
+	opts.BusyTimeout = 5 * time.Second
+	lock(200 * time.Millisecond)
+	db, err = OpenWithOptions(schemaV2, dbPath, opts)
+	if err != nil {
+		t.Fatalf("expected the migration to wait for the lock: %v", err)
+	}
+	db.Close()
+	if !SchemasEqual(schemaV2, dbPath) {
+		t.Error("expected the database to be migrated")
+	}
+}
@@ -0,0 +1,58 @@
+package autosqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrMigrationTimeout is returned by MigrateContext when the deadline of its
+// context passes before the migration is done. The error also matches
+// context.DeadlineExceeded; a migration whose context is cancelled instead
+// fails with an error matching context.Canceled. Either way the database is
+// left as it was, without the partly migrated copy.
+var ErrMigrationTimeout = errors.New("migration timed out")
+
+// enterPhase is called as a migration with a context starts each phase, before
+// the context is checked. It is a variable so that tests can cancel a migration
+// at a given phase.
+var enterPhase = func(phase string) {}
+
+// interrupted returns the error to fail a migration with if its context is
+// done as it enters phase, or nil to carry on. A migration that has got as far
+// as moving the new database into place is finished, whatever its context.
+func (opts *Options) interrupted(phase string) error {
+	if opts.ctx == nil {
+		return nil
+	}
+	enterPhase(phase)
+	if opts.ctx.Err() == nil {
+		return nil
+	}
+	return &MigrationError{Phase: phase, Err: contextError(opts.ctx)}
+}
+
+// contextError returns the error for a migration stopped because ctx is done:
+// ErrMigrationTimeout together with context.DeadlineExceeded if its deadline
+// passed, or otherwise ctx.Err().
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrMigrationTimeout, ctx.Err())
+	}
+	return ctx.Err()
+}
+
+// cancelledMigration turns err, from a migration under ctx, into the error for a
+// migration stopped by ctx if ctx is done, so that however the stop surfaced,
+// for example as SQLite's "interrupted" or as ctx's error wrapped by a step of
+// the migration, it matches the same sentinels and keeps its phase and table.
+func cancelledMigration(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	var merr *MigrationError
+	if !errors.As(err, &merr) {
+		return fmt.Errorf("%w (%v)", contextError(ctx), err)
+	}
+	return &MigrationError{Phase: merr.Phase, Table: merr.Table, Err: contextError(ctx)}
+}
@@ -0,0 +1,100 @@
+package autosqlite
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateContextCancelledAtEachPhase(t *testing.T) {
+	for _, phase := range []string{PhaseBackup, PhaseSchema, PhaseDataCopy, PhasePostMigrate, PhaseAnalyze, PhaseVersionRecord, PhaseReplace} {
+		t.Run(phase, func(t *testing.T) {
+			dbPath := tempDBPath(t)
+			db, err := Open(schemaV1, dbPath)
+			if err != nil {
+				t.Fatalf("failed to create db: %v", err)
+			}
+			if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+			db.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			defer func(saved func(string)) { enterPhase = saved }(enterPhase)
+			enterPhase = func(p string) {
+				if p == phase {
+					cancel()
+				}
+			}
+
+			opts := DefaultOptions()
+			opts.RunAnalyze = true
+			_, err = MigrateContext(ctx, schemaV2, dbPath, opts)
+			if !errors.Is(err, context.Canceled) || errors.Is(err, ErrMigrationTimeout) {
+				t.Fatalf("expected a cancelled migration, got %v", err)
+			}
+			var merr *MigrationError
+			if !errors.As(err, &merr) || merr.Phase != phase {
+				t.Errorf("expected the migration to stop in phase %s, got %v", phase, err)
+			}
+
+			if !SchemasEqual(schemaV1, dbPath) {
+				t.Error("expected the database to keep its old schema")
+			}
+			db, err = Open(schemaV1, dbPath)
+			if err != nil {
+				t.Fatalf("failed to reopen db: %v", err)
+			}
+			defer db.Close()
+			var name string
+			if err := db.QueryRow("SELECT name FROM users").Scan(&name); err != nil || name != "alice" {
+				t.Errorf("expected the data to be intact, got %q, %v", name, err)
+			}
+			assertNoStrayFiles(t, filepath.Dir(dbPath))
+		})
+	}
+}
+
+func TestMigrateContextTimeout(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = MigrateContext(ctx, schemaV2, dbPath, nil)
+	if !errors.Is(err, ErrMigrationTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrMigrationTimeout, got %v", err)
+	}
+	var merr *MigrationError
+	if !errors.As(err, &merr) || merr.Phase != PhaseBackup {
+		t.Errorf("expected the migration to stop before the backup, got %v", err)
+	}
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Error("expected the database to keep its old schema")
+	}
+	assertNoStrayFiles(t, filepath.Dir(dbPath))
+}
+
+// assertNoStrayFiles fails t if dir has a partly migrated database or its
+// journal left in it.
+func assertNoStrayFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	for _, e := range entries {
+		if name := e.Name(); strings.Contains(name, ".tmp") || strings.HasSuffix(name, "-journal") {
+			t.Errorf("unexpected file left behind: %s", name)
+		}
+	}
+}
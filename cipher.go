@@ -0,0 +1,90 @@
+package autosqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrNotEncrypted is returned when Options.EncryptionKey is set but a database
+// file autosqlite created, such as the backup or the migrated database, isn't
+// encrypted, for example because SQLite was built without encryption support.
+var ErrNotEncrypted = errors.New("database file is not encrypted")
+
+// plaintextHeader starts every unencrypted SQLite database file.
+const plaintextHeader = "SQLite format 3\x00"
+
+// openDB opens the database at dsn as sql.Open does, except that if
+// opts.EncryptionKey is set, each connection is given the key with PRAGMA key
+// before it is used, and if opts.BusyTimeout is set, it replaces any busy
+// timeout in dsn. The key is kept out of dsn, so it can't end up in logs or
+// error messages.
+func openDB(dsn string, opts *Options) (*sql.DB, error) {
+	if opts.BusyTimeout != 0 {
+		dsn = withBusyTimeout(dsn, opts.BusyTimeout)
+	}
+	if opts.EncryptionKey == "" {
+		return sql.Open("sqlite3", dsn)
+	}
+	return sql.OpenDB(&keyedConnector{dsn: dsn, key: opts.EncryptionKey}), nil
+}
+
+// keyedConnector opens connections to dsn, each keyed with key.
+type keyedConnector struct {
+	dsn, key string
+}
+
+func (c *keyedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyKey(conn.(*sqlite3.SQLiteConn), c.key); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set encryption key: %w", err)
+	}
+	return conn, nil
+}
+
+func (c *keyedConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+// applyKey gives conn the encryption key. It is a variable so that tests can see
+// which connections are keyed.
+var applyKey = func(conn *sqlite3.SQLiteConn, key string) error {
+	_, err := conn.Exec("PRAGMA key = '"+strings.ReplaceAll(key, "'", "''")+"'", nil)
+	return err
+}
+
+// checkEncrypted returns ErrNotEncrypted if opts.EncryptionKey is set and the
+// database file filename starts with the plaintext SQLite header, so that a
+// key that silently does nothing never leaves readable copies of the data
+// behind. An empty file hasn't been written yet, and passes.
+func checkEncrypted(filename string, opts *Options) error {
+	if opts.EncryptionKey == "" || isMemoryDatabase(filename) {
+		return nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to check encryption: %w", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, len(plaintextHeader))
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to check encryption: %w", err)
+	}
+	if string(header[:n]) == plaintextHeader {
+		return fmt.Errorf("%w: %s", ErrNotEncrypted, filename)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package autosqlite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestEncryptionKey(t *testing.T) {
+	const key = "s3cret'key"
+	const schema = `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`
+
+	// Record the files connections are keyed for; this build of SQLite has no
+	// encryption, so the key itself does nothing
+	var mu sync.Mutex
+	keyed := make(map[string]bool)
+	applyKeyOrig := applyKey
+	applyKey = func(conn *sqlite3.SQLiteConn, k string) error {
+		if k != key {
+			t.Errorf("expected key %q, got %q", key, k)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		keyed[filepath.Base(conn.GetFilename("main"))] = true
+		return applyKeyOrig(conn, k)
+	}
+	defer func() { applyKey = applyKeyOrig }()
+
+	opts := DefaultOptions()
+	opts.EncryptionKey = key
+
+	// Creating a database
+	dbPath := tempDBPath(t)
+	_, err := OpenWithOptions(schema, dbPath, opts)
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("expected ErrNotEncrypted, got %v", err)
+	}
+	if strings.Contains(err.Error(), key) {
+		t.Errorf("expected the key not to appear in the error: %v", err)
+	}
+	if !keyed[filepath.Base(dbPath)] {
+		t.Errorf("expected the new database's connections to be keyed, got %v", keyed)
+	}
+	// The plaintext file is removed rather than opened as is next time
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("expected the plaintext database to be removed, got %v", err)
+	}
+	if _, err := OpenWithOptions(schema, dbPath, opts); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected a second open to fail with ErrNotEncrypted too, got %v", err)
+	}
+	withMode := *opts
+	withMode.FileMode = 0600
+	if _, err := OpenWithOptions(schema, dbPath, &withMode); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected ErrNotEncrypted with FileMode, got %v", err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("expected the file created with FileMode to be removed, got %v", err)
+	}
+
+	// Backing up an existing database
+	dbPath = tempDBPath(t)
+	db, err := Open(schema, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+	newSchema := schema + "\nCREATE TABLE posts (id INTEGER PRIMARY KEY);"
+	_, err = OpenWithOptions(newSchema, dbPath, opts)
+	var migrationErr *MigrationError
+	if !errors.Is(err, ErrNotEncrypted) || !errors.As(err, &migrationErr) || migrationErr.Phase != PhaseBackup {
+		t.Errorf("expected the plaintext backup to be refused, got %v", err)
+	}
+	if !keyed[filepath.Base(dbPath)] {
+		t.Errorf("expected the backup to be made on a keyed connection, got %v", keyed)
+	}
+
+	// Building the migrated database
+	newDbPath := dbPath + ".new"
+	_, err = MigrateToNewFileWithOptions(newSchema, dbPath, newDbPath, opts)
+	if !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected the plaintext migrated database to be refused, got %v", err)
+	}
+	if !keyed[filepath.Base(newDbPath)] {
+		t.Errorf("expected the migrated database's connections to be keyed, got %v", keyed)
+	}
+}
+
+func TestCheckEncrypted(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(`CREATE TABLE t (x);`, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	if err := checkEncrypted(dbPath, DefaultOptions()); err != nil {
+		t.Errorf("expected no check without a key, got %v", err)
+	}
+	opts := DefaultOptions()
+	opts.EncryptionKey = "key"
+	if err := checkEncrypted(dbPath, opts); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected ErrNotEncrypted, got %v", err)
+	}
+	if err := checkEncrypted(":memory:", opts); err != nil {
+		t.Errorf("expected an in-memory database to pass, got %v", err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/jes/autosqlite"
 )
@@ -17,22 +19,32 @@ func main() {
 	// Migration control flags
 	inPlace := flag.Bool("in-place", false, "Migrate database in place (creates backup)")
 	newDb := flag.String("new-db", "", "Create new database file with migrated schema")
+	force := flag.Bool("force", false, "Allow migrating back to an older schema (may lose data)")
 
 	// Feature flags
 	dryRun := flag.Bool("dry-run", false, "Test migration without applying changes")
 	validate := flag.Bool("validate", false, "Validate schema syntax only")
+	lint := flag.Bool("lint", false, "Check schema for likely mistakes")
 	verbose := flag.Bool("verbose", false, "Show detailed migration information")
 
+	// Maintenance flags
+	resetVersion := flag.Bool("reset-version", false, "Clear the version history of -db")
+	seed := flag.Bool("seed", true, "With -reset-version, record the current schema as version 1")
+
 	flag.Parse()
 
 	// Handle different commands
 	switch {
 	case *validate:
-		validateSchema(*schemaPath)
+		validateSchema(*schemaPath, *verbose)
+	case *lint:
+		lintSchema(*schemaPath)
 	case *dryRun:
 		dryRunMigration(*schemaPath, *dbPath, *verbose)
+	case *resetVersion:
+		resetVersionHistory(*dbPath, *seed)
 	case *schemaPath != "" && *dbPath != "" && (*inPlace || *newDb != ""):
-		createOrMigrate(*schemaPath, *dbPath, *inPlace, *newDb, *verbose)
+		createOrMigrate(*schemaPath, *dbPath, *inPlace, *newDb, *force, *verbose)
 	default:
 		printUsage()
 	}
@@ -43,24 +55,30 @@ func printUsage() {
 
 Commands:
   -validate -schema <file>                    Validate schema syntax
+  -lint -schema <file>                        Check schema for likely mistakes
   -dry-run -schema <file> -db <file>          Test migration without applying
   -schema <file> -db <file> -in-place         Migrate database in place
   -schema <file> -db <file> -new-db <file>    Create new database with migrated schema
+  -reset-version -db <file>                   Restart version history at the current schema
 
 Options:
   -verbose                                   Show detailed information
+  -force                                     With -in-place, allow a downgrade to an older schema
+  -seed=false                                With -reset-version, leave the history empty
 
 Examples:
   %s -validate -schema schema.sql
+  %s -lint -schema schema.sql
   %s -dry-run -schema schema.sql -db app.db
   %s -schema schema.sql -db app.db -in-place
   %s -schema schema.sql -db app.db -new-db app_v2.db
-`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+  %s -reset-version -db app.db
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	flag.PrintDefaults()
 	os.Exit(1)
 }
 
-func validateSchema(schemaPath string) {
+func validateSchema(schemaPath string, verbose bool) {
 	if schemaPath == "" {
 		fmt.Fprintf(os.Stderr, "Error: -schema flag is required for validation\n")
 		os.Exit(1)
@@ -72,15 +90,80 @@ func validateSchema(schemaPath string) {
 		os.Exit(1)
 	}
 
-	// Try to create a temporary database to validate the schema
-	db, err := autosqlite.Open(string(schema), ":memory:")
+	objects, err := autosqlite.ValidateSchema(string(schema))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Schema validation failed: %v\n", err)
 		os.Exit(1)
 	}
-	defer db.Close()
 
-	fmt.Printf("✓ Schema is valid\n")
+	fmt.Printf("✓ Schema valid: %s\n", describeObjects(objects))
+	if verbose {
+		for _, obj := range objects {
+			if obj.Table != obj.Name {
+				fmt.Printf("  %s %s on %s\n", obj.Type, obj.Name, obj.Table)
+			} else {
+				fmt.Printf("  %s %s\n", obj.Type, obj.Name)
+			}
+		}
+	}
+}
+
+// lintSchema prints the warnings Lint finds in the schema file, and exits with a
+// failure status if there are any, so that it can serve as a pre-commit hook.
+func lintSchema(schemaPath string) {
+	if schemaPath == "" {
+		fmt.Fprintf(os.Stderr, "Error: -schema flag is required for linting\n")
+		os.Exit(1)
+	}
+
+	schema, err := os.ReadFile(schemaPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading schema file: %v\n", err)
+		os.Exit(1)
+	}
+
+	warnings, err := autosqlite.Lint(string(schema))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Schema validation failed: %v\n", err)
+		os.Exit(1)
+	}
+	if len(warnings) == 0 {
+		fmt.Println("✓ No problems found")
+		return
+	}
+	for _, w := range warnings {
+		fmt.Printf("%s: %s\n", schemaPath, w)
+	}
+	os.Exit(1)
+}
+
+// describeObjects summarizes objects as e.g. "3 tables, 2 indexes, 1 trigger".
+func describeObjects(objects []autosqlite.SchemaObject) string {
+	counts := make(map[string]int)
+	for _, obj := range objects {
+		counts[obj.Type]++
+	}
+
+	var parts []string
+	for _, typ := range []string{"table", "index", "view", "trigger"} {
+		n := counts[typ]
+		if n == 0 {
+			continue
+		}
+		name := typ
+		if n != 1 {
+			if typ == "index" {
+				name = "indexes"
+			} else {
+				name += "s"
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", n, name))
+	}
+	if len(parts) == 0 {
+		return "no objects"
+	}
+	return strings.Join(parts, ", ")
 }
 
 func dryRunMigration(schemaPath, dbPath string, verbose bool) {
@@ -120,7 +203,41 @@ func dryRunMigration(schemaPath, dbPath string, verbose bool) {
 	}
 }
 
-func createOrMigrate(schemaPath, dbPath string, inPlace bool, newDbPath string, verbose bool) {
+func resetVersionHistory(dbPath string, seed bool) {
+	if dbPath == "" {
+		fmt.Fprintf(os.Stderr, "Error: -db flag is required for -reset-version\n")
+		os.Exit(1)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if seed {
+		err = autosqlite.ResetVersionHistory(db)
+	} else {
+		err = autosqlite.ClearVersionHistory(db)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if seed {
+		fmt.Printf("✓ Version history reset: current schema recorded as version 1\n")
+	} else {
+		fmt.Printf("✓ Version history cleared\n")
+	}
+}
+
+func createOrMigrate(schemaPath, dbPath string, inPlace bool, newDbPath string, force, verbose bool) {
 	schema, err := os.ReadFile(schemaPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading schema file: %v\n", err)
@@ -140,10 +257,24 @@ func createOrMigrate(schemaPath, dbPath string, inPlace bool, newDbPath string,
 
 	if inPlace {
 		// Migrate in place (this will create a backup automatically)
-		db, err2 = autosqlite.Open(string(schema), dbPath)
+		opts := autosqlite.DefaultOptions()
+		if force {
+			fmt.Fprintf(os.Stderr, "WARNING: -force allows a backward migration, which drops any tables and\n")
+			fmt.Fprintf(os.Stderr, "columns the schema doesn't have, along with their data. The database is\n")
+			fmt.Fprintf(os.Stderr, "backed up to %s.backup first.\n", dbPath)
+			opts.AllowBackward = true
+		}
+		var result autosqlite.Result
+		db, result, err2 = autosqlite.OpenWithResultOptions(string(schema), dbPath, opts)
+		if err2 == nil && result == autosqlite.Migrated {
+			warnLostRows(dbPath+".backup", dbPath)
+		}
 	} else if newDbPath != "" {
 		// Create new database with migrated schema
 		db, err2 = autosqlite.MigrateToNewFile(string(schema), dbPath, newDbPath)
+		if err2 == nil {
+			warnLostRows(dbPath, newDbPath)
+		}
 	} else {
 		fmt.Fprintf(os.Stderr, "Error: Either -in-place or -new-db must be specified\n")
 		os.Exit(1)
@@ -165,3 +296,23 @@ func createOrMigrate(schemaPath, dbPath string, inPlace bool, newDbPath string,
 		}
 	}
 }
+
+// warnLostRows warns about each table that has fewer rows in the migrated
+// database at newPath than in the database at oldPath it was migrated from.
+func warnLostRows(oldPath, newPath string) {
+	deltas, err := autosqlite.VerifyRowCounts(oldPath, newPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to compare row counts: %v\n", err)
+		return
+	}
+	tables := make([]string, 0, len(deltas))
+	for table := range deltas {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		if delta := deltas[table]; delta.Lost() {
+			fmt.Fprintf(os.Stderr, "WARNING: table %s has fewer rows than before the migration: %s\n", table, delta)
+		}
+	}
+}
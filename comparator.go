@@ -0,0 +1,55 @@
+package autosqlite
+
+import (
+	"slices"
+)
+
+// Comparator checks databases against a schema that has been prepared once, for
+// when many databases share a schema, such as the shards of a partitioned
+// store. SchemasEqual builds the schema in a scratch in-memory database on every
+// call; a Comparator does that once, in Compile. It is safe for concurrent use.
+type Comparator struct {
+	schema    string
+	canonical []string
+	opts      *Options
+}
+
+// Compile prepares schema for comparison with databases. It returns an error if
+// the schema fails to execute.
+func Compile(schema string) (*Comparator, error) {
+	return CompileWithOptions(schema, nil)
+}
+
+// CompileWithOptions is like Compile but takes Options controlling the
+// comparison, such as IgnoreColumnOrder and ReadOnly. A nil opts is the same as
+// DefaultOptions().
+func CompileWithOptions(schema string, opts *Options) (*Comparator, error) {
+	opts = resolveOptions(opts)
+	canonical, err := canonicalSchema(schema, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Comparator{schema: schema, canonical: canonical, opts: opts}, nil
+}
+
+// Equals reports whether the database at dbPath has the compiled schema, as
+// SchemasEqual would. It returns false if the database can't be read.
+func (c *Comparator) Equals(dbPath string) bool {
+	if c.opts.ReadOnly {
+		dbPath = readOnlyDSN(dbPath)
+	}
+	db, err := openDB(dbPath, c.opts)
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+
+	if matchesRecordedVersion(db, c.schema) {
+		return true
+	}
+	dbSchema, err := getFullSchema(db, c.opts)
+	if err != nil {
+		return false
+	}
+	return slices.Equal(dbSchema, c.canonical)
+}
@@ -0,0 +1,40 @@
+package autosqlite
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestComparator(t *testing.T) {
+	dir := t.TempDir()
+	var shards []string
+	for i, schema := range []string{schemaV1, schemaV1, schemaV2} {
+		dbPath := filepath.Join(dir, fmt.Sprintf("shard%d.db", i))
+		db, err := Open(schema, dbPath)
+		if err != nil {
+			t.Fatalf("failed to create shard: %v", err)
+		}
+		db.Close()
+		shards = append(shards, dbPath)
+	}
+
+	// Spelled differently from the schema the shards were created with, so
+	// the comparison can't rely on the recorded hash
+	c, err := Compile("create table users (id integer primary key, name text);")
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	for i, want := range []bool{true, true, false} {
+		if got := c.Equals(shards[i]); got != want {
+			t.Errorf("shard %d: expected Equals to be %v, got %v", i, want, got)
+		}
+	}
+	if c.Equals(filepath.Join(dir, "missing", "shard.db")) {
+		t.Error("expected a missing database not to be equal")
+	}
+
+	if _, err := Compile("CREATE TABLE broken ("); err == nil {
+		t.Error("expected an error for an invalid schema")
+	}
+}
@@ -0,0 +1,31 @@
+package autosqlite
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ConflictFunc is the type of Options.OnConflict. It is called with the name
+// of the table in the new schema, the row that could not be inserted as it
+// was read from the old database, keyed by column name in the new schema, and
+// the constraint error SQLite reported for it.
+type ConflictFunc func(table string, row map[string]interface{}, err error)
+
+// isConstraintError reports whether err is SQLite's SQLITE_CONSTRAINT, which
+// means the statement broke a UNIQUE, PRIMARY KEY, NOT NULL, CHECK or FOREIGN
+// KEY constraint.
+func isConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint
+}
+
+// conflictRow returns the values of a row that is being inserted into columns,
+// keyed by column name, for a ConflictFunc.
+func conflictRow(columns []string, values []any) map[string]interface{} {
+	row := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		row[column] = values[i]
+	}
+	return row
+}
@@ -0,0 +1,100 @@
+package autosqlite
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOnConflict(t *testing.T) {
+	const oldSchema = `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`
+	const newSchema = `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE);`
+
+	type conflict struct {
+		table string
+		id    int64
+		email string
+	}
+	for _, tc := range []struct {
+		policy ConflictPolicy
+		kept   []int64 // ids left in the table
+	}{
+		{ConflictIgnore, []int64{1, 2}},
+		{ConflictReplace, []int64{3, 4}},
+	} {
+		dbPath := tempDBPath(t)
+		db, err := Open(oldSchema, dbPath)
+		if err != nil {
+			t.Fatalf("failed to create db: %v", err)
+		}
+		if _, err := db.Exec("INSERT INTO users (id, email) VALUES (1, 'a'), (2, 'b'), (3, 'a'), (4, 'b')"); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		db.Close()
+
+		var conflicts []conflict
+		var report MigrationReport
+		opts := DefaultOptions()
+		opts.ConflictPolicy = tc.policy
+		opts.Report = &report
+		opts.OnConflict = func(table string, row map[string]interface{}, err error) {
+			if !isConstraintError(err) {
+				t.Errorf("expected a constraint error, got %v", err)
+			}
+			email, _ := row["email"].(string)
+			conflicts = append(conflicts, conflict{table, row["id"].(int64), email})
+		}
+		db, err = OpenWithOptions(newSchema, dbPath, opts)
+		if err != nil {
+			t.Fatalf("migration failed: %v", err)
+		}
+
+		want := []conflict{{"users", 3, "a"}, {"users", 4, "b"}}
+		if len(conflicts) != len(want) || conflicts[0] != want[0] || conflicts[1] != want[1] {
+			t.Errorf("policy %d: expected conflicts %v, got %v", tc.policy, want, conflicts)
+		}
+		var kept []int64
+		rows, err := db.Query("SELECT id FROM users ORDER BY id")
+		if err != nil {
+			t.Fatalf("failed to query: %v", err)
+		}
+		for rows.Next() {
+			var id int64
+			rows.Scan(&id)
+			kept = append(kept, id)
+		}
+		rows.Close()
+		if len(kept) != 2 || kept[0] != tc.kept[0] || kept[1] != tc.kept[1] {
+			t.Errorf("policy %d: expected ids %v, got %v", tc.policy, tc.kept, kept)
+		}
+		if report.Stats.RowsSkipped != 2 {
+			t.Errorf("policy %d: expected 2 rows skipped, got %d", tc.policy, report.Stats.RowsSkipped)
+		}
+		db.Close()
+	}
+}
+
+func TestOnConflictAbort(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (email) VALUES ('a'), ('a')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	calls := 0
+	opts := DefaultOptions()
+	opts.OnConflict = func(string, map[string]interface{}, error) { calls++ }
+	_, err = OpenWithOptions(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE);`, dbPath, opts)
+	var migErr *MigrationError
+	if !errors.As(err, &migErr) || migErr.Table != "users" || calls != 1 {
+		t.Errorf("expected the migration to fail after one callback, got %v after %d", err, calls)
+	}
+
+	opts.RebuildInPlace = true
+	if _, err := OpenWithOptions(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE);`, dbPath, opts); err == nil {
+		t.Error("expected OnConflict to be refused with RebuildInPlace")
+	}
+}
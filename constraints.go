@@ -0,0 +1,224 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Constraints describes the constraints of a table, as returned by
+// GetConstraints.
+type Constraints struct {
+	PrimaryKey  []string           // Primary key columns in key order, or nil if none is declared
+	NotNull     []string           // Columns with a NOT NULL constraint
+	Unique      []UniqueConstraint // UNIQUE constraints and unique indexes
+	Checks      []CheckConstraint  // CHECK constraints, of columns and of the table
+	ForeignKeys []ForeignKey       // FOREIGN KEY constraints
+}
+
+// UniqueConstraint is a UNIQUE constraint of a table, or a unique index on it.
+type UniqueConstraint struct {
+	Index   string   // Name of the index that enforces the constraint
+	Columns []string // Indexed columns in order, with "" for an expression
+	Inline  bool     // Declared in CREATE TABLE rather than by CREATE UNIQUE INDEX
+}
+
+// CheckConstraint is a CHECK constraint of a table or one of its columns.
+type CheckConstraint struct {
+	Name string // Name given with CONSTRAINT, or ""
+	Expr string // The checked expression, as written
+}
+
+// ForeignKey is a FOREIGN KEY constraint of a table, or a REFERENCES clause of
+// one of its columns.
+type ForeignKey struct {
+	Columns    []string // Columns of the table
+	Table      string   // Referenced (parent) table
+	References []string // Referenced columns, or "" for each one that refers to the parent's primary key
+	OnUpdate   string   // ON UPDATE action, e.g. "CASCADE" or "NO ACTION"
+	OnDelete   string   // ON DELETE action
+}
+
+// GetConstraints returns the constraints of table in db. Partial unique indexes
+// are left out, since they don't constrain the whole table. Returns an error if
+// the table does not exist or if there's a database error.
+func GetConstraints(db *sql.DB, table string) (*Constraints, error) {
+	var createSQL string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name=? COLLATE NOCASE", table).Scan(&createSQL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table definition: %w", err)
+	}
+
+	c := &Constraints{Checks: checkConstraints(createSQL)}
+	if err := c.readColumns(db, table); err != nil {
+		return nil, err
+	}
+	if err := c.readUnique(db, table); err != nil {
+		return nil, err
+	}
+	if err := c.readForeignKeys(db, table); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readColumns fills in the primary key and NOT NULL columns of table.
+func (c *Constraints) readColumns(db *sql.DB, table string) error {
+	rows, err := db.Query(`SELECT name, "notnull", pk FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return fmt.Errorf("failed to read columns: %w", err)
+	}
+	defer rows.Close()
+
+	type keyColumn struct {
+		name string
+		seq  int
+	}
+	var pk []keyColumn
+	for rows.Next() {
+		var name string
+		var notNull bool
+		var seq int
+		if err := rows.Scan(&name, &notNull, &seq); err != nil {
+			return fmt.Errorf("failed to read columns: %w", err)
+		}
+		if notNull {
+			c.NotNull = append(c.NotNull, name)
+		}
+		if seq > 0 {
+			pk = append(pk, keyColumn{name, seq})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read columns: %w", err)
+	}
+
+	slices.SortFunc(pk, func(a, b keyColumn) int { return a.seq - b.seq })
+	for _, col := range pk {
+		c.PrimaryKey = append(c.PrimaryKey, col.name)
+	}
+	return nil
+}
+
+// readUnique fills in the UNIQUE constraints and unique indexes of table.
+func (c *Constraints) readUnique(db *sql.DB, table string) error {
+	rows, err := db.Query(`SELECT name, origin FROM pragma_index_list(?) WHERE "unique" AND NOT partial AND origin != 'pk' ORDER BY name`, table)
+	if err != nil {
+		return fmt.Errorf("failed to read indexes: %w", err)
+	}
+	var unique []UniqueConstraint
+	for rows.Next() {
+		var name, origin string
+		if err := rows.Scan(&name, &origin); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read indexes: %w", err)
+		}
+		unique = append(unique, UniqueConstraint{Index: name, Inline: origin == "u"})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read indexes: %w", err)
+	}
+
+	for i := range unique {
+		columns, err := indexColumns(db, unique[i].Index)
+		if err != nil {
+			return err
+		}
+		unique[i].Columns = columns
+	}
+	c.Unique = unique
+	return nil
+}
+
+// indexColumns returns the columns of index in order, with "" for expressions.
+func indexColumns(db *sql.DB, index string) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM pragma_index_info(?) ORDER BY seqno", index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index %s: %w", index, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name sql.NullString
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to read index %s: %w", index, err)
+		}
+		columns = append(columns, name.String)
+	}
+	return columns, rows.Err()
+}
+
+// readForeignKeys fills in the foreign keys of table.
+func (c *Constraints) readForeignKeys(db *sql.DB, table string) error {
+	rows, err := db.Query("SELECT id, \"table\", \"from\", \"to\", on_update, on_delete FROM pragma_foreign_key_list(?) ORDER BY id, seq", table)
+	if err != nil {
+		return fmt.Errorf("failed to read foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	lastID := -1
+	for rows.Next() {
+		var id int
+		var parent, from, onUpdate, onDelete string
+		var to sql.NullString
+		if err := rows.Scan(&id, &parent, &from, &to, &onUpdate, &onDelete); err != nil {
+			return fmt.Errorf("failed to read foreign keys: %w", err)
+		}
+		if id != lastID {
+			c.ForeignKeys = append(c.ForeignKeys, ForeignKey{Table: parent, OnUpdate: onUpdate, OnDelete: onDelete})
+			lastID = id
+		}
+		fk := &c.ForeignKeys[len(c.ForeignKeys)-1]
+		fk.Columns = append(fk.Columns, from)
+		fk.References = append(fk.References, to.String)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read foreign keys: %w", err)
+	}
+	return nil
+}
+
+// checkConstraints finds the CHECK constraints in a CREATE TABLE statement.
+func checkConstraints(createSQL string) []CheckConstraint {
+	tokens := tokenize(createSQL)
+	var checks []CheckConstraint
+	for i, tok := range tokens {
+		if !tok.is("CHECK") || i+1 >= len(tokens) || tokens[i+1].text != "(" {
+			continue
+		}
+		// Find the matching close parenthesis
+		depth := 0
+		end := -1
+		for j := i + 1; j < len(tokens) && end == -1; j++ {
+			if tokens[j].kind != tokPunct {
+				continue
+			}
+			switch tokens[j].text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+				if depth == 0 {
+					end = j
+				}
+			}
+		}
+		if end == -1 {
+			continue
+		}
+
+		check := CheckConstraint{Expr: strings.TrimSpace(createSQL[tokens[i+1].pos+1 : tokens[end].pos])}
+		if i >= 2 && tokens[i-2].is("CONSTRAINT") {
+			check.Name = tokens[i-1].name()
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
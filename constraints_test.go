@@ -0,0 +1,81 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+)
+
+func TestGetConstraints(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	schema := `
+		CREATE TABLE teams (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE);
+		CREATE TABLE members (
+			team_id INTEGER NOT NULL REFERENCES teams ON DELETE CASCADE,
+			user_id INTEGER NOT NULL,
+			email TEXT CHECK (email LIKE '%@%'),
+			role TEXT,
+			PRIMARY KEY (user_id, team_id),
+			CONSTRAINT valid_role CHECK (role IN ('admin', 'member')),
+			FOREIGN KEY (role) REFERENCES roles(name)
+		);
+		CREATE UNIQUE INDEX idx_members_email ON members(lower(email), team_id);
+		CREATE UNIQUE INDEX idx_members_admin ON members(team_id) WHERE role = 'admin';`
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	c, err := GetConstraints(db, "members")
+	if err != nil {
+		t.Fatalf("GetConstraints failed: %v", err)
+	}
+	if want := []string{"user_id", "team_id"}; !slices.Equal(c.PrimaryKey, want) {
+		t.Errorf("expected primary key %v, got %v", want, c.PrimaryKey)
+	}
+	if want := []string{"team_id", "user_id"}; !slices.Equal(c.NotNull, want) {
+		t.Errorf("expected NOT NULL columns %v, got %v", want, c.NotNull)
+	}
+	if len(c.Unique) != 1 || c.Unique[0].Index != "idx_members_email" || c.Unique[0].Inline ||
+		!slices.Equal(c.Unique[0].Columns, []string{"", "team_id"}) {
+		t.Errorf("expected only the unique expression index, got %+v", c.Unique)
+	}
+	wantChecks := []CheckConstraint{{Expr: "email LIKE '%@%'"}, {Name: "valid_role", Expr: "role IN ('admin', 'member')"}}
+	if !slices.Equal(c.Checks, wantChecks) {
+		t.Errorf("expected checks %+v, got %+v", wantChecks, c.Checks)
+	}
+	if len(c.ForeignKeys) != 2 {
+		t.Fatalf("expected 2 foreign keys, got %+v", c.ForeignKeys)
+	}
+	for _, fk := range c.ForeignKeys {
+		switch fk.Table {
+		case "teams":
+			if !slices.Equal(fk.Columns, []string{"team_id"}) || !slices.Equal(fk.References, []string{""}) || fk.OnDelete != "CASCADE" {
+				t.Errorf("unexpected foreign key to teams: %+v", fk)
+			}
+		case "roles":
+			if !slices.Equal(fk.Columns, []string{"role"}) || !slices.Equal(fk.References, []string{"name"}) || fk.OnDelete != "NO ACTION" {
+				t.Errorf("unexpected foreign key to roles: %+v", fk)
+			}
+		default:
+			t.Errorf("unexpected foreign key: %+v", fk)
+		}
+	}
+
+	c, err = GetConstraints(db, "TEAMS")
+	if err != nil {
+		t.Fatalf("GetConstraints failed: %v", err)
+	}
+	if len(c.Unique) != 1 || !c.Unique[0].Inline || !slices.Equal(c.Unique[0].Columns, []string{"name"}) {
+		t.Errorf("expected the inline UNIQUE constraint, got %+v", c.Unique)
+	}
+
+	if _, err := GetConstraints(db, "missing"); err == nil {
+		t.Error("expected an error for a missing table")
+	}
+}
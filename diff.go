@@ -0,0 +1,212 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SchemaDiff lists the tables, indexes, triggers and views that differ between
+// two schemas. Definitions are compared the same way SchemasEqual compares them.
+type SchemaDiff struct {
+	Added   []SchemaObject // Objects only in the new schema
+	Removed []SchemaObject // Objects only in the old schema
+	Changed []SchemaObject // Objects in both schemas with different definitions
+
+	// Schema versions recorded in the two databases, or 0 for a database
+	// without version history. Only CompareDatabases fills these in; they
+	// don't count towards Empty.
+	OldVersion int
+	NewVersion int
+}
+
+// Empty reports whether the two schemas are the same.
+func (d *SchemaDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns the differences one per line, marked "+" for added, "-" for
+// removed and "~" for changed objects.
+func (d *SchemaDiff) String() string {
+	var b strings.Builder
+	for _, section := range []struct {
+		mark    string
+		objects []SchemaObject
+	}{{"+", d.Added}, {"-", d.Removed}, {"~", d.Changed}} {
+		for _, obj := range section.objects {
+			fmt.Fprintf(&b, "%s %s %s\n", section.mark, obj.Type, obj.Name)
+		}
+	}
+	return b.String()
+}
+
+// schemaEntry is an object of a database schema with its normalized definition.
+type schemaEntry struct {
+	object SchemaObject
+	sql    string
+}
+
+// schemaEntries returns the objects of db as compared by getFullSchema, sorted by
+// type and name. Tables in opts.SkipTables and the objects on them are left out.
+func schemaEntries(db queryer, opts *Options) ([]schemaEntry, error) {
+	rows, err := db.Query(`SELECT type, name, tbl_name, sql FROM sqlite_master WHERE type IN ('table','index','trigger','view') AND name NOT LIKE 'sqlite_%' AND tbl_name != ?
+		AND name NOT IN (`+shadowTablesQuery+`) ORDER BY type, name`, versionTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []schemaEntry
+	for rows.Next() {
+		var e schemaEntry
+		if err := rows.Scan(&e.object.Type, &e.object.Name, &e.object.Table, &e.sql); err != nil {
+			return nil, err
+		}
+		if opts.skips(e.object.Table) {
+			continue
+		}
+		// Normalize whitespace, keyword case and DEFAULT expressions
+		if e.object.Type == "table" && opts.IgnoreColumnOrder {
+			e.sql = canonicalSQLUnordered(e.sql)
+		} else {
+			e.sql = canonicalSQL(e.sql)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// diffDatabases compares the schemas of oldDB and newDB.
+func diffDatabases(oldDB, newDB queryer, opts *Options) (*SchemaDiff, error) {
+	oldEntries, err := schemaEntries(oldDB, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read old schema: %w", err)
+	}
+	newEntries, err := schemaEntries(newDB, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read new schema: %w", err)
+	}
+
+	key := func(obj SchemaObject) string {
+		return obj.Type + " " + strings.ToLower(obj.Name)
+	}
+	oldByKey := make(map[string]schemaEntry)
+	for _, e := range oldEntries {
+		oldByKey[key(e.object)] = e
+	}
+	newKeys := make(map[string]bool)
+
+	diff := &SchemaDiff{}
+	for _, e := range newEntries {
+		newKeys[key(e.object)] = true
+		old, ok := oldByKey[key(e.object)]
+		if !ok {
+			diff.Added = append(diff.Added, e.object)
+		} else if old.sql != e.sql {
+			diff.Changed = append(diff.Changed, e.object)
+		}
+	}
+	for _, e := range oldEntries {
+		if !newKeys[key(e.object)] {
+			diff.Removed = append(diff.Removed, e.object)
+		}
+	}
+	return diff, nil
+}
+
+// CompareDatabases compares the schemas of two existing databases, for example to
+// find out why production differs from staging. Objects only in dbPathB are
+// reported as Added and objects only in dbPathA as Removed. The _autosqlite_version
+// table is not compared, but the schema version of each database is reported in
+// OldVersion and NewVersion. Neither database is modified; if one doesn't exist
+// the error wraps ErrDatabaseMissing.
+func CompareDatabases(dbPathA, dbPathB string) (*SchemaDiff, error) {
+	return CompareDatabasesWithOptions(dbPathA, dbPathB, nil)
+}
+
+// CompareDatabasesWithOptions is like CompareDatabases but takes Options
+// controlling the comparison. A nil opts is the same as DefaultOptions().
+func CompareDatabasesWithOptions(dbPathA, dbPathB string, opts *Options) (*SchemaDiff, error) {
+	opts = resolveOptions(opts)
+
+	dbA, err := openForComparison(dbPathA, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer dbA.Close()
+	dbB, err := openForComparison(dbPathB, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer dbB.Close()
+
+	diff, err := diffDatabases(dbA, dbB, opts)
+	if err != nil {
+		return nil, err
+	}
+	if diff.OldVersion, err = currentVersionNumber(dbA); err != nil {
+		return nil, err
+	}
+	if diff.NewVersion, err = currentVersionNumber(dbB); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// MigrateToMatch migrates the database at dbPath to the schema of the database at
+// targetDbPath, for example to bring a development database in line with a
+// golden copy of production's structure. The schema is taken from the target
+// with DumpSchema, so its _autosqlite_version table is not part of it, and the
+// migration is otherwise the same as Migrate's. The target is opened read-only;
+// if it doesn't exist the error wraps ErrDatabaseMissing.
+func MigrateToMatch(targetDbPath, dbPath string) (*sql.DB, error) {
+	return MigrateToMatchWithOptions(targetDbPath, dbPath, nil)
+}
+
+// MigrateToMatchWithOptions is like MigrateToMatch but takes Options controlling
+// the migration. A nil opts is the same as DefaultOptions().
+func MigrateToMatchWithOptions(targetDbPath, dbPath string, opts *Options) (*sql.DB, error) {
+	opts = resolveOptions(opts)
+	target, err := openForComparison(targetDbPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := DumpSchema(target)
+	target.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target schema: %w", err)
+	}
+	return MigrateWithOptions(schema, dbPath, opts)
+}
+
+// openForComparison opens the existing database at dbPath read-only.
+func openForComparison(dbPath string, opts *Options) (*sql.DB, error) {
+	filename := extractFilenameFromConnectionString(dbPath)
+	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrDatabaseMissing, filename)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to stat database: %w", err)
+	}
+
+	db, err := openDB(readOnlyDSN(dbPath), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+// diffSchema compares the schema of db with schema.
+func diffSchema(db *sql.DB, schema string, opts *Options) (*SchemaDiff, error) {
+	tempDB, err := openTemporaryDB()
+	if err != nil {
+		return nil, err
+	}
+	defer tempDB.Close()
+
+	if err := execSchema(tempDB, schema); err != nil {
+		return nil, fmt.Errorf("failed to execute schema: %w", err)
+	}
+	return diffDatabases(db, tempDB, opts)
+}
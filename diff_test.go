@@ -0,0 +1,87 @@
+package autosqlite
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareDatabases(t *testing.T) {
+	staging := tempDBPath(t)
+	prod := filepath.Join(t.TempDir(), "prod.db")
+
+	db, err := Open(schemaV1, prod)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+	for _, schema := range []string{schemaV1, schemaV2 + " CREATE INDEX idx_users_email ON users(email);"} {
+		db, err := Open(schema, staging)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		db.Close()
+	}
+
+	diff, err := CompareDatabases(prod, staging)
+	if err != nil {
+		t.Fatalf("CompareDatabases failed: %v", err)
+	}
+	want := "+ index idx_users_email\n~ table users\n"
+	if diff.String() != want {
+		t.Errorf("expected diff %q, got %q", want, diff.String())
+	}
+	if diff.OldVersion != 1 || diff.NewVersion != 2 {
+		t.Errorf("expected versions 1 and 2, got %d and %d", diff.OldVersion, diff.NewVersion)
+	}
+
+	diff, err = CompareDatabases(staging, staging)
+	if err != nil || !diff.Empty() {
+		t.Errorf("expected no differences between a database and itself, got %v, %v", diff, err)
+	}
+
+	if _, err := CompareDatabases(prod, filepath.Join(t.TempDir(), "missing.db")); !errors.Is(err, ErrDatabaseMissing) {
+		t.Errorf("expected ErrDatabaseMissing, got %v", err)
+	}
+}
+
+func TestMigrateToMatch(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "golden.db")
+	dev := tempDBPath(t)
+
+	db, err := Open(schemaV2+" CREATE INDEX idx_users_email ON users(email);", golden)
+	if err != nil {
+		t.Fatalf("failed to create golden db: %v", err)
+	}
+	db.Close()
+	db, err = Open(schemaV1, dev)
+	if err != nil {
+		t.Fatalf("failed to create dev db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	db, err = MigrateToMatch(golden, dev)
+	if err != nil {
+		t.Fatalf("MigrateToMatch failed: %v", err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM users").Scan(&name); err != nil || name != "alice" {
+		t.Errorf("expected data to be kept, got %q, %v", name, err)
+	}
+	db.Close()
+
+	diff, err := CompareDatabases(golden, dev)
+	if err != nil {
+		t.Fatalf("CompareDatabases failed: %v", err)
+	}
+	if !diff.Empty() {
+		t.Errorf("expected the databases to match, got %v", diff)
+	}
+
+	if _, err := MigrateToMatch(filepath.Join(t.TempDir(), "missing.db"), dev); !errors.Is(err, ErrDatabaseMissing) {
+		t.Errorf("expected ErrDatabaseMissing, got %v", err)
+	}
+}
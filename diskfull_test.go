@@ -0,0 +1,92 @@
+//go:build linux
+
+package autosqlite
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// limitFileSize makes writes that would grow any file of the process past limit
+// bytes fail, much as they would on a full disk, until the test ends.
+func limitFileSize(t *testing.T, limit uint64) {
+	var old syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &old); err != nil {
+		t.Fatalf("failed to get file size limit: %v", err)
+	}
+	// Without this the process is killed with SIGXFSZ instead of seeing an error
+	signal.Ignore(syscall.SIGXFSZ)
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: limit, Max: old.Max}); err != nil {
+		t.Fatalf("failed to set file size limit: %v", err)
+	}
+	t.Cleanup(func() {
+		syscall.Setrlimit(syscall.RLIMIT_FSIZE, &old)
+		signal.Reset(syscall.SIGXFSZ)
+	})
+}
+
+func TestDiskFull(t *testing.T) {
+	const padSchema = `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, pad BLOB);`
+
+	tests := []struct {
+		name       string
+		limit      uint64
+		backfills  map[string]string
+		phase      string
+		wantBackup bool
+	}{
+		// The backup can't be written
+		{name: "backup", limit: 64 << 10, phase: PhaseBackup},
+		// The backup is written but the migrated copy outgrows the limit
+		{name: "data copy", limit: 1 << 20, backfills: map[string]string{"users.pad": "zeroblob(10000)"}, phase: PhaseDataCopy, wantBackup: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := tempDBPath(t)
+			db, err := Open(schemaV1, dbPath)
+			if err != nil {
+				t.Fatalf("failed to create db: %v", err)
+			}
+			for i := 0; i < 1000; i++ {
+				if _, err := db.Exec("INSERT INTO users (name) VALUES (?)", strings.Repeat("x", 200)); err != nil {
+					t.Fatalf("failed to insert: %v", err)
+				}
+			}
+			db.Close()
+			original, err := os.ReadFile(dbPath)
+			if err != nil {
+				t.Fatalf("failed to read db: %v", err)
+			}
+
+			opts := DefaultOptions()
+			opts.Backfills = tt.backfills
+			limitFileSize(t, tt.limit)
+			_, err = MigrateWithOptions(padSchema, dbPath, opts)
+			var migErr *MigrationError
+			if !errors.As(err, &migErr) || migErr.Phase != tt.phase {
+				t.Fatalf("expected migration to fail during %s, got %v", tt.phase, err)
+			}
+
+			after, err := os.ReadFile(dbPath)
+			if err != nil || !bytes.Equal(original, after) {
+				t.Fatalf("expected the database to be unchanged, %v", err)
+			}
+			if !SchemasEqual(schemaV1, dbPath) {
+				t.Errorf("expected the database to keep its schema")
+			}
+			for _, leftover := range []string{".tmp", ".tmp-journal"} {
+				if _, err := os.Stat(dbPath + leftover); !os.IsNotExist(err) {
+					t.Errorf("expected %s to be removed, got %v", leftover, err)
+				}
+			}
+			if _, err := os.Stat(dbPath + ".backup"); os.IsNotExist(err) == tt.wantBackup {
+				t.Errorf("expected backup to exist: %v, got %v", tt.wantBackup, err)
+			}
+		})
+	}
+}
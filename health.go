@@ -0,0 +1,112 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrDatabaseMissing is returned by HealthCheck when the database file doesn't exist.
+	ErrDatabaseMissing = errors.New("database does not exist")
+	// ErrSchemaMismatch is returned by HealthCheck when the database doesn't have the schema.
+	ErrSchemaMismatch = errors.New("database schema does not match")
+	// ErrIntegrityCheckFailed is returned by HealthCheck when SQLite reports corruption.
+	ErrIntegrityCheckFailed = errors.New("database integrity check failed")
+)
+
+// HealthCheck checks that the database at dbPath exists, has the given schema and
+// passes SQLite's integrity check, for example as a readiness probe. It never
+// creates or migrates the database. It returns nil if all is well, or an error
+// wrapping ErrDatabaseMissing, ErrSchemaMismatch or ErrIntegrityCheckFailed.
+//
+// By default the faster PRAGMA quick_check is used; set
+// Options.FullIntegrityCheck for the full PRAGMA integrity_check.
+func HealthCheck(schema, dbPath string) error {
+	return HealthCheckWithOptions(schema, dbPath, nil)
+}
+
+// HealthCheckWithOptions is like HealthCheck but takes Options. A nil opts is the
+// same as DefaultOptions().
+func HealthCheckWithOptions(schema, dbPath string, opts *Options) error {
+	opts = resolveOptions(opts)
+
+	filename := extractFilenameFromConnectionString(dbPath)
+	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrDatabaseMissing, filename)
+	} else if err != nil {
+		return fmt.Errorf("failed to stat database: %w", err)
+	}
+
+	db, err := openDB(dbPath, opts)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	// Check integrity first: a corrupt schema page would otherwise be reported
+	// as a schema mismatch
+	if err := checkIntegrity(db, !opts.FullIntegrityCheck); err != nil {
+		return err
+	}
+	ok, err := schemaMatches(db, schema, opts)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrIntegrityCheckFailed, err)
+	}
+	if !ok {
+		return ErrSchemaMismatch
+	}
+	return nil
+}
+
+// checkIntegrity runs PRAGMA quick_check, or PRAGMA integrity_check if quick is
+// false, on db and returns an error wrapping ErrIntegrityCheckFailed with the
+// problems it reports. A database too damaged for the check to run at all also
+// fails with ErrIntegrityCheckFailed.
+func checkIntegrity(db *sql.DB, quick bool) error {
+	pragma := "PRAGMA integrity_check"
+	if quick {
+		pragma = "PRAGMA quick_check"
+	}
+	rows, err := db.Query(pragma)
+	if isCorrupt(err) {
+		return fmt.Errorf("%w: %v", ErrIntegrityCheckFailed, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to run %s: %w", pragma, err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return fmt.Errorf("failed to read %s result: %w", pragma, err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); isCorrupt(err) {
+		return fmt.Errorf("%w: %v", ErrIntegrityCheckFailed, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to run %s: %w", pragma, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrIntegrityCheckFailed, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// isCorrupt reports whether err is SQLite saying the file is damaged or isn't a
+// database at all.
+func isCorrupt(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB
+	}
+	return false
+}
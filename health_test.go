@@ -0,0 +1,69 @@
+package autosqlite
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	dbPath := tempDBPath(t)
+
+	if err := HealthCheck(schemaV1, dbPath); !errors.Is(err, ErrDatabaseMissing) {
+		t.Errorf("expected ErrDatabaseMissing, got %v", err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Fatalf("HealthCheck must not create the database")
+	}
+
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	if err := HealthCheck(schemaV1, dbPath); err != nil {
+		t.Errorf("expected healthy database, got %v", err)
+	}
+	opts := DefaultOptions()
+	opts.FullIntegrityCheck = true
+	if err := HealthCheckWithOptions(schemaV1, dbPath, opts); err != nil {
+		t.Errorf("expected healthy database with full integrity check, got %v", err)
+	}
+	if err := HealthCheck(schemaV2, dbPath); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("expected ErrSchemaMismatch, got %v", err)
+	}
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Errorf("HealthCheck must not migrate the database")
+	}
+}
+
+func TestHealthCheckCorruptSchemaPage(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	// Zero the b-tree header of page 1, which holds sqlite_master
+	f, err := os.OpenFile(dbPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open db file: %v", err)
+	}
+	if _, err := f.WriteAt(make([]byte, 200), 100); err != nil {
+		t.Fatalf("failed to corrupt db file: %v", err)
+	}
+	f.Close()
+
+	err = HealthCheck(schemaV1, dbPath)
+	if !errors.Is(err, ErrIntegrityCheckFailed) {
+		t.Fatalf("expected ErrIntegrityCheckFailed, got %v", err)
+	}
+	if errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("corruption must not be reported as a schema mismatch: %v", err)
+	}
+}
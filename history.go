@@ -0,0 +1,353 @@
+package autosqlite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// legacyTimestampFormat is the format of timestamps recorded by SQLite's
+// datetime('now'), which older versions of this package used. They are UTC.
+const legacyTimestampFormat = "2006-01-02 15:04:05"
+
+// AppliedAt parses Timestamp, the time the version was applied. Timestamps are
+// recorded in RFC 3339 format; those written by older versions of this package
+// in SQLite's "YYYY-MM-DD HH:MM:SS" format are also accepted and taken as UTC.
+func (v SchemaVersion) AppliedAt() (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v.Timestamp); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(legacyTimestampFormat, v.Timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid schema version timestamp %q", v.Timestamp)
+	}
+	return t, nil
+}
+
+// AppliedSchemas returns every schema version recorded in the database's
+// _autosqlite_version table, oldest first, including the schema text that
+// was applied and its description. Compressed schema text is decompressed; SchemaSQL is empty for
+// versions whose text was pruned or recorded with StoreHashOnly. It returns an
+// empty list if the database has no version table.
+func AppliedSchemas(db *sql.DB) ([]SchemaVersion, error) {
+	exists, err := versionTableExists(db)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	columns, err := versionColumns(db, "version", "hash", "timestamp", "schema_sql", "description")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT " + columns + " FROM " + versionTableName + " ORDER BY version, rowid")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query version table: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []SchemaVersion
+	for rows.Next() {
+		var version SchemaVersion
+		var schemaSQL []byte
+		var description sql.NullString
+		if err := rows.Scan(&version.Version, &version.Hash, &version.Timestamp, &schemaSQL, &description); err != nil {
+			return nil, fmt.Errorf("failed to scan version row: %w", err)
+		}
+		if version.SchemaSQL, err = decodeSchemaSQL(schemaSQL); err != nil {
+			return nil, fmt.Errorf("failed to decode schema of version %d: %w", version.Version, err)
+		}
+		version.Description = description.String
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// ErrVersionNotFound is returned by SchemaAtVersion when the database has no
+// record of the version.
+var ErrVersionNotFound = errors.New("schema version not found")
+
+// ErrSchemaNotStored is returned by SchemaAtVersion when the version is
+// recorded but its schema text isn't, because it was pruned by
+// PruneVersionHistory or recorded with StoreHashOnly.
+var ErrSchemaNotStored = errors.New("schema text not stored")
+
+// SchemaAtVersion returns the schema text that was applied at version, as
+// recorded in the database's _autosqlite_version table, for example to compare
+// two versions with SchemasEqualStrings or to create a database with an older
+// schema. Compressed schema text is decompressed. If the version was recorded
+// more than once, because the history was cleared and started again, the latest
+// record is used.
+func SchemaAtVersion(db *sql.DB, version int) (string, error) {
+	exists, err := versionTableExists(db)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+	}
+
+	column, err := versionColumns(db, "schema_sql")
+	if err != nil {
+		return "", err
+	}
+	var schemaSQL []byte
+	err = db.QueryRow("SELECT "+column+" FROM "+versionTableName+" WHERE version = ? ORDER BY rowid DESC LIMIT 1", version).Scan(&schemaSQL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query version table: %w", err)
+	}
+	if schemaSQL == nil {
+		return "", fmt.Errorf("%w: version %d", ErrSchemaNotStored, version)
+	}
+	schema, err := decodeSchemaSQL(schemaSQL)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode schema of version %d: %w", version, err)
+	}
+	return schema, nil
+}
+
+// PruneVersionHistory bounds the growth of the _autosqlite_version table by
+// discarding the stored schema text of all but the keepLast most recent
+// versions. The current version is always kept in full, even if keepLast is
+// less than 1.
+//
+// Rows are not deleted: their version numbers, hashes and timestamps are kept,
+// because backward migration detection relies on knowing every hash that was
+// ever applied. Pruned versions are still listed by AppliedSchemas, with an
+// empty SchemaSQL.
+func PruneVersionHistory(db *sql.DB, keepLast int) error {
+	exists, err := versionTableExists(db)
+	if err != nil || !exists {
+		return err
+	}
+
+	if keepLast < 1 {
+		keepLast = 1
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`UPDATE %[1]s SET schema_sql = NULL WHERE rowid NOT IN (
+		SELECT rowid FROM %[1]s ORDER BY version DESC, rowid DESC LIMIT ?
+	)`, versionTableName), keepLast)
+	if err != nil {
+		return fmt.Errorf("failed to prune version history: %w", err)
+	}
+	return nil
+}
+
+// ClearVersionHistory deletes every row of the _autosqlite_version table, for
+// when a database has to go back to an older schema, which Open and Migrate
+// otherwise refuse as a backward migration. The next migration then starts a
+// new history at version 1. It holds the migration lock while it runs, so it
+// can't interfere with a migration of the same database by another process.
+func ClearVersionHistory(db *sql.DB) error {
+	return resetVersionHistory(db, false, DefaultOptions())
+}
+
+// ResetVersionHistory is like ClearVersionHistory but then records the
+// database's current schema as version 1, so that the history restarts from
+// the schema the database has now.
+func ResetVersionHistory(db *sql.DB) error {
+	return ResetVersionHistoryWithOptions(db, nil)
+}
+
+// ResetVersionHistoryWithOptions is like ResetVersionHistory but takes Options,
+// of which Clock and SchemaStorage apply to the version it records. A nil opts
+// is the same as DefaultOptions().
+func ResetVersionHistoryWithOptions(db *sql.DB, opts *Options) error {
+	return resetVersionHistory(db, true, resolveOptions(opts))
+}
+
+// resetVersionHistory clears the version table of db under the migration lock
+// and, if seed is set, records the current schema as version 1.
+func resetVersionHistory(db *sql.DB, seed bool, opts *Options) error {
+	filename, err := databaseFile(db)
+	if err != nil {
+		return err
+	}
+	if !isMemoryDatabase(filename) {
+		unlock, err := acquireMigrationLock(filename)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	var schema string
+	if seed {
+		if schema, err = DumpSchema(db); err != nil {
+			return fmt.Errorf("failed to dump schema: %w", err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	exists, err := versionTableExists(tx)
+	if err != nil {
+		return fmt.Errorf("failed to check for version table: %w", err)
+	}
+	if exists {
+		if _, err := tx.Exec("DELETE FROM " + versionTableName); err != nil {
+			return fmt.Errorf("failed to clear version history: %w", err)
+		}
+	}
+
+	if seed {
+		version := &SchemaVersion{
+			Version:   1,
+			Hash:      calculateSchemaHash(schema),
+			Timestamp: opts.timestamp(),
+		}
+		if err := recordSchemaVersion(tx, version, schema, opts.SchemaStorage); err != nil {
+			return fmt.Errorf("failed to record schema version: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// descriptionDirective introduces a schema comment giving a description of the
+// schema version, recorded in the version table when it is applied.
+const descriptionDirective = "autosqlite:description"
+
+// schemaDescription returns the description given by a comment of the form
+//
+//	-- autosqlite:description "add email to users"
+//
+// in schema, or "" if there is none. The quotes are optional; quoted text is
+// unescaped as a Go string literal. If there are several such comments, the
+// first one wins.
+func schemaDescription(schema string) string {
+	for _, line := range strings.Split(schema, "\n") {
+		comment, ok := strings.CutPrefix(strings.TrimSpace(line), "--")
+		if !ok {
+			continue
+		}
+		text, ok := strings.CutPrefix(strings.TrimSpace(comment), descriptionDirective)
+		if !ok || (text != "" && text[0] != ' ' && text[0] != '\t') {
+			continue
+		}
+		text = strings.TrimSpace(text)
+		if unquoted, err := strconv.Unquote(text); err == nil {
+			return unquoted
+		}
+		return text
+	}
+	return ""
+}
+
+// schemaFingerprint returns a hash of the schema of db as stored in
+// sqlite_master, excluding the version table and SQLite's internal tables.
+// Unlike getFullSchema it doesn't normalize anything, so it is cheap, but only
+// useful for telling whether the schema has changed at all.
+func schemaFingerprint(db dbtx) (string, error) {
+	rows, err := db.Query(`SELECT type, name, tbl_name, ifnull(sql, '') FROM sqlite_master
+		WHERE name NOT LIKE 'sqlite_%' AND tbl_name != ? ORDER BY type, name`, versionTableName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read schema: %w", err)
+	}
+	defer rows.Close()
+
+	h := sha256.New()
+	for rows.Next() {
+		var typ, name, table, text string
+		if err := rows.Scan(&typ, &name, &table, &text); err != nil {
+			return "", fmt.Errorf("failed to read schema: %w", err)
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00", typ, name, table, text)
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("failed to read schema: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// matchesRecordedVersion reports whether schema is the schema of the latest
+// version recorded in db and the database's schema is still the one it had
+// then, in which case the database certainly has schema and the full
+// comparison can be skipped. It returns false whenever that can't be told,
+// for example for versions recorded before fingerprints were.
+func matchesRecordedVersion(db *sql.DB, schema string) bool {
+	exists, err := versionTableExists(db)
+	if err != nil || !exists {
+		return false
+	}
+	columns, err := versionColumns(db, "hash", "fingerprint")
+	if err != nil {
+		return false
+	}
+	var hash, fingerprint sql.NullString
+	err = db.QueryRow("SELECT "+columns+" FROM "+versionTableName+" ORDER BY version DESC, rowid DESC LIMIT 1").Scan(&hash, &fingerprint)
+	if err != nil || !fingerprint.Valid || hash.String != calculateSchemaHash(schema) {
+		return false
+	}
+	current, err := schemaFingerprint(db)
+	return err == nil && current == fingerprint.String
+}
+
+// versionTableExists reports whether db has an _autosqlite_version table.
+func versionTableExists(db dbtx) (bool, error) {
+	var name string
+	err := db.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name=?", versionTableName).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// encodeSchemaSQL returns the value to store in the schema_sql column for schemaSQL.
+func encodeSchemaSQL(schemaSQL string, storage SchemaStorage) (interface{}, error) {
+	switch storage {
+	case StoreHashOnly:
+		return nil, nil
+	case StoreCompressed:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write([]byte(schemaSQL)); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return schemaSQL, nil
+	}
+}
+
+// decodeSchemaSQL returns the schema text from a schema_sql column value, which
+// may be plain text, gzip-compressed or NULL.
+func decodeSchemaSQL(stored []byte) (string, error) {
+	if len(stored) < 2 || stored[0] != 0x1f || stored[1] != 0x8b {
+		return string(stored), nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
@@ -0,0 +1,426 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppliedSchemas(t *testing.T) {
+	dbPath := tempDBPath(t)
+	for _, schema := range []string{schemaV1, schemaV2} {
+		db, err := Open(schema, dbPath)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		db.Close()
+	}
+
+	db, err := Open(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	versions, err := AppliedSchemas(db)
+	if err != nil {
+		t.Fatalf("AppliedSchemas failed: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(versions))
+	}
+	for i, schema := range []string{schemaV1, schemaV2} {
+		v := versions[i]
+		if v.Version != i+1 {
+			t.Fatalf("version %d: got version number %d", i, v.Version)
+		}
+		if v.Hash != calculateSchemaHash(schema) {
+			t.Fatalf("version %d: wrong hash", i)
+		}
+		if v.SchemaSQL != schema {
+			t.Fatalf("version %d: wrong schema text %q", i, v.SchemaSQL)
+		}
+		if v.Timestamp == "" {
+			t.Fatalf("version %d: missing timestamp", i)
+		}
+	}
+
+	// A database without version tracking has no history
+	plain := tempDBPath(t)
+	db2, err := sql.Open("sqlite3", plain)
+	if err != nil {
+		t.Fatalf("failed to open plain db: %v", err)
+	}
+	defer db2.Close()
+	versions, err = AppliedSchemas(db2)
+	if err != nil || len(versions) != 0 {
+		t.Fatalf("expected no versions, got %v: %v", versions, err)
+	}
+}
+
+func TestPruneVersionHistory(t *testing.T) {
+	dbPath := tempDBPath(t)
+	schemaV3 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, age INTEGER);`
+	schemaV4 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, age INTEGER, bio TEXT);`
+	for _, schema := range []string{schemaV1, schemaV2, schemaV3} {
+		db, err := Open(schema, dbPath)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		db.Close()
+	}
+
+	db, err := Open(schemaV3, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if err := PruneVersionHistory(db, 0); err != nil {
+		t.Fatalf("PruneVersionHistory failed: %v", err)
+	}
+	versions, err := AppliedSchemas(db)
+	if err != nil {
+		t.Fatalf("AppliedSchemas failed: %v", err)
+	}
+	db.Close()
+
+	if len(versions) != 3 {
+		t.Fatalf("pruning must not delete rows, got %d versions", len(versions))
+	}
+	if versions[0].SchemaSQL != "" || versions[1].SchemaSQL != "" {
+		t.Fatalf("old schema text should have been pruned")
+	}
+	if versions[2].SchemaSQL != schemaV3 {
+		t.Fatalf("current schema text must be kept, got %q", versions[2].SchemaSQL)
+	}
+
+	// Backward migrations are still detected from the kept hashes
+	if _, err := Open(schemaV1, dbPath); err == nil || !strings.Contains(err.Error(), "backward migration detected") {
+		t.Fatalf("expected backward migration error after pruning, got %v", err)
+	}
+
+	// And forward migrations still carry the pruned history over
+	db, err = Open(schemaV4, dbPath)
+	if err != nil {
+		t.Fatalf("migration after pruning failed: %v", err)
+	}
+	defer db.Close()
+	versions, err = AppliedSchemas(db)
+	if err != nil || len(versions) != 4 {
+		t.Fatalf("expected 4 versions after migration, got %d: %v", len(versions), err)
+	}
+}
+
+func TestSchemaAtVersion(t *testing.T) {
+	dbPath := tempDBPath(t)
+	for _, schema := range []string{schemaV1, schemaV2} {
+		db, err := Open(schema, dbPath)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		db.Close()
+	}
+	opts := DefaultOptions()
+	opts.SchemaStorage = StoreCompressed
+	schemaV3 := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, age INTEGER);`
+	db, err := OpenWithOptions(schemaV3, dbPath, opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	for version, want := range map[int]string{1: schemaV1, 2: schemaV2, 3: schemaV3} {
+		if schema, err := SchemaAtVersion(db, version); err != nil || schema != want {
+			t.Errorf("version %d: expected %q, got %q, %v", version, want, schema, err)
+		}
+	}
+	if _, err := SchemaAtVersion(db, 4); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("expected ErrVersionNotFound, got %v", err)
+	}
+	if err := PruneVersionHistory(db, 1); err != nil {
+		t.Fatalf("PruneVersionHistory failed: %v", err)
+	}
+	if _, err := SchemaAtVersion(db, 1); !errors.Is(err, ErrSchemaNotStored) {
+		t.Errorf("expected ErrSchemaNotStored after pruning, got %v", err)
+	}
+}
+
+func TestClearVersionHistory(t *testing.T) {
+	for _, seed := range []bool{false, true} {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			dbPath := tempDBPath(t)
+			for _, schema := range []string{schemaV1, schemaV2} {
+				db, err := Open(schema, dbPath)
+				if err != nil {
+					t.Fatalf("failed to open db: %v", err)
+				}
+				db.Close()
+			}
+
+			db, err := sql.Open("sqlite3", dbPath)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			if seed {
+				err = ResetVersionHistory(db)
+			} else {
+				err = ClearVersionHistory(db)
+			}
+			if err != nil {
+				t.Fatalf("failed to reset version history: %v", err)
+			}
+			versions, err := AppliedSchemas(db)
+			db.Close()
+			if err != nil {
+				t.Fatalf("AppliedSchemas failed: %v", err)
+			}
+			if seed {
+				if len(versions) != 1 || versions[0].Version != 1 || versions[0].SchemaSQL == "" {
+					t.Fatalf("expected the current schema as version 1, got %+v", versions)
+				}
+			} else if len(versions) != 0 {
+				t.Fatalf("expected no versions, got %+v", versions)
+			}
+			if _, err := os.Stat(dbPath + ".migration.lock"); !os.IsNotExist(err) {
+				t.Errorf("expected the migration lock to be released, got %v", err)
+			}
+
+			// Going back to the old schema is no longer refused, and starts
+			// from the schema the database had
+			db, err = Open(schemaV1, dbPath)
+			if err != nil {
+				t.Fatalf("downgrade after reset failed: %v", err)
+			}
+			defer db.Close()
+			versions, err = AppliedSchemas(db)
+			if err != nil || len(versions) != 2 || versions[1].SchemaSQL != schemaV1 {
+				t.Fatalf("expected 2 versions after downgrade, got %+v: %v", versions, err)
+			}
+		})
+	}
+}
+
+func TestResetVersionHistoryWithOptions(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	opts := DefaultOptions()
+	opts.Clock = func() time.Time { return time.Date(2024, 3, 1, 11, 30, 0, 0, time.UTC) }
+	opts.SchemaStorage = StoreHashOnly
+	if err := ResetVersionHistoryWithOptions(db, opts); err != nil {
+		t.Fatalf("failed to reset version history: %v", err)
+	}
+	versions, err := AppliedSchemas(db)
+	if err != nil {
+		t.Fatalf("AppliedSchemas failed: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Timestamp != "2024-03-01T11:30:00Z" || versions[0].SchemaSQL != "" {
+		t.Fatalf("expected version 1 stamped by the clock and stored as a hash only, got %+v", versions)
+	}
+}
+
+func TestSchemaStorage(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		storage SchemaStorage
+		want    string
+	}{
+		{"Full", StoreFull, schemaV2},
+		{"Compressed", StoreCompressed, schemaV2},
+		{"HashOnly", StoreHashOnly, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dbPath := tempDBPath(t)
+			opts := DefaultOptions()
+			opts.SchemaStorage = tc.storage
+			for _, schema := range []string{schemaV1, schemaV2} {
+				db, err := OpenWithOptions(schema, dbPath, opts)
+				if err != nil {
+					t.Fatalf("failed to open db: %v", err)
+				}
+				db.Close()
+			}
+
+			db, err := sql.Open("sqlite3", dbPath)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer db.Close()
+			versions, err := AppliedSchemas(db)
+			if err != nil {
+				t.Fatalf("AppliedSchemas failed: %v", err)
+			}
+			if len(versions) != 2 || versions[1].SchemaSQL != tc.want {
+				t.Fatalf("unexpected history: %+v", versions)
+			}
+
+			// Backward migration detection only needs the hash
+			if _, err := OpenWithOptions(schemaV1, dbPath, opts); err == nil || !strings.Contains(err.Error(), "backward migration detected") {
+				t.Fatalf("expected backward migration error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClock(t *testing.T) {
+	dbPath := tempDBPath(t)
+	opts := DefaultOptions()
+	now := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+	opts.Clock = func() time.Time { return now }
+
+	for _, schema := range []string{schemaV1, schemaV2} {
+		db, err := OpenWithOptions(schema, dbPath, opts)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		db.Close()
+		now = now.Add(24 * time.Hour)
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	versions, err := AppliedSchemas(db)
+	if err != nil {
+		t.Fatalf("AppliedSchemas failed: %v", err)
+	}
+	want := []string{"2024-03-01T11:30:00Z", "2024-03-02T11:30:00Z"}
+	for i, v := range versions {
+		if v.Timestamp != want[i] {
+			t.Errorf("version %d: expected timestamp %s, got %s", v.Version, want[i], v.Timestamp)
+		}
+	}
+}
+
+func TestAppliedAt(t *testing.T) {
+	want := time.Date(2024, 3, 1, 11, 30, 0, 0, time.UTC)
+	for _, ts := range []string{"2024-03-01T11:30:00Z", "2024-03-01T12:30:00+01:00", "2024-03-01 11:30:00"} {
+		got, err := SchemaVersion{Timestamp: ts}.AppliedAt()
+		if err != nil || !got.Equal(want) {
+			t.Errorf("AppliedAt(%q) = %v, %v; want %v", ts, got, err, want)
+		}
+	}
+	if _, err := (SchemaVersion{Timestamp: "yesterday"}).AppliedAt(); err == nil {
+		t.Errorf("expected error for an invalid timestamp")
+	}
+
+	// Round trip through the version table
+	opts := DefaultOptions()
+	opts.Clock = func() time.Time { return want }
+	db, err := OpenWithOptions(schemaV1, tempDBPath(t), opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	version, err := getCurrentSchemaVersion(db)
+	if err != nil {
+		t.Fatalf("failed to get version: %v", err)
+	}
+	if got, err := version.AppliedAt(); err != nil || !got.Equal(want) {
+		t.Errorf("expected recorded time %v, got %v, %v", want, got, err)
+	}
+}
+
+func TestSchemaDescription(t *testing.T) {
+	tests := []struct {
+		schema string
+		want   string
+	}{
+		{`-- autosqlite:description "add email to users"` + "\n" + schemaV1, "add email to users"},
+		{"CREATE TABLE t (x);\n  --autosqlite:description add an index\n", "add an index"},
+		{`-- autosqlite:description "say \"hi\""`, `say "hi"`},
+		{"-- autosqlite:descriptions are not this", ""},
+		{"-- just a comment\n" + schemaV1, ""},
+	}
+	for _, test := range tests {
+		if got := schemaDescription(test.schema); got != test.want {
+			t.Errorf("schemaDescription(%q) = %q, want %q", test.schema, got, test.want)
+		}
+	}
+
+	// A version table created by an older version of this package gets the
+	// column when the next version is recorded
+	dbPath := tempDBPath(t)
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	legacy := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE _autosqlite_version (version INTEGER, hash TEXT NOT NULL, timestamp TEXT NOT NULL, schema_sql TEXT);`
+	if _, err := db.Exec(legacy); err != nil {
+		t.Fatalf("failed to create legacy db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO _autosqlite_version VALUES (1, ?, '2024-03-01 11:30:00', ?)", calculateSchemaHash(schemaV1), schemaV1); err != nil {
+		t.Fatalf("failed to record legacy version: %v", err)
+	}
+	if versions, err := AppliedSchemas(db); err != nil || len(versions) != 1 {
+		t.Fatalf("expected to read the legacy version table, got %+v: %v", versions, err)
+	}
+	db.Close()
+
+	db, err = Open("-- autosqlite:description \"add email\"\n"+schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+	versions, err := AppliedSchemas(db)
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %+v: %v", versions, err)
+	}
+	if versions[0].Description != "" || versions[1].Description != "add email" {
+		t.Errorf("expected descriptions %q and %q, got %q and %q", "", "add email", versions[0].Description, versions[1].Description)
+	}
+}
+
+func TestUpgradeVersionTable(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if _, err := db.Exec(schemaV1); err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE _autosqlite_version (version INTEGER, hash TEXT NOT NULL, timestamp TEXT NOT NULL)"); err != nil {
+		t.Fatalf("failed to create legacy version table: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO _autosqlite_version VALUES (1, ?, '2024-03-01 11:30:00')", calculateSchemaHash(schemaV1)); err != nil {
+		t.Fatalf("failed to record legacy version: %v", err)
+	}
+	db.Close()
+
+	// Opening without a migration brings the table up to date
+	db, err = Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	present, err := versionTableColumnSet(db)
+	if err != nil {
+		t.Fatalf("failed to inspect version table: %v", err)
+	}
+	for _, col := range versionTableColumns {
+		if !present[col.name] {
+			t.Errorf("expected version table column %s to be added", col.name)
+		}
+	}
+	db.Close()
+
+	db, err = Open(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+	versions, err := AppliedSchemas(db)
+	if err != nil || len(versions) != 2 || versions[1].SchemaSQL != schemaV2 {
+		t.Fatalf("expected 2 versions, got %+v: %v", versions, err)
+	}
+}
@@ -0,0 +1,270 @@
+package autosqlite
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// LintWarning is a likely mistake in a schema found by Lint. The schema still
+// applies, but probably doesn't do what was meant.
+type LintWarning struct {
+	Line    int          // Line of the schema the statement starts on, starting at 1
+	Object  SchemaObject // Object the warning is about
+	Message string       // What is wrong
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("line %d: %s %s: %s", w.Line, w.Object.Type, w.Object.Name, w.Message)
+}
+
+// Lint checks schema for mistakes that SQLite accepts without complaint, for
+// example as a pre-commit check on a schema file. The schema must first pass
+// ValidateSchema, whose error Lint returns otherwise. The warnings, in the order
+// of the statements they are about, cover:
+//
+//   - an object defined twice with IF NOT EXISTS, whose second definition is
+//     silently ignored
+//   - the _autosqlite_version table, which is reserved for the version history
+//   - a foreign key on a table or column the schema doesn't define, or on
+//     columns without a PRIMARY KEY or UNIQUE constraint, which SQLite only
+//     reports once the foreign key is checked
+//   - a trigger that uses a table or view the schema doesn't define, or a view
+//     that can't be queried, which SQLite only reports once they are used
+//
+// Tables used inside a trigger are found from the words after FROM, JOIN, INTO
+// and UPDATE, so unusual SQL may escape the check.
+func Lint(schema string) ([]LintWarning, error) {
+	if _, err := ValidateSchema(schema); err != nil {
+		return nil, err
+	}
+
+	var warnings []LintWarning
+	lines := make(map[string]int) // Line each object is first defined on
+	key := func(typ, name string) string {
+		// Tables, views and indexes share one namespace; triggers have their own
+		if typ != "trigger" {
+			typ = "table"
+		}
+		return typ + " " + foldName(name)
+	}
+	var triggers []statement
+	for _, stmt := range splitStatements(schema) {
+		typ, name, ok := createdObject(stmt.tokens)
+		if !ok {
+			continue
+		}
+		line := strings.Count(schema[:stmt.offset], "\n") + 1
+		obj := SchemaObject{Type: typ, Name: name}
+		if first, ok := lines[key(typ, name)]; ok {
+			warnings = append(warnings, LintWarning{Line: line, Object: obj,
+				Message: fmt.Sprintf("already defined at line %d, so this definition is ignored", first)})
+			continue
+		}
+		lines[key(typ, name)] = line
+		if sameName(name, versionTableName) {
+			warnings = append(warnings, LintWarning{Line: line, Object: obj,
+				Message: "the name is reserved for autosqlite's version history"})
+		}
+		if typ == "trigger" {
+			triggers = append(triggers, stmt)
+		}
+	}
+
+	db, err := openTemporaryDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if err := execSchema(db, schema); err != nil {
+		return nil, fmt.Errorf("failed to execute schema: %w", err)
+	}
+	objects, err := masterObjects(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schema objects: %w", err)
+	}
+	defined := func(name string) bool {
+		return slices.ContainsFunc(objects, func(obj masterObject) bool {
+			return (obj.Type == "table" || obj.Type == "view") && sameName(obj.Name, name)
+		})
+	}
+
+	for _, obj := range objects {
+		line := lines[key(obj.Type, obj.Name)]
+		var messages []string
+		switch obj.Type {
+		case "table":
+			if messages, err = foreignKeyProblems(db, obj.Name, defined); err != nil {
+				return nil, fmt.Errorf("failed to check foreign keys of table %s: %w", obj.Name, err)
+			}
+		case "view":
+			rows, err := db.Query("SELECT * FROM " + obj.Name + " LIMIT 0")
+			if err != nil {
+				messages = append(messages, fmt.Sprintf("can't be queried: %v", err))
+			} else {
+				rows.Close()
+			}
+		}
+		for _, message := range messages {
+			warnings = append(warnings, LintWarning{Line: line, Object: obj.SchemaObject, Message: message})
+		}
+	}
+
+	for _, stmt := range triggers {
+		_, name, _ := createdObject(stmt.tokens)
+		for _, table := range triggerBodyTables(stmt.tokens) {
+			if !defined(table) {
+				warnings = append(warnings, LintWarning{
+					Line:    strings.Count(schema[:stmt.offset], "\n") + 1,
+					Object:  SchemaObject{Type: "trigger", Name: name},
+					Message: fmt.Sprintf("uses table %s, which the schema doesn't define", table),
+				})
+			}
+		}
+	}
+
+	for i, w := range warnings {
+		if w.Object.Table != "" {
+			continue
+		}
+		for _, obj := range objects {
+			if key(obj.Type, obj.Name) == key(w.Object.Type, w.Object.Name) {
+				warnings[i].Object.Table = obj.Table
+			}
+		}
+	}
+	slices.SortStableFunc(warnings, func(a, b LintWarning) int { return a.Line - b.Line })
+	return warnings, nil
+}
+
+// foreignKeyProblems describes what is wrong with the foreign keys of table in
+// db, where defined reports whether a table exists.
+func foreignKeyProblems(db queryer, table string, defined func(string) bool) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA foreign_key_list(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	type reference struct {
+		parent, to string
+	}
+	var references []reference
+	for rows.Next() {
+		var id, seq int
+		var parent, from, onUpdate, onDelete, match string
+		var to *string
+		if err := rows.Scan(&id, &seq, &parent, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ref := reference{parent: parent}
+		if to != nil {
+			ref.to = *to
+		}
+		references = append(references, ref)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	missing := false
+	for _, ref := range references {
+		if !defined(ref.parent) {
+			problems = append(problems, fmt.Sprintf("foreign key references table %s, which the schema doesn't define", ref.parent))
+			missing = true
+			continue
+		}
+		if ref.to == "" {
+			continue
+		}
+		columns, err := columnInfo(db, ref.parent)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(columns, func(col ColumnInfo) bool { return sameName(col.Name, ref.to) }) {
+			problems = append(problems, fmt.Sprintf("foreign key references column %s.%s, which doesn't exist", ref.parent, ref.to))
+			missing = true
+		}
+	}
+	if missing {
+		return problems, nil
+	}
+
+	// SQLite checks that the parent key is a PRIMARY KEY or UNIQUE when it
+	// checks the foreign key, and fails with "foreign key mismatch" otherwise
+	if rows, err := db.Query(fmt.Sprintf("PRAGMA foreign_key_check(%s)", table)); err != nil {
+		problems = append(problems, fmt.Sprintf("foreign key can't be checked: %v", err))
+	} else {
+		rows.Close()
+	}
+	return problems, nil
+}
+
+// triggerBodyTables returns the names of the tables and views that the body of
+// the CREATE TRIGGER statement tokens reads or writes, as far as they can be
+// told from the words after FROM, JOIN, INTO and UPDATE. Common table
+// expressions and table-valued functions are left out.
+func triggerBodyTables(tokens []token) []string {
+	begin := slices.IndexFunc(tokens, func(tok token) bool { return tok.is("BEGIN") })
+	if begin == -1 {
+		return nil
+	}
+	body := tokens[begin+1:]
+
+	// Names of common table expressions: "name AS (" or "name(columns) AS ("
+	ctes := make(map[string]bool)
+	for i := 1; i+1 < len(body); i++ {
+		if !body[i].is("AS") || body[i+1].text != "(" {
+			continue
+		}
+		j := i - 1
+		if body[j].text == ")" {
+			for j > 0 && body[j].text != "(" {
+				j--
+			}
+			j--
+		}
+		if j >= 0 {
+			ctes[foldName(body[j].name())] = true
+		}
+	}
+
+	var tables []string
+	for i := 0; i+1 < len(body); i++ {
+		if !body[i].is("FROM") && !body[i].is("JOIN") && !body[i].is("INTO") && !body[i].is("UPDATE") {
+			continue
+		}
+		next := body[i+1]
+		if next.kind != tokWord && next.kind != tokIdent {
+			continue
+		}
+		name := next.name()
+		// schema.name
+		if i+3 < len(body) && body[i+2].text == "." {
+			name = body[i+3].name()
+			i += 2
+		}
+		if (body[i].is("FROM") || body[i].is("JOIN")) && i+2 < len(body) && body[i+2].text == "(" {
+			continue // Table-valued function
+		}
+		// UPDATE in an upsert or a trigger's UPDATE OF is no table
+		if next.kind == tokWord && isKeyword(next.text) || ctes[foldName(name)] {
+			continue
+		}
+		if !slices.ContainsFunc(tables, func(t string) bool { return sameName(t, name) }) {
+			tables = append(tables, name)
+		}
+	}
+	return tables
+}
+
+// isKeyword reports whether word is an SQL keyword that can follow FROM, JOIN,
+// INTO or UPDATE in place of a table name.
+func isKeyword(word string) bool {
+	switch strings.ToUpper(word) {
+	case "SET", "OF", "OR", "SELECT", "VALUES", "DEFAULT", "ROLLBACK", "ABORT", "REPLACE", "FAIL", "IGNORE":
+		return true
+	}
+	return false
+}
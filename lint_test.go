@@ -0,0 +1,70 @@
+package autosqlite
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	schema := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY);
+CREATE TABLE posts (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id),
+	editor TEXT REFERENCES users(name),
+	group_id INTEGER REFERENCES groups(id),
+	author_id INTEGER REFERENCES users(uid)
+);
+CREATE TABLE _autosqlite_version (version INTEGER);
+CREATE VIEW recent AS SELECT * FROM post ORDER BY id DESC;
+CREATE TRIGGER log_post AFTER INSERT ON posts BEGIN
+	INSERT INTO audit (post_id) VALUES (NEW.id);
+	UPDATE users SET name = name WHERE id IN (WITH counts(n) AS (SELECT count(*) FROM posts) SELECT n FROM counts);
+	SELECT value FROM json_each('[]');
+END;
+`
+	warnings, err := Lint(schema)
+	if err != nil {
+		t.Fatalf("Lint failed: %v", err)
+	}
+	var got []string
+	for _, w := range warnings {
+		got = append(got, w.String())
+	}
+	want := []string{
+		"line 2: table users: already defined at line 1, so this definition is ignored",
+		"line 3: table posts: foreign key references column users.uid, which doesn't exist",
+		"line 3: table posts: foreign key references table groups, which the schema doesn't define",
+		"line 10: table _autosqlite_version: the name is reserved for autosqlite's version history",
+		"line 11: view recent: can't be queried: no such table: main.post",
+		"line 12: trigger log_post: uses table audit, which the schema doesn't define",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected warnings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(warnings) > 0 && warnings[len(warnings)-1].Object.Table != "posts" {
+		t.Errorf("expected the trigger's warning to name its table, got %+v", warnings[len(warnings)-1].Object)
+	}
+
+	// Once the missing tables are there, the foreign key on a column that isn't
+	// unique is caught
+	warnings, err = Lint(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE posts (id INTEGER PRIMARY KEY, editor TEXT REFERENCES users(name));`)
+	if err != nil {
+		t.Fatalf("Lint failed: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, "foreign key mismatch") {
+		t.Errorf("expected a foreign key mismatch warning, got %v", warnings)
+	}
+
+	warnings, err = Lint(schemaV1WithPosts)
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("expected no warnings for a clean schema, got %v, %v", warnings, err)
+	}
+
+	var serr *StatementError
+	if _, err := Lint("CREATE TABLE t (id INTEGER PRIMARY KEY); CREATE TABLE t (id);"); !errors.As(err, &serr) {
+		t.Errorf("expected the schema's StatementError, got %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDrainTimeout is returned by ReloadSchema when connections of the old pool
+// are still in use after Options.DrainTimeout.
+var ErrDrainTimeout = errors.New("timed out waiting for database connections to be released")
+
+// DB is a database handle that remembers the schema and path it was opened
+// with, for long-running applications that check or change the schema later.
+// It embeds *sql.DB, so it can be used for queries directly.
+type DB struct {
+	*sql.DB
+	schema string
+	dbPath string
+	opts   *Options
+	stats  Stats
+
+	fromVersion, toVersion int
+}
+
+// OpenManaged opens dbPath with schema as Open does and returns a DB that
+// remembers both.
+func OpenManaged(schema, dbPath string) (*DB, error) {
+	return OpenManagedWithOptions(schema, dbPath, nil)
+}
+
+// OpenManagedWithOptions is like OpenManaged but takes Options, which are also
+// used by later calls to the DB's methods. A nil opts is the same as
+// DefaultOptions().
+func OpenManagedWithOptions(schema, dbPath string, opts *Options) (*DB, error) {
+	d := &DB{dbPath: dbPath, opts: resolveOptions(opts)}
+	if err := d.open(schema); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// open opens the database with schema, migrating it if necessary, and makes it
+// the DB's current handle and schema.
+func (d *DB) open(schema string) error {
+	// Collect stats for Stats without losing the caller's own report
+	opts := *d.opts
+	var report MigrationReport
+	opts.Report = &report
+
+	db, result, err := OpenWithResultOptions(schema, d.dbPath, &opts)
+	if d.opts.Report != nil {
+		*d.opts.Report = report
+	}
+	if err != nil {
+		return err
+	}
+	if result == Migrated {
+		d.stats = report.Stats
+	}
+	d.fromVersion, d.toVersion = report.FromVersion, report.ToVersion
+	d.DB = db
+	d.schema = schema
+	return nil
+}
+
+// Schema returns the schema the database was last opened or migrated with.
+func (d *DB) Schema() string {
+	return d.schema
+}
+
+// Path returns the path the database was opened with.
+func (d *DB) Path() string {
+	return d.dbPath
+}
+
+// MigrationStats returns statistics about the last migration performed through
+// this DB, or zero Stats if there hasn't been one. It isn't called Stats so as
+// not to hide the embedded sql.DB's connection pool statistics.
+func (d *DB) MigrationStats() Stats {
+	return d.stats
+}
+
+// Reopen closes the database handle and opens the database again with the
+// remembered schema, migrating it if the file has changed in the meantime.
+func (d *DB) Reopen() error {
+	if err := d.DB.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+	return d.open(d.schema)
+}
+
+// Versions returns the schema versions of the database before and after it was
+// last opened or migrated through this DB, as in MigrationReport. They differ
+// only if that recorded a new version, whatever the Result; a schema change
+// that only touches comments or formatting records none.
+func (d *DB) Versions() (from, to int) {
+	return d.fromVersion, d.toVersion
+}
+
+// CurrentVersion returns the schema version recorded in the database, or 0 if
+// it has no version history.
+func (d *DB) CurrentVersion() (int, error) {
+	return currentVersionNumber(d.DB)
+}
+
+// Diff compares the database's schema with newSchema, showing what MigrateTo
+// would change. The database itself is not modified.
+func (d *DB) Diff(newSchema string) (*SchemaDiff, error) {
+	return diffSchema(d.DB, newSchema, d.opts)
+}
+
+// MigrateTo migrates the database to newSchema, which becomes the remembered
+// schema. The current handle is closed and replaced by one to the migrated
+// database, so any *sql.DB obtained from the DB earlier must not be used
+// afterwards. Nothing is migrated if the database already has newSchema.
+func (d *DB) MigrateTo(newSchema string) error {
+	if err := d.DB.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+	if err := d.open(newSchema); err != nil {
+		// Leave a usable handle to the unmigrated database if we can
+		_, err = d.reopenAfterFailure(err)
+		return err
+	}
+	return nil
+}
+
+// ReloadSchema migrates a running application's database to newSchema and
+// returns the new connection pool, which also replaces the one embedded in d.
+// It is meant for daemons that pick up schema changes without restarting.
+//
+// To make sure no write is lost, the old pool is first closed and drained:
+// queries and transactions already running are allowed to finish, and
+// ReloadSchema waits until every connection has been released, for up to
+// Options.DrainTimeout. Only then is the database migrated and reopened.
+//
+// Caveats:
+//   - Anything that starts on the old pool once ReloadSchema has begun fails
+//     with "sql: database is closed", so callers should pause their own work or
+//     be ready to retry it on the returned pool.
+//   - A transaction that is never committed or rolled back keeps its
+//     connection; after DrainTimeout ReloadSchema gives up with
+//     ErrDrainTimeout and reopens the database with the old schema.
+//   - Prepared statements and *sql.Conn values belong to the old pool and must
+//     be recreated.
+//   - ReloadSchema must not run concurrently with other methods of d.
+//
+// If the database already has newSchema, the current pool is returned
+// unchanged. If the migration fails, the database is reopened with its old
+// schema and that pool is returned along with the error.
+func (d *DB) ReloadSchema(newSchema string) (*sql.DB, error) {
+	diff, err := d.Diff(newSchema)
+	if err != nil {
+		return d.DB, err
+	}
+	if diff.Empty() {
+		d.schema = newSchema
+		return d.DB, nil
+	}
+
+	old := d.DB
+	if err := old.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close database: %w", err)
+	}
+	// Close doesn't wait for connections that are in use, e.g. by transactions
+	timeout := d.opts.DrainTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	for old.Stats().OpenConnections > 0 {
+		if time.Now().After(deadline) {
+			return d.reopenAfterFailure(ErrDrainTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := d.open(newSchema); err != nil {
+		return d.reopenAfterFailure(err)
+	}
+	return d.DB, nil
+}
+
+// reopenAfterFailure opens a new handle to the database after a failed reload,
+// so that the application can carry on with the old schema, and returns it
+// together with err.
+func (d *DB) reopenAfterFailure(err error) (*sql.DB, error) {
+	db, openErr := openDB(d.dbPath, d.opts)
+	if openErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("failed to reopen database: %w", openErr))
+	}
+	d.DB = db
+	return db, err
+}
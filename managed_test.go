@@ -0,0 +1,167 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManagedDB(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := OpenManaged(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("OpenManaged failed: %v", err)
+	}
+	defer func() { db.Close() }()
+
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if v, err := db.CurrentVersion(); err != nil || v != 1 {
+		t.Errorf("expected version 1, got %d, %v", v, err)
+	}
+	if db.Schema() != schemaV1 || db.Path() != dbPath {
+		t.Errorf("unexpected schema or path: %q %q", db.Schema(), db.Path())
+	}
+
+	newSchema := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT);
+CREATE TABLE tags (id INTEGER PRIMARY KEY);
+CREATE INDEX idx_users_name ON users(name);`
+	diff, err := db.Diff(newSchema)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	want := "+ index idx_users_name\n+ table tags\n~ table users\n"
+	if diff.String() != want {
+		t.Errorf("expected diff %q, got %q", want, diff.String())
+	}
+	if diff, err := db.Diff(schemaV1); err != nil || !diff.Empty() {
+		t.Errorf("expected empty diff against the current schema, got %v, %v", diff, err)
+	}
+
+	if err := db.MigrateTo(newSchema); err != nil {
+		t.Fatalf("MigrateTo failed: %v", err)
+	}
+	if v, err := db.CurrentVersion(); err != nil || v != 2 {
+		t.Errorf("expected version 2, got %d, %v", v, err)
+	}
+	if stats := db.MigrationStats(); stats.RowsCopied != 1 || stats.TablesCopied != 1 {
+		t.Errorf("unexpected stats after migration: %+v", stats)
+	}
+	// Stats is still the connection pool statistics of the embedded sql.DB
+	if pool := db.Stats(); pool.OpenConnections < 1 {
+		t.Errorf("expected an open connection in the pool stats, got %+v", pool)
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM users").Scan(&name); err != nil || name != "alice" {
+		t.Errorf("expected alice after migration, got %q, %v", name, err)
+	}
+
+	if err := db.Reopen(); err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if db.Schema() != newSchema {
+		t.Errorf("expected remembered schema to be the new schema")
+	}
+
+	diff, err = db.Diff(schemaV1)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if !strings.Contains(diff.String(), "- table tags") {
+		t.Errorf("expected tags to be removed, got %q", diff.String())
+	}
+}
+
+func TestManagedVersions(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := OpenManaged(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("OpenManaged failed: %v", err)
+	}
+	defer func() { db.Close() }()
+
+	check := func(step string, wantFrom, wantTo int) {
+		t.Helper()
+		if from, to := db.Versions(); from != wantFrom || to != wantTo {
+			t.Errorf("%s: expected versions %d -> %d, got %d -> %d", step, wantFrom, wantTo, from, to)
+		}
+	}
+	check("create", 0, 1)
+	if err := db.Reopen(); err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	check("reopen", 1, 1)
+	if err := db.MigrateTo("-- users\n" + schemaV1); err != nil {
+		t.Fatalf("MigrateTo failed: %v", err)
+	}
+	check("comment-only change", 1, 1)
+	if err := db.MigrateTo(schemaV2); err != nil {
+		t.Fatalf("MigrateTo failed: %v", err)
+	}
+	check("migration", 1, 2)
+}
+
+func TestReloadSchema(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := OpenManaged(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("OpenManaged failed: %v", err)
+	}
+	defer func() { db.Close() }()
+
+	// A transaction in flight when the reload starts is allowed to finish,
+	// and its write is carried over
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	done := make(chan error)
+	var pool *sql.DB
+	go func() {
+		var err error
+		pool, err = db.ReloadSchema(schemaV2)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("ReloadSchema returned before the transaction finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ReloadSchema failed: %v", err)
+	}
+	if pool != db.DB {
+		t.Errorf("expected the returned pool to replace the embedded one")
+	}
+	var name string
+	if err := pool.QueryRow("SELECT name FROM users WHERE email IS NULL").Scan(&name); err != nil || name != "alice" {
+		t.Errorf("expected alice in the migrated database, got %q, %v", name, err)
+	}
+
+	// A transaction that never finishes makes the reload give up
+	db.opts.DrainTimeout = 50 * time.Millisecond
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	defer tx.Rollback()
+	pool, err = db.ReloadSchema(schemaV1WithPosts)
+	if !errors.Is(err, ErrDrainTimeout) {
+		t.Fatalf("expected ErrDrainTimeout, got %v", err)
+	}
+	if err := pool.QueryRow("SELECT name FROM users").Scan(&name); err != nil {
+		t.Errorf("expected the reopened pool to work, got %v", err)
+	}
+	if !SchemasEqual(schemaV2, dbPath) {
+		t.Errorf("expected schema to be unchanged after a failed reload")
+	}
+}
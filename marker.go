@@ -0,0 +1,54 @@
+package autosqlite
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gofrs/flock"
+)
+
+// markerPath returns the path of the marker file that Options.MarkMigrating
+// creates for the database file filename.
+func markerPath(filename string) string {
+	return filename + ".migrating"
+}
+
+// markMigrating creates the marker file for the database file filename, saying
+// which process started migrating it and when, and returns a function that
+// removes it.
+func markMigrating(filename string, opts *Options) (func(), error) {
+	path := markerPath(filename)
+	content := fmt.Sprintf("pid %d\nstarted %s\n", os.Getpid(), opts.timestamp())
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return nil, fmt.Errorf("failed to create migration marker: %w", err)
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// IsMigrating reports whether a migration of the database at dbPath is in
+// progress, in this or another process, for example so that a health check
+// can report that an instance is migrating rather than failing. It relies on
+// the marker file that migrations create with Options.MarkMigrating, so
+// migrations without it are not seen. A marker left behind by a process that
+// died mid-migration is ignored, since the migration lock is no longer held.
+func IsMigrating(dbPath string) bool {
+	filename := extractFilenameFromConnectionString(dbPath)
+	if _, err := os.Stat(markerPath(filename)); err != nil {
+		return false
+	}
+	lockPath := migrationLockPath(filename)
+	if _, err := os.Stat(lockPath); err != nil {
+		return false
+	}
+
+	lock := flock.New(lockPath)
+	locked, err := lock.TryLock()
+	if err != nil {
+		return true // Can't tell, so trust the marker
+	}
+	if locked {
+		lock.Unlock()
+		return false
+	}
+	return true
+}
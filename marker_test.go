@@ -0,0 +1,63 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMarkMigrating(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	var during bool
+	var marker string
+	opts := DefaultOptions()
+	opts.MarkMigrating = true
+	opts.PostMigrate = []PostMigrateStep{{Name: "observe", Run: func(*sql.Tx) error {
+		during = IsMigrating(dbPath)
+		content, _ := os.ReadFile(dbPath + ".migrating")
+		marker = string(content)
+		return nil
+	}}}
+	db, err = OpenWithOptions(schemaV2, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	db.Close()
+
+	if !during {
+		t.Error("expected IsMigrating to be true during the migration")
+	}
+	if !strings.Contains(marker, fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("expected the marker to name this process, got %q", marker)
+	}
+	if IsMigrating(dbPath) {
+		t.Error("expected IsMigrating to be false after the migration")
+	}
+	if _, err := os.Stat(dbPath + ".migrating"); !os.IsNotExist(err) {
+		t.Errorf("expected the marker to be removed, got %v", err)
+	}
+
+	// A marker left by a process that died is stale once nobody holds the lock
+	if err := os.WriteFile(dbPath+".migrating", []byte("pid 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write marker: %v", err)
+	}
+	if IsMigrating(dbPath) {
+		t.Error("expected a stale marker to be ignored")
+	}
+	unlock, err := acquireMigrationLock(dbPath)
+	if err != nil {
+		t.Fatalf("failed to lock: %v", err)
+	}
+	defer unlock()
+	if !IsMigrating(dbPath) {
+		t.Error("expected a marker with the lock held to count")
+	}
+}
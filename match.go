@@ -0,0 +1,59 @@
+package autosqlite
+
+// MatchStrategy says how the columns of a table in the old database are
+// paired with those of the table in the new schema that its data is copied
+// into. See Options.ColumnMatching.
+type MatchStrategy int
+
+const (
+	// MatchByName copies each column into the new column of the same name,
+	// compared case-insensitively. Columns without a namesake are left out.
+	MatchByName MatchStrategy = iota
+
+	// MatchByPosition copies the first column into the first new column, the
+	// second into the second, and so on up to the shorter of the two tables,
+	// whatever their names. Data lands in whichever column is in its place,
+	// so a column added, dropped or moved anywhere but at the end silently
+	// puts data in the wrong column.
+	MatchByPosition
+)
+
+func (s MatchStrategy) String() string {
+	switch s {
+	case MatchByName:
+		return "by name"
+	case MatchByPosition:
+		return "by position"
+	}
+	return "unknown"
+}
+
+// matchStrategyFor returns the MatchStrategy for table in the new schema.
+func (opts *Options) matchStrategyFor(table string) MatchStrategy {
+	for t, strategy := range opts.ColumnMatching {
+		if sameName(t, table) {
+			return strategy
+		}
+	}
+	return MatchByName
+}
+
+// matchColumns returns the columns of newColumns whose data is copied from
+// oldColumns as strategy says, in the order of newColumns, and the old column
+// each is copied from. matched is oldColumns renamed after the columns they
+// are copied into, so that they can be compared with newColumns by name.
+func matchColumns(oldColumns, newColumns []ColumnInfo, strategy MatchStrategy) (common, sources []string, matched []ColumnInfo) {
+	if strategy != MatchByPosition {
+		common = FindCommonColumns(oldColumns, newColumns)
+		return common, common, oldColumns
+	}
+	n := min(len(oldColumns), len(newColumns))
+	for i := 0; i < n; i++ {
+		common = append(common, newColumns[i].Name)
+		sources = append(sources, oldColumns[i].Name)
+		col := oldColumns[i]
+		col.Name = newColumns[i].Name
+		matched = append(matched, col)
+	}
+	return common, sources, matched
+}
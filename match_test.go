@@ -0,0 +1,57 @@
+package autosqlite
+
+import (
+	"testing"
+)
+
+func TestMatchByPosition(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV2+" CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT);", dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name, email) VALUES ('alice', 'alice@example.com'); INSERT INTO posts (title) VALUES ('hello')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	// users renames its columns and drops the last; posts renames title but
+	// still matches by name, so loses it
+	const schema = `CREATE TABLE users (id INTEGER PRIMARY KEY, full_name TEXT NOT NULL DEFAULT '');
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, headline TEXT);`
+	opts := DefaultOptions()
+	opts.ColumnMatching = map[string]MatchStrategy{"USERS": MatchByPosition}
+	db, err = MigrateWithOptions(schema, dbPath, opts)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	defer db.Close()
+
+	var name string
+	if err := db.QueryRow("SELECT full_name FROM users WHERE id = 1").Scan(&name); err != nil || name != "alice" {
+		t.Errorf("expected full_name alice, got %q, %v", name, err)
+	}
+	var headline *string
+	if err := db.QueryRow("SELECT headline FROM posts WHERE id = 1").Scan(&headline); err != nil || headline != nil {
+		t.Errorf("expected posts to match by name and leave headline NULL, got %v, %v", headline, err)
+	}
+}
+
+func TestMatchByPositionRebuildInPlace(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.RebuildInPlace = true
+	opts.ColumnMatching = map[string]MatchStrategy{"users": MatchByPosition}
+	if _, err := MigrateWithOptions(schemaV1, dbPath, opts); err == nil {
+		t.Fatal("expected MatchByPosition with RebuildInPlace to fail")
+	}
+	if !SchemasEqual(schemaV2, dbPath) {
+		t.Error("expected the database to be left unchanged")
+	}
+}
@@ -0,0 +1,96 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// MigrateDB migrates the database behind the open handle db to schema, in place,
+// with the same version tracking and backward-migration check as Migrate. It is
+// meant for in-memory databases, which have no file to back up or replace and
+// only exist as long as a handle to them does, so that tests can exercise the
+// migration path without touching disk:
+//
+//	db, _ := autosqlite.Open(schemaV1, ":memory:")
+//	result, err := autosqlite.MigrateDB(schemaV2, db)
+//
+// The tables are rebuilt as with Options.RebuildInPlace, and db stays usable
+// afterwards. It works on a file database too, but no backup is made and the
+// migration lock is not taken. Returns Unchanged if db already has schema.
+func MigrateDB(schema string, db *sql.DB) (Result, error) {
+	return MigrateDBWithOptions(schema, db, nil)
+}
+
+// MigrateDBWithOptions is like MigrateDB but takes Options controlling the
+// migration. A nil opts is the same as DefaultOptions().
+func MigrateDBWithOptions(schema string, db *sql.DB, opts *Options) (Result, error) {
+	opts = resolveOptions(opts)
+	opts.Report.reset()
+	start := time.Now()
+
+	baseline, changed, err := checkInPlaceMigration(db, schema, opts)
+	if err != nil {
+		return 0, err
+	}
+	if !changed {
+		return Unchanged, nil
+	}
+
+	if err := rebuildInPlace(db, schema, baseline, opts); err != nil {
+		return 0, err
+	}
+	if opts.Report != nil {
+		opts.Report.Stats.Duration = time.Since(start)
+	}
+	return Migrated, nil
+}
+
+// checkInPlaceMigration reports whether db, a database or a transaction on one,
+// needs migrating to schema, after the same checks as Migrate: that opts allows
+// changes, the migration is not backward and the database is at
+// opts.ExpectedFromVersion. If db has no version history, baseline is its
+// current schema, to be recorded by recordMigration.
+func checkInPlaceMigration(db dbtx, schema string, opts *Options) (baseline string, changed bool, err error) {
+	current, err := getFullSchema(db, opts)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read database schema: %w", err)
+	}
+	target, err := canonicalSchema(schema, opts)
+	if err != nil {
+		return "", false, &MigrationError{Phase: PhaseSchema, Err: err}
+	}
+	if slices.Equal(current, target) {
+		return "", false, nil
+	}
+	if opts.ReadOnly {
+		return "", false, fmt.Errorf("%w: cannot migrate", ErrReadOnly)
+	}
+	if err := checkEmptySchema(db, schema, opts); err != nil {
+		return "", false, err
+	}
+
+	isForward, err := isForwardMigration(db, schema)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to check migration direction: %w", err)
+	}
+	if !isForward && !opts.AllowBackward {
+		return "", false, errors.New("backward migration detected: this is not allowed to prevent data loss. If you need to downgrade, clear the version history with ClearVersionHistory")
+	}
+
+	fromVersion, err := getCurrentSchemaVersion(db)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get current schema version: %w", err)
+	}
+	if err := checkExpectedVersion(fromVersion, opts); err != nil {
+		return "", false, err
+	}
+	if fromVersion == nil {
+		if baseline, err = dumpSchema(db); err != nil {
+			return "", false, fmt.Errorf("failed to read existing schema: %w", err)
+		}
+	}
+	return baseline, true, nil
+}
@@ -0,0 +1,37 @@
+package autosqlite
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrateDB(t *testing.T) {
+	db, err := Open(schemaV1, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to create in-memory db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	result, err := MigrateDB(schemaV2, db)
+	if err != nil || result != Migrated {
+		t.Fatalf("expected Migrated, got %v, %v", result, err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM users WHERE email IS NULL").Scan(&name); err != nil || name != "alice" {
+		t.Errorf("expected data to be migrated, got %q, %v", name, err)
+	}
+	version, err := getCurrentSchemaVersion(db)
+	if err != nil || version == nil || version.Version != 2 || version.Hash != calculateSchemaHash(schemaV2) {
+		t.Errorf("expected version 2 to be recorded, got %+v, %v", version, err)
+	}
+
+	if result, err := MigrateDB(schemaV2, db); err != nil || result != Unchanged {
+		t.Errorf("expected Unchanged, got %v, %v", result, err)
+	}
+	if _, err := MigrateDB(schemaV1, db); err == nil || !strings.Contains(err.Error(), "backward migration") {
+		t.Errorf("expected backward migration to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// GenerateMigrationSQL returns an SQL script that takes a database from
+// fromSchema to toSchema, for reviewing a schema change before it is made: added
+// tables, indexes, views and triggers are created, removed ones dropped, and
+// changed indexes, views and triggers dropped and created again. A table that
+// only gains columns is given them with ALTER TABLE ADD COLUMN. Any other change
+// to a table can't be made in place, and is shown as a comment saying the table
+// needs a full rebuild, followed by its new definition.
+//
+// The script describes the change, not how autosqlite makes it: Migrate always
+// builds a new database and copies the data into it. Differences are found as
+// SchemasEqual finds them, and the script is empty if there are none. To compare
+// two recorded versions of a database's schema, pass them in from
+// SchemaAtVersion.
+func GenerateMigrationSQL(fromSchema, toSchema string) (string, error) {
+	return GenerateMigrationSQLWithOptions(fromSchema, toSchema, nil)
+}
+
+// GenerateMigrationSQLWithOptions is like GenerateMigrationSQL but takes Options
+// controlling the comparison, such as IgnoreColumnOrder and SkipTables. A nil
+// opts is the same as DefaultOptions().
+func GenerateMigrationSQLWithOptions(fromSchema, toSchema string, opts *Options) (string, error) {
+	opts = resolveOptions(opts)
+
+	oldDB, err := openTemporaryDB()
+	if err != nil {
+		return "", err
+	}
+	defer oldDB.Close()
+	if err := execSchema(oldDB, fromSchema); err != nil {
+		return "", fmt.Errorf("failed to execute old schema: %w", err)
+	}
+	newDB, err := openTemporaryDB()
+	if err != nil {
+		return "", err
+	}
+	defer newDB.Close()
+	if err := execSchema(newDB, toSchema); err != nil {
+		return "", fmt.Errorf("failed to execute new schema: %w", err)
+	}
+
+	diff, err := diffDatabases(oldDB, newDB, opts)
+	if err != nil {
+		return "", err
+	}
+	if diff.Empty() {
+		return "", nil
+	}
+	return migrationScript(oldDB, newDB, diff, opts)
+}
+
+// migrationScript writes out the statements for diff, the differences between
+// the schemas of oldDB and newDB. Objects are dropped in the reverse of the
+// order they were created in oldDB, and created in the order of newDB, so that
+// nothing is dropped before what depends on it or created before what it uses.
+func migrationScript(oldDB, newDB *sql.DB, diff *SchemaDiff, opts *Options) (string, error) {
+	key := func(obj SchemaObject) string {
+		return obj.Type + " " + strings.ToLower(obj.Name)
+	}
+	changed := make(map[string]bool)
+	for _, obj := range diff.Changed {
+		changed[key(obj)] = true
+	}
+	dropped := make(map[string]bool)
+	for _, obj := range diff.Removed {
+		dropped[key(obj)] = true
+	}
+	for _, obj := range diff.Changed {
+		if obj.Type != "table" {
+			dropped[key(obj)] = true
+		}
+	}
+	created := make(map[string]bool)
+	for _, obj := range diff.Added {
+		created[key(obj)] = true
+	}
+
+	var b strings.Builder
+	oldObjects, err := masterObjects(oldDB)
+	if err != nil {
+		return "", fmt.Errorf("failed to read old schema: %w", err)
+	}
+	for _, obj := range slices.Backward(oldObjects) {
+		if dropped[key(obj.SchemaObject)] {
+			fmt.Fprintf(&b, "DROP %s %s;\n", strings.ToUpper(obj.Type), obj.Name)
+		}
+	}
+
+	newObjects, err := masterObjects(newDB)
+	if err != nil {
+		return "", fmt.Errorf("failed to read new schema: %w", err)
+	}
+	for _, obj := range newObjects {
+		k := key(obj.SchemaObject)
+		switch {
+		case created[k] || (changed[k] && obj.Type != "table"):
+			fmt.Fprintf(&b, "%s;\n", obj.sql)
+		case changed[k]:
+			added, ok, err := addedColumnDefinitions(oldDB, obj.Name, obj.sql, opts)
+			if err != nil {
+				return "", fmt.Errorf("failed to compare table %s: %w", obj.Name, err)
+			}
+			if !ok {
+				fmt.Fprintf(&b, "-- Table %s needs a full rebuild: its new definition can't be reached with ALTER TABLE.\n", obj.Name)
+				fmt.Fprintf(&b, "-- %s;\n", strings.ReplaceAll(obj.sql, "\n", "\n-- "))
+				continue
+			}
+			for _, def := range added {
+				fmt.Fprintf(&b, "ALTER TABLE %s ADD COLUMN %s;\n", obj.Name, def)
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// masterObject is an object of a database schema with its definition as written.
+type masterObject struct {
+	SchemaObject
+	sql string
+}
+
+// masterObjects returns the objects of db that have a definition, in the order
+// they were created.
+func masterObjects(db queryer) ([]masterObject, error) {
+	rows, err := db.Query("SELECT type, name, tbl_name, sql FROM sqlite_master WHERE sql IS NOT NULL ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []masterObject
+	for rows.Next() {
+		var obj masterObject
+		if err := rows.Scan(&obj.Type, &obj.Name, &obj.Table, &obj.sql); err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// addedColumnDefinitions works out whether table in oldDB becomes the table
+// defined by newSQL just by adding columns to it, and if so returns the
+// definitions of the columns to add, in order. It finds out by adding them to
+// the table, so that SQLite's own rules for ALTER TABLE ADD COLUMN apply, and
+// then comparing the result with newSQL; the change is rolled back either way.
+func addedColumnDefinitions(oldDB *sql.DB, table, newSQL string, opts *Options) (added []string, ok bool, err error) {
+	oldColumns, err := columnInfo(oldDB, table)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, def := range tableDefinitions(newSQL) {
+		tokens := tokenize(def)
+		if len(tokens) == 0 {
+			continue
+		}
+		if slices.ContainsFunc(oldColumns, func(col ColumnInfo) bool { return sameName(col.Name, tokens[0].name()) }) {
+			continue
+		}
+		if isTableConstraint(tokens[0]) {
+			return nil, false, nil
+		}
+		if !addableColumn(tokens) {
+			return nil, false, nil
+		}
+		added = append(added, def)
+	}
+	if len(added) == 0 {
+		return nil, false, nil
+	}
+
+	tx, err := oldDB.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+	for _, def := range added {
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, def)); err != nil {
+			return nil, false, nil
+		}
+	}
+	var altered string
+	if err := tx.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name=? COLLATE NOCASE", table).Scan(&altered); err != nil {
+		return nil, false, err
+	}
+	canonical := canonicalSQL
+	if opts.IgnoreColumnOrder {
+		canonical = canonicalSQLUnordered
+	}
+	if canonical(altered) != canonical(newSQL) {
+		return nil, false, nil
+	}
+	return added, true, nil
+}
+
+// tableDefinitions returns the text of each column definition and table
+// constraint of a CREATE TABLE statement, as written but without comments
+// around it.
+func tableDefinitions(createSQL string) []string {
+	var defs []string
+	var def []token
+	text := func() string {
+		last := def[len(def)-1]
+		return createSQL[def[0].pos : last.pos+len(last.text)]
+	}
+	depth := 0
+	for _, tok := range tokenize(createSQL) {
+		if tok.kind == tokPunct {
+			switch {
+			case tok.text == "(":
+				depth++
+				if depth == 1 {
+					continue
+				}
+			case tok.text == ")" && depth == 1:
+				if len(def) > 0 {
+					defs = append(defs, text())
+				}
+				return defs
+			case tok.text == ")":
+				depth--
+			case tok.text == "," && depth == 1:
+				if len(def) > 0 {
+					defs = append(defs, text())
+				}
+				def = nil
+				continue
+			}
+		}
+		if depth > 0 {
+			def = append(def, tok)
+		}
+	}
+	return defs
+}
+
+// addableColumn reports whether the column definition tokens can be added to a
+// table that has rows. SQLite only checks this when a table isn't empty, so it
+// can't be left to trying: a NOT NULL column needs a DEFAULT other than NULL,
+// and the DEFAULT can't be CURRENT_TIME, CURRENT_DATE or CURRENT_TIMESTAMP.
+func addableColumn(tokens []token) bool {
+	notNull, hasDefault := false, false
+	for i, tok := range tokens {
+		var next token
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+		switch {
+		case tok.is("NOT") && next.is("NULL"):
+			notNull = true
+		case tok.is("DEFAULT"):
+			if next.is("CURRENT_TIME") || next.is("CURRENT_DATE") || next.is("CURRENT_TIMESTAMP") {
+				return false
+			}
+			hasDefault = !next.is("NULL")
+		}
+	}
+	return hasDefault || !notNull
+}
+
+// isTableConstraint reports whether a definition in a CREATE TABLE statement
+// starting with tok is a table constraint rather than a column definition.
+func isTableConstraint(tok token) bool {
+	for _, kw := range []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN"} {
+		if tok.is(kw) {
+			return true
+		}
+	}
+	return false
+}
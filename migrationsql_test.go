@@ -0,0 +1,95 @@
+package autosqlite
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateMigrationSQL(t *testing.T) {
+	from := `
+CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER, body TEXT);
+CREATE TABLE old_logs (msg TEXT);
+CREATE INDEX idx_posts_user ON posts(user_id);
+CREATE VIEW user_names AS SELECT name FROM users;
+`
+	to := `
+CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, email TEXT, -- contact address
+	active INTEGER NOT NULL DEFAULT 1);
+CREATE TABLE posts (id INTEGER PRIMARY KEY, body TEXT);
+CREATE TABLE tags (id INTEGER PRIMARY KEY, label TEXT);
+CREATE VIEW user_names AS SELECT name, email FROM users;
+`
+	script, err := GenerateMigrationSQL(from, to)
+	if err != nil {
+		t.Fatalf("GenerateMigrationSQL failed: %v", err)
+	}
+	want := `DROP VIEW user_names;
+DROP INDEX idx_posts_user;
+DROP TABLE old_logs;
+ALTER TABLE users ADD COLUMN email TEXT;
+ALTER TABLE users ADD COLUMN active INTEGER NOT NULL DEFAULT 1;
+-- Table posts needs a full rebuild: its new definition can't be reached with ALTER TABLE.
+-- CREATE TABLE posts (id INTEGER PRIMARY KEY, body TEXT);
+CREATE TABLE tags (id INTEGER PRIMARY KEY, label TEXT);
+CREATE VIEW user_names AS SELECT name, email FROM users;
+`
+	if script != want {
+		t.Errorf("unexpected script:\n%s\nwant:\n%s", script, want)
+	}
+
+	// Applying the script to the old schema gives the new one, apart from the
+	// tables it can't change in place
+	db, err := openTemporaryDB()
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	if err := execSchema(db, from); err != nil {
+		t.Fatalf("failed to create old schema: %v", err)
+	}
+	if _, err := db.Exec(script); err != nil {
+		t.Fatalf("failed to run script: %v", err)
+	}
+	diff, err := diffSchema(db, to, DefaultOptions())
+	if err != nil {
+		t.Fatalf("diffSchema failed: %v", err)
+	}
+	if got := diff.String(); got != "~ table posts\n" {
+		t.Errorf("expected only posts to differ after the script, got:\n%s", got)
+	}
+
+	script, err = GenerateMigrationSQL(from, from)
+	if err != nil {
+		t.Fatalf("GenerateMigrationSQL failed: %v", err)
+	}
+	if script != "" {
+		t.Errorf("expected an empty script for the same schema, got:\n%s", script)
+	}
+}
+
+func TestGenerateMigrationSQLNeedsRebuild(t *testing.T) {
+	from := "CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT);"
+	for name, to := range map[string]string{
+		"not null without default": "CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT NOT NULL);",
+		"unique column":            "CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT UNIQUE);",
+		"time default":             "CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT DEFAULT CURRENT_TIMESTAMP);",
+		"table constraint":         "CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, CHECK (a != ''));",
+		"column in the middle":     "CREATE TABLE t (id INTEGER PRIMARY KEY, b TEXT, a TEXT);",
+		"changed column":           "CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT NOT NULL);",
+	} {
+		t.Run(name, func(t *testing.T) {
+			script, err := GenerateMigrationSQL(from, to)
+			if err != nil {
+				t.Fatalf("GenerateMigrationSQL failed: %v", err)
+			}
+			if !strings.HasPrefix(script, "-- Table t needs a full rebuild") || strings.Contains(script, "ADD COLUMN") {
+				t.Errorf("expected a rebuild note, got:\n%s", script)
+			}
+		})
+	}
+
+	if _, err := GenerateMigrationSQL(from, "CREATE TABLE t (id INTEGER PRIMARY KEY,"); err == nil {
+		t.Error("expected an error for an invalid schema")
+	}
+}
@@ -0,0 +1,85 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OpenAllError is returned by OpenAll when some of the databases couldn't be
+// opened. The others are open all the same.
+type OpenAllError struct {
+	Errors map[string]error // Error for each path that couldn't be opened
+}
+
+func (e *OpenAllError) Error() string {
+	paths := make([]string, 0, len(e.Errors))
+	for path := range e.Errors {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	fmt.Fprintf(&b, "failed to open %d databases", len(paths))
+	for _, path := range paths {
+		fmt.Fprintf(&b, "\n%s: %v", path, e.Errors[path])
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors of the databases that couldn't be opened, so that
+// errors.Is and errors.As look at each of them.
+func (e *OpenAllError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// OpenAll opens each of the databases at dbPaths with schema, as Open does,
+// creating or migrating them as needed, for applications that give each
+// tenant a database of its own. The schema is prepared once, as by Compile, and
+// each database is compared with it rather than building the schema again.
+//
+// A database that can't be opened doesn't stop the others: the result holds a
+// handle for each database that was opened, keyed by its path, and the error is
+// an *OpenAllError with the error for each of the rest. The caller must close
+// every handle, even when the error is not nil. The databases are opened one
+// after another, in order; if the schema itself is invalid, none is opened.
+func OpenAll(schema string, dbPaths []string) (map[string]*sql.DB, error) {
+	return OpenAllWithOptions(schema, dbPaths, nil)
+}
+
+// OpenAllWithOptions is like OpenAll but takes Options, which apply to every
+// database. A Report describes the last database only. A nil opts is the same
+// as DefaultOptions().
+func OpenAllWithOptions(schema string, dbPaths []string, opts *Options) (map[string]*sql.DB, error) {
+	compiled, err := CompileWithOptions(schema, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile schema: %w", err)
+	}
+	withCompiled := *compiled.opts
+	withCompiled.compiled = compiled
+
+	dbs := make(map[string]*sql.DB, len(dbPaths))
+	failures := make(map[string]error)
+	for _, dbPath := range dbPaths {
+		if _, ok := dbs[dbPath]; ok {
+			continue
+		}
+		if _, ok := failures[dbPath]; ok {
+			continue
+		}
+		db, err := OpenWithOptions(schema, dbPath, &withCompiled)
+		if err != nil {
+			failures[dbPath] = err
+			continue
+		}
+		dbs[dbPath] = db
+	}
+	if len(failures) > 0 {
+		return dbs, &OpenAllError{Errors: failures}
+	}
+	return dbs, nil
+}
@@ -0,0 +1,73 @@
+package autosqlite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenAll(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.db")
+	db, err := Open(schemaV1, existing)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+	current := filepath.Join(dir, "current.db")
+	db, err = Open(schemaV2, current)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+	broken := filepath.Join(dir, "broken.db")
+	if err := os.WriteFile(broken, []byte("not a database, just some text that is long enough"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	created := filepath.Join(dir, "created.db")
+
+	dbs, err := OpenAll(schemaV2, []string{existing, broken, current, created, existing})
+	defer func() {
+		for _, db := range dbs {
+			db.Close()
+		}
+	}()
+	var openErr *OpenAllError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("expected an OpenAllError, got %v", err)
+	}
+	if len(openErr.Errors) != 1 || openErr.Errors[broken] == nil {
+		t.Errorf("expected only %s to fail, got %v", broken, openErr.Errors)
+	}
+	if len(dbs) != 3 {
+		t.Fatalf("expected 3 open databases, got %d", len(dbs))
+	}
+	for _, path := range []string{existing, current, created} {
+		if dbs[path] == nil {
+			t.Errorf("expected %s to be open", path)
+			continue
+		}
+		if !SchemasEqual(schemaV2, path) {
+			t.Errorf("expected %s to have the new schema", path)
+		}
+	}
+	var name string
+	if err := dbs[existing].QueryRow("SELECT name FROM users").Scan(&name); err != nil || name != "alice" {
+		t.Errorf("expected the migrated database to keep its data, got %q, %v", name, err)
+	}
+}
+
+func TestOpenAllInvalidSchema(t *testing.T) {
+	dbPath := tempDBPath(t)
+	dbs, err := OpenAll("CREATE TABLE (", []string{dbPath})
+	if err == nil || dbs != nil {
+		t.Fatalf("expected an invalid schema to fail, got %v, %v", dbs, err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("expected no database to be created, got %v", err)
+	}
+}
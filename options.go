@@ -0,0 +1,435 @@
+package autosqlite
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Options controls optional behaviour of Open, Migrate and MigrateToNewFile.
+// Start from DefaultOptions and change the fields you need; passing a nil
+// *Options to any of the *WithOptions functions is the same as passing
+// DefaultOptions(). The zero Options keeps every safety check on; it differs
+// from DefaultOptions only in not retrying.
+type Options struct {
+	// RunAnalyze runs ANALYZE on the migrated database once the data has been
+	// copied and PostMigrate has run. Query planner statistics (sqlite_stat1
+	// and friends) are not carried over by a migration, so without this they
+	// are missing until the application runs ANALYZE itself, and queries can
+	// be slow until then. With RebuildInPlace, MigrateDB and MigrateTx only
+	// the rebuilt tables are analyzed, since the others keep their
+	// statistics. PRAGMA optimize is no substitute here: it only analyzes
+	// tables that queries on the same connection have used. The cost is
+	// proportional to the amount of data, so it is off by default.
+	RunAnalyze bool
+
+	// TableRenames lists tables that were renamed between the old database and
+	// the new schema. The data of each From table is copied into its To table
+	// using the usual common-column rules, instead of being dropped.
+	TableRenames []TableRename
+
+	// BackupMode controls what Migrate does when the ".backup" file from an
+	// earlier migration already exists. The default is BackupOverwrite.
+	BackupMode BackupMode
+
+	// IgnoreColumnOrder makes schema comparison treat tables that declare the
+	// same columns in a different order as equal, so that moving a column
+	// doesn't rebuild the table. Column order is visible to SELECT * and
+	// INSERT without a column list, so comparison is strict by default.
+	IgnoreColumnOrder bool
+
+	// SchemaStorage controls how the schema text of each applied version is
+	// stored in the _autosqlite_version table. The default is StoreFull.
+	SchemaStorage SchemaStorage
+
+	// Retry is how to retry operations that fail transiently: the initial
+	// ping of a new database, and schema execution, backups and version
+	// recording that fail with SQLITE_BUSY because another connection holds
+	// a lock. This smooths over contention and slow or network filesystems.
+	// The default is DefaultRetryPolicy(); if nil, nothing is retried.
+	Retry *RetryPolicy
+
+	// NoCreateDirs stops Open creating any missing parent directories of a new
+	// database, so that the directory must exist already and a misconfigured
+	// path is an error rather than a surprise mkdir.
+	NoCreateDirs bool
+
+	// DirMode is the permission mode for missing parent directories Open creates,
+	// before the umask. If it is zero, 0755 is used, as it is by default.
+	DirMode os.FileMode
+
+	// FileMode is the permission mode for a newly created database file and
+	// for the backup and replacement files written by a migration. If it is
+	// zero, new databases get SQLite's default mode and migrations reuse the
+	// mode of the existing database file, so a migration never widens the
+	// permissions of the database or exposes its data through the backup.
+	FileMode os.FileMode
+
+	// ExpectedFromVersion, if non-zero, is the version the database must be at
+	// for Migrate to proceed; otherwise it returns ErrVersionMismatch without
+	// touching the database. Use it to insist that migrations are applied one
+	// at a time, so a binary that is several schema versions ahead of the
+	// database refuses to skip the versions in between. A database without
+	// version history counts as version 0.
+	ExpectedFromVersion int
+
+	// PreservePrevious, if set, keeps the database as it was before each
+	// migration at a permanent path, unlike the ".backup" file which the next
+	// migration replaces. It is a file name in which "{version}" is replaced
+	// by the schema version the database was at, e.g. "app.v{version}.db"
+	// gives app.v1.db, app.v2.db and so on. A relative name is resolved
+	// against the database's directory. The migration fails rather than
+	// overwrite an existing file.
+	PreservePrevious string
+
+	// FullIntegrityCheck makes integrity checks, such as HealthCheck's, run
+	// the full PRAGMA integrity_check instead of PRAGMA quick_check, which
+	// skips the checks of index contents and runs much faster.
+	FullIntegrityCheck bool
+
+	// ReadOnly opens the database with mode=ro, for example on a read replica
+	// or a read-only mount. Schema comparison then never writes to the
+	// database or creates journal files, and if the database doesn't exist or
+	// would need a migration, Open and Migrate return ErrReadOnly instead.
+	ReadOnly bool
+
+	// Report, if not nil, is filled in with statistics about the migration
+	// performed by Open, Migrate, MigrateToNewFile or MigrateDataBetween.
+	// See MigrationReport.
+	Report *MigrationReport
+
+	// OnTableCopied, if not nil, is called as a migration finishes copying
+	// the data of each table, or fails to, for example to log which tables
+	// take longest while the migration is still running. With
+	// ParallelTables the time is that spent writing the table, including
+	// any wait for rows still being read.
+	OnTableCopied func(TableTiming)
+
+	// DrainTimeout is how long DB.ReloadSchema waits for queries and
+	// transactions on the old connection pool to finish before giving up.
+	// If it is zero, 30 seconds is used, as it is by default.
+	DrainTimeout time.Duration
+
+	// Clock returns the time recorded when a schema version is applied, so
+	// tests can use a fixed time or an application its own clock. If nil,
+	// time.Now is used. Times are stored in UTC, formatted as RFC 3339.
+	Clock func() time.Time
+
+	// ConflictPolicy controls what happens when a row copied from the old
+	// database violates a UNIQUE, PRIMARY KEY, NOT NULL or CHECK constraint of
+	// the new schema, for example after adding a UNIQUE constraint to a column
+	// with duplicates. The default, ConflictAbort, fails the migration. The
+	// number of rows dropped is reported in MigrationReport.Stats.RowsSkipped.
+	ConflictPolicy ConflictPolicy
+
+	// OnConflict, if not nil, is called for each copied row that violates a
+	// constraint of the new schema, before ConflictPolicy is applied to it,
+	// so that the rows a lossy migration drops or replaces can be logged or
+	// saved elsewhere. Finding them means inserting rows one at a time and
+	// checking each, which makes the copy slower. Rows written to the
+	// database being migrated would be lost when it is replaced, so a
+	// dead-letter table belongs in another database. Not supported with
+	// RebuildInPlace.
+	OnConflict ConflictFunc
+
+	// BestEffort makes a migration carry on when the data of a table can't
+	// be copied, for example because part of the old database is corrupt or
+	// its rows break a constraint of the new schema: the table is left empty
+	// in the new database, the failure is added to
+	// MigrationReport.TableFailures and the other tables are copied as usual.
+	// The migration only fails, with ErrNoTablesCopied, if every table fails.
+	// Meant for salvaging what can be salvaged; check the report before
+	// trusting the result. Not supported with RebuildInPlace.
+	BestEffort bool
+
+	// Backfills fills new columns of existing rows with a value computed from
+	// the old row, for when a constant DEFAULT won't do, such as a NOT NULL
+	// slug column derived from a name. Keys are "table.column", naming a
+	// column of the new schema that the old table doesn't have; values are
+	// SQL expressions evaluated against the old table, e.g.
+	// "lower(replace(name, ' ', '-'))". An expression may only refer to
+	// columns of the old table.
+	Backfills map[string]string
+
+	// ColumnMatching sets how the columns of a table are paired with those
+	// of its old table when its data is copied, keyed by the table's name in
+	// the new schema. Tables not listed match by name. MatchByPosition suits
+	// a simple reshape that renames columns in place, but puts data in the
+	// wrong column if any column moves; for anything else give the new
+	// columns Backfills, which may read any old column.
+	ColumnMatching map[string]MatchStrategy
+
+	// RebuildInPlace makes Migrate change the database file itself instead of
+	// building a new file and renaming it over the old one. Connections that
+	// other processes or pools already have open keep using the file they
+	// opened, so after a rename they go on seeing the old database until they
+	// reconnect; with RebuildInPlace they see the migrated schema and data
+	// straight away. Changed tables are rebuilt in a single transaction
+	// following the procedure recommended by SQLite, holding a write lock on
+	// the database until it commits. The backup is made as usual.
+	// TableRenames, MigratePreview, OnConflict, BestEffort and MatchByPosition
+	// are not supported.
+	RebuildInPlace bool
+
+	// SkipBackupVerify stops Migrate checking the backup before changing the
+	// database. Normally the backup must pass PRAGMA quick_check (or
+	// integrity_check, see FullIntegrityCheck) and have the same schema as the
+	// database, so that a backup that was truncated, for example because the
+	// disk filled up, fails the migration instead of leaving no way back. The
+	// check reads the whole backup once.
+	SkipBackupVerify bool
+
+	// EncryptionKey, if not empty, is given to every connection autosqlite
+	// opens, including those to the backup and the new database a migration
+	// builds, with PRAGMA key, and the *sql.DB returned uses it too. It
+	// needs SQLite built with encryption, such as SQLCipher linked in with
+	// the libsqlite3 build tag. The key is never put into a DSN, so it
+	// doesn't appear in logs or errors. As a safeguard against a build
+	// without encryption, which ignores the key, creating a database, a
+	// backup or a migrated database that isn't encrypted fails with
+	// ErrNotEncrypted.
+	EncryptionKey string
+
+	// BusyTimeout, if not zero, is the busy timeout, as PRAGMA busy_timeout
+	// sets it, of every connection autosqlite opens, including those to the backup, the new
+	// database a migration builds and the databases it compares, and the
+	// *sql.DB returned uses it too. SQLite then waits up to that long for
+	// another connection's lock instead of failing with SQLITE_BUSY at once.
+	// It takes the place of a _busy_timeout in the DSN, which only reaches
+	// connections to the database itself. The default leaves the timeout to
+	// the DSN, or to the driver's 5 seconds.
+	BusyTimeout time.Duration
+
+	// Synchronous, if not empty, is the PRAGMA synchronous level (OFF,
+	// NORMAL, FULL or EXTRA) of the connections that build the new database
+	// during a migration, for example OFF to speed up copying a large
+	// database. It applies to nothing else: once the copy is done the new
+	// file is flushed to disk with fsync, and the *sql.DB returned, like
+	// every other connection, keeps the level of the DSN. A crash of the
+	// process loses nothing a lower level would keep, but a crash of the
+	// operating system or a power cut during the copy may leave the new
+	// database corrupt; the old database is untouched until the copy is
+	// flushed, so the migration just has to be run again. The default uses
+	// the level of the DSN throughout.
+	Synchronous string
+
+	// PostMigrate lists data fixes to run, in order, on the migrated database
+	// after the data has been copied and before it replaces the old one. All
+	// steps run in a single transaction that commits only if the migration
+	// goes ahead, and each step runs inside its own SAVEPOINT, so a failing
+	// step's changes are rolled back without undoing the steps before it.
+	// Whether the migration then fails or carries on with the next step is
+	// up to the step; see PostMigrateStep. With RebuildInPlace the steps run
+	// in the rebuild's transaction.
+	PostMigrate []PostMigrateStep
+
+	// StrictRowid makes a migration fail with ErrRowidChanged instead of
+	// adding a warning to MigrationReport.Warnings when it changes how the
+	// rows of a copied table are identified: an INTEGER PRIMARY KEY that
+	// stops or starts being an alias for the rowid, AUTOINCREMENT being added
+	// or removed, or a switch to or from WITHOUT ROWID. Such changes can
+	// silently renumber rows or change whether ids are reused.
+	StrictRowid bool
+
+	// TempDir, if set, is the directory Migrate builds the migrated database
+	// in, for example a large scratch volume when the database's own
+	// partition has little free space. The finished file is renamed over the
+	// database, or if TempDir is on another filesystem, copied next to it
+	// first and then renamed, which needs room for one more copy there.
+	// By default the file is built next to the database.
+	TempDir string
+
+	// ResetUserVersion stops Migrate copying PRAGMA user_version from the old
+	// database to the migrated one, so the new database file starts at 0.
+	// It is copied by default for applications that keep their own version
+	// number there.
+	ResetUserVersion bool
+
+	// ResetApplicationID stops Migrate copying PRAGMA application_id from the
+	// old database to the migrated one. It is copied by default so that tools
+	// that identify the file by it still recognise it.
+	ResetApplicationID bool
+
+	// AllowBackward lets Migrate go back to a schema that was applied before
+	// the current one, which is normally refused to prevent data loss: tables
+	// and columns that the older schema doesn't have are dropped along with
+	// their data. The backup is made as usual, and the downgrade is recorded
+	// as a new version, so the history shows it happened.
+	AllowBackward bool
+
+	// AllowEmptySchema lets Migrate go to a schema that defines no tables
+	// when the database has some, dropping them all. Without it the migration
+	// fails with ErrEmptySchema, since such a schema is far more often a
+	// mistake, such as an empty schema file, than a wish to empty the database.
+	AllowEmptySchema bool
+
+	// SkipTables lists tables that migrations leave as they are, such as a
+	// large append-only log whose definition never changes. They are ignored
+	// when comparing schemas, so a difference in them never causes a
+	// migration, and when another change does, each keeps its definition,
+	// indexes and triggers from the existing database, whatever the new
+	// schema says, and its rows are copied over unchanged in a single
+	// statement instead of column by column. With RebuildInPlace they are not
+	// touched at all. A skipped table that the database doesn't have yet is
+	// created from the new schema.
+	SkipTables []string
+
+	// ParallelTables, if greater than 1, makes a migration read up to that
+	// many tables from the old database at once, which can shorten the copy
+	// of a database with many tables. It only helps tables whose rows pass
+	// through Go, those with Backfills, or all of them with OnConflict;
+	// others are copied within SQLite in any case.
+	// SQLite allows only one writer, so the
+	// rows are still written to the new database a table at a time, in the
+	// same order and with the same results as a sequential copy; reading
+	// ahead just keeps the writer busy. Each reader uses its own connection
+	// to the old database, so the number is limited by SetMaxOpenConns, and
+	// an in-memory database is always copied sequentially. The default is to
+	// copy tables one after another.
+	ParallelTables int
+
+	// MarkMigrating makes Migrate create a marker file, the database path
+	// with ".migrating" added, while it migrates the database, so that other
+	// processes can tell a migration is in progress with IsMigrating, for
+	// example to take an instance out of a load balancer while it migrates.
+	// The file says which process is migrating and since when. It is only
+	// created once Migrate has found that the database needs migrating, and
+	// is removed when the migration finishes or fails.
+	MarkMigrating bool
+
+	// ctx is the context given to MigrateContext, or nil.
+	ctx context.Context
+
+	// compiled is the schema prepared by OpenAll, which comparisons with that
+	// schema use instead of building it again, or nil.
+	compiled *Comparator
+}
+
+// SchemaStorage selects how schema text is stored in the version table. Only
+// the hash is needed to detect backward migrations, so the text can be
+// compressed or dropped to save space.
+type SchemaStorage int
+
+const (
+	// StoreFull stores the schema text as-is.
+	StoreFull SchemaStorage = iota
+	// StoreCompressed stores the schema text gzip-compressed, as a BLOB.
+	StoreCompressed
+	// StoreHashOnly stores no schema text, only its hash.
+	StoreHashOnly
+)
+
+// BackupMode controls what happens to an existing backup file when a migration
+// creates a new one.
+type BackupMode int
+
+const (
+	// BackupOverwrite replaces the existing backup.
+	BackupOverwrite BackupMode = iota
+	// BackupFail aborts the migration with ErrBackupExists, leaving both the
+	// database and the existing backup untouched.
+	BackupFail
+	// BackupRotate keeps the existing backup by renaming it to ".backup.1",
+	// after moving any ".backup.1" to ".backup.2", and so on.
+	BackupRotate
+)
+
+// ConflictPolicy selects how rows that violate a constraint of the new schema are
+// handled while data is copied.
+type ConflictPolicy int
+
+const (
+	// ConflictAbort fails the migration at the first conflicting row.
+	ConflictAbort ConflictPolicy = iota
+	// ConflictIgnore skips conflicting rows (INSERT OR IGNORE), keeping the
+	// row that was copied first.
+	ConflictIgnore
+	// ConflictReplace replaces the existing row (INSERT OR REPLACE), keeping
+	// the row that was copied last.
+	ConflictReplace
+)
+
+// insertVerb returns the INSERT statement verb that implements p.
+func (p ConflictPolicy) insertVerb() string {
+	switch p {
+	case ConflictIgnore:
+		return "INSERT OR IGNORE"
+	case ConflictReplace:
+		return "INSERT OR REPLACE"
+	}
+	return "INSERT"
+}
+
+// TableRename describes a table that was renamed between the old and new schema.
+type TableRename struct {
+	From string // Table name in the old database
+	To   string // Table name in the new schema
+}
+
+// DefaultOptions returns the options used by Open, Migrate and MigrateToNewFile.
+func DefaultOptions() *Options {
+	retryPolicy := DefaultRetryPolicy()
+	return &Options{
+		Retry:        &retryPolicy,
+		DirMode:      0755,
+		DrainTimeout: 30 * time.Second,
+	}
+}
+
+// retryPolicy returns opts.Retry, or a policy of a single attempt if it is nil.
+func (opts *Options) retryPolicy() RetryPolicy {
+	if opts.Retry == nil {
+		return RetryPolicy{MaxAttempts: 1}
+	}
+	return *opts.Retry
+}
+
+// timestamp returns the current time from opts.Clock, formatted for the version table.
+func (opts *Options) timestamp() string {
+	now := time.Now
+	if opts.Clock != nil {
+		now = opts.Clock
+	}
+	return now().UTC().Format(time.RFC3339)
+}
+
+// skips reports whether table is one of SkipTables.
+func (opts *Options) skips(table string) bool {
+	return slices.ContainsFunc(opts.SkipTables, func(skip string) bool { return sameName(skip, table) })
+}
+
+// backfillsFor returns the Backfills expressions for the columns of table, keyed
+// by column name as folded by foldName.
+func (opts *Options) backfillsFor(table string) map[string]string {
+	var backfills map[string]string
+	for key, expr := range opts.Backfills {
+		t, column, ok := strings.Cut(key, ".")
+		if !ok || !sameName(t, table) {
+			continue
+		}
+		if backfills == nil {
+			backfills = make(map[string]string)
+		}
+		backfills[foldName(column)] = expr
+	}
+	return backfills
+}
+
+// migrationContext returns the context the migration runs under: the one given
+// to MigrateContext, or context.Background().
+func (opts *Options) migrationContext() context.Context {
+	if opts.ctx == nil {
+		return context.Background()
+	}
+	return opts.ctx
+}
+
+// resolveOptions returns opts, or DefaultOptions() if opts is nil.
+func resolveOptions(opts *Options) *Options {
+	if opts == nil {
+		return DefaultOptions()
+	}
+	return opts
+}
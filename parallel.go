@@ -0,0 +1,187 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCopyAborted stops a table reader once the parallel copy has finished early.
+var errCopyAborted = errors.New("table copy aborted")
+
+// copyBatchSize is how many rows a reader hands to the writer at a time.
+const copyBatchSize = 256
+
+// tableJob is one table of a parallel copy: its plan, and the rows its reader
+// has read so far, in batches.
+type tableJob struct {
+	oldTable, newTable string
+	skip               bool       // A SkipTables table, copied by copySkippedTable
+	plan               *tableCopy // nil if there is nothing to copy
+	attached           bool       // Copied by writeAttached, without a reader
+	err                error      // Why the table couldn't be planned, for Options.BestEffort
+	rows               chan [][]any
+	errc               chan error // The reader's result, sent after rows is closed
+}
+
+// readParallelism returns how many tables migrateData may read from oldDB at
+// once: opts.ParallelTables, limited to the connections oldDB allows. An
+// in-memory database exists only on its own connection, so it is always read
+// one table at a time.
+func readParallelism(oldDB *sql.DB, opts *Options) int {
+	n := opts.ParallelTables
+	if n <= 1 {
+		return 1
+	}
+	if limit := oldDB.Stats().MaxOpenConnections; limit > 0 {
+		n = min(n, limit)
+	}
+
+	if filename, err := databaseFile(oldDB); err != nil || isMemoryDatabase(filename) {
+		return 1
+	}
+	return n
+}
+
+// copyTablesParallel copies the same tables as copyTables, reading up to n of
+// them from oldDB at once. SQLite allows a single writer, so the rows are still
+// written by one goroutine, a table at a time in the order of newTables, each
+// in its own transaction; readers that get ahead wait for it. Tables that
+// copyTable would copy with writeAttached don't need reading, and are copied
+// that way in their turn.
+func copyTablesParallel(oldDB, newDB *sql.DB, newTables []string, sources map[string]string, n int, opts *Options) error {
+	oldFile, err := databaseFile(oldDB)
+	if err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Err: err}
+	}
+
+	var jobs []*tableJob
+	for _, tableName := range newTables {
+		oldName, ok := sources[tableName]
+		if !ok {
+			continue
+		}
+		job := &tableJob{oldTable: oldName, newTable: tableName, skip: opts.skips(tableName)}
+		jobs = append(jobs, job)
+		if !job.skip {
+			backfills := opts.backfillsFor(tableName)
+			var plan *tableCopy
+			err := checkRowidChanges(oldDB, newDB, oldName, tableName, opts)
+			if err == nil {
+				plan, err = planTableCopy(oldDB, newDB, oldName, tableName, backfills, opts.matchStrategyFor(tableName))
+			}
+			if err != nil {
+				if !opts.BestEffort {
+					return &MigrationError{Phase: PhaseDataCopy, Table: tableName, Err: err}
+				}
+				job.err = err
+				continue
+			}
+			job.plan = plan
+			job.attached = opts.copiesAttached(oldFile, backfills)
+			if plan != nil && !job.attached {
+				job.rows = make(chan [][]any, 16)
+				job.errc = make(chan error, 1)
+			}
+		}
+	}
+
+	// Closing done stops the launcher and any readers still running; it
+	// happens before waiting for them, so an early return can't deadlock
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(done)
+
+	// Readers start in table order, so the table being written always has one
+	sem := make(chan struct{}, n)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, job := range jobs {
+			if job.rows == nil {
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				send := func(batch [][]any) error {
+					select {
+					case job.rows <- batch:
+						return nil
+					case <-done:
+						return errCopyAborted
+					}
+				}
+				batch := make([][]any, 0, copyBatchSize)
+				err := job.plan.read(opts.migrationContext(), oldDB, func(values []any) error {
+					batch = append(batch, values)
+					if len(batch) < copyBatchSize {
+						return nil
+					}
+					err := send(batch)
+					batch = make([][]any, 0, copyBatchSize)
+					return err
+				})
+				if err == nil && len(batch) > 0 {
+					err = send(batch)
+				}
+				close(job.rows)
+				job.errc <- err
+			}()
+		}
+	}()
+
+	outcomes := &tableOutcomes{opts: opts}
+	for _, job := range jobs {
+		start := time.Now()
+		var copied, skipped int64
+		var err error
+		switch {
+		case job.err != nil:
+			err = job.err
+		case job.skip:
+			copied, err = copySkippedTable(oldDB, newDB, oldFile, job.oldTable, job.newTable, opts)
+		case job.plan == nil:
+		case job.attached:
+			copied, skipped, err = job.plan.writeAttached(opts.migrationContext(), newDB, oldFile, opts.ConflictPolicy)
+		default:
+			copied, skipped, err = job.plan.write(opts.migrationContext(), newDB, opts.ConflictPolicy, opts.OnConflict, func(insert func([]any) error) error {
+				for batch := range job.rows {
+					for _, values := range batch {
+						if err := insert(values); err != nil {
+							return err
+						}
+					}
+				}
+				return <-job.errc
+			})
+		}
+		opts.tableCopied(job.newTable, start, copied, err)
+		if err != nil {
+			if err := outcomes.fail(job.newTable, err); err != nil {
+				return err
+			}
+			// Let the reader finish, so that the next one can start
+			if job.rows != nil {
+				for range job.rows {
+				}
+			}
+			continue
+		}
+		outcomes.succeeded++
+		if opts.Report != nil {
+			opts.Report.Stats.TablesCopied++
+			opts.Report.Stats.RowsCopied += copied
+			opts.Report.Stats.RowsSkipped += skipped
+		}
+	}
+	return outcomes.done()
+}
@@ -0,0 +1,144 @@
+package autosqlite
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// manyTablesSchema returns a schema of n tables, t00 to t(n-1), adding extra
+// to each table's columns.
+func manyTablesSchema(n int, extra string) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "CREATE TABLE t%02d (id INTEGER PRIMARY KEY, name TEXT%s);\n", i, extra)
+	}
+	return b.String()
+}
+
+// noteBackfills returns Backfills for the note column of manyTablesSchema(n,
+// ", note TEXT"), which make the tables' rows pass through Go.
+func noteBackfills(n int) map[string]string {
+	backfills := make(map[string]string)
+	for i := 0; i < n; i++ {
+		backfills[fmt.Sprintf("t%02d.note", i)] = "upper(name)"
+	}
+	return backfills
+}
+
+// createManyTables creates a database at dbPath with the tables of
+// manyTablesSchema(n, ""), giving table i rows(i) rows.
+func createManyTables(tb testing.TB, dbPath string, n int, rows func(i int) int) {
+	tb.Helper()
+	db, err := Open(manyTablesSchema(n, ""), dbPath)
+	if err != nil {
+		tb.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < n; i++ {
+		_, err := db.Exec(fmt.Sprintf(`WITH RECURSIVE seq(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM seq WHERE x < %d)
+			INSERT INTO t%02d (name) SELECT 'row ' || (x %% 10) FROM seq`, rows(i), i))
+		if err != nil {
+			tb.Fatalf("failed to insert: %v", err)
+		}
+	}
+}
+
+func TestParallelTables(t *testing.T) {
+	const tables = 20
+	rows := func(i int) int { return (i + 1) * 300 }
+
+	dbPath := tempDBPath(t)
+	createManyTables(t, dbPath, tables, rows)
+
+	var report MigrationReport
+	opts := DefaultOptions()
+	opts.ParallelTables = 4
+	opts.Backfills = noteBackfills(tables)
+	opts.Report = &report
+	db, err := OpenWithOptions(manyTablesSchema(tables, ", note TEXT"), dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+
+	var total int64
+	for i := 0; i < tables; i++ {
+		var count, maxID int
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*), COALESCE(MAX(id), 0) FROM t%02d", i)).Scan(&count, &maxID); err != nil {
+			t.Fatalf("failed to count t%02d: %v", i, err)
+		}
+		if count != rows(i) || maxID != rows(i) {
+			t.Errorf("t%02d: expected %d rows, got %d (max id %d)", i, rows(i), count, maxID)
+		}
+		total += int64(rows(i))
+	}
+	if report.Stats.TablesCopied != tables || report.Stats.RowsCopied != total {
+		t.Errorf("expected %d tables and %d rows copied, got %+v", tables, total, report.Stats)
+	}
+}
+
+func TestParallelTablesConflicts(t *testing.T) {
+	const tables = 8
+	dbPath := tempDBPath(t)
+	createManyTables(t, dbPath, tables, func(int) int { return 100 })
+
+	// Each table has only 10 distinct names
+	unique := manyTablesSchema(tables, " UNIQUE")
+
+	conflicts := 0
+	opts := DefaultOptions()
+	opts.ParallelTables = 3
+	opts.OnConflict = func(string, map[string]interface{}, error) { conflicts++ }
+	_, err := OpenWithOptions(unique, dbPath, opts)
+	var migErr *MigrationError
+	if !errors.As(err, &migErr) || migErr.Phase != PhaseDataCopy || migErr.Table != "t00" {
+		t.Fatalf("expected a data copy error on t00, got %v", err)
+	}
+
+	var report MigrationReport
+	opts.ConflictPolicy = ConflictIgnore
+	opts.Report = &report
+	db, err := OpenWithOptions(unique, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < tables; i++ {
+		var count int
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM t%02d", i)).Scan(&count); err != nil || count != 10 {
+			t.Errorf("t%02d: expected 10 rows, got %d, %v", i, count, err)
+		}
+	}
+	if conflicts != 1+tables*90 {
+		t.Errorf("expected %d conflicts, got %d", 1+tables*90, conflicts)
+	}
+	if report.Stats.RowsCopied != tables*10 || report.Stats.RowsSkipped != tables*90 {
+		t.Errorf("expected %d rows copied and %d skipped, got %+v", tables*10, tables*90, report.Stats)
+	}
+}
+
+func BenchmarkParallelTables(b *testing.B) {
+	const tables = 20
+	src := filepath.Join(b.TempDir(), "src.db")
+	createManyTables(b, src, tables, func(int) int { return 5000 })
+	newSchema := manyTablesSchema(tables, ", note TEXT")
+
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprintf("parallel=%d", n), func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.ParallelTables = n
+			opts.Backfills = noteBackfills(tables)
+			for i := 0; i < b.N; i++ {
+				dst := filepath.Join(b.TempDir(), "dst.db")
+				db, err := MigrateToNewFileWithOptions(newSchema, src, dst, opts)
+				if err != nil {
+					b.Fatalf("migration failed: %v", err)
+				}
+				db.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// PostMigrateStep is a data fix run on the migrated database once its data has
+// been copied, for example to fill in a new column from other tables. See
+// Options.PostMigrate.
+type PostMigrateStep struct {
+	// Name identifies the step in errors and in MigrationReport.
+	Name string
+
+	// Run performs the step using tx. Its changes are undone if it returns an
+	// error.
+	Run func(tx *sql.Tx) error
+
+	// ContinueOnError makes a failure of this step non-fatal: only the
+	// step's own changes are rolled back, the failure is recorded in
+	// MigrationReport.PostMigrateFailures and the remaining steps run as
+	// usual. By default a failing step fails the whole migration.
+	ContinueOnError bool
+}
+
+// PostMigrateFailure records a PostMigrateStep that failed with ContinueOnError set.
+type PostMigrateFailure struct {
+	Step string // Name of the step
+	Err  error  // Error returned by the step
+}
+
+// runPostMigrateTx runs opts.PostMigrate on db in a transaction of its own.
+func runPostMigrateTx(db *sql.DB, opts *Options) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return &MigrationError{Phase: PhasePostMigrate, Err: fmt.Errorf("failed to begin transaction: %w", err)}
+	}
+	if err := runPostMigrate(tx, opts); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return &MigrationError{Phase: PhasePostMigrate, Err: err}
+	}
+	return nil
+}
+
+// runPostMigrate runs opts.PostMigrate in tx, each inside its own SAVEPOINT so
+// that a failing step can be rolled back without undoing the others.
+func runPostMigrate(tx *sql.Tx, opts *Options) error {
+	for i, step := range opts.PostMigrate {
+		savepoint := fmt.Sprintf("autosqlite_post_migrate_%d", i)
+		if _, err := tx.Exec("SAVEPOINT " + savepoint); err != nil {
+			return &MigrationError{Phase: PhasePostMigrate, Err: fmt.Errorf("failed to start step %s: %w", step.Name, err)}
+		}
+
+		stepErr := step.Run(tx)
+		if stepErr != nil {
+			if _, err := tx.Exec("ROLLBACK TO " + savepoint); err != nil {
+				return &MigrationError{Phase: PhasePostMigrate, Err: fmt.Errorf("failed to roll back step %s: %w", step.Name, err)}
+			}
+		}
+		if _, err := tx.Exec("RELEASE " + savepoint); err != nil {
+			return &MigrationError{Phase: PhasePostMigrate, Err: fmt.Errorf("failed to finish step %s: %w", step.Name, err)}
+		}
+
+		if stepErr != nil {
+			if !step.ContinueOnError {
+				return &MigrationError{Phase: PhasePostMigrate, Err: fmt.Errorf("step %s: %w", step.Name, stepErr)}
+			}
+			if opts.Report != nil {
+				opts.Report.PostMigrateFailures = append(opts.Report.PostMigrateFailures, PostMigrateFailure{Step: step.Name, Err: stepErr})
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestPostMigrate(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	errBadFix := errors.New("bad fix")
+	opts := DefaultOptions()
+	var report MigrationReport
+	opts.Report = &report
+	opts.PostMigrate = []PostMigrateStep{
+		{Name: "emails", Run: func(tx *sql.Tx) error {
+			_, err := tx.Exec("UPDATE users SET email = name || '@example.com'")
+			return err
+		}},
+		{Name: "broken", ContinueOnError: true, Run: func(tx *sql.Tx) error {
+			if _, err := tx.Exec("INSERT INTO users (name) VALUES ('mallory')"); err != nil {
+				return err
+			}
+			return errBadFix
+		}},
+		{Name: "names", Run: func(tx *sql.Tx) error {
+			_, err := tx.Exec("UPDATE users SET name = upper(name)")
+			return err
+		}},
+	}
+
+	db, err = MigrateWithOptions(schemaV2, dbPath, opts)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+
+	var count int
+	var name, email string
+	db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	if err := db.QueryRow("SELECT name, email FROM users").Scan(&name, &email); err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if count != 1 || name != "ALICE" || email != "alice@example.com" {
+		t.Errorf("expected only the failed step to be rolled back, got %d rows, %q %q", count, name, email)
+	}
+	if len(report.PostMigrateFailures) != 1 || report.PostMigrateFailures[0].Step != "broken" || !errors.Is(report.PostMigrateFailures[0].Err, errBadFix) {
+		t.Errorf("expected the broken step to be reported, got %+v", report.PostMigrateFailures)
+	}
+	db.Close()
+
+	// A step without ContinueOnError fails the migration and leaves the database alone
+	opts.PostMigrate = []PostMigrateStep{{Name: "fatal", Run: func(tx *sql.Tx) error { return errBadFix }}}
+	_, err = MigrateWithOptions(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, age INTEGER);`, dbPath, opts)
+	var migErr *MigrationError
+	if !errors.As(err, &migErr) || migErr.Phase != PhasePostMigrate || !errors.Is(err, errBadFix) {
+		t.Fatalf("expected a post-migrate MigrationError, got %v", err)
+	}
+	if !SchemasEqual(schemaV2, dbPath) {
+		t.Errorf("expected the failed migration to leave the database unchanged")
+	}
+}
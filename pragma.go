@@ -0,0 +1,97 @@
+package autosqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// persistentPragmas are the PRAGMAs a schema may declare. Their settings are
+// stored in the database file rather than the connection, so they are applied
+// to a new database before its schema is executed, and again to the new file
+// built by each migration. They are not compared by SchemasEqual.
+var persistentPragmas = []string{"page_size", "auto_vacuum", "encoding", "journal_mode", "application_id", "user_version"}
+
+// schemaPragma is a persistent PRAGMA declared in a schema.
+type schemaPragma struct {
+	name  string // Lower-case name, one of persistentPragmas
+	value string // Value as written
+}
+
+// parsePragma parses a PRAGMA statement of the form "PRAGMA name = value" or
+// "PRAGMA name(value)", returning an error wrapping ErrUnsupportedStatement if
+// it isn't a persistent PRAGMA with a single literal value.
+func parsePragma(stmt statement) (schemaPragma, error) {
+	unsupported := fmt.Errorf("%w: PRAGMA: only persistent settings (%s) may be set in a schema; set others in the connection string (e.g. \"app.db?_foreign_keys=on\") or run them after opening the database",
+		ErrUnsupportedStatement, strings.Join(persistentPragmas, ", "))
+
+	tokens := stmt.tokens
+	if len(tokens) < 4 || tokens[1].kind != tokWord {
+		return schemaPragma{}, unsupported
+	}
+	name := strings.ToLower(tokens[1].text)
+	if !slices.Contains(persistentPragmas, name) {
+		return schemaPragma{}, unsupported
+	}
+
+	rest := tokens[2:]
+	switch {
+	case rest[0].text == "=" && rest[0].kind == tokPunct:
+		rest = rest[1:]
+	case rest[0].text == "(" && rest[0].kind == tokPunct && rest[len(rest)-1].text == ")":
+		rest = rest[1 : len(rest)-1]
+	default:
+		return schemaPragma{}, unsupported
+	}
+	value := ""
+	if len(rest) == 2 && rest[0].kind == tokPunct && (rest[0].text == "-" || rest[0].text == "+") {
+		value = rest[0].text
+		rest = rest[1:]
+	}
+	if len(rest) != 1 || rest[0].kind == tokPunct || rest[0].kind == tokIdent {
+		return schemaPragma{}, fmt.Errorf("%w: PRAGMA %s: the value must be a single literal", ErrUnsupportedStatement, name)
+	}
+	return schemaPragma{name: name, value: value + rest[0].text}, nil
+}
+
+// splitSchemaPragmas returns the persistent PRAGMAs declared in schema, and the
+// schema with those statements blanked out so that the rest can be executed in
+// a transaction. Line numbers and offsets in the schema are unchanged. Other
+// PRAGMAs are left in place for checkSchemaStatements to reject.
+func splitSchemaPragmas(schema string) ([]schemaPragma, string) {
+	var pragmas []schemaPragma
+	body := []byte(schema)
+	for _, stmt := range splitStatements(schema) {
+		if !stmt.tokens[0].is("PRAGMA") {
+			continue
+		}
+		pragma, err := parsePragma(stmt)
+		if err != nil {
+			continue
+		}
+		pragmas = append(pragmas, pragma)
+		for i := stmt.offset; i < stmt.offset+len(stmt.text); i++ {
+			if body[i] != '\n' {
+				body[i] = ' '
+			}
+		}
+	}
+	return pragmas, string(body)
+}
+
+// applyPragmas sets pragmas on conn, in order.
+func applyPragmas(ctx context.Context, conn *sql.Conn, pragmas []schemaPragma) error {
+	for _, pragma := range pragmas {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA %s = %s", pragma.name, pragma.value)); err != nil {
+			return fmt.Errorf("failed to set PRAGMA %s: %w", pragma.name, err)
+		}
+	}
+	return nil
+}
+
+// declaresPragma reports whether pragmas sets the PRAGMA name.
+func declaresPragma(pragmas []schemaPragma, name string) bool {
+	return slices.ContainsFunc(pragmas, func(p schemaPragma) bool { return p.name == name })
+}
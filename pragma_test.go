@@ -0,0 +1,67 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSchemaPragmas(t *testing.T) {
+	schema := "PRAGMA page_size = 8192;\n" + schemaV1 + "\npragma Journal_Mode(wal);\nPRAGMA user_version = -3;"
+	pragmas, body := splitSchemaPragmas(schema)
+	want := []schemaPragma{{"page_size", "8192"}, {"journal_mode", "wal"}, {"user_version", "-3"}}
+	if !slices.Equal(pragmas, want) {
+		t.Errorf("expected pragmas %v, got %v", want, pragmas)
+	}
+	if strings.Contains(strings.ToUpper(body), "PRAGMA") || !strings.Contains(body, schemaV1) || strings.Count(body, "\n") != 3 {
+		t.Errorf("expected the PRAGMAs to be blanked out, got %q", body)
+	}
+
+	for _, bad := range []string{"PRAGMA foreign_keys = ON", "PRAGMA page_size", "PRAGMA user_version = 1 + 1", "PRAGMA main.page_size = 4096"} {
+		if err := checkSchemaStatements(bad); !errors.Is(err, ErrUnsupportedStatement) {
+			t.Errorf("expected %q to be rejected, got %v", bad, err)
+		}
+	}
+}
+
+func TestPersistentPragmas(t *testing.T) {
+	const pragmas = "PRAGMA page_size = 8192;\nPRAGMA journal_mode = WAL;\n"
+	dbPath := tempDBPath(t)
+	db, err := Open(pragmas+"PRAGMA user_version = 7;\n"+schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	checkPragmas(t, db, 8192, "wal", 7)
+	db.Close()
+
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Error("expected PRAGMAs to be left out of the comparison")
+	}
+
+	// The declared settings survive a migration, and a declared user_version
+	// wins over the preserved one
+	db, err = Open(pragmas+"PRAGMA user_version = 8;\n"+schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+	checkPragmas(t, db, 8192, "wal", 8)
+}
+
+// checkPragmas checks the page_size, journal_mode and user_version of db.
+func checkPragmas(t *testing.T, db *sql.DB, pageSize int, journalMode string, userVersion int) {
+	t.Helper()
+	var gotPageSize, gotUserVersion int
+	var gotJournalMode string
+	if err := db.QueryRow("PRAGMA page_size").Scan(&gotPageSize); err != nil || gotPageSize != pageSize {
+		t.Errorf("expected page_size %d, got %d, %v", pageSize, gotPageSize, err)
+	}
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&gotJournalMode); err != nil || gotJournalMode != journalMode {
+		t.Errorf("expected journal_mode %s, got %s, %v", journalMode, gotJournalMode, err)
+	}
+	if err := db.QueryRow("PRAGMA user_version").Scan(&gotUserVersion); err != nil || gotUserVersion != userVersion {
+		t.Errorf("expected user_version %d, got %d, %v", userVersion, gotUserVersion, err)
+	}
+}
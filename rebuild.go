@@ -0,0 +1,514 @@
+package autosqlite
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MigrateTables migrates only the named tables of the database at dbPath to their
+// definitions in schema, together with their indexes and triggers, and leaves every
+// other table as it is. Each table is rebuilt inside the database: the old table is
+// renamed out of the way, the new table is created, the data for common columns is
+// copied into it and the old table is dropped. All tables are rebuilt in one
+// transaction, so either all of them are migrated or none.
+//
+// This lets a large migration be applied in pieces. No backup is made and no
+// schema version is recorded, since the database doesn't match schema until
+// every table has been migrated; a later Open with the full schema finishes the
+// migration as usual. Named tables that don't exist in the database yet are
+// created.
+//
+// Returns a *sql.DB handle or an error.
+func MigrateTables(schema, dbPath string, tables []string) (*sql.DB, error) {
+	return MigrateTablesWithOptions(schema, dbPath, tables, nil)
+}
+
+// MigrateTablesWithOptions is like MigrateTables but takes Options controlling the
+// rebuild, such as ConflictPolicy, EncryptionKey and BusyTimeout. A nil opts is the
+// same as DefaultOptions().
+func MigrateTablesWithOptions(schema, dbPath string, tables []string, opts *Options) (*sql.DB, error) {
+	opts = resolveOptions(opts)
+	filename := extractFilenameFromConnectionString(dbPath)
+	if !isMemoryDatabase(filename) {
+		unlock, err := acquireMigrationLock(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	target, err := openTemporaryDB()
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+	if err := execSchema(target, schema); err != nil {
+		return nil, &MigrationError{Phase: PhaseSchema, Err: err}
+	}
+
+	db, err := openDB(dbPath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if isMemoryDatabase(filename) {
+		// Every connection to :memory: is a separate database
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := rebuildTables(db, target, tables, opts); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// rebuildTables rebuilds each of tables in db to match its definition, indexes and
+// triggers in target, a database that already has the new schema. The rebuild
+// follows the procedure recommended by the SQLite documentation for schema changes
+// ALTER TABLE can't make, and runs in a single transaction.
+func rebuildTables(db, target *sql.DB, tables []string, opts *Options) error {
+	return inRebuildTx(db, func(tx *sql.Tx) error {
+		for _, table := range tables {
+			if err := rebuildTableWithStats(tx, target, table, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rebuildInPlace migrates db to schema within the database file itself, for
+// Options.RebuildInPlace. Only the objects that differ are touched: changed and
+// added tables are rebuilt as by MigrateTables, removed ones dropped, and
+// indexes, triggers and views are dropped and recreated as needed. The new
+// schema version is recorded as by recordMigration. Everything happens in one
+// transaction.
+func rebuildInPlace(db *sql.DB, schema, baseline string, opts *Options) error {
+	plan, err := planRebuild(db, schema, opts)
+	if err != nil {
+		return err
+	}
+	defer plan.target.Close()
+
+	return inRebuildTx(db, func(tx *sql.Tx) error {
+		return plan.apply(tx, schema, baseline, opts)
+	})
+}
+
+// rebuildPlan is what rebuildInPlace changes in a database, as worked out by
+// planRebuild.
+type rebuildPlan struct {
+	target *sql.DB        // Scratch database with the new schema
+	drop   []SchemaObject // Objects to drop first
+	tables []string       // Tables to rebuild, with their indexes and triggers
+	create []SchemaObject // Other objects to create once the tables are in place
+}
+
+// planRebuild works out how to migrate db, a database or a transaction on one,
+// to schema in place. The caller must close plan.target.
+func planRebuild(db queryer, schema string, opts *Options) (plan *rebuildPlan, err error) {
+	if len(opts.TableRenames) > 0 {
+		return nil, errors.New("TableRenames is not supported with RebuildInPlace")
+	}
+	if opts.OnConflict != nil {
+		return nil, errors.New("OnConflict is not supported with RebuildInPlace")
+	}
+	if opts.BestEffort {
+		return nil, errors.New("BestEffort is not supported with RebuildInPlace")
+	}
+	for _, strategy := range opts.ColumnMatching {
+		if strategy == MatchByPosition {
+			return nil, errors.New("MatchByPosition is not supported with RebuildInPlace")
+		}
+	}
+
+	target, err := openTemporaryDB()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			target.Close()
+		}
+	}()
+	if err := execSchema(target, schema); err != nil {
+		return nil, &MigrationError{Phase: PhaseSchema, Err: err}
+	}
+
+	diff, err := diffDatabases(db, target, opts)
+	if err != nil {
+		return nil, &MigrationError{Phase: PhaseSchema, Err: err}
+	}
+
+	// Objects to drop first, and to create once the tables are in place
+	var drop, create []SchemaObject
+	var tables []string
+	for _, obj := range diff.Removed {
+		drop = append(drop, obj)
+	}
+	for _, obj := range diff.Changed {
+		if obj.Type == "table" {
+			tables = append(tables, obj.Name)
+			continue
+		}
+		drop = append(drop, obj)
+		create = append(create, obj)
+	}
+	for _, obj := range diff.Added {
+		if obj.Type == "table" {
+			tables = append(tables, obj.Name)
+			continue
+		}
+		create = append(create, obj)
+	}
+	// Skipped tables are left out of the diff, but ones the database lacks
+	// are still created
+	added, err := missingSkippedTables(db, target, opts)
+	if err != nil {
+		return nil, &MigrationError{Phase: PhaseSchema, Err: err}
+	}
+	tables = append(tables, added...)
+	// Dropping a view drops its triggers, so those must be recreated too
+	for _, obj := range diff.Changed {
+		if obj.Type != "view" {
+			continue
+		}
+		triggers, err := viewTriggers(target, obj.Name)
+		if err != nil {
+			return nil, &MigrationError{Phase: PhaseSchema, Err: err}
+		}
+		for _, trigger := range triggers {
+			if !slices.Contains(create, trigger) {
+				create = append(create, trigger)
+			}
+		}
+	}
+	// Rebuilding a table recreates its indexes and triggers
+	create = slices.DeleteFunc(create, func(obj SchemaObject) bool {
+		return obj.Type != "view" && slices.ContainsFunc(tables, func(table string) bool { return sameName(table, obj.Table) })
+	})
+	// Views first, since triggers may be defined on them
+	rank := func(obj SchemaObject) int {
+		if obj.Type == "view" {
+			return 0
+		}
+		return 1
+	}
+	slices.SortStableFunc(create, func(a, b SchemaObject) int {
+		return cmp.Compare(rank(a), rank(b))
+	})
+
+	return &rebuildPlan{target: target, drop: drop, tables: tables, create: create}, nil
+}
+
+// apply makes the changes of the plan in tx, runs opts.PostMigrate, analyzes the
+// rebuilt tables if opts.RunAnalyze is set and records schema as the new version
+// as recordMigration does. tx must be set up as by
+// inRebuildTx.
+func (p *rebuildPlan) apply(tx *sql.Tx, schema, baseline string, opts *Options) error {
+	for _, obj := range p.drop {
+		// Dropping a table drops its indexes and triggers too
+		if _, err := tx.Exec(fmt.Sprintf("DROP %s IF EXISTS %s", strings.ToUpper(obj.Type), obj.Name)); err != nil {
+			return &MigrationError{Phase: PhaseSchema, Err: fmt.Errorf("failed to drop %s %s: %w", obj.Type, obj.Name, err)}
+		}
+	}
+	for _, table := range p.tables {
+		if err := rebuildTableWithStats(tx, p.target, table, opts); err != nil {
+			return err
+		}
+	}
+	for _, obj := range p.create {
+		var stmt string
+		if err := p.target.QueryRow("SELECT sql FROM sqlite_master WHERE type=? AND name=?", obj.Type, obj.Name).Scan(&stmt); err != nil {
+			return &MigrationError{Phase: PhaseSchema, Err: fmt.Errorf("failed to read definition of %s %s: %w", obj.Type, obj.Name, err)}
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			return &MigrationError{Phase: PhaseSchema, Err: fmt.Errorf("failed to create %s %s: %w", obj.Type, obj.Name, err)}
+		}
+	}
+	if err := runPostMigrate(tx, opts); err != nil {
+		return err
+	}
+	// Dropping a table drops its statistics, so only rebuilt tables need them
+	if opts.RunAnalyze {
+		for _, table := range p.tables {
+			if _, err := tx.Exec("ANALYZE " + table); err != nil {
+				return &MigrationError{Phase: PhaseAnalyze, Table: table, Err: err}
+			}
+		}
+	}
+	return recordMigration(tx, schema, baseline, opts)
+}
+
+// rebuildTableWithStats rebuilds table as rebuildTable does, wrapping any error in
+// a MigrationError and adding the rows copied to opts.Report.
+func rebuildTableWithStats(tx *sql.Tx, target *sql.DB, table string, opts *Options) error {
+	if err := checkRowidChanges(tx, target, table, table, opts); err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Table: table, Err: err}
+	}
+	copied, skipped, err := rebuildTable(tx, target, table, opts)
+	if err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Table: table, Err: err}
+	}
+	if opts.Report != nil {
+		opts.Report.Stats.TablesCopied++
+		opts.Report.Stats.RowsCopied += copied
+		opts.Report.Stats.RowsSkipped += skipped
+	}
+	return nil
+}
+
+// inRebuildTx runs fn in a transaction on a single connection to db, set up so
+// that tables can be dropped and replaced, and commits if fn succeeds.
+func inRebuildTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	ctx := context.Background()
+
+	// PRAGMAs apply per connection, so pin one for the whole rebuild
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	defer conn.Close()
+
+	// Dropping the old table must not cascade to, or be blocked by, other tables
+	// that refer to it; foreign keys are checked once everything is rebuilt
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return fmt.Errorf("failed to read foreign_keys setting: %w", err)
+	}
+	if foreignKeys {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return fmt.Errorf("failed to disable foreign keys: %w", err)
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	// Views and triggers of other tables may refer to a table while it is being
+	// replaced; the legacy behaviour renames the new table without checking them
+	if _, err := conn.ExecContext(ctx, "PRAGMA legacy_alter_table = ON"); err != nil {
+		return fmt.Errorf("failed to enable legacy_alter_table: %w", err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA legacy_alter_table = OFF")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if foreignKeys {
+		if err := checkForeignKeys(tx); err != nil {
+			tx.Rollback()
+			return &MigrationError{Phase: PhaseDataCopy, Err: err}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// rebuildTable replaces table in tx with its definition from target, copying the
+// data of common columns and recreating its indexes and triggers. Conflicts and
+// new columns are handled according to opts.ConflictPolicy and opts.Backfills.
+// It returns the number of rows copied and skipped because of a conflict.
+func rebuildTable(tx *sql.Tx, target *sql.DB, table string, opts *Options) (copied, skipped int64, err error) {
+	var newSQL string
+	err = target.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name=? COLLATE NOCASE", table).Scan(&newSQL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, fmt.Errorf("table %s is not in the new schema", table)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	dependents, err := dependentObjects(target, table)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=? COLLATE NOCASE", table).Scan(&exists); err != nil {
+		return 0, 0, err
+	}
+	if exists == 0 {
+		for _, stmt := range append([]string{newSQL}, dependents...) {
+			if _, err := tx.Exec(stmt); err != nil {
+				return 0, 0, err
+			}
+		}
+		return 0, 0, nil
+	}
+
+	oldColumns, err := columnInfo(tx, table)
+	if err != nil {
+		return 0, 0, err
+	}
+	newColumns, err := GetColumnInfo(target, table)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	backfills := opts.backfillsFor(table)
+	if err := checkAddedNotNullColumns(tx, table, oldColumns, newColumns, backfills); err != nil {
+		return 0, 0, err
+	}
+	backfilled, err := backfillExpressions(tx, table, oldColumns, newColumns, backfills)
+	if err != nil {
+		return 0, 0, err
+	}
+	oldVirtual, err := getVirtualTable(tx, table)
+	if err != nil {
+		return 0, 0, err
+	}
+	newVirtual, _ := parseVirtualTable(newSQL)
+
+	// Dropping the table deletes its AUTOINCREMENT sequence, which may be ahead
+	// of the largest rowid that was copied
+	var seq sql.NullInt64
+	var hasSequences int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='sqlite_sequence'").Scan(&hasSequences); err != nil {
+		return 0, 0, err
+	}
+	if hasSequences > 0 {
+		err := tx.QueryRow("SELECT seq FROM sqlite_sequence WHERE name=? COLLATE NOCASE", table).Scan(&seq)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, err
+		}
+	}
+
+	// Move the old table out of the way rather than renaming the new one into
+	// place, so that the new table's SQL is stored exactly as written in the
+	// schema and compares equal to it. With legacy_alter_table and foreign keys
+	// off, the rename leaves references from other tables, views and triggers alone.
+	oldName := "_autosqlite_old_" + table
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", table, oldName)); err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.Exec(newSQL); err != nil {
+		return 0, 0, err
+	}
+
+	commonColumns := FindCommonColumns(oldColumns, newColumns)
+	if (len(commonColumns) > 0 || oldVirtual.isRtree() && newVirtual.isRtree()) && !oldVirtual.isContentless() {
+		insertColumns := slices.Clone(commonColumns)
+		selectColumns := selectExpressions(commonColumns, commonColumns, newColumns)
+		for _, col := range newColumns {
+			if expr, ok := backfilled[foldName(col.Name)]; ok {
+				insertColumns = append(insertColumns, col.Name)
+				selectColumns = append(selectColumns, expr)
+			}
+		}
+		insertColumns, selectColumns, err := virtualCopyColumns(oldVirtual, &newVirtual, insertColumns, selectColumns)
+		if err != nil {
+			return 0, 0, err
+		}
+		copySQL := fmt.Sprintf("%s INTO %s (%s) SELECT %s FROM %s", opts.ConflictPolicy.insertVerb(),
+			table, strings.Join(insertColumns, ", "), strings.Join(selectColumns, ", "), oldName)
+		res, err := tx.Exec(copySQL)
+		if err != nil {
+			return 0, 0, err
+		}
+		if copied, err = res.RowsAffected(); err != nil {
+			return 0, 0, err
+		}
+
+		// Rows lost to IGNORE or REPLACE show up as a shortfall in the row count
+		if opts.ConflictPolicy != ConflictAbort {
+			var read int64
+			if err := tx.QueryRow("SELECT COUNT(*) FROM " + oldName).Scan(&read); err != nil {
+				return 0, 0, err
+			}
+			if err := tx.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&copied); err != nil {
+				return 0, 0, err
+			}
+			skipped = max(read-copied, 0)
+		}
+	}
+
+	// Also drops the old indexes and triggers, whose names the new ones reuse
+	if _, err := tx.Exec("DROP TABLE " + oldName); err != nil {
+		return 0, 0, err
+	}
+
+	if seq.Valid {
+		if _, err := tx.Exec("UPDATE sqlite_sequence SET seq = MAX(seq, ?) WHERE name = ? COLLATE NOCASE", seq.Int64, table); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	for _, stmt := range dependents {
+		if _, err := tx.Exec(stmt); err != nil {
+			return 0, 0, err
+		}
+	}
+	return copied, skipped, nil
+}
+
+// dependentObjects returns the SQL of the indexes and triggers of table in db, in
+// the order they were created. Indexes SQLite creates for constraints are left
+// out, since creating the table creates them.
+func dependentObjects(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query("SELECT sql FROM sqlite_master WHERE type IN ('index','trigger') AND tbl_name=? COLLATE NOCASE AND sql IS NOT NULL ORDER BY rowid", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statements []string
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			return nil, err
+		}
+		statements = append(statements, stmt)
+	}
+	return statements, rows.Err()
+}
+
+// viewTriggers returns the triggers defined on view in db.
+func viewTriggers(db *sql.DB, view string) ([]SchemaObject, error) {
+	rows, err := db.Query("SELECT type, name, tbl_name FROM sqlite_master WHERE type='trigger' AND tbl_name=? COLLATE NOCASE", view)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list triggers of view %s: %w", view, err)
+	}
+	defer rows.Close()
+
+	var triggers []SchemaObject
+	for rows.Next() {
+		var obj SchemaObject
+		if err := rows.Scan(&obj.Type, &obj.Name, &obj.Table); err != nil {
+			return nil, err
+		}
+		triggers = append(triggers, obj)
+	}
+	return triggers, rows.Err()
+}
+
+// checkForeignKeys returns an error describing the first foreign key violation
+// in tx, if there is one.
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return fmt.Errorf("failed to check foreign keys: %w", err)
+		}
+		return fmt.Errorf("foreign key violation: row %d of table %s refers to a missing row of %s", rowid.Int64, table, parent)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,184 @@
+package autosqlite
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMigrateTables(t *testing.T) {
+	oldSchema := `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);
+CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id), title TEXT);
+CREATE INDEX idx_posts_user ON posts(user_id);
+CREATE VIEW user_posts AS SELECT users.name, posts.title FROM users JOIN posts ON posts.user_id = users.id;`
+
+	newSchema := `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, email TEXT NOT NULL DEFAULT '');
+CREATE INDEX idx_users_email ON users(email);
+CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id), title TEXT, body TEXT);
+CREATE INDEX idx_posts_user ON posts(user_id);
+CREATE VIEW user_posts AS SELECT users.name, posts.title FROM users JOIN posts ON posts.user_id = users.id;
+CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT);`
+
+	dbPath := tempDBPath(t)
+	db, err := Open(oldSchema, dbPath+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	for _, stmt := range []string{
+		"INSERT INTO users (name) VALUES ('alice'), ('bob')",
+		"DELETE FROM users WHERE name = 'bob'",
+		"INSERT INTO posts (user_id, title) VALUES (1, 'hello')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	db, err = MigrateTables(newSchema, dbPath+"?_foreign_keys=on", []string{"users", "tags"})
+	if err != nil {
+		t.Fatalf("MigrateTables failed: %v", err)
+	}
+	defer db.Close()
+
+	// users is migrated, with its data, index and AUTOINCREMENT sequence
+	var name, email string
+	if err := db.QueryRow("SELECT name, email FROM users WHERE id = 1").Scan(&name, &email); err != nil || name != "alice" || email != "" {
+		t.Errorf("expected alice with empty email, got %q %q, %v", name, email, err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND name='idx_users_email'").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected idx_users_email to be created")
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('carol')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	var id int
+	db.QueryRow("SELECT id FROM users WHERE name = 'carol'").Scan(&id)
+	if id != 3 {
+		t.Errorf("expected AUTOINCREMENT to continue at 3, got %d", id)
+	}
+
+	// posts is untouched and the view still works
+	columns, err := GetColumns(db, "posts")
+	if err != nil {
+		t.Fatalf("failed to get columns: %v", err)
+	}
+	if len(columns) != 3 {
+		t.Errorf("expected posts to keep its 3 columns, got %v", columns)
+	}
+	var title string
+	if err := db.QueryRow("SELECT title FROM user_posts WHERE name = 'alice'").Scan(&title); err != nil || title != "hello" {
+		t.Errorf("expected view to return hello, got %q, %v", title, err)
+	}
+
+	// tags didn't exist and is created
+	tables, err := GetTables(db)
+	if err != nil {
+		t.Fatalf("failed to get tables: %v", err)
+	}
+	found := false
+	for _, table := range tables {
+		found = found || table == "tags"
+	}
+	if !found {
+		t.Errorf("expected tags table to be created, got %v", tables)
+	}
+
+	// A table missing from the new schema is an error, and nothing changes
+	if _, err := MigrateTables(newSchema, dbPath, []string{"posts", "missing"}); err == nil {
+		t.Errorf("expected error for table not in schema")
+	}
+	columns, _ = GetColumns(db, "posts")
+	if len(columns) != 3 {
+		t.Errorf("expected failed MigrateTables to leave posts unchanged, got %v", columns)
+	}
+}
+
+func TestMigrateTablesWithOptions(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice'), (NULL)"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.BusyTimeout = 1234 * time.Millisecond
+	opts.ConflictPolicy = ConflictIgnore
+	db, err = MigrateTablesWithOptions(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);`, dbPath, []string{"users"}, opts)
+	if err != nil {
+		t.Fatalf("MigrateTablesWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	var timeout int
+	if err := db.QueryRow("PRAGMA busy_timeout").Scan(&timeout); err != nil || timeout != 1234 {
+		t.Errorf("expected busy_timeout 1234, got %d, %v", timeout, err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 1 {
+		t.Errorf("expected the NULL name to be dropped, leaving 1 row, got %d, %v", count, err)
+	}
+}
+
+func TestRebuildInPlace(t *testing.T) {
+	oldSchema := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER, title TEXT);
+CREATE TABLE old_stuff (id INTEGER PRIMARY KEY);
+CREATE INDEX idx_posts_user ON posts(user_id);
+CREATE VIEW user_names AS SELECT name FROM users;`
+
+	newSchema := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT NOT NULL DEFAULT '');
+CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER, title TEXT);
+CREATE INDEX idx_posts_title ON posts(title);
+CREATE VIEW user_names AS SELECT name, email FROM users;`
+
+	dbPath := tempDBPath(t)
+	db, err := Open(oldSchema, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice'); INSERT INTO posts (user_id, title) VALUES (1, 'hello')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.RebuildInPlace = true
+	var report MigrationReport
+	opts.Report = &report
+	migrated, err := MigrateWithOptions(newSchema, dbPath, opts)
+	if err != nil {
+		t.Fatalf("in-place migration failed: %v", err)
+	}
+	defer migrated.Close()
+
+	// The connection opened before the migration sees the new schema and data
+	var name, email string
+	if err := db.QueryRow("SELECT name, email FROM user_names").Scan(&name, &email); err != nil || name != "alice" || email != "" {
+		t.Errorf("expected old connection to see migrated view, got %q %q, %v", name, email, err)
+	}
+	if report.Stats.TablesCopied != 1 || report.Stats.RowsCopied != 1 {
+		t.Errorf("expected only users to be rebuilt, got %+v", report.Stats)
+	}
+
+	if !SchemasEqual(newSchema, dbPath) {
+		t.Errorf("expected database to have the new schema")
+	}
+	var title string
+	if err := db.QueryRow("SELECT title FROM posts WHERE user_id = 1").Scan(&title); err != nil || title != "hello" {
+		t.Errorf("expected posts to keep its data, got %q, %v", title, err)
+	}
+	version, err := getCurrentSchemaVersion(migrated)
+	if err != nil || version == nil || version.Version != 2 {
+		t.Errorf("expected version 2 to be recorded, got %+v, %v", version, err)
+	}
+	if _, err := os.Stat(dbPath + ".backup"); err != nil {
+		t.Errorf("expected a backup: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package autosqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Recover salvages what can still be read of the damaged database at dbPath
+// into a new database created from schema, and puts it in place of the damaged
+// one, which is kept as dbPath + ".corrupt", with its journal or WAL if it has
+// one. It is a last resort for a database that is too corrupt to migrate, for
+// example after a power loss.
+//
+// The data is copied as by a migration, but a table that can't be read in full
+// keeps the rows that could be: reading skips past each damaged part of the
+// table, resuming at later rowids, and carries on after it. A WITHOUT ROWID
+// table keeps the rows before the first damage. A table that can't be read at
+// all is left empty, as with Options.BestEffort. Options.Report says what was
+// lost: tables that couldn't be copied are listed in TableFailures, and tables
+// that lost rows in Warnings. The version history is kept as far as it can be
+// read, and schema is recorded as a new version.
+//
+// Recover fails if the schema of the damaged database can't be read, or if no
+// table could be copied. It also refuses to overwrite a ".corrupt" file left by
+// an earlier recovery.
+func Recover(schema, dbPath string) (*sql.DB, error) {
+	return RecoverWithOptions(schema, dbPath, nil)
+}
+
+// RecoverWithOptions is like Recover but takes Options controlling how the data
+// is copied: ConflictPolicy, OnConflict, TableRenames, Backfills, Report,
+// SchemaStorage and Clock apply as for a migration. A nil opts is the same as
+// DefaultOptions().
+func RecoverWithOptions(schema, dbPath string, opts *Options) (*sql.DB, error) {
+	opts = resolveOptions(opts)
+	opts.Report.reset()
+	start := time.Now()
+
+	filename := extractFilenameFromConnectionString(dbPath)
+	if isMemoryDatabase(filename) {
+		return nil, errors.New("cannot recover an in-memory database")
+	}
+	corruptPath := filename + ".corrupt"
+	if _, err := os.Stat(corruptPath); err == nil {
+		return nil, fmt.Errorf("%s from an earlier recovery is in the way", corruptPath)
+	}
+
+	unlock, err := acquireMigrationLock(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	newDbPath := filename + ".recover"
+	removeDatabaseFiles(newDbPath)
+	if err := recoverInto(schema, dbPath, newDbPath, opts); err != nil {
+		removeDatabaseFiles(newDbPath)
+		return nil, err
+	}
+
+	// Keep the damaged database, together with the journal or WAL that belongs
+	// to it; the new one mustn't inherit them
+	if err := os.Link(filename, corruptPath); err != nil {
+		if err := copyFile(filename, corruptPath); err != nil {
+			removeDatabaseFiles(newDbPath)
+			return nil, &MigrationError{Phase: PhaseReplace, Err: fmt.Errorf("failed to keep damaged database: %w", err)}
+		}
+	}
+	for _, suffix := range []string{"-journal", "-wal"} {
+		if err := os.Rename(filename+suffix, corruptPath+suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			removeDatabaseFiles(newDbPath)
+			return nil, &MigrationError{Phase: PhaseReplace, Err: fmt.Errorf("failed to keep damaged database's %s: %w", suffix[1:], err)}
+		}
+	}
+	os.Remove(filename + "-shm")
+	if err := replaceFile(newDbPath, filename); err != nil {
+		removeDatabaseFiles(newDbPath)
+		return nil, &MigrationError{Phase: PhaseReplace, Err: err}
+	}
+
+	db, err := openDB(dbPath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recovered database: %w", err)
+	}
+	if opts.Report != nil {
+		opts.Report.Stats.Duration = time.Since(start)
+	}
+	return db, nil
+}
+
+// recoverInto creates a database at newDbPath from schema and salvages into it
+// the data and version history of the damaged database at dbPath, for Recover.
+func recoverInto(schema, dbPath, newDbPath string, opts *Options) error {
+	oldDB, err := openDB(readOnlyDSN(dbPath), opts)
+	if err != nil {
+		return fmt.Errorf("failed to open damaged database: %w", err)
+	}
+	defer oldDB.Close()
+
+	newDB, err := openDB(newDbPath, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create new database: %w", err)
+	}
+	defer newDB.Close()
+	if err := execSchema(newDB, schema); err != nil {
+		return &MigrationError{Phase: PhaseSchema, Err: err}
+	}
+	if err := checkEncrypted(newDbPath, opts); err != nil {
+		return &MigrationError{Phase: PhaseSchema, Err: err}
+	}
+
+	if err := recoverTables(oldDB, newDB, opts); err != nil {
+		return err
+	}
+
+	// A damaged history is no reason to give up the data
+	if exists, err := versionTableExists(oldDB); err == nil && exists {
+		if err := createVersionTable(newDB); err != nil {
+			return &MigrationError{Phase: PhaseVersionRecord, Err: err}
+		}
+		if _, _, damage, err := recoverTable(oldDB, newDB, versionTableName, versionTableName, opts); err != nil || damage != nil {
+			opts.Report.warn(fmt.Sprintf("version history: %v", errors.Join(err, damage)))
+		}
+	}
+	return recordMigration(newDB, schema, "", opts)
+}
+
+// recoverTables salvages the rows of each table of newDB from its table in
+// oldDB, for Recover.
+func recoverTables(oldDB, newDB *sql.DB, opts *Options) error {
+	oldTables, err := GetTables(oldDB)
+	if err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Err: fmt.Errorf("failed to get tables from damaged database: %w", err)}
+	}
+	newTables, err := GetTables(newDB)
+	if err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Err: fmt.Errorf("failed to get tables from new database: %w", err)}
+	}
+	sources, err := tableSources(oldTables, newTables, opts.TableRenames)
+	if err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Err: err}
+	}
+
+	bestEffort := *opts
+	bestEffort.BestEffort = true
+	outcomes := &tableOutcomes{opts: &bestEffort}
+	for _, table := range newTables {
+		oldName, ok := sources[table]
+		if !ok {
+			continue
+		}
+		copied, skipped, damage, err := recoverTable(oldDB, newDB, oldName, table, opts)
+		if err != nil {
+			outcomes.fail(table, err)
+			continue
+		}
+		if damage != nil {
+			opts.Report.warn(fmt.Sprintf("table %s: rows lost to damage: %v", table, damage))
+		}
+		outcomes.succeeded++
+		if opts.Report != nil {
+			opts.Report.Stats.TablesCopied++
+			opts.Report.Stats.RowsCopied += copied
+			opts.Report.Stats.RowsSkipped += skipped
+		}
+	}
+	return outcomes.done()
+}
+
+// recoverTable copies the rows of oldTable that can be read into newTable, as
+// migrateTable does. damage is the first error that kept rows from being read.
+func recoverTable(oldDB, newDB *sql.DB, oldTable, newTable string, opts *Options) (copied, skipped int64, damage, err error) {
+	plan, err := planTableCopy(oldDB, newDB, oldTable, newTable, opts.backfillsFor(newTable), opts.matchStrategyFor(newTable))
+	if err != nil || plan == nil {
+		return 0, 0, nil, err
+	}
+	behaviour, _, err := tableRowidBehaviour(oldDB, oldTable)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	copied, skipped, err = plan.write(opts.migrationContext(), newDB, opts.ConflictPolicy, opts.OnConflict, func(insert func([]any) error) error {
+		var err error
+		damage, err = plan.salvage(oldDB, behaviour.withoutRowid, insert)
+		return err
+	})
+	return copied, skipped, damage, err
+}
+
+// salvage reads the rows of the old table as read does, but carries on past
+// damaged parts of the table. After an error, reading resumes just after the
+// last rowid read, skipping twice as far ahead each time it fails again, so
+// that a damaged page is soon passed. Rows of a WITHOUT ROWID table are read up
+// to the first error. damage is the first error that kept rows from being
+// read; err is an error from fn, which stops the reading.
+func (c *tableCopy) salvage(oldDB *sql.DB, withoutRowid bool, fn func(values []any) error) (damage, err error) {
+	var fnErr error
+	insert := func(values []any) error {
+		fnErr = fn(values)
+		return fnErr
+	}
+	if withoutRowid {
+		damage = c.read(context.Background(), oldDB, insert)
+		if fnErr != nil {
+			return nil, fnErr
+		}
+		return damage, nil
+	}
+
+	query := fmt.Sprintf("SELECT rowid, %s FROM %s WHERE rowid >= ? ORDER BY rowid", strings.Join(c.selectColumns, ", "), c.oldTable)
+	next, gap := int64(math.MinInt64), int64(1)
+	for {
+		last, n, err := c.readFrom(oldDB, query, next, insert)
+		if fnErr != nil {
+			return nil, fnErr
+		}
+		if err == nil {
+			return damage, nil
+		}
+		if damage == nil {
+			damage = err
+		}
+		if n > 0 {
+			next, gap = last, 1
+		}
+		if next > math.MaxInt64-gap {
+			return damage, nil
+		}
+		next += gap
+		if gap < 1<<62 {
+			gap *= 2
+		}
+	}
+}
+
+// readFrom calls fn with the values of each row that query, selecting the rowid
+// and then the values to insert, gives for rowids from on. It returns the last
+// rowid read and the number of rows read before any error.
+func (c *tableCopy) readFrom(oldDB *sql.DB, query string, from int64, fn func(values []any) error) (last int64, n int, err error) {
+	rows, err := oldDB.Query(query, from)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rowid int64
+		values := make([]interface{}, len(c.insertColumns))
+		valuePtrs := []interface{}{&rowid}
+		for i := range values {
+			valuePtrs = append(valuePtrs, &values[i])
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return last, n, err
+		}
+		if err := fn(values); err != nil {
+			return last, n, err
+		}
+		last = rowid
+		n++
+	}
+	return last, n, rows.Err()
+}
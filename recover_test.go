@@ -0,0 +1,79 @@
+package autosqlite
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	const schema = `CREATE TABLE small (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE big (id INTEGER PRIMARY KEY, payload TEXT);`
+	const newSchema = `CREATE TABLE small (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE big (id INTEGER PRIMARY KEY, payload TEXT, flag INTEGER);`
+
+	dbPath := tempDBPath(t)
+	db, err := Open(schema, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO small (name) VALUES ('a'), ('b');
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 3000)
+		INSERT INTO big (payload) SELECT printf('%0300d', i) FROM n`); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	var pageSize, pages int
+	db.QueryRow("PRAGMA page_size").Scan(&pageSize)
+	db.QueryRow("PRAGMA page_count").Scan(&pages)
+	db.Close()
+
+	// Overwrite a page in the middle of big
+	f, err := os.OpenFile(dbPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open database file: %v", err)
+	}
+	if _, err := f.WriteAt(bytes.Repeat([]byte{0xff}, pageSize), int64(pages/2*pageSize)); err != nil {
+		t.Fatalf("failed to damage database: %v", err)
+	}
+	f.Close()
+
+	var report MigrationReport
+	opts := DefaultOptions()
+	opts.Report = &report
+	db, err = RecoverWithOptions(newSchema, dbPath, opts)
+	if err != nil {
+		t.Fatalf("recovery failed: %v", err)
+	}
+	defer db.Close()
+
+	var small, big, last int
+	if err := db.QueryRow("SELECT COUNT(*) FROM small").Scan(&small); err != nil || small != 2 {
+		t.Errorf("expected both rows of small, got %d, %v", small, err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*), MAX(id) FROM big").Scan(&big, &last); err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	if big >= 3000 || big < 2900 || last != 3000 {
+		t.Errorf("expected all but the damaged page of big, up to the last row, got %d rows up to %d", big, last)
+	}
+	if len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0], "table big") {
+		t.Errorf("expected a warning about big, got %v", report.Warnings)
+	}
+	if int64(big+small) != report.Stats.RowsCopied {
+		t.Errorf("expected %d rows copied, got %d", big+small, report.Stats.RowsCopied)
+	}
+	if _, err := os.Stat(dbPath + ".corrupt"); err != nil {
+		t.Errorf("expected the damaged database to be kept: %v", err)
+	}
+	if !SchemasEqual(newSchema, dbPath) {
+		t.Error("expected the recovered database to have the new schema")
+	}
+	if versions, err := AppliedSchemas(db); err != nil || len(versions) != 2 {
+		t.Errorf("expected the version history to be kept, got %v, %v", versions, err)
+	}
+
+	if _, err := Recover(newSchema, dbPath); err == nil || !strings.Contains(err.Error(), ".corrupt") {
+		t.Errorf("expected the earlier recovery's file to be in the way, got %v", err)
+	}
+}
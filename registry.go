@@ -0,0 +1,136 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+)
+
+// ErrUnregisteredSchema is returned by SchemaRegistry.Open when the database's
+// schema, or the schema asked for, isn't in the registry.
+var ErrUnregisteredSchema = errors.New("schema is not registered")
+
+// ErrSchemaOutOfSequence is returned by SchemaRegistry.Open when the database
+// is already at a later registered version than the one asked for.
+var ErrSchemaOutOfSequence = errors.New("schema version is out of sequence")
+
+// SchemaRegistry is the sequence of schemas a database may have, for example
+// one per release, so that only a registered schema is ever applied and only
+// ever to a database at an earlier registered version. Versions are the
+// application's own numbers, and need not match those of the version history.
+type SchemaRegistry struct {
+	schemas map[int]string
+}
+
+// NewSchemaRegistry returns an empty SchemaRegistry.
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{schemas: make(map[int]string)}
+}
+
+// Register adds schema to r as version, which must be positive. It fails if
+// the version is already registered, if another version has the same schema,
+// or if the schema is not valid.
+func (r *SchemaRegistry) Register(version int, schema string) error {
+	if version < 1 {
+		return fmt.Errorf("schema version %d must be positive", version)
+	}
+	if _, ok := r.schemas[version]; ok {
+		return fmt.Errorf("schema version %d is already registered", version)
+	}
+	hash := calculateSchemaHash(schema)
+	for v, other := range r.schemas {
+		if calculateSchemaHash(other) == hash {
+			return fmt.Errorf("schema version %d is the same as version %d", version, v)
+		}
+	}
+	if _, err := ValidateSchema(schema); err != nil {
+		return fmt.Errorf("schema version %d: %w", version, err)
+	}
+	r.schemas[version] = schema
+	return nil
+}
+
+// Versions returns the registered versions in ascending order.
+func (r *SchemaRegistry) Versions() []int {
+	versions := make([]int, 0, len(r.schemas))
+	for v := range r.schemas {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// Open opens the database at dbPath with the schema registered as version, as
+// OpenWithOptions does, provided the database is new or has a registered
+// schema of that version or an earlier one. Otherwise it fails with
+// ErrUnregisteredSchema or ErrSchemaOutOfSequence, without changing the
+// database. A nil opts is the same as DefaultOptions().
+//
+// The database's version is found from the hash of the schema last recorded
+// in its version history. For a database without version history, or whose
+// recorded schema isn't registered, its schema is compared with each
+// registered one instead, and the latest that matches is its version. If
+// another migration records a version between the check and the migration,
+// the migration fails with ErrVersionMismatch.
+func (r *SchemaRegistry) Open(version int, dbPath string, opts *Options) (*sql.DB, error) {
+	opts = resolveOptions(opts)
+	schema, ok := r.schemas[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: version %d", ErrUnregisteredSchema, version)
+	}
+
+	filename := extractFilenameFromConnectionString(dbPath)
+	if _, err := os.Stat(filename); isMemoryDatabase(filename) || errors.Is(err, os.ErrNotExist) {
+		return OpenWithOptions(schema, dbPath, opts)
+	}
+
+	current, recorded, err := r.databaseVersion(dbPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	if current > version {
+		return nil, fmt.Errorf("%w: database is at version %d, later than version %d", ErrSchemaOutOfSequence, current, version)
+	}
+
+	// Only migrate the database as it was checked
+	checked := *opts
+	checked.ExpectedFromVersion = recorded
+	return OpenWithOptions(schema, dbPath, &checked)
+}
+
+// databaseVersion returns the registered version of the database at dbPath,
+// and the version its version history is at, or 0 if it has none.
+func (r *SchemaRegistry) databaseVersion(dbPath string, opts *Options) (version, recorded int, err error) {
+	db, err := openDB(readOnlyDSN(dbPath), opts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	current, err := getCurrentSchemaVersion(db)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get current schema version: %w", err)
+	}
+	if current != nil {
+		recorded = current.Version
+		for v, schema := range r.schemas {
+			if calculateSchemaHash(schema) == current.Hash {
+				return v, recorded, nil
+			}
+		}
+	}
+
+	for _, v := range slices.Backward(r.Versions()) {
+		equal, err := schemaMatches(db, r.schemas[v], opts)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to compare with schema version %d: %w", v, err)
+		}
+		if equal {
+			return v, recorded, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("%w: the database's schema is none of the registered versions", ErrUnregisteredSchema)
+}
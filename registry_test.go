@@ -0,0 +1,111 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+)
+
+const schemaV3 = `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT, age INTEGER);`
+
+func testRegistry(t *testing.T) *SchemaRegistry {
+	t.Helper()
+	r := NewSchemaRegistry()
+	for v, schema := range []string{schemaV1, schemaV2, schemaV3} {
+		if err := r.Register(v+1, schema); err != nil {
+			t.Fatalf("failed to register version %d: %v", v+1, err)
+		}
+	}
+	return r
+}
+
+func TestSchemaRegistryRegister(t *testing.T) {
+	r := testRegistry(t)
+	if got := r.Versions(); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("expected versions [1 2 3], got %v", got)
+	}
+	if err := r.Register(2, schemaV1WithPosts); err == nil {
+		t.Error("expected registering a version twice to fail")
+	}
+	if err := r.Register(4, "-- Same as version 1\n"+schemaV1); err == nil {
+		t.Error("expected registering the same schema twice to fail")
+	}
+	if err := r.Register(0, schemaV1WithPosts); err == nil {
+		t.Error("expected registering version 0 to fail")
+	}
+	if err := r.Register(5, "CREATE TABLE ("); err == nil {
+		t.Error("expected registering an invalid schema to fail")
+	}
+}
+
+func TestSchemaRegistryOpen(t *testing.T) {
+	r := testRegistry(t)
+	dbPath := tempDBPath(t)
+	open := func(version int) error {
+		t.Helper()
+		db, err := r.Open(version, dbPath, nil)
+		if err == nil {
+			db.Close()
+		}
+		return err
+	}
+
+	if err := open(1); err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if err := open(3); err != nil {
+		t.Fatalf("failed to skip to version 3: %v", err)
+	}
+	if !SchemasEqual(schemaV3, dbPath) {
+		t.Error("expected the database to have version 3")
+	}
+	if err := open(3); err != nil {
+		t.Errorf("failed to open at the same version: %v", err)
+	}
+	if err := open(2); !errors.Is(err, ErrSchemaOutOfSequence) {
+		t.Errorf("expected ErrSchemaOutOfSequence going back to version 2, got %v", err)
+	}
+	if err := open(4); !errors.Is(err, ErrUnregisteredSchema) {
+		t.Errorf("expected ErrUnregisteredSchema for version 4, got %v", err)
+	}
+	if !SchemasEqual(schemaV3, dbPath) {
+		t.Error("expected the database to be left at version 3")
+	}
+
+	// A schema applied without the registry is rejected
+	db, err := Open(schemaV1WithPosts, dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	db.Close()
+	if err := open(3); !errors.Is(err, ErrUnregisteredSchema) {
+		t.Errorf("expected ErrUnregisteredSchema for an unknown schema, got %v", err)
+	}
+}
+
+func TestSchemaRegistryLegacyDatabase(t *testing.T) {
+	r := testRegistry(t)
+	dbPath := tempDBPath(t)
+	legacy, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if _, err := legacy.Exec(schemaV2); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	legacy.Close()
+
+	// Without version history the schema itself tells the version
+	if _, err := r.Open(1, dbPath, nil); !errors.Is(err, ErrSchemaOutOfSequence) {
+		t.Errorf("expected ErrSchemaOutOfSequence, got %v", err)
+	}
+	db, err := r.Open(3, dbPath, nil)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	db.Close()
+	if !SchemasEqual(schemaV3, dbPath) {
+		t.Error("expected the database to have version 3")
+	}
+}
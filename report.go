@@ -0,0 +1,92 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Stats holds measurements of a migration, for example for a metrics endpoint.
+type Stats struct {
+	Duration     time.Duration // Wall-clock time of the whole migration, including the backup
+	TablesCopied int           // Number of tables whose data was copied
+	RowsCopied   int64         // Total number of rows copied
+	RowsSkipped  int64         // Rows dropped because of a conflict, see Options.ConflictPolicy
+	BackupBytes  int64         // Size of the backup file
+}
+
+// MigrationReport describes what a migration did. To get one, point
+// Options.Report at a MigrationReport; it is reset at the start of each call
+// and filled in as the migration proceeds, so after a failure it describes
+// the work done up to that point.
+type MigrationReport struct {
+	Stats Stats
+
+	// Warnings describes changes the migration made that may surprise
+	// the application, such as a table whose ids no longer alias the rowid
+	// (see Options.StrictRowid).
+	Warnings []string
+
+	// PostMigrateFailures lists the Options.PostMigrate steps that failed
+	// but were allowed to, in the order they ran.
+	PostMigrateFailures []PostMigrateFailure
+
+	// TableFailures lists the tables whose data couldn't be copied, in the
+	// order they were copied, when Options.BestEffort is set.
+	TableFailures []TableFailure
+
+	// ForeignKeyViolations lists the FOREIGN KEY constraints of the new
+	// schema that the copied data breaks, when the database connection
+	// doesn't enforce foreign keys; otherwise they fail the migration with
+	// ErrForeignKeyViolation.
+	ForeignKeyViolations []ConstraintViolation
+
+	// FromVersion and ToVersion are the schema versions of the database
+	// before and after the call, 0 meaning no version history: 0 and 1 for a
+	// newly created database, and the current version twice for one opened
+	// unchanged. They are set by OpenWithResultOptions, and by the functions
+	// built on it, MigrateWithOptions and SafeMigrate.
+	FromVersion int
+	ToVersion   int
+
+	// TableTimings lists how long copying each table's data took, in the
+	// order the tables were copied. See also Options.OnTableCopied.
+	TableTimings []TableTiming
+}
+
+// VersionChanged reports whether the call recorded a new schema version, for
+// example to invalidate caches only when the schema really changed.
+func (r *MigrationReport) VersionChanged() bool {
+	return r.FromVersion != r.ToVersion
+}
+
+// warn adds warning to r.Warnings, if r is not nil.
+func (r *MigrationReport) warn(warning string) {
+	if r != nil {
+		r.Warnings = append(r.Warnings, warning)
+	}
+}
+
+// setVersions sets r.FromVersion to from, or to the current version of db if
+// from is -1, and r.ToVersion to the current version of db, if r is not nil.
+func (r *MigrationReport) setVersions(db *sql.DB, from int) error {
+	if r == nil {
+		return nil
+	}
+	to, err := currentVersionNumber(db)
+	if err != nil {
+		return fmt.Errorf("failed to get current schema version: %w", err)
+	}
+	if from == -1 {
+		from = to
+	}
+	r.FromVersion, r.ToVersion = from, to
+	return nil
+}
+
+// reset clears r, if it is not nil.
+func (r *MigrationReport) reset() {
+	if r != nil {
+		*r = MigrationReport{}
+	}
+}
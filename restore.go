@@ -0,0 +1,109 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Restore puts the ".backup" file made by the last migration of the database
+// at dbPath back in its place, undoing the migration and anything written to
+// the database since. If the backup has a WAL, for example because it was
+// opened in WAL mode to look at it, the WAL is checkpointed into the backup
+// first, so the restored database holds every transaction committed to the
+// backup. The backup's content is left as it is.
+//
+// The backup is copied next to the database, checked with SQLite's integrity
+// check and then renamed over the database, whose journal, WAL and
+// shared-memory files are removed so that none of them is taken for part of
+// the restored file. Other connections to the database must be closed first.
+// If there is no backup the error wraps ErrDatabaseMissing.
+func Restore(dbPath string) (*sql.DB, error) {
+	return RestoreWithOptions(dbPath, nil)
+}
+
+// RestoreWithOptions is like Restore but takes Options for opening the backup
+// and the restored database, such as EncryptionKey and FullIntegrityCheck. A
+// nil opts is the same as DefaultOptions().
+func RestoreWithOptions(dbPath string, opts *Options) (*sql.DB, error) {
+	opts = resolveOptions(opts)
+	if opts.ReadOnly {
+		return nil, fmt.Errorf("%w: cannot restore", ErrReadOnly)
+	}
+
+	filename := extractFilenameFromConnectionString(dbPath)
+	if isMemoryDatabase(filename) {
+		return nil, errors.New("cannot restore an in-memory database")
+	}
+	backupPath := filename + ".backup"
+	if _, err := os.Stat(backupPath); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrDatabaseMissing, backupPath)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to stat backup: %w", err)
+	}
+
+	unlock, err := acquireMigrationLock(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := restoreBackup(filename, opts); err != nil {
+		return nil, err
+	}
+
+	db, err := openDB(dbPath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open restored database: %w", err)
+	}
+	return db, nil
+}
+
+// restoreBackup does the work of RestoreWithOptions for the database file
+// filename, whose migration lock the caller holds.
+func restoreBackup(filename string, opts *Options) error {
+	restorePath := filename + ".restore"
+	removeDatabaseFiles(restorePath)
+	if err := copyDatabase(filename+".backup", restorePath, opts); err != nil {
+		removeDatabaseFiles(restorePath)
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	if err := prepareRestore(restorePath, opts); err != nil {
+		removeDatabaseFiles(restorePath)
+		return err
+	}
+
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(filename + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			removeDatabaseFiles(restorePath)
+			return fmt.Errorf("failed to remove database's %s: %w", suffix[1:], err)
+		}
+	}
+	if err := replaceFile(restorePath, filename); err != nil {
+		removeDatabaseFiles(restorePath)
+		return fmt.Errorf("failed to replace database: %w", err)
+	}
+	return nil
+}
+
+// prepareRestore checks the copy of a backup at path, and folds any WAL that
+// checking it left into the database file, which is the only file that gets
+// renamed into place.
+func prepareRestore(path string, opts *Options) error {
+	db, err := openDB(path, opts)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer db.Close()
+	if err := checkIntegrity(db, !opts.FullIntegrityCheck); err != nil {
+		return fmt.Errorf("backup is damaged: %w", err)
+	}
+	complete, err := checkpointWAL(db)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	if !complete {
+		return errors.New("failed to checkpoint WAL of backup: it is still in use")
+	}
+	return nil
+}
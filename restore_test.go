@@ -0,0 +1,125 @@
+package autosqlite
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRestore(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	db, err = Migrate(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name, email) VALUES ('bob', 'bob@example.com')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	db, err = Restore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	defer db.Close()
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Error("expected the database to have its schema from before the migration")
+	}
+	var names []string
+	rows, err := db.Query("SELECT name FROM users ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		names = append(names, name)
+	}
+	rows.Close()
+	if len(names) != 1 || names[0] != "alice" {
+		t.Errorf("expected only the rows from before the migration, got %v", names)
+	}
+	if _, err := os.Stat(dbPath + ".backup"); err != nil {
+		t.Errorf("expected the backup to be kept: %v", err)
+	}
+}
+
+func TestRestoreWALBackup(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+	db, err = Migrate(schemaV2, dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	db.Close()
+
+	// Write to the backup in WAL mode, keeping the connection open so that the
+	// rows are only in the backup's WAL
+	backup, err := openDB(dbPath+".backup?_journal_mode=WAL", DefaultOptions())
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer backup.Close()
+	if _, err := backup.Exec("INSERT INTO users (name) VALUES ('carol'), ('dave')"); err != nil {
+		t.Fatalf("failed to write to backup: %v", err)
+	}
+	if !hasWAL(dbPath + ".backup") {
+		t.Fatal("expected the backup to have a WAL")
+	}
+
+	// The restored database must not pick up the migrated database's WAL either
+	migrated, err := openDB(dbPath+"?_journal_mode=WAL", DefaultOptions())
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if _, err := migrated.Exec("INSERT INTO users (name) VALUES ('eve')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	migrated.Close()
+
+	db, err = Restore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 2 {
+		t.Errorf("expected the 2 rows in the backup's WAL, got %d, %v", count, err)
+	}
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Error("expected the database to have its schema from before the migration")
+	}
+	if _, err := os.Stat(dbPath + ".restore"); !os.IsNotExist(err) {
+		t.Errorf("expected no leftover copy, got %v", err)
+	}
+	// The backup's WAL is checkpointed into it rather than copied alongside
+	if hasWAL(dbPath + ".backup") {
+		t.Error("expected the backup's WAL to have been checkpointed")
+	}
+}
+
+func TestRestoreWithoutBackup(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	if _, err := Restore(dbPath); !errors.Is(err, ErrDatabaseMissing) {
+		t.Errorf("expected ErrDatabaseMissing, got %v", err)
+	}
+}
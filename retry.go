@@ -0,0 +1,70 @@
+package autosqlite
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// RetryPolicy says how to retry an operation that fails transiently, such as
+// the initial ping of a new database or a write that fails with SQLITE_BUSY
+// because another connection holds a lock. It is set for all of them at once
+// with Options.Retry.
+type RetryPolicy struct {
+	// MaxAttempts is how many times to try the operation in all, including
+	// the first; 0 or 1 means it isn't retried.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry; it doubles for each
+	// further retry.
+	Backoff time.Duration
+
+	// Jitter makes each delay a random length between half and all of it,
+	// so that processes that failed together don't all retry together.
+	Jitter bool
+}
+
+// DefaultRetryPolicy returns the policy DefaultOptions sets Options.Retry to:
+// 3 attempts, 50ms apart and then 100ms apart, without jitter.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, Backoff: 50 * time.Millisecond}
+}
+
+// delay returns how long to wait before retry number attempt, counting from 0.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff << attempt
+	if p.Jitter && d > 0 {
+		d = d/2 + rand.N(d/2+1)
+	}
+	return d
+}
+
+// retry calls fn until it succeeds, fails with an error that retryable rejects,
+// or has been called policy.MaxAttempts times, sleeping between calls as policy
+// says. The last error is returned.
+func retry(policy RetryPolicy, retryable func(error) bool, fn func() error) error {
+	err := fn()
+	for attempt := 0; attempt+1 < policy.MaxAttempts && err != nil && retryable(err); attempt++ {
+		time.Sleep(policy.delay(attempt))
+		err = fn()
+	}
+	return err
+}
+
+// anyError is a retryable func that retries every error.
+func anyError(error) bool {
+	return true
+}
+
+// isBusy reports whether err is SQLite's SQLITE_BUSY or SQLITE_LOCKED, which
+// mean another connection holds a conflicting lock and the operation may
+// succeed if tried again.
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	transient := errors.New("transient")
+	permanent := errors.New("permanent")
+
+	calls := 0
+	err := retry(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}, anyError, func() error {
+		calls++
+		if calls < 3 {
+			return transient
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expected success on third call, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = retry(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}, anyError, func() error {
+		calls++
+		return transient
+	})
+	if err != transient || calls != 3 {
+		t.Fatalf("expected to give up after 3 calls, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = retry(RetryPolicy{MaxAttempts: 6, Backoff: time.Millisecond}, func(err error) bool { return err == transient }, func() error {
+		calls++
+		return permanent
+	})
+	if err != permanent || calls != 1 {
+		t.Fatalf("non-retryable error should not be retried, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	calls := 0
+	err := retry(RetryPolicy{}, anyError, func() error {
+		calls++
+		return errors.New("transient")
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("expected a zero policy to try once, got %v after %d calls", err, calls)
+	}
+
+	policy := RetryPolicy{MaxAttempts: 4, Backoff: 10 * time.Millisecond}
+	for attempt, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond} {
+		if got := policy.delay(attempt); got != want {
+			t.Errorf("retry %d: expected delay %v, got %v", attempt, want, got)
+		}
+	}
+	policy.Jitter = true
+	for i := 0; i < 100; i++ {
+		if got := policy.delay(2); got < 20*time.Millisecond || got > 40*time.Millisecond {
+			t.Fatalf("expected a jittered delay between 20ms and 40ms, got %v", got)
+		}
+	}
+
+	opts := DefaultOptions()
+	if got := opts.retryPolicy(); got != DefaultRetryPolicy() {
+		t.Errorf("expected the default options to use the default policy, got %+v", got)
+	}
+	opts.Retry = nil
+	if got := opts.retryPolicy(); got.MaxAttempts != 1 {
+		t.Errorf("expected a nil Retry to mean a single attempt, got %+v", got)
+	}
+	opts.Retry = &policy
+	if got := opts.retryPolicy(); got != policy {
+		t.Errorf("expected Retry to be used, got %+v", got)
+	}
+}
+
+func TestIsBusy(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db1, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db1.Close()
+	if _, err := db1.Exec(schemaV1); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	// Hold an exclusive lock from one connection
+	tx, err := db1.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO users (name) VALUES ('locked')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	// No busy timeout, so the write fails straight away
+	db2, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=0")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db2.Close()
+	_, err = db2.Exec("INSERT INTO users (name) VALUES ('blocked')")
+	if err == nil {
+		t.Fatalf("expected write to fail while locked")
+	}
+	if !isBusy(err) {
+		t.Fatalf("expected busy error, got %v", err)
+	}
+	if isBusy(errors.New("other")) {
+		t.Fatalf("unrelated error reported as busy")
+	}
+}
@@ -0,0 +1,117 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// RowCountDelta compares the number of rows of a table before and after a
+// migration, as reported by VerifyRowCounts.
+type RowCountDelta struct {
+	Old int64 // Rows in the old database, or 0 if the table is new
+	New int64 // Rows in the new database, or 0 if the table was dropped
+
+	// Dropped is set for a table the new database doesn't have, whose rows
+	// went with it on purpose; Added for a table only the new database has.
+	Dropped bool
+	Added   bool
+}
+
+// Lost reports whether the table has fewer rows than before, even though it
+// wasn't dropped: rows went missing, for example because of a constraint of
+// the new schema or Options.ConflictPolicy.
+func (d RowCountDelta) Lost() bool {
+	return !d.Dropped && d.New < d.Old
+}
+
+func (d RowCountDelta) String() string {
+	switch {
+	case d.Dropped:
+		return fmt.Sprintf("%d rows, dropped", d.Old)
+	case d.Added:
+		return fmt.Sprintf("%d rows, added", d.New)
+	case d.Lost():
+		return fmt.Sprintf("%d -> %d rows, %d lost", d.Old, d.New, d.Old-d.New)
+	}
+	return fmt.Sprintf("%d -> %d rows", d.Old, d.New)
+}
+
+// VerifyRowCounts compares the number of rows of each table of the database at
+// oldDbPath, such as the backup Migrate makes, with the same table in the
+// database at newDbPath, such as the migrated database, to check after a
+// migration that no table lost rows it shouldn't have. The result is keyed by
+// table name, in the new database where the table has one; use Lost to find
+// the tables that need a look. Both databases are opened read-only; if one
+// doesn't exist the error wraps ErrDatabaseMissing.
+func VerifyRowCounts(oldDbPath, newDbPath string) (map[string]RowCountDelta, error) {
+	return VerifyRowCountsWithOptions(oldDbPath, newDbPath, nil)
+}
+
+// VerifyRowCountsWithOptions is like VerifyRowCounts but takes the Options the
+// migration used: a table in TableRenames is compared with the table it was
+// renamed to. A nil opts is the same as DefaultOptions().
+func VerifyRowCountsWithOptions(oldDbPath, newDbPath string, opts *Options) (map[string]RowCountDelta, error) {
+	opts = resolveOptions(opts)
+
+	oldDB, err := openForComparison(oldDbPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer oldDB.Close()
+	newDB, err := openForComparison(newDbPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer newDB.Close()
+
+	oldTables, err := listTables(oldDB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables from old database: %w", err)
+	}
+	newTables, err := listTables(newDB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables from new database: %w", err)
+	}
+	sources, err := tableSources(oldTables, newTables, opts.TableRenames)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas := make(map[string]RowCountDelta)
+	compared := make(map[string]bool)
+	for _, table := range newTables {
+		var delta RowCountDelta
+		if delta.New, err = countRows(newDB, table); err != nil {
+			return nil, err
+		}
+		if oldName, ok := sources[table]; ok {
+			if delta.Old, err = countRows(oldDB, oldName); err != nil {
+				return nil, err
+			}
+			compared[foldName(oldName)] = true
+		} else {
+			delta.Added = true
+		}
+		deltas[table] = delta
+	}
+	for _, table := range oldTables {
+		if compared[foldName(table)] {
+			continue
+		}
+		delta := RowCountDelta{Dropped: true}
+		if delta.Old, err = countRows(oldDB, table); err != nil {
+			return nil, err
+		}
+		deltas[table] = delta
+	}
+	return deltas, nil
+}
+
+// countRows returns the number of rows in table.
+func countRows(db *sql.DB, table string) (int64, error) {
+	var n int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count rows of table %s: %w", table, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,90 @@
+package autosqlite
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyRowCounts(t *testing.T) {
+	oldPath := tempDBPath(t)
+	db, err := Open(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		CREATE TABLE logs (id INTEGER PRIMARY KEY, message TEXT);
+		CREATE TABLE sessions (id INTEGER PRIMARY KEY);
+	`, oldPath)
+	if err != nil {
+		t.Fatalf("failed to create old db: %v", err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO users (email) VALUES ('a@example.com'), ('a@example.com'), ('b@example.com');
+		INSERT INTO logs (message) VALUES ('one'), ('two');
+		INSERT INTO sessions DEFAULT VALUES;
+	`); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	newPath := tempDBPath(t)
+	opts := DefaultOptions()
+	opts.ConflictPolicy = ConflictIgnore
+	opts.TableRenames = []TableRename{{From: "logs", To: "events"}}
+	opts.AllowBackward = true
+	db, err = MigrateToNewFileWithOptions(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE);
+		CREATE TABLE events (id INTEGER PRIMARY KEY, message TEXT);
+		CREATE TABLE settings (key TEXT PRIMARY KEY);
+	`, oldPath, newPath, opts)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	db.Close()
+
+	deltas, err := VerifyRowCountsWithOptions(oldPath, newPath, opts)
+	if err != nil {
+		t.Fatalf("VerifyRowCounts failed: %v", err)
+	}
+	expected := map[string]RowCountDelta{
+		"users":    {Old: 3, New: 2},
+		"events":   {Old: 2, New: 2},
+		"settings": {Added: true},
+		"sessions": {Old: 1, Dropped: true},
+	}
+	if len(deltas) != len(expected) {
+		t.Errorf("expected %d tables, got %v", len(expected), deltas)
+	}
+	for table, want := range expected {
+		if got := deltas[table]; got != want {
+			t.Errorf("table %s: expected %+v, got %+v", table, want, got)
+		}
+	}
+	for table, delta := range deltas {
+		if delta.Lost() != (table == "users") {
+			t.Errorf("table %s: Lost() = %v", table, delta.Lost())
+		}
+	}
+	if s := deltas["users"].String(); s != "3 -> 2 rows, 1 lost" {
+		t.Errorf("unexpected description %q", s)
+	}
+
+	// Without the rename, logs looks dropped and events added
+	deltas, err = VerifyRowCounts(oldPath, newPath)
+	if err != nil {
+		t.Fatalf("VerifyRowCounts failed: %v", err)
+	}
+	if !deltas["logs"].Dropped || !deltas["events"].Added {
+		t.Errorf("expected logs dropped and events added, got %v", deltas)
+	}
+}
+
+func TestVerifyRowCountsMissingDatabase(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	if _, err := VerifyRowCounts(dbPath, tempDBPath(t)); !errors.Is(err, ErrDatabaseMissing) {
+		t.Errorf("expected ErrDatabaseMissing, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package autosqlite
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrRowidChanged is returned when Options.StrictRowid is set and a migration
+// would change how a table's rows are identified; see Options.StrictRowid.
+var ErrRowidChanged = errors.New("table's rowid behaviour changes")
+
+// rowidBehaviour describes how the rows of a table are identified.
+type rowidBehaviour struct {
+	alias         string // Column that is an alias for the rowid, or ""
+	autoincrement bool   // Rowids are never reused
+	withoutRowid  bool   // WITHOUT ROWID table, with no rowid at all
+}
+
+// tableRowidBehaviour works out the rowidBehaviour of table in db. ok is false if
+// there is no such table.
+func tableRowidBehaviour(db queryer, table string) (b rowidBehaviour, ok bool, err error) {
+	rows, err := db.Query("SELECT sql FROM sqlite_master WHERE type='table' AND name=? COLLATE NOCASE", table)
+	if err != nil {
+		return rowidBehaviour{}, false, err
+	}
+	var createSQL string
+	if ok = rows.Next(); ok {
+		err = rows.Scan(&createSQL)
+	}
+	rows.Close()
+	if err != nil || !ok {
+		return rowidBehaviour{}, false, err
+	}
+
+	tokens := tokenize(createSQL)
+	for i, tok := range tokens {
+		switch {
+		case tok.is("AUTOINCREMENT"):
+			b.autoincrement = true
+		case tok.is("WITHOUT") && i+1 < len(tokens) && tokens[i+1].is("ROWID"):
+			b.withoutRowid = true
+		}
+	}
+	if b.withoutRowid {
+		return b, true, nil
+	}
+
+	columns, err := columnInfo(db, table)
+	if err != nil {
+		return rowidBehaviour{}, false, err
+	}
+	// A single-column primary key declared exactly as INTEGER aliases the rowid
+	var pk []ColumnInfo
+	for _, col := range columns {
+		if col.PrimaryKey {
+			pk = append(pk, col)
+		}
+	}
+	if len(pk) == 1 && strings.EqualFold(pk[0].Type, "INTEGER") {
+		b.alias = pk[0].Name
+	}
+	return b, true, nil
+}
+
+// rowidChanges describes the differences between before and after that change what
+// the rowids, or ids aliasing them, of copied rows mean.
+func rowidChanges(before, after rowidBehaviour) []string {
+	// Everything else follows from gaining or losing the rowid
+	switch {
+	case before.withoutRowid && !after.withoutRowid:
+		return []string{"it gains a rowid"}
+	case !before.withoutRowid && after.withoutRowid:
+		return []string{"it becomes WITHOUT ROWID, so rowids are lost"}
+	}
+
+	var changes []string
+	switch {
+	case before.alias != "" && after.alias == "":
+		changes = append(changes, fmt.Sprintf("%s is no longer an alias for the rowid, so rowids will be renumbered", before.alias))
+	case before.alias == "" && after.alias != "":
+		changes = append(changes, fmt.Sprintf("%s becomes an alias for the rowid, so it must hold unique integers", after.alias))
+	case !sameName(before.alias, after.alias):
+		changes = append(changes, fmt.Sprintf("the rowid alias changes from %s to %s", before.alias, after.alias))
+	}
+	switch {
+	case !before.autoincrement && after.autoincrement:
+		changes = append(changes, "AUTOINCREMENT is added, so ids of deleted rows are no longer reused")
+	case before.autoincrement && !after.autoincrement:
+		changes = append(changes, "AUTOINCREMENT is removed, so ids of deleted rows may be reused")
+	}
+	return changes
+}
+
+// checkRowidChanges compares the rowid behaviour of oldTable in oldDB with newTable
+// in newDB. Changes are added to opts.Report as warnings, or with opts.StrictRowid
+// returned as an error wrapping ErrRowidChanged.
+func checkRowidChanges(oldDB, newDB queryer, oldTable, newTable string, opts *Options) error {
+	before, ok, err := tableRowidBehaviour(oldDB, oldTable)
+	if err != nil || !ok {
+		return err
+	}
+	after, ok, err := tableRowidBehaviour(newDB, newTable)
+	if err != nil || !ok {
+		return err
+	}
+
+	changes := rowidChanges(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+	if opts.StrictRowid {
+		return fmt.Errorf("%w: %s", ErrRowidChanged, strings.Join(changes, "; "))
+	}
+	if opts.Report != nil {
+		for _, change := range changes {
+			opts.Report.Warnings = append(opts.Report.Warnings, fmt.Sprintf("table %s: %s", newTable, change))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package autosqlite
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRowidChangeWarnings(t *testing.T) {
+	tests := []struct {
+		schema string
+		want   string // Expected warning, or "" for none
+	}{
+		{schemaV2, ""},
+		{`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);`, "AUTOINCREMENT is added"},
+		{`CREATE TABLE users (id INT PRIMARY KEY, name TEXT);`, "id is no longer an alias for the rowid"},
+		{`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT) WITHOUT ROWID;`, "WITHOUT ROWID"},
+	}
+	for _, tt := range tests {
+		dbPath := tempDBPath(t)
+		db, err := Open(schemaV1, dbPath)
+		if err != nil {
+			t.Fatalf("failed to create db: %v", err)
+		}
+		if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		db.Close()
+
+		opts := DefaultOptions()
+		var report MigrationReport
+		opts.Report = &report
+		db, err = MigrateWithOptions(tt.schema, dbPath, opts)
+		if err != nil {
+			t.Fatalf("migration to %s failed: %v", tt.schema, err)
+		}
+		db.Close()
+
+		if tt.want == "" {
+			if len(report.Warnings) != 0 {
+				t.Errorf("%s: expected no warnings, got %v", tt.schema, report.Warnings)
+			}
+			continue
+		}
+		if len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0], tt.want) || !strings.HasPrefix(report.Warnings[0], "table users: ") {
+			t.Errorf("%s: expected a warning about %q, got %v", tt.schema, tt.want, report.Warnings)
+		}
+	}
+
+	// StrictRowid refuses the migration instead
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+	opts := DefaultOptions()
+	opts.StrictRowid = true
+	if _, err := MigrateWithOptions(`CREATE TABLE users (id TEXT PRIMARY KEY, name TEXT);`, dbPath, opts); !errors.Is(err, ErrRowidChanged) {
+		t.Errorf("expected ErrRowidChanged, got %v", err)
+	}
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Errorf("expected the refused migration to leave the database unchanged")
+	}
+}
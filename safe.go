@@ -0,0 +1,164 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrVerificationFailed is returned by SafeMigrate when the migrated database
+// fails one of its checks. The database is left as it was before the migration.
+var ErrVerificationFailed = errors.New("migrated database failed verification")
+
+// promoted is called by SafeMigrate once the migrated database has been moved
+// into place at filename, before it is checked there. It is a variable so that
+// tests can damage the database at that point.
+var promoted = func(filename string) {}
+
+// SafeMigrationReport describes what SafeMigrate did and what its checks found.
+type SafeMigrationReport struct {
+	Result     Result                   // Migrated, or Unchanged if the database already had the schema
+	Migration  MigrationReport          // What the migration did, as Options.Report describes it
+	RowCounts  map[string]RowCountDelta // Rows of each table in the backup and in the migrated database
+	Problems   []string                 // What the checks found wrong with the migrated database
+	RolledBack bool                     // The backup was restored after the migrated database was in place
+}
+
+// SafeMigrate migrates the database at dbPath to schema like MigrateWithOptions,
+// but only puts the migrated database in place once it has passed every check,
+// for production migrations where the cost of checking is worth paying. After
+// the backup is made and the new database built next to the old one, the new
+// database must pass:
+//
+//   - SQLite's full integrity check
+//   - PRAGMA foreign_key_check, whether or not the connection enforces foreign
+//     keys
+//   - VerifyRowCounts against the backup: no table may have lost more rows than
+//     ConflictPolicy or OnConflict skipped on purpose, so a PostMigrate step that
+//     deletes rows fails the check too
+//
+// If any check fails, the new database is removed, the database is left as it
+// was and the error wraps ErrVerificationFailed. Otherwise the new database is
+// renamed into place and checked again there, with a quick check and a
+// comparison with schema; should that fail, the backup is restored, undoing
+// anything written to the database in the meantime, and RolledBack is set.
+//
+// The report is returned even when the migration fails, describing what was
+// done and found up to that point; it is also copied to opts.Report if that is
+// set. SafeMigrate can't be used with Options.RebuildInPlace. A nil opts is the
+// same as DefaultOptions().
+func SafeMigrate(schema, dbPath string, opts *Options) (*sql.DB, *SafeMigrationReport, error) {
+	opts = resolveOptions(opts)
+	report := &SafeMigrationReport{}
+	if opts.RebuildInPlace {
+		return nil, report, errors.New("SafeMigrate is not supported with RebuildInPlace")
+	}
+	withReport := *opts
+	withReport.Report = &report.Migration
+	if opts.Report != nil {
+		defer func() { *opts.Report = report.Migration }()
+	}
+	start := time.Now()
+
+	m, db, err := stageMigration(schema, dbPath, &withReport)
+	if err != nil {
+		return nil, report, err
+	}
+	if m == nil {
+		if err := report.Migration.setVersions(db, -1); err != nil {
+			db.Close()
+			return nil, report, err
+		}
+		report.Result = Unchanged
+		return db, report, nil
+	}
+	defer m.unlock()
+
+	report.Problems, err = verifyMigrated(db, m.backupPath, m.newDbPath, &withReport, report)
+	db.Close()
+	if err != nil {
+		removeDatabaseFiles(m.newDbPath)
+		return nil, report, fmt.Errorf("failed to verify migrated database: %w", err)
+	}
+	if len(report.Problems) > 0 {
+		removeDatabaseFiles(m.newDbPath)
+		return nil, report, fmt.Errorf("%w: %s", ErrVerificationFailed, strings.Join(report.Problems, "; "))
+	}
+
+	db, err = m.commit()
+	if err != nil {
+		return nil, report, err
+	}
+	promoted(m.filename)
+	report.Problems = verifyPromoted(db, schema, dbPath, &withReport)
+	if len(report.Problems) > 0 {
+		db.Close()
+		err := fmt.Errorf("%w once in place: %s", ErrVerificationFailed, strings.Join(report.Problems, "; "))
+		if restoreErr := restoreBackup(m.filename, &withReport); restoreErr != nil {
+			return nil, report, fmt.Errorf("%w, and restoring the backup failed: %v", err, restoreErr)
+		}
+		report.RolledBack = true
+		return nil, report, fmt.Errorf("%w; the backup was restored", err)
+	}
+
+	if err := report.Migration.setVersions(db, m.oldVersion); err != nil {
+		db.Close()
+		return nil, report, err
+	}
+	report.Result = Migrated
+	report.Migration.Stats.Duration = time.Since(start)
+	return db, report, nil
+}
+
+// verifyMigrated runs SafeMigrate's checks on db, the migrated database at
+// newDbPath, comparing its row counts with the backup at backupPath. It
+// returns what is wrong with the database, and sets report.RowCounts.
+func verifyMigrated(db *sql.DB, backupPath, newDbPath string, opts *Options, report *SafeMigrationReport) ([]string, error) {
+	var problems []string
+	// A database too damaged to check fails the check too
+	if err := checkIntegrity(db, false); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	violations, err := foreignKeyViolations(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	for _, v := range violations {
+		problems = append(problems, fmt.Sprintf("%v rows break %s", v.Rows, v))
+	}
+
+	report.RowCounts, err = VerifyRowCountsWithOptions(backupPath, newDbPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	var lost int64
+	var lostTables []string
+	for table, delta := range report.RowCounts {
+		if delta.Lost() {
+			lost += delta.Old - delta.New
+			lostTables = append(lostTables, fmt.Sprintf("table %s lost rows: %s", table, delta))
+		}
+	}
+	if lost > report.Migration.Stats.RowsSkipped {
+		slices.Sort(lostTables)
+		problems = append(problems, lostTables...)
+	}
+	return problems, nil
+}
+
+// verifyPromoted checks db, the migrated database once it is in place at dbPath,
+// and returns what is wrong with it.
+func verifyPromoted(db *sql.DB, schema, dbPath string, opts *Options) []string {
+	var problems []string
+	if err := checkIntegrity(db, true); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if !SchemasEqualWithOptions(schema, dbPath, opts) {
+		problems = append(problems, "the database doesn't have the new schema")
+	}
+	return problems
+}
@@ -0,0 +1,178 @@
+package autosqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// createSafeTestDB creates a database with schemaV1 and two users.
+func createSafeTestDB(t *testing.T) string {
+	t.Helper()
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice'), ('bob')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	return dbPath
+}
+
+func TestSafeMigrate(t *testing.T) {
+	dbPath := createSafeTestDB(t)
+
+	opts := DefaultOptions()
+	opts.Report = &MigrationReport{}
+	db, report, err := SafeMigrate(schemaV2, dbPath, opts)
+	if err != nil {
+		t.Fatalf("SafeMigrate failed: %v", err)
+	}
+	defer db.Close()
+	if report.Result != Migrated || report.RolledBack || len(report.Problems) != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if got := report.RowCounts["users"]; got != (RowCountDelta{Old: 2, New: 2}) {
+		t.Errorf("expected users to keep its 2 rows, got %+v", got)
+	}
+	if opts.Report.Stats.RowsCopied != 2 || report.Migration.Stats.RowsCopied != 2 {
+		t.Errorf("expected the migration report to count 2 rows, got %d and %d", opts.Report.Stats.RowsCopied, report.Migration.Stats.RowsCopied)
+	}
+	if _, err := db.Exec("INSERT INTO users (name, email) VALUES ('carol', 'carol@example.com')"); err != nil {
+		t.Errorf("expected the returned handle to have the new schema: %v", err)
+	}
+	db.Close()
+
+	db, report, err = SafeMigrate(schemaV2, dbPath, nil)
+	if err != nil {
+		t.Fatalf("SafeMigrate failed: %v", err)
+	}
+	db.Close()
+	if report.Result != Unchanged {
+		t.Errorf("expected the database to be unchanged, got %s", report.Result)
+	}
+}
+
+// assertNotMigrated checks that the database at dbPath still has schemaV1, the
+// two users from createSafeTestDB and no leftover migrated copy.
+func assertNotMigrated(t *testing.T, dbPath string) {
+	t.Helper()
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Error("expected the database to keep its old schema")
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 2 {
+		t.Errorf("expected the 2 users to be kept, got %d, %v", count, err)
+	}
+	if _, err := os.Stat(dbPath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected the migrated copy to be removed, got %v", err)
+	}
+}
+
+func TestSafeMigrateForeignKeyViolation(t *testing.T) {
+	dbPath := tempDBPath(t)
+	const before = `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER);`
+	const after = `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id));`
+	db, err := Open(before, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO posts (user_id) VALUES (42)"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	_, report, err := SafeMigrate(after, dbPath, nil)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Fatalf("expected ErrVerificationFailed, got %v", err)
+	}
+	if len(report.Problems) != 1 || !strings.Contains(report.Problems[0], "FOREIGN KEY") {
+		t.Errorf("expected the foreign key violation to be reported, got %v", report.Problems)
+	}
+	if !SchemasEqual(before, dbPath) {
+		t.Error("expected the database to keep its old schema")
+	}
+}
+
+func TestSafeMigrateLostRows(t *testing.T) {
+	dbPath := createSafeTestDB(t)
+
+	opts := DefaultOptions()
+	opts.PostMigrate = []PostMigrateStep{{
+		Name: "delete bob",
+		Run: func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM users WHERE name = 'bob'")
+			return err
+		},
+	}}
+	_, report, err := SafeMigrate(schemaV2, dbPath, opts)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Fatalf("expected ErrVerificationFailed, got %v", err)
+	}
+	if len(report.Problems) != 1 || !strings.Contains(report.Problems[0], "table users lost rows") {
+		t.Errorf("expected the lost row to be reported, got %v", report.Problems)
+	}
+	assertNotMigrated(t, dbPath)
+}
+
+func TestSafeMigrateSkippedRows(t *testing.T) {
+	dbPath := createSafeTestDB(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	// Rows the conflict policy drops on purpose aren't lost
+	opts := DefaultOptions()
+	opts.ConflictPolicy = ConflictIgnore
+	db, report, err := SafeMigrate(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT UNIQUE);`, dbPath, opts)
+	if err != nil {
+		t.Fatalf("SafeMigrate failed: %v", err)
+	}
+	db.Close()
+	if got := report.RowCounts["users"]; got != (RowCountDelta{Old: 3, New: 2}) {
+		t.Errorf("expected users to go from 3 rows to 2, got %+v", got)
+	}
+}
+
+func TestSafeMigrateRollsBack(t *testing.T) {
+	dbPath := createSafeTestDB(t)
+
+	defer func(saved func(string)) { promoted = saved }(promoted)
+	promoted = func(filename string) {
+		// Overwrite everything after the first page
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("failed to read db: %v", err)
+		}
+		copy(data[4096:], bytes.Repeat([]byte{0xff}, len(data)-4096))
+		if err := os.WriteFile(filename, data, 0644); err != nil {
+			t.Fatalf("failed to damage db: %v", err)
+		}
+	}
+
+	_, report, err := SafeMigrate(schemaV2, dbPath, nil)
+	if !errors.Is(err, ErrVerificationFailed) || !strings.Contains(err.Error(), "the backup was restored") {
+		t.Fatalf("expected the migration to fail once in place, got %v", err)
+	}
+	if !report.RolledBack {
+		t.Error("expected the backup to be restored")
+	}
+	assertNotMigrated(t, dbPath)
+}
@@ -0,0 +1,112 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+// carryOverSkippedTables replaces the tables of newDB that are in
+// opts.SkipTables with their definitions from oldDB, along with their indexes
+// and triggers, so that their data can be copied over unchanged. Skipped
+// tables that oldDB doesn't have are left as the new schema defines them.
+func carryOverSkippedTables(oldDB, newDB *sql.DB, opts *Options) error {
+	if len(opts.SkipTables) == 0 {
+		return nil
+	}
+	newTables, err := GetTables(newDB)
+	if err != nil {
+		return fmt.Errorf("failed to get tables from new database: %w", err)
+	}
+
+	for _, skip := range opts.SkipTables {
+		rows, err := oldDB.Query("SELECT type, name, sql FROM sqlite_master WHERE tbl_name=? COLLATE NOCASE AND sql IS NOT NULL ORDER BY type='trigger', type='index', rowid", skip)
+		if err != nil {
+			return fmt.Errorf("failed to read definition of table %s: %w", skip, err)
+		}
+		var stmts []string
+		for rows.Next() {
+			var typ, name, stmt string
+			if err := rows.Scan(&typ, &name, &stmt); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read definition of table %s: %w", skip, err)
+			}
+			stmts = append(stmts, stmt)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read definition of table %s: %w", skip, err)
+		}
+		if len(stmts) == 0 {
+			continue // Not in the old database
+		}
+
+		// Dropping the table drops its indexes and triggers from the new schema too
+		if i := slices.IndexFunc(newTables, func(t string) bool { return sameName(t, skip) }); i != -1 {
+			if _, err := newDB.Exec("DROP TABLE " + newTables[i]); err != nil {
+				return fmt.Errorf("failed to drop table %s from new schema: %w", newTables[i], err)
+			}
+		}
+		for _, stmt := range stmts {
+			if _, err := newDB.Exec(stmt); err != nil {
+				return fmt.Errorf("failed to carry over table %s: %w", skip, err)
+			}
+		}
+	}
+	return nil
+}
+
+// copySkippedTable copies every row of table from oldDB, whose file is oldFile,
+// to newDB. If the table has the same definition in both and oldDB is a file,
+// the rows are copied verbatim by a single INSERT ... SELECT * with oldDB
+// attached; otherwise it falls back to copyTable.
+func copySkippedTable(oldDB, newDB *sql.DB, oldFile, oldTable, newTable string, opts *Options) (int64, error) {
+	var oldSQL, newSQL string
+	if err := oldDB.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name=?", oldTable).Scan(&oldSQL); err != nil {
+		return 0, err
+	}
+	if err := newDB.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name=?", newTable).Scan(&newSQL); err != nil {
+		return 0, err
+	}
+	if oldSQL != newSQL || isMemoryDatabase(oldFile) {
+		copied, _, err := copyTable(oldDB, newDB, oldFile, oldTable, newTable, opts)
+		return copied, err
+	}
+
+	ctx := opts.migrationContext()
+	conn, release, err := attachDatabase(ctx, newDB, oldFile)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	result, err := conn.ExecContext(ctx, fmt.Sprintf("INSERT INTO main.%s SELECT * FROM %s.%s", newTable, attachedName, oldTable))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// missingSkippedTables returns the tables in opts.SkipTables that target has and
+// db doesn't.
+func missingSkippedTables(db, target queryer, opts *Options) ([]string, error) {
+	if len(opts.SkipTables) == 0 {
+		return nil, nil
+	}
+	existing, err := listTables(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables: %w", err)
+	}
+	targetTables, err := listTables(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables from new schema: %w", err)
+	}
+
+	var missing []string
+	for _, table := range targetTables {
+		if opts.skips(table) && !slices.ContainsFunc(existing, func(t string) bool { return sameName(t, table) }) {
+			missing = append(missing, table)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,87 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestSkipTables(t *testing.T) {
+	const oldSchema = `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE events (id INTEGER PRIMARY KEY AUTOINCREMENT, msg TEXT);
+		CREATE INDEX idx_events_msg ON events(msg);`
+	// Changes users, and would change events too if it weren't skipped
+	const newSchema = `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT);
+		CREATE TABLE events (id INTEGER PRIMARY KEY, msg TEXT, level INTEGER NOT NULL);
+		CREATE TABLE audit (id INTEGER PRIMARY KEY, note TEXT);`
+
+	for _, inPlace := range []bool{false, true} {
+		name := "new file"
+		if inPlace {
+			name = "in place"
+		}
+		t.Run(name, func(t *testing.T) {
+			dbPath := tempDBPath(t)
+			db, err := Open(oldSchema, dbPath)
+			if err != nil {
+				t.Fatalf("failed to create db: %v", err)
+			}
+			if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice'); INSERT INTO events (msg) VALUES ('one'), ('two')"); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+			db.Close()
+
+			opts := DefaultOptions()
+			opts.SkipTables = []string{"EVENTS", "audit"}
+			opts.RebuildInPlace = inPlace
+			if SchemasEqualWithOptions(oldSchema+"\nCREATE TABLE events2 (x);", dbPath, opts) {
+				t.Fatal("expected other changes to still be noticed")
+			}
+			if !SchemasEqualWithOptions(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`, dbPath, opts) {
+				t.Fatal("expected skipped tables to be ignored when comparing")
+			}
+
+			var report MigrationReport
+			opts.Report = &report
+			db, err = OpenWithOptions(newSchema, dbPath, opts)
+			if err != nil {
+				t.Fatalf("migration failed: %v", err)
+			}
+			defer db.Close()
+
+			columns, err := GetColumns(db, "users")
+			if err != nil || len(columns) != 3 {
+				t.Errorf("expected users to be migrated, got %v, %v", columns, err)
+			}
+			columns, err = GetColumns(db, "events")
+			if err != nil || len(columns) != 2 {
+				t.Errorf("expected events to keep its definition, got %v, %v", columns, err)
+			}
+			if !objectExists(t, db, "index", "idx_events_msg") {
+				t.Error("expected the index on events to be kept")
+			}
+			if !objectExists(t, db, "table", "audit") {
+				t.Error("expected a skipped table the database lacked to be created")
+			}
+			var count, seq int
+			if err := db.QueryRow("SELECT COUNT(*) FROM events").Scan(&count); err != nil || count != 2 {
+				t.Errorf("expected 2 events, got %d, %v", count, err)
+			}
+			if err := db.QueryRow("SELECT seq FROM sqlite_sequence WHERE name = 'events'").Scan(&seq); err != nil || seq != 2 {
+				t.Errorf("expected the AUTOINCREMENT sequence to be kept, got %d, %v", seq, err)
+			}
+			if !inPlace && report.Stats.RowsCopied != 3 {
+				t.Errorf("expected 3 rows copied, got %d", report.Stats.RowsCopied)
+			}
+		})
+	}
+}
+
+// objectExists reports whether db has a schema object of type typ called name.
+func objectExists(t *testing.T, db *sql.DB, typ, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type=? AND name=?", typ, name).Scan(&n); err != nil {
+		t.Fatalf("failed to query schema: %v", err)
+	}
+	return n > 0
+}
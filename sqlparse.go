@@ -0,0 +1,439 @@
+package autosqlite
+
+import (
+	"slices"
+	"strings"
+)
+
+// tokenKind identifies the kind of a token produced by tokenize.
+type tokenKind int
+
+const (
+	tokWord   tokenKind = iota // Keyword or bare identifier
+	tokIdent                   // Quoted identifier: "x", `x` or [x]
+	tokString                  // String literal: 'x'
+	tokNumber                  // Numeric literal
+	tokPunct                   // Any other single character
+)
+
+// token is a lexical token of an SQL string. Comments and whitespace are dropped.
+type token struct {
+	kind tokenKind
+	text string // Source text, including any quotes
+	pos  int    // Byte offset in the source
+}
+
+// is reports whether t is the keyword kw, compared case-insensitively.
+func (t token) is(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+// name returns the identifier t refers to, with any quoting removed.
+func (t token) name() string {
+	switch t.kind {
+	case tokIdent:
+		if t.text[0] == '[' {
+			return t.text[1 : len(t.text)-1]
+		}
+		q := t.text[:1]
+		return strings.ReplaceAll(t.text[1:len(t.text)-1], q+q, q)
+	case tokString:
+		return strings.ReplaceAll(t.text[1:len(t.text)-1], "''", "'")
+	}
+	return t.text
+}
+
+// tokenize splits sql into tokens. It understands enough of SQLite's lexical
+// rules to find statement boundaries and object names: comments, string
+// literals and quoted identifiers are handled, everything else is split into
+// words, numbers and single punctuation characters. Unterminated quotes or
+// comments run to the end of the input.
+func tokenize(sql string) []token {
+	var tokens []token
+	i := 0
+	for i < len(sql) {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				i = len(sql)
+			} else {
+				i += end + 1
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end == -1 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+		case c == '\'' || c == '"' || c == '`':
+			start := i
+			i++
+			for i < len(sql) {
+				if sql[i] == c {
+					if i+1 < len(sql) && sql[i+1] == c {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+			kind := tokIdent
+			if c == '\'' {
+				kind = tokString
+			}
+			tokens = append(tokens, token{kind, sql[start:i], start})
+		case c == '[':
+			start := i
+			end := strings.IndexByte(sql[i:], ']')
+			if end == -1 {
+				i = len(sql)
+			} else {
+				i += end + 1
+			}
+			tokens = append(tokens, token{tokIdent, sql[start:i], start})
+		case isWordChar(c):
+			start := i
+			for i < len(sql) && isWordChar(sql[i]) {
+				i++
+			}
+			kind := tokWord
+			if c >= '0' && c <= '9' {
+				kind = tokNumber
+				// Keep decimals together, e.g. 1.5
+				if i+1 < len(sql) && sql[i] == '.' && sql[i+1] >= '0' && sql[i+1] <= '9' {
+					i++
+					for i < len(sql) && isWordChar(sql[i]) {
+						i++
+					}
+				}
+			}
+			tokens = append(tokens, token{kind, sql[start:i], start})
+		default:
+			tokens = append(tokens, token{tokPunct, sql[i : i+1], i})
+			i++
+		}
+	}
+	return tokens
+}
+
+// isWordChar reports whether c can be part of a keyword, bare identifier or number.
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// foldName returns name with ASCII letters lower-cased. SQLite matches table and
+// column names case-insensitively for ASCII letters only, so two names refer to
+// the same object exactly when their folded forms are equal.
+func foldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, name)
+}
+
+// quoteIdentifier returns name as an SQL identifier: unchanged if it can be
+// written bare, otherwise in double quotes with any double quotes in it
+// doubled. Names that are SQL keywords, such as order or group, are quoted.
+func quoteIdentifier(name string) string {
+	bare := name != "" && !(name[0] >= '0' && name[0] <= '9') && !sqlKeywords[strings.ToUpper(name)]
+	for i := 0; i < len(name) && bare; i++ {
+		bare = isWordChar(name[i])
+	}
+	if bare {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlKeywords holds SQLite's keywords, which can't all be used as bare identifiers.
+var sqlKeywords = func() map[string]bool {
+	keywords := make(map[string]bool)
+	for _, keyword := range strings.Fields(`
+		ABORT ACTION ADD AFTER ALL ALTER ALWAYS ANALYZE AND AS ASC ATTACH
+		AUTOINCREMENT BEFORE BEGIN BETWEEN BY CASCADE CASE CAST CHECK COLLATE
+		COLUMN COMMIT CONFLICT CONSTRAINT CREATE CROSS CURRENT CURRENT_DATE
+		CURRENT_TIME CURRENT_TIMESTAMP DATABASE DEFAULT DEFERRABLE DEFERRED
+		DELETE DESC DETACH DISTINCT DO DROP EACH ELSE END ESCAPE EXCEPT EXCLUDE
+		EXCLUSIVE EXISTS EXPLAIN FAIL FILTER FIRST FOLLOWING FOR FOREIGN FROM
+		FULL GENERATED GLOB GROUP GROUPS HAVING IF IGNORE IMMEDIATE IN INDEX
+		INDEXED INITIALLY INNER INSERT INSTEAD INTERSECT INTO IS ISNULL JOIN KEY
+		LAST LEFT LIKE LIMIT MATCH MATERIALIZED NATURAL NO NOT NOTHING NOTNULL
+		NULL NULLS OF OFFSET ON OR ORDER OTHERS OUTER OVER PARTITION PLAN PRAGMA
+		PRECEDING PRIMARY QUERY RAISE RANGE RECURSIVE REFERENCES REGEXP REINDEX
+		RELEASE RENAME REPLACE RESTRICT RETURNING RIGHT ROLLBACK ROW ROWS
+		SAVEPOINT SELECT SET TABLE TEMP TEMPORARY THEN TIES TO TRANSACTION
+		TRIGGER UNBOUNDED UNION UNIQUE UPDATE USING VACUUM VALUES VIEW VIRTUAL
+		WHEN WHERE WINDOW WITH WITHOUT`) {
+		keywords[keyword] = true
+	}
+	return keywords
+}()
+
+// sameName reports whether a and b refer to the same table or column.
+func sameName(a, b string) bool {
+	return foldName(a) == foldName(b)
+}
+
+// statement is a single SQL statement found by splitStatements.
+type statement struct {
+	text   string  // Source text without the terminating semicolon
+	offset int     // Byte offset of the statement in the source
+	tokens []token // Tokens of the statement, positions relative to the source
+}
+
+// splitStatements splits sql into individual statements at top-level semicolons.
+// Semicolons inside the BEGIN ... END body of a CREATE TRIGGER do not end the
+// statement. Empty statements are dropped.
+func splitStatements(sql string) []statement {
+	var statements []statement
+	var current []token
+	inBody := false
+	caseDepth := 0
+
+	flush := func(end int) {
+		if len(current) > 0 {
+			start := current[0].pos
+			statements = append(statements, statement{
+				text:   strings.TrimSpace(sql[start:end]),
+				offset: start,
+				tokens: current,
+			})
+		}
+		current = nil
+		inBody = false
+		caseDepth = 0
+	}
+
+	for _, tok := range tokenize(sql) {
+		if tok.kind == tokPunct && tok.text == ";" && !inBody {
+			flush(tok.pos)
+			continue
+		}
+		current = append(current, tok)
+
+		if !inBody {
+			if tok.is("BEGIN") && isCreateTrigger(current) {
+				inBody = true
+			}
+			continue
+		}
+		switch {
+		case tok.is("CASE"):
+			caseDepth++
+		case tok.is("END"):
+			if caseDepth > 0 {
+				caseDepth--
+			} else {
+				inBody = false
+			}
+		}
+	}
+	flush(len(sql))
+	return statements
+}
+
+// isCreateTrigger reports whether tokens start a CREATE [TEMP] TRIGGER statement.
+func isCreateTrigger(tokens []token) bool {
+	typ, _, ok := createdObject(tokens)
+	return ok && typ == "trigger"
+}
+
+// createdObject parses the start of a CREATE statement and returns the type
+// ("table", "index", "view" or "trigger") and name of the object it creates.
+// Virtual tables are reported as "table". ok is false for other statements.
+func createdObject(tokens []token) (typ, name string, ok bool) {
+	i := 0
+	next := func() (token, bool) {
+		if i >= len(tokens) {
+			return token{}, false
+		}
+		i++
+		return tokens[i-1], true
+	}
+
+	tok, more := next()
+	if !more || !tok.is("CREATE") {
+		return "", "", false
+	}
+	for {
+		tok, more = next()
+		if !more {
+			return "", "", false
+		}
+		if tok.is("TEMP") || tok.is("TEMPORARY") || tok.is("UNIQUE") || tok.is("VIRTUAL") {
+			continue
+		}
+		break
+	}
+	switch {
+	case tok.is("TABLE"), tok.is("INDEX"), tok.is("VIEW"), tok.is("TRIGGER"):
+		typ = strings.ToLower(tok.text)
+	default:
+		return "", "", false
+	}
+
+	tok, more = next()
+	if more && tok.is("IF") {
+		// IF NOT EXISTS
+		next()
+		next()
+		tok, more = next()
+	}
+	if !more {
+		return "", "", false
+	}
+	name = tok.name()
+	// schema.name
+	if i+1 < len(tokens) && tokens[i].kind == tokPunct && tokens[i].text == "." {
+		name = tokens[i+1].name()
+	}
+	return typ, name, true
+}
+
+// canonicalSQL returns a normalized form of a single SQL statement for
+// comparison. Comments are removed, whitespace is collapsed, bare words are
+// upper-cased and redundant parentheses around a literal DEFAULT value are
+// dropped, so that for example "default (0)" and "DEFAULT 0" compare equal.
+// IF NOT EXISTS and IF EXISTS after the object type of a CREATE or DROP are
+// removed, since whether SQLite keeps them in sqlite_master depends on the
+// object and its version.
+// Bare words are keywords, type names and unquoted identifiers, all of which
+// SQLite treats case-insensitively. String literals and quoted identifiers are
+// left untouched.
+func canonicalSQL(sql string) string {
+	return strings.Join(canonicalTokens(tokenize(sql)), " ")
+}
+
+// canonicalSQLUnordered is like canonicalSQL but also sorts the comma-separated
+// definitions inside the first pair of parentheses, which for a CREATE TABLE
+// statement are its column definitions and table constraints. Two tables that
+// declare the same columns in a different order therefore compare equal.
+func canonicalSQLUnordered(sql string) string {
+	out := canonicalTokens(tokenize(sql))
+
+	open := slices.Index(out, "(")
+	if open == -1 {
+		return strings.Join(out, " ")
+	}
+
+	var defs []string
+	start := open + 1
+	depth := 0
+	for i := open; i < len(out); i++ {
+		switch out[i] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 1 {
+				defs = append(defs, strings.Join(out[start:i], " "))
+				start = i + 1
+			}
+			continue
+		default:
+			continue
+		}
+		if depth == 0 {
+			defs = append(defs, strings.Join(out[start:i], " "))
+			slices.Sort(defs)
+			head := strings.Join(out[:open+1], " ")
+			tail := strings.Join(out[i:], " ")
+			return head + " " + strings.Join(defs, " , ") + " " + tail
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// canonicalTokens returns the canonical text of each of tokens, as used by canonicalSQL.
+func canonicalTokens(tokens []token) []string {
+	var out []string
+	for i := 0; i < len(tokens); i++ {
+		if n := ifExistsClause(tokens, i); n > 0 {
+			i += n - 1
+			continue
+		}
+		out = append(out, canonicalToken(tokens[i]))
+		if tokens[i].is("DEFAULT") {
+			value, n := defaultLiteral(tokens[i+1:])
+			if n > 0 {
+				out = append(out, value...)
+				i += n
+			}
+		}
+	}
+	return out
+}
+
+// ifExistsClause returns the number of tokens in the IF NOT EXISTS or IF EXISTS
+// clause starting at tokens[i], or 0 if there is none there. The clause only
+// counts straight after TABLE, INDEX, VIEW or TRIGGER, where CREATE and DROP
+// take it.
+func ifExistsClause(tokens []token, i int) int {
+	if i == 0 || !tokens[i].is("IF") {
+		return 0
+	}
+	prev := tokens[i-1]
+	if !prev.is("TABLE") && !prev.is("INDEX") && !prev.is("VIEW") && !prev.is("TRIGGER") {
+		return 0
+	}
+	switch {
+	case i+2 < len(tokens) && tokens[i+1].is("NOT") && tokens[i+2].is("EXISTS"):
+		return 3
+	case i+1 < len(tokens) && tokens[i+1].is("EXISTS"):
+		return 2
+	}
+	return 0
+}
+
+// canonicalToken returns the text of t, upper-cased if it is a bare word.
+func canonicalToken(t token) string {
+	if t.kind == tokWord {
+		return strings.ToUpper(t.text)
+	}
+	return t.text
+}
+
+// defaultLiteral recognizes a DEFAULT value that is a literal wrapped in one or
+// more pairs of parentheses, such as "(0)", "((-1))" or "(CURRENT_TIMESTAMP)".
+// It returns the canonical tokens of the literal without the parentheses and
+// the number of tokens consumed, or 0 if tokens don't start with such a value.
+func defaultLiteral(tokens []token) ([]string, int) {
+	depth := 0
+	for depth < len(tokens) && tokens[depth].kind == tokPunct && tokens[depth].text == "(" {
+		depth++
+	}
+	if depth == 0 {
+		return nil, 0
+	}
+
+	i := depth
+	var value []string
+	if i < len(tokens) && tokens[i].kind == tokPunct && (tokens[i].text == "-" || tokens[i].text == "+") {
+		value = append(value, tokens[i].text)
+		i++
+	}
+	if i >= len(tokens) || tokens[i].kind == tokPunct {
+		return nil, 0
+	}
+	value = append(value, canonicalToken(tokens[i]))
+	i++
+
+	for n := 0; n < depth; n++ {
+		if i >= len(tokens) || tokens[i].kind != tokPunct || tokens[i].text != ")" {
+			return nil, 0
+		}
+		i++
+	}
+	return value, i
+}
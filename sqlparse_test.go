@@ -0,0 +1,36 @@
+package autosqlite
+
+import (
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	schema := `-- users; with a semicolon in a comment
+	CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT DEFAULT 'a;b');
+	/* block; comment */
+	CREATE TRIGGER user_insert AFTER INSERT ON users BEGIN
+	  UPDATE users SET name = CASE WHEN new.name IS NULL THEN 'x' ELSE new.name END WHERE id = new.id;
+	  INSERT INTO users (name) VALUES ('triggered');
+	END;
+	CREATE INDEX "idx;name" ON users(name)`
+
+	statements := splitStatements(schema)
+	if len(statements) != 3 {
+		for _, stmt := range statements {
+			t.Logf("statement: %q", stmt.text)
+		}
+		t.Fatalf("expected 3 statements, got %d", len(statements))
+	}
+
+	want := []struct{ typ, name string }{
+		{"table", "users"},
+		{"trigger", "user_insert"},
+		{"index", "idx;name"},
+	}
+	for i, stmt := range statements {
+		typ, name, ok := createdObject(stmt.tokens)
+		if !ok || typ != want[i].typ || name != want[i].name {
+			t.Fatalf("statement %d: got %s %s, want %s %s", i, typ, name, want[i].typ, want[i].name)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SchemaFromStructs returns a schema with a CREATE TABLE statement for each of
+// models, which are structs or pointers to structs, so that the schema can be
+// kept in Go alongside the types that hold its rows. The result can be passed to
+// Open, or added to a SchemaBuilder with hand-written indexes and triggers.
+//
+// A table is named after its struct type in snake case, so UserProfile becomes
+// user_profile, unless the model has a TableName() string method. Each exported
+// field is a column, also named in snake case; the fields of embedded structs
+// are columns of the table too. Column types follow from the field types:
+//
+//	int, uint and their sized variants, bool    INTEGER
+//	float32, float64                           REAL
+//	string                                     TEXT
+//	[]byte                                     BLOB
+//	time.Time                                  DATETIME
+//
+// Pointers to these and the sql.Null types map to the same types. A field's
+// autosqlite tag holds comma-separated options:
+//
+//	primary_key     part of the primary key
+//	not_null        NOT NULL
+//	unique          UNIQUE
+//	default=VALUE   DEFAULT VALUE, which can't contain a comma
+//	name=NAME       the column name
+//	type=TYPE       the column type, for field types not listed above
+//
+// A tag of "-" leaves the field out. An INTEGER primary_key on its own becomes
+// an alias of the rowid, as in SQL.
+func SchemaFromStructs(models ...interface{}) (string, error) {
+	var statements []string
+	tables := make(map[string]bool)
+	for _, model := range models {
+		table, err := tableFromStruct(model)
+		if err != nil {
+			return "", err
+		}
+		if tables[foldName(table.name)] {
+			return "", fmt.Errorf("table %s is defined by more than one model", table.name)
+		}
+		tables[foldName(table.name)] = true
+		statements = append(statements, table.createSQL())
+	}
+	return strings.Join(statements, "\n"), nil
+}
+
+// structTable is a table described by a model struct.
+type structTable struct {
+	name       string
+	columns    []structColumn
+	primaryKey []string
+}
+
+// structColumn is a column of a structTable.
+type structColumn struct {
+	name, typ   string
+	notNull     bool
+	unique      bool
+	defaultExpr string
+}
+
+// tableFromStruct describes the table for model, for SchemaFromStructs.
+func tableFromStruct(model interface{}) (*structTable, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("model %T is not a struct", model)
+	}
+
+	table := &structTable{name: snakeCase(t.Name())}
+	if named, ok := model.(interface{ TableName() string }); ok {
+		table.name = named.TableName()
+	}
+	if table.name == "" {
+		return nil, fmt.Errorf("model %T has no table name", model)
+	}
+	if err := table.addFields(t); err != nil {
+		return nil, fmt.Errorf("model %T: %w", model, err)
+	}
+	if len(table.columns) == 0 {
+		return nil, fmt.Errorf("model %T has no columns", model)
+	}
+	return table, nil
+}
+
+// addFields adds a column for each exported field of the struct type t, and for
+// the fields of the structs embedded in it.
+func (table *structTable) addFields(t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("autosqlite")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct && columnType(field.Type) == "" {
+			if err := table.addFields(field.Type); err != nil {
+				return err
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		col := structColumn{name: snakeCase(field.Name), typ: columnType(field.Type)}
+		primaryKey := false
+		for _, option := range strings.Split(tag, ",") {
+			key, value, hasValue := strings.Cut(strings.TrimSpace(option), "=")
+			switch {
+			case key == "" && !hasValue:
+			case key == "primary_key" && !hasValue:
+				primaryKey = true
+			case key == "not_null" && !hasValue:
+				col.notNull = true
+			case key == "unique" && !hasValue:
+				col.unique = true
+			case key == "default" && hasValue:
+				col.defaultExpr = value
+			case key == "name" && hasValue && value != "":
+				col.name = value
+			case key == "type" && hasValue && value != "":
+				col.typ = value
+			default:
+				return fmt.Errorf("field %s has unknown autosqlite tag option %q", field.Name, option)
+			}
+		}
+		if col.typ == "" {
+			return fmt.Errorf("field %s has type %s, which has no SQLite column type; give one with the type tag option", field.Name, field.Type)
+		}
+		for _, other := range table.columns {
+			if sameName(other.name, col.name) {
+				return fmt.Errorf("column %s is declared by more than one field", col.name)
+			}
+		}
+		table.columns = append(table.columns, col)
+		if primaryKey {
+			table.primaryKey = append(table.primaryKey, col.name)
+		}
+	}
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// nullTypes maps the sql.Null types to the column type of the value they hold.
+var nullTypes = map[reflect.Type]string{
+	reflect.TypeOf(sql.NullBool{}):    "INTEGER",
+	reflect.TypeOf(sql.NullByte{}):    "INTEGER",
+	reflect.TypeOf(sql.NullInt16{}):   "INTEGER",
+	reflect.TypeOf(sql.NullInt32{}):   "INTEGER",
+	reflect.TypeOf(sql.NullInt64{}):   "INTEGER",
+	reflect.TypeOf(sql.NullFloat64{}): "REAL",
+	reflect.TypeOf(sql.NullString{}):  "TEXT",
+	reflect.TypeOf(sql.NullTime{}):    "DATETIME",
+}
+
+// columnType returns the SQLite column type for a field of type t, or "" if
+// there isn't one.
+func columnType(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if typ, ok := nullTypes[t]; ok {
+		return typ
+	}
+	switch {
+	case t == timeType:
+		return "DATETIME"
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return "BLOB"
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		return "REAL"
+	case reflect.String:
+		return "TEXT"
+	}
+	return ""
+}
+
+// createSQL returns the CREATE TABLE statement for table. Names that need it are
+// quoted, so that a model or field named after an SQL keyword, such as Order or
+// From, still gives a valid statement.
+func (table *structTable) createSQL() string {
+	var defs []string
+	for _, col := range table.columns {
+		def := quoteIdentifier(col.name) + " " + col.typ
+		if len(table.primaryKey) == 1 && table.primaryKey[0] == col.name {
+			def += " PRIMARY KEY"
+		}
+		if col.notNull {
+			def += " NOT NULL"
+		}
+		if col.unique {
+			def += " UNIQUE"
+		}
+		if col.defaultExpr != "" {
+			def += " DEFAULT " + col.defaultExpr
+		}
+		defs = append(defs, def)
+	}
+	if len(table.primaryKey) > 1 {
+		var keys []string
+		for _, key := range table.primaryKey {
+			keys = append(keys, quoteIdentifier(key))
+		}
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n    %s\n);", quoteIdentifier(table.name), strings.Join(defs, ",\n    "))
+}
+
+// snakeCase converts a Go identifier such as UserID or HTTPRequest to snake case,
+// as in user_id and http_request.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// A word starts at an upper-case letter after a lower-case one, or at
+			// the last upper-case letter of an acronym followed by a lower-case one
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
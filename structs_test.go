@@ -0,0 +1,173 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+type structTimestamps struct {
+	CreatedAt time.Time `autosqlite:"not_null"`
+	UpdatedAt *time.Time
+}
+
+type structUser struct {
+	ID       int64  `autosqlite:"primary_key"`
+	Email    string `autosqlite:"not_null,unique"`
+	Name     sql.NullString
+	Active   bool    `autosqlite:"not_null,default=1"`
+	Score    float64 `autosqlite:"name=rating"`
+	Avatar   []byte
+	internal string
+	Ignored  string `autosqlite:"-"`
+	structTimestamps
+}
+
+type structMembership struct {
+	UserID  int64  `autosqlite:"primary_key"`
+	GroupID int64  `autosqlite:"primary_key"`
+	Role    string `autosqlite:"type=TEXT COLLATE NOCASE"`
+}
+
+func (structMembership) TableName() string { return "memberships" }
+
+func TestSchemaFromStructs(t *testing.T) {
+	schema, err := SchemaFromStructs(structUser{}, &structMembership{})
+	if err != nil {
+		t.Fatalf("SchemaFromStructs failed: %v", err)
+	}
+	want := `CREATE TABLE struct_user (
+    id INTEGER PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    name TEXT,
+    active INTEGER NOT NULL DEFAULT 1,
+    rating REAL,
+    avatar BLOB,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME
+);
+CREATE TABLE memberships (
+    user_id INTEGER,
+    group_id INTEGER,
+    role TEXT COLLATE NOCASE,
+    PRIMARY KEY (user_id, group_id)
+);`
+	if schema != want {
+		t.Errorf("unexpected schema:\n%s\nwant:\n%s", schema, want)
+	}
+
+	db, err := Open(schema, tempDBPath(t))
+	if err != nil {
+		t.Fatalf("failed to open db with generated schema: %v", err)
+	}
+	defer db.Close()
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := db.Exec("INSERT INTO struct_user (email, created_at) VALUES (?, ?)", "a@example.com", created); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	var got time.Time
+	var active bool
+	if err := db.QueryRow("SELECT created_at, active FROM struct_user").Scan(&got, &active); err != nil {
+		t.Fatalf("failed to read back: %v", err)
+	}
+	if !got.Equal(created) || !active {
+		t.Errorf("read back created_at %v, active %v", got, active)
+	}
+}
+
+type Order struct {
+	ID    int64  `autosqlite:"primary_key"`
+	Group string `autosqlite:"not_null"`
+	From  string
+	Quote string `autosqlite:"name=say \"hi\""`
+}
+
+func TestSchemaFromStructsKeywords(t *testing.T) {
+	schema, err := SchemaFromStructs(Order{})
+	if err != nil {
+		t.Fatalf("SchemaFromStructs failed: %v", err)
+	}
+	want := `CREATE TABLE "order" (
+    id INTEGER PRIMARY KEY,
+    "group" TEXT NOT NULL,
+    "from" TEXT,
+    "say ""hi""" TEXT
+);`
+	if schema != want {
+		t.Errorf("unexpected schema:\n%s\nwant:\n%s", schema, want)
+	}
+
+	db, err := Open(schema, tempDBPath(t))
+	if err != nil {
+		t.Fatalf("failed to open db with generated schema: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`INSERT INTO "order" ("group", "from", "say ""hi""") VALUES ('a', 'b', 'c')`); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+}
+
+func TestSchemaFromStructsErrors(t *testing.T) {
+	type unsupported struct {
+		Tags []string
+	}
+	type badTag struct {
+		ID int `autosqlite:"primary"`
+	}
+	type duplicate struct {
+		Name  string
+		Other string `autosqlite:"name=NAME"`
+	}
+	type empty struct {
+		hidden int
+	}
+	for name, model := range map[string]interface{}{
+		"not a struct":     42,
+		"unsupported type": unsupported{},
+		"unknown option":   badTag{},
+		"duplicate column": duplicate{},
+		"no columns":       empty{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := SchemaFromStructs(model); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	if _, err := SchemaFromStructs(structUser{}, &structUser{}); err == nil || !strings.Contains(err.Error(), "more than one model") {
+		t.Errorf("expected a duplicate table error, got %v", err)
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	for in, want := range map[string]string{
+		"users":     "users",
+		"user_id":   "user_id",
+		"Order":     `"Order"`,
+		"group":     `"group"`,
+		"2fa":       `"2fa"`,
+		"full name": `"full name"`,
+		`say "hi"`:  `"say ""hi"""`,
+		"":          `""`,
+	} {
+		if got := quoteIdentifier(in); got != want {
+			t.Errorf("quoteIdentifier(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, want := range map[string]string{
+		"User":        "user",
+		"UserID":      "user_id",
+		"HTTPRequest": "http_request",
+		"OAuth2Token": "o_auth2_token",
+		"already":     "already",
+	} {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package autosqlite
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// synchronousDSN returns dsn with the synchronous level of its connections set
+// to level, for building the new database of a migration.
+func synchronousDSN(dsn, level string) (string, error) {
+	switch strings.ToUpper(level) {
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return "", fmt.Errorf("unknown synchronous level %q: must be OFF, NORMAL, FULL or EXTRA", level)
+	}
+	// _sync is the driver's other name for _synchronous
+	return withDSNParam(dsn, "_synchronous", strings.ToUpper(level), "_sync"), nil
+}
+
+// syncDatabaseFiles flushes the database file at path, and its WAL if it has
+// one, to disk, for a database written with a lower synchronous level.
+func syncDatabaseFiles(path string) error {
+	files := []string{path}
+	if hasWAL(path) {
+		files = append(files, path+"-wal")
+	}
+	for _, file := range files {
+		f, err := os.OpenFile(file, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		err = f.Sync()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestMigrateSynchronous(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath+"?_sync=FULL")
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	// The connections copying the data use the option's level
+	opts := DefaultOptions()
+	opts.Synchronous = "off"
+	copyLevel := -1
+	opts.PostMigrate = []PostMigrateStep{{Name: "level", Run: func(tx *sql.Tx) error {
+		return tx.QueryRow("PRAGMA synchronous").Scan(&copyLevel)
+	}}}
+	db, err = MigrateWithOptions(schemaV2, dbPath+"?_sync=FULL", opts)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	defer db.Close()
+	if copyLevel != 0 {
+		t.Errorf("expected synchronous 0 (OFF) during the copy, got %d", copyLevel)
+	}
+
+	// The migrated database keeps the DSN's level and its data
+	var level int
+	if err := db.QueryRow("PRAGMA synchronous").Scan(&level); err != nil || level != 2 {
+		t.Errorf("expected synchronous 2 (FULL) after the migration, got %d, %v", level, err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM users").Scan(&name); err != nil || name != "alice" {
+		t.Errorf("expected alice, got %q, %v", name, err)
+	}
+}
+
+func TestMigrateSynchronousUnknown(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.Synchronous = "SOMETIMES"
+	if _, err := MigrateWithOptions(schemaV2, dbPath, opts); err == nil {
+		t.Fatal("expected an unknown synchronous level to fail the migration")
+	}
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Error("expected the database to be left at its old schema")
+	}
+}
@@ -0,0 +1,38 @@
+package autosqlite
+
+import (
+	"fmt"
+	"time"
+)
+
+// TableTiming records how long a migration took to copy the data of one table,
+// to find the tables that make a migration slow.
+type TableTiming struct {
+	Table    string        // Table in the new schema
+	Start    time.Time     // When copying the table started
+	Duration time.Duration // How long copying it took
+	Rows     int64         // Rows copied
+	Err      error         // Why the table failed to copy, with Options.BestEffort
+}
+
+func (t TableTiming) String() string {
+	if t.Err != nil {
+		return fmt.Sprintf("%s: failed after %v: %v", t.Table, t.Duration, t.Err)
+	}
+	return fmt.Sprintf("%s: %d rows in %v", t.Table, t.Rows, t.Duration)
+}
+
+// tableCopied records the timing of a table copied, or failed to copy, since
+// start, in opts.Report and by calling opts.OnTableCopied.
+func (opts *Options) tableCopied(table string, start time.Time, rows int64, err error) {
+	if opts.Report == nil && opts.OnTableCopied == nil {
+		return
+	}
+	timing := TableTiming{Table: table, Start: start, Duration: time.Since(start), Rows: rows, Err: err}
+	if opts.Report != nil {
+		opts.Report.TableTimings = append(opts.Report.TableTimings, timing)
+	}
+	if opts.OnTableCopied != nil {
+		opts.OnTableCopied(timing)
+	}
+}
@@ -0,0 +1,45 @@
+package autosqlite
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTableTimings(t *testing.T) {
+	for _, parallel := range []int{0, 2} {
+		dbPath := tempDBPath(t)
+		db, err := Open(schemaV1WithPosts, dbPath)
+		if err != nil {
+			t.Fatalf("failed to create db: %v", err)
+		}
+		if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice'), ('bob'); INSERT INTO posts (title) VALUES ('hello')"); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		db.Close()
+
+		opts := DefaultOptions()
+		opts.ParallelTables = parallel
+		var report MigrationReport
+		opts.Report = &report
+		var logged []TableTiming
+		opts.OnTableCopied = func(timing TableTiming) { logged = append(logged, timing) }
+		db, err = MigrateWithOptions(schemaV1WithPosts+" CREATE INDEX idx_posts_title ON posts(title);", dbPath, opts)
+		if err != nil {
+			t.Fatalf("parallel %d: failed to migrate: %v", parallel, err)
+		}
+		db.Close()
+
+		if !slices.Equal(logged, report.TableTimings) {
+			t.Errorf("parallel %d: expected the hook to see the report's timings %v, got %v", parallel, report.TableTimings, logged)
+		}
+		if len(report.TableTimings) != 2 {
+			t.Fatalf("parallel %d: expected timings for 2 tables, got %v", parallel, report.TableTimings)
+		}
+		rows := map[string]int64{"posts": 1, "users": 2}
+		for _, timing := range report.TableTimings {
+			if timing.Rows != rows[timing.Table] || timing.Err != nil || timing.Start.IsZero() || timing.Duration < 0 {
+				t.Errorf("parallel %d: unexpected timing %+v", parallel, timing)
+			}
+		}
+	}
+}
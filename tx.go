@@ -0,0 +1,80 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrForeignKeysEnabled is returned by MigrateTx when foreign key enforcement is
+// on for the transaction's connection.
+var ErrForeignKeysEnabled = errors.New("foreign keys are enabled")
+
+// MigrateTx migrates the database of the open transaction tx to schema, as part
+// of that transaction, so that an application can make its startup all or
+// nothing: the migration takes effect when the application commits tx, and
+// rolling tx back undoes it along with everything else.
+//
+// Only changes made within the database can be part of a transaction. MigrateTx
+// therefore rebuilds the changed tables in place, as with
+// Options.RebuildInPlace, and runs Options.PostMigrate and records the new
+// schema version in tx too. The file operations of Migrate can't be undone by
+// a rollback, so none are done: no backup is made, and the migration lock is
+// not taken, so the application must make sure no other process migrates the
+// database at the same time. The same checks are made as by Migrate, and
+// Unchanged is returned if the database already has schema.
+//
+// Tables can only be dropped and rebuilt safely with foreign key enforcement
+// off, and PRAGMA foreign_keys can't be changed inside a transaction, so if
+// it is on for tx's connection, MigrateTx returns ErrForeignKeysEnabled and
+// leaves tx untouched. If MigrateTx returns any other error, tx may hold part
+// of the migration and must be rolled back.
+func MigrateTx(tx *sql.Tx, schema string) (Result, error) {
+	return MigrateTxWithOptions(tx, schema, nil)
+}
+
+// MigrateTxWithOptions is like MigrateTx but takes Options controlling the
+// migration. A nil opts is the same as DefaultOptions(). Options that concern
+// files, such as BackupMode, TempDir and PreservePrevious, have no effect.
+func MigrateTxWithOptions(tx *sql.Tx, schema string, opts *Options) (Result, error) {
+	opts = resolveOptions(opts)
+	opts.Report.reset()
+	start := time.Now()
+
+	var foreignKeys bool
+	if err := tx.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return 0, fmt.Errorf("failed to read foreign_keys setting: %w", err)
+	}
+	if foreignKeys {
+		return 0, fmt.Errorf("%w: turn them off with PRAGMA foreign_keys = OFF before beginning the transaction", ErrForeignKeysEnabled)
+	}
+
+	baseline, changed, err := checkInPlaceMigration(tx, schema, opts)
+	if err != nil {
+		return 0, err
+	}
+	if !changed {
+		return Unchanged, nil
+	}
+
+	plan, err := planRebuild(tx, schema, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer plan.target.Close()
+
+	// See inRebuildTx
+	if _, err := tx.Exec("PRAGMA legacy_alter_table = ON"); err != nil {
+		return 0, fmt.Errorf("failed to enable legacy_alter_table: %w", err)
+	}
+	defer tx.Exec("PRAGMA legacy_alter_table = OFF")
+
+	if err := plan.apply(tx, schema, baseline, opts); err != nil {
+		return 0, err
+	}
+	if opts.Report != nil {
+		opts.Report.Stats.Duration = time.Since(start)
+	}
+	return Migrated, nil
+}
@@ -0,0 +1,94 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestMigrateTx(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('alice')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.PostMigrate = []PostMigrateStep{{Name: "emails", Run: func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE users SET email = lower(name) || '@example.com'")
+		return err
+	}}}
+	migrate := func() *sql.Tx {
+		t.Helper()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("failed to begin: %v", err)
+		}
+		result, err := MigrateTxWithOptions(tx, schemaV2, opts)
+		if err != nil || result != Migrated {
+			tx.Rollback()
+			t.Fatalf("expected Migrated, got %v, %v", result, err)
+		}
+		if _, err := tx.Exec("INSERT INTO users (name, email) VALUES ('bob', 'bob@example.com')"); err != nil {
+			tx.Rollback()
+			t.Fatalf("failed to use the migrated schema in the transaction: %v", err)
+		}
+		return tx
+	}
+
+	// Rolling back undoes the migration with the rest of the transaction
+	if err := migrate().Rollback(); err != nil {
+		t.Fatalf("failed to roll back: %v", err)
+	}
+	if !SchemasEqual(schemaV1, dbPath) {
+		t.Error("expected the rollback to undo the migration")
+	}
+	if version, err := getCurrentSchemaVersion(db); err != nil || version.Version != 1 {
+		t.Errorf("expected version 1 after the rollback, got %+v, %v", version, err)
+	}
+
+	if err := migrate().Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if !SchemasEqual(schemaV2, dbPath) {
+		t.Error("expected the commit to apply the migration")
+	}
+	if version, err := getCurrentSchemaVersion(db); err != nil || version.Version != 2 {
+		t.Errorf("expected version 2 after the commit, got %+v, %v", version, err)
+	}
+	var email string
+	if err := db.QueryRow("SELECT email FROM users WHERE name = 'alice'").Scan(&email); err != nil || email != "alice@example.com" {
+		t.Errorf("expected the post-migrate step to run, got %q, %v", email, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	defer tx.Rollback()
+	if result, err := MigrateTx(tx, schemaV2); err != nil || result != Unchanged {
+		t.Errorf("expected Unchanged, got %v, %v", result, err)
+	}
+}
+
+func TestMigrateTxForeignKeys(t *testing.T) {
+	dbPath := tempDBPath(t)
+	db, err := Open(schemaV1, dbPath+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := MigrateTx(tx, schemaV2); !errors.Is(err, ErrForeignKeysEnabled) {
+		t.Errorf("expected ErrForeignKeysEnabled, got %v", err)
+	}
+}
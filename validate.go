@@ -0,0 +1,195 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnsupportedStatement is wrapped by the *StatementError for a schema statement
+// that configures the database connection instead of defining part of the schema,
+// such as ATTACH, BEGIN or a PRAGMA other than the persistent ones a schema may
+// declare (see Options). Such statements would only affect the connection that
+// happens to execute the schema, and nothing about them is kept in the
+// database or compared by SchemasEqual.
+var ErrUnsupportedStatement = errors.New("statement not supported in a schema")
+
+// ErrStatementOrder is wrapped by the *StatementError for a schema statement that
+// fails because it needs a table or view that a later statement creates, such as
+// a trigger or index on a table defined further down, for example in a schema
+// fragment added to a SchemaBuilder after the one that uses it. Statements are
+// always executed in the order they are written.
+var ErrStatementOrder = errors.New("statement depends on a later statement")
+
+// SchemaObject describes a table, index, trigger or view defined by a schema.
+type SchemaObject struct {
+	Type  string // "table", "index", "trigger" or "view"
+	Name  string // Name of the object
+	Table string // Table the object belongs to; same as Name for tables and views
+}
+
+// StatementError reports which statement of a schema failed to execute.
+type StatementError struct {
+	Index int    // Index of the statement in the schema, starting at 1
+	Line  int    // Line of the schema the statement starts on, starting at 1
+	SQL   string // Text of the statement
+	Err   error  // Error returned by SQLite
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("statement %d at line %d: %v\n%s", e.Index, e.Line, e.Err, e.SQL)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// locateSchemaError finds out which statement of schema caused err, an error from
+// executing the whole schema, by running the statements one at a time in a
+// scratch database. It returns a *StatementError wrapping err, or err itself if
+// no single statement fails on its own.
+func locateSchemaError(schema string, err error) error {
+	if isBusy(err) {
+		return err // Not caused by the schema
+	}
+	_, verr := ValidateSchema(schema)
+	var serr *StatementError
+	if !errors.As(verr, &serr) {
+		return err
+	}
+	// The ordering advice says more than SQLite's error
+	if !errors.Is(serr.Err, ErrStatementOrder) {
+		serr.Err = err
+	}
+	return serr
+}
+
+// orderError returns an error wrapping ErrStatementOrder if err, the error from
+// executing statements[i], is SQLite complaining about a missing table or view
+// that a later statement creates. Otherwise it returns err.
+func orderError(statements []statement, i int, err error) error {
+	missing, ok := strings.CutPrefix(err.Error(), "no such table: ")
+	if !ok {
+		return err
+	}
+	missing = strings.TrimPrefix(missing, "main.")
+	for j := i + 1; j < len(statements); j++ {
+		typ, name, ok := createdObject(statements[j].tokens)
+		if ok && (typ == "table" || typ == "view") && sameName(name, missing) {
+			return fmt.Errorf("%w: %s %s is created by statement %d; move it before statement %d (%v)", ErrStatementOrder, typ, name, j+1, i+1, err)
+		}
+	}
+	return err
+}
+
+// unsupportedStatements maps the first keyword of statements that can't be part of
+// a schema to advice on what to do instead.
+var unsupportedStatements = map[string]string{
+	"ATTACH":    "ATTACH only applies to the connection that runs it; attach databases after opening",
+	"DETACH":    "DETACH only applies to the connection that runs it",
+	"VACUUM":    "VACUUM is maintenance, not schema; run it after opening the database",
+	"BEGIN":     "the schema is already executed in a transaction",
+	"COMMIT":    "the schema is already executed in a transaction",
+	"END":       "the schema is already executed in a transaction",
+	"ROLLBACK":  "the schema is already executed in a transaction",
+	"SAVEPOINT": "the schema is already executed in a transaction",
+	"RELEASE":   "the schema is already executed in a transaction",
+}
+
+// checkSchemaStatements returns a *StatementError wrapping ErrUnsupportedStatement
+// for the first statement of schema that can't be part of a schema, or nil.
+func checkSchemaStatements(schema string) error {
+	for i, stmt := range splitStatements(schema) {
+		if err := checkStatement(stmt); err != nil {
+			return &StatementError{
+				Index: i + 1,
+				Line:  strings.Count(schema[:stmt.offset], "\n") + 1,
+				SQL:   stmt.text,
+				Err:   err,
+			}
+		}
+	}
+	return nil
+}
+
+// checkStatement returns an error wrapping ErrUnsupportedStatement if stmt can't
+// be part of a schema.
+func checkStatement(stmt statement) error {
+	first := stmt.tokens[0]
+	if first.kind != tokWord {
+		return nil
+	}
+	keyword := strings.ToUpper(first.text)
+	if keyword == "PRAGMA" {
+		_, err := parsePragma(stmt)
+		return err
+	}
+	if advice, ok := unsupportedStatements[keyword]; ok {
+		return fmt.Errorf("%w: %s: %s", ErrUnsupportedStatement, keyword, advice)
+	}
+	return nil
+}
+
+// SplitStatements splits schema into its individual SQL statements, without
+// the terminating semicolons. Semicolons in comments, string literals, quoted
+// identifiers and trigger bodies are handled.
+func SplitStatements(schema string) []string {
+	var texts []string
+	for _, stmt := range splitStatements(schema) {
+		texts = append(texts, stmt.text)
+	}
+	return texts
+}
+
+// ValidateSchema checks schema by executing its statements one at a time, in the
+// order they are written, in a scratch in-memory database, and returns the
+// objects it defines in the order they are created. If a statement fails, or
+// isn't allowed in a schema (see ErrUnsupportedStatement), the error is a
+// *StatementError saying which one. A trigger body or view may refer to a table
+// created later, since SQLite only looks for it when the trigger fires or the
+// view is used, but an index or trigger on a table created later fails with
+// ErrStatementOrder.
+func ValidateSchema(schema string) ([]SchemaObject, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open in-memory database: %w", err)
+	}
+	defer db.Close()
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	statements := splitStatements(schema)
+	for i, stmt := range statements {
+		err := checkStatement(stmt)
+		if err == nil {
+			if _, err = db.Exec(stmt.text); err != nil {
+				err = orderError(statements, i, err)
+			}
+		}
+		if err != nil {
+			return nil, &StatementError{
+				Index: i + 1,
+				Line:  strings.Count(schema[:stmt.offset], "\n") + 1,
+				SQL:   stmt.text,
+				Err:   err,
+			}
+		}
+	}
+
+	rows, err := db.Query("SELECT type, name, tbl_name FROM sqlite_master WHERE type IN ('table','index','trigger','view') AND name NOT LIKE 'sqlite_%' AND name NOT IN (" + shadowTablesQuery + ") ORDER BY rowid")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schema objects: %w", err)
+	}
+	defer rows.Close()
+
+	var objects []SchemaObject
+	for rows.Next() {
+		var obj SchemaObject
+		if err := rows.Scan(&obj.Type, &obj.Name, &obj.Table); err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
@@ -0,0 +1,82 @@
+package autosqlite
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	schema := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+CREATE INDEX idx_users_name ON users(name);
+CREATE VIEW user_names AS SELECT name FROM users;
+CREATE TRIGGER users_insert AFTER INSERT ON users BEGIN
+  UPDATE users SET name = 'x' WHERE id = new.id;
+END;`
+
+	objects, err := ValidateSchema(schema)
+	if err != nil {
+		t.Fatalf("ValidateSchema failed: %v", err)
+	}
+	want := []SchemaObject{
+		{"table", "users", "users"},
+		{"index", "idx_users_name", "users"},
+		{"view", "user_names", "user_names"},
+		{"trigger", "users_insert", "users"},
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("expected %v, got %v", want, objects)
+	}
+
+	bad := `CREATE TABLE users (id INTEGER PRIMARY KEY);
+
+CREATE INDEX idx_missing ON users(missing);`
+	_, err = ValidateSchema(bad)
+	var serr *StatementError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected a StatementError, got %v", err)
+	}
+	if serr.Index != 2 || serr.Line != 3 || serr.SQL != "CREATE INDEX idx_missing ON users(missing)" {
+		t.Errorf("unexpected statement error: %+v", serr)
+	}
+}
+
+func TestSplitStatementsExported(t *testing.T) {
+	got := SplitStatements("CREATE TABLE a (x); -- comment; here\n CREATE TABLE b (y TEXT DEFAULT ';');")
+	want := []string{"CREATE TABLE a (x)", "CREATE TABLE b (y TEXT DEFAULT ';')"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestUnsupportedStatements(t *testing.T) {
+	schema := schemaV1 + "\n-- keep writers out of the way\npragma foreign_keys = ON;"
+
+	_, err := ValidateSchema(schema)
+	var serr *StatementError
+	if !errors.As(err, &serr) || !errors.Is(err, ErrUnsupportedStatement) {
+		t.Fatalf("expected a StatementError wrapping ErrUnsupportedStatement, got %v", err)
+	}
+	if serr.Index != 2 || serr.Line != 3 {
+		t.Errorf("expected statement 2 at line 3, got statement %d at line %d", serr.Index, serr.Line)
+	}
+
+	dbPath := tempDBPath(t)
+	if _, err := Open(schema, dbPath); !errors.Is(err, ErrUnsupportedStatement) {
+		t.Errorf("expected Open to reject the schema, got %v", err)
+	}
+	db, err := Open(schemaV1, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+	if _, err := Open(schemaV2+"\nATTACH 'other.db' AS other;", dbPath); !errors.Is(err, ErrUnsupportedStatement) {
+		t.Errorf("expected a migration to reject the schema, got %v", err)
+	}
+
+	// Keywords inside other statements are fine
+	if _, err := ValidateSchema(`CREATE TABLE pragma_notes (begin TEXT, "commit" TEXT);
+		CREATE TRIGGER t AFTER INSERT ON pragma_notes BEGIN SELECT 1; END;`); err != nil {
+		t.Errorf("expected the schema to be valid, got %v", err)
+	}
+}
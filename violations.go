@@ -0,0 +1,367 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrForeignKeyViolation is returned by a migration when the copied data
+// breaks a FOREIGN KEY constraint of the new schema and the database connection
+// enforces foreign keys, for example with "_foreign_keys=on" in dbPath.
+var ErrForeignKeyViolation = errors.New("foreign key violation")
+
+// ConstraintViolation is a constraint of the new schema that some rows of the
+// existing database would break if they were migrated, as found by
+// ValidateConstraints.
+type ConstraintViolation struct {
+	Table      string   // Table in the new schema
+	Constraint string   // "NOT NULL", "PRIMARY KEY", "UNIQUE", "CHECK" or "FOREIGN KEY"
+	Columns    []string // Columns the constraint is on
+	Detail     string   // The CHECK expression, or the table a FOREIGN KEY refers to
+	Rows       int64    // Number of rows that would break it
+}
+
+func (v ConstraintViolation) String() string {
+	s := fmt.Sprintf("%s: %s", v.Table, v.Constraint)
+	if len(v.Columns) > 0 {
+		s += " (" + strings.Join(v.Columns, ", ") + ")"
+	}
+	if v.Detail != "" {
+		s += " " + v.Detail
+	}
+	return fmt.Sprintf("%s: %d rows", s, v.Rows)
+}
+
+// ValidateConstraints checks the data of the database at dbPath against the
+// NOT NULL, PRIMARY KEY, UNIQUE, CHECK and FOREIGN KEY constraints of schema,
+// without migrating it, and returns every constraint that rows would break,
+// so that the data can be fixed before the migration is attempted. The rows
+// are checked as a migration would copy them: common columns, with NULLs
+// replaced by the DEFAULT of NOT NULL columns, and new columns filled in with
+// their DEFAULT. The database is opened read-only and nothing is copied.
+//
+// The values are checked before the column affinities and collations of the
+// new schema apply to them, so a constraint that depends on those, such as a
+// UNIQUE column that becomes COLLATE NOCASE, may be broken by rows that are
+// not reported. A UNIQUE constraint on an expression is not checked.
+func ValidateConstraints(schema, dbPath string) ([]ConstraintViolation, error) {
+	return ValidateConstraintsWithOptions(schema, dbPath, nil)
+}
+
+// ValidateConstraintsWithOptions is like ValidateConstraints but takes the
+// Options the migration will use: TableRenames and Backfills change the rows
+// that are checked, and SkipTables are not checked. A nil opts is the same as
+// DefaultOptions().
+func ValidateConstraintsWithOptions(schema, dbPath string, opts *Options) ([]ConstraintViolation, error) {
+	opts = resolveOptions(opts)
+
+	db, err := openForComparison(dbPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	target, err := openTemporaryDB()
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+	if err := execSchema(target, schema); err != nil {
+		return nil, fmt.Errorf("failed to execute schema: %w", err)
+	}
+
+	oldTables, err := GetTables(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables from database: %w", err)
+	}
+	newTables, err := GetTables(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables from new schema: %w", err)
+	}
+	sources, err := tableSources(oldTables, newTables, opts.TableRenames)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each table's rows as the migration would insert them, as a subquery on db
+	projections := make(map[string]string)
+	for _, table := range newTables {
+		oldName, ok := sources[table]
+		if !ok || opts.skips(table) {
+			continue
+		}
+		projection, err := projectRows(db, target, oldName, table, opts.backfillsFor(table), opts.matchStrategyFor(table))
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", table, err)
+		}
+		if projection != "" {
+			projections[foldName(table)] = projection
+		}
+	}
+
+	var violations []ConstraintViolation
+	for _, table := range newTables {
+		projection, ok := projections[foldName(table)]
+		if !ok {
+			continue
+		}
+		found, err := tableViolations(db, target, table, projection, projections)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", table, err)
+		}
+		violations = append(violations, found...)
+	}
+	return violations, nil
+}
+
+// projectRows returns a subquery on db that gives the rows of oldTable as a
+// migration would insert them into newTable of target, with a column for each
+// column of newTable. It returns "" if no data would be copied.
+func projectRows(db, target *sql.DB, oldTable, newTable string, backfills map[string]string, matching MatchStrategy) (string, error) {
+	oldColumns, err := GetColumnInfo(db, oldTable)
+	if err != nil {
+		return "", err
+	}
+	newColumns, err := GetColumnInfo(target, newTable)
+	if err != nil {
+		return "", err
+	}
+	commonColumns, sourceColumns, matchedColumns := matchColumns(oldColumns, newColumns, matching)
+	if len(commonColumns) == 0 {
+		return "", nil
+	}
+	backfilled, err := backfillExpressions(db, oldTable, matchedColumns, newColumns, backfills)
+	if err != nil {
+		return "", err
+	}
+
+	common := make(map[string]string)
+	for i, expr := range selectExpressions(commonColumns, sourceColumns, newColumns) {
+		common[commonColumns[i]] = expr
+	}
+	var columns []string
+	for _, col := range newColumns {
+		expr, ok := common[col.Name]
+		if !ok {
+			expr, ok = backfilled[foldName(col.Name)]
+		}
+		switch {
+		case ok:
+			columns = append(columns, expr)
+		case col.DefaultValue.Valid:
+			columns = append(columns, fmt.Sprintf("%s AS %s", col.DefaultValue.String, col.Name))
+		default:
+			columns = append(columns, "NULL AS "+col.Name)
+		}
+	}
+	return fmt.Sprintf("(SELECT %s FROM %s)", strings.Join(columns, ", "), oldTable), nil
+}
+
+// tableViolations returns the constraints of table in target that the rows of
+// projection, a subquery from projectRows, break. projections holds the
+// projections of the other tables by folded name, for checking foreign keys.
+func tableViolations(db, target *sql.DB, table, projection string, projections map[string]string) ([]ConstraintViolation, error) {
+	constraints, err := GetConstraints(target, table)
+	if err != nil {
+		return nil, err
+	}
+	behaviour, _, err := tableRowidBehaviour(target, table)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := GetColumnInfo(target, table)
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []ConstraintViolation
+	check := func(v ConstraintViolation, query string) error {
+		if err := db.QueryRow(query).Scan(&v.Rows); err != nil {
+			return fmt.Errorf("failed to check %s constraint: %w", v.Constraint, err)
+		}
+		if v.Rows > 0 {
+			v.Table = table
+			violations = append(violations, v)
+		}
+		return nil
+	}
+	// Rows that have a value in every one of columns
+	notNull := func(alias string, columns []string) string {
+		conditions := make([]string, len(columns))
+		for i, col := range columns {
+			conditions[i] = alias + col + " IS NOT NULL"
+		}
+		return strings.Join(conditions, " AND ")
+	}
+	// Rows beyond the first with each combination of values in columns
+	duplicates := func(columns []string) string {
+		return fmt.Sprintf("SELECT COALESCE(SUM(n - 1), 0) FROM (SELECT COUNT(*) AS n FROM %s WHERE %s GROUP BY %s HAVING n > 1)",
+			projection, notNull("", columns), strings.Join(columns, ", "))
+	}
+
+	for _, col := range columns {
+		// A NULL rowid alias is given the next rowid, and a WITHOUT ROWID
+		// primary key can't be NULL even if not declared NOT NULL
+		required := col.NotNull || (col.PrimaryKey && behaviour.withoutRowid)
+		if !required || sameName(col.Name, behaviour.alias) {
+			continue
+		}
+		v := ConstraintViolation{Constraint: "NOT NULL", Columns: []string{col.Name}}
+		if err := check(v, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s IS NULL", projection, col.Name)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(constraints.PrimaryKey) > 0 {
+		v := ConstraintViolation{Constraint: "PRIMARY KEY", Columns: constraints.PrimaryKey}
+		if err := check(v, duplicates(constraints.PrimaryKey)); err != nil {
+			return nil, err
+		}
+	}
+	for _, unique := range constraints.Unique {
+		if slices.Contains(unique.Columns, "") {
+			continue // On an expression
+		}
+		v := ConstraintViolation{Constraint: "UNIQUE", Columns: unique.Columns}
+		if err := check(v, duplicates(unique.Columns)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range constraints.Checks {
+		v := ConstraintViolation{Constraint: "CHECK", Detail: c.Expr}
+		if err := check(v, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE NOT (%s)", projection, c.Expr)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, fk := range constraints.ForeignKeys {
+		references := fk.References
+		if slices.Contains(references, "") {
+			parent, err := GetConstraints(target, fk.Table)
+			if err != nil || len(parent.PrimaryKey) != len(fk.Columns) {
+				continue // Not a valid reference, which SQLite reports when it's used
+			}
+			references = parent.PrimaryKey
+		}
+		// A parent table with no data to copy has no rows to refer to
+		exists := "0"
+		if parent, ok := projections[foldName(fk.Table)]; ok {
+			conditions := make([]string, len(fk.Columns))
+			for i, col := range fk.Columns {
+				conditions[i] = fmt.Sprintf("p.%s = c.%s", references[i], col)
+			}
+			exists = fmt.Sprintf("EXISTS (SELECT 1 FROM %s AS p WHERE %s)", parent, strings.Join(conditions, " AND "))
+		}
+		v := ConstraintViolation{Constraint: "FOREIGN KEY", Columns: fk.Columns, Detail: "REFERENCES " + fk.Table}
+		if err := check(v, fmt.Sprintf("SELECT COUNT(*) FROM %s AS c WHERE %s AND NOT %s", projection, notNull("c.", fk.Columns), exists)); err != nil {
+			return nil, err
+		}
+	}
+	return violations, nil
+}
+
+// foreignKeyViolations returns the FOREIGN KEY constraints of db that rows break,
+// one for each constraint, as reported by PRAGMA foreign_key_check.
+func foreignKeyViolations(db queryer) ([]ConstraintViolation, error) {
+	rows, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, err
+	}
+	type constraint struct {
+		table string
+		id    int
+	}
+	var order []constraint
+	counts := make(map[constraint]int64)
+	parents := make(map[constraint]string)
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var id int
+		if err := rows.Scan(&table, &rowid, &parent, &id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c := constraint{table, id}
+		if counts[c] == 0 {
+			order = append(order, c)
+		}
+		counts[c]++
+		parents[c] = parent
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var violations []ConstraintViolation
+	for _, c := range order {
+		columns, err := foreignKeyColumns(db, c.table, c.id)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, ConstraintViolation{
+			Table:      c.table,
+			Constraint: "FOREIGN KEY",
+			Columns:    columns,
+			Detail:     "REFERENCES " + parents[c],
+			Rows:       counts[c],
+		})
+	}
+	return violations, nil
+}
+
+// foreignKeyColumns returns the child columns of the foreign key of table with
+// the given id in PRAGMA foreign_key_list.
+func foreignKeyColumns(db queryer, table string, id int) ([]string, error) {
+	rows, err := db.Query(`SELECT "from" FROM pragma_foreign_key_list(?) WHERE id = ? ORDER BY seq`, table, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// checkMigratedForeignKeys checks the data copied into newDB against its
+// FOREIGN KEY constraints, once PostMigrate steps have had the chance to fix it
+// up. Migrate copies the data into a file opened without the application's
+// connection parameters, so foreign keys aren't enforced and tables can be
+// copied in any order, even tables that refer to each other; this is where
+// broken references are caught instead. If oldDB, opened as the
+// application opens the database, enforces foreign keys, the first violation
+// fails the migration with ErrForeignKeyViolation; otherwise they are all
+// listed in opts.Report, as the application allowed them before.
+func checkMigratedForeignKeys(oldDB, newDB *sql.DB, opts *Options) error {
+	violations, err := foreignKeyViolations(newDB)
+	if err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Err: fmt.Errorf("failed to check foreign keys: %w", err)}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	var enforced bool
+	if err := oldDB.QueryRow("PRAGMA foreign_keys").Scan(&enforced); err != nil {
+		return &MigrationError{Phase: PhaseDataCopy, Err: fmt.Errorf("failed to read foreign_keys setting: %w", err)}
+	}
+	if enforced {
+		v := violations[0]
+		return &MigrationError{Phase: PhaseDataCopy, Table: v.Table, Err: fmt.Errorf("%w: %s", ErrForeignKeyViolation, v)}
+	}
+	if opts.Report != nil {
+		opts.Report.ForeignKeyViolations = violations
+	}
+	return nil
+}
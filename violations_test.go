@@ -0,0 +1,61 @@
+package autosqlite
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestValidateConstraints(t *testing.T) {
+	const oldSchema = `CREATE TABLE teams (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, age INTEGER, team_id INTEGER);
+		CREATE TABLE tags (post INTEGER, tag TEXT);`
+	const newSchema = `CREATE TABLE teams (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL UNIQUE, age INTEGER CHECK (age >= 0),
+			team_id INTEGER REFERENCES teams(id), role TEXT NOT NULL DEFAULT 'member');
+		CREATE TABLE tags (post INTEGER, tag TEXT, PRIMARY KEY (post, tag)) WITHOUT ROWID;`
+
+	dbPath := tempDBPath(t)
+	db, err := Open(oldSchema, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`INSERT INTO teams (id, name) VALUES (1, 'x');
+		INSERT INTO users (id, email, age, team_id) VALUES (1, 'a', 30, 1), (2, 'a', -1, 1), (3, NULL, 20, 9), (4, 'b', 40, NULL);
+		INSERT INTO tags VALUES (1, 'go'), (1, 'go'), (1, 'go'), (2, NULL)`); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	violations, err := ValidateConstraints(newSchema, dbPath)
+	if err != nil {
+		t.Fatalf("ValidateConstraints failed: %v", err)
+	}
+	want := []ConstraintViolation{
+		{Table: "tags", Constraint: "NOT NULL", Columns: []string{"tag"}, Rows: 1},
+		{Table: "tags", Constraint: "PRIMARY KEY", Columns: []string{"post", "tag"}, Rows: 2},
+		{Table: "users", Constraint: "NOT NULL", Columns: []string{"email"}, Rows: 1},
+		{Table: "users", Constraint: "UNIQUE", Columns: []string{"email"}, Rows: 1},
+		{Table: "users", Constraint: "CHECK", Detail: "age >= 0", Rows: 1},
+		{Table: "users", Constraint: "FOREIGN KEY", Columns: []string{"team_id"}, Detail: "REFERENCES teams", Rows: 1},
+	}
+	if !slices.EqualFunc(violations, want, func(a, b ConstraintViolation) bool { return a.String() == b.String() }) {
+		t.Errorf("expected violations\n%v\ngot\n%v", want, violations)
+	}
+	if !SchemasEqual(oldSchema, dbPath) {
+		t.Error("expected the database to be left as it was")
+	}
+
+	// Once the data is fixed there is nothing to report
+	if _, err := db.Exec(`DELETE FROM users WHERE id IN (2, 3); DELETE FROM tags`); err != nil {
+		t.Fatalf("failed to fix data: %v", err)
+	}
+	if violations, err := ValidateConstraints(newSchema, dbPath); err != nil || len(violations) != 0 {
+		t.Errorf("expected no violations, got %v, %v", violations, err)
+	}
+
+	if _, err := ValidateConstraints(newSchema, filepath.Join(t.TempDir(), "missing.db")); !errors.Is(err, ErrDatabaseMissing) {
+		t.Errorf("expected ErrDatabaseMissing, got %v", err)
+	}
+}
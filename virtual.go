@@ -0,0 +1,177 @@
+package autosqlite
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// virtualTable describes a virtual table, as declared by its CREATE VIRTUAL
+// TABLE statement.
+type virtualTable struct {
+	module string    // Module name, lower-cased, e.g. "fts5"
+	args   [][]token // Module arguments, each as its tokens
+}
+
+// parseVirtualTable parses a CREATE VIRTUAL TABLE statement. ok is false if
+// createSQL is any other statement.
+func parseVirtualTable(createSQL string) (vt virtualTable, ok bool) {
+	tokens := tokenize(createSQL)
+	if len(tokens) < 3 || !tokens[0].is("CREATE") || !tokens[1].is("VIRTUAL") {
+		return virtualTable{}, false
+	}
+	using := -1
+	for i, tok := range tokens {
+		if tok.is("USING") {
+			using = i
+			break
+		}
+	}
+	if using == -1 || using+1 >= len(tokens) {
+		return virtualTable{}, false
+	}
+	vt.module = strings.ToLower(tokens[using+1].name())
+
+	// Arguments are separated by top-level commas inside the parentheses
+	rest := tokens[using+2:]
+	if len(rest) == 0 || rest[0].text != "(" {
+		return vt, true
+	}
+	depth := 0
+	var arg []token
+	for _, tok := range rest[1:] {
+		if tok.kind == tokPunct {
+			switch {
+			case tok.text == "(":
+				depth++
+			case tok.text == ")" && depth == 0:
+				if len(arg) > 0 {
+					vt.args = append(vt.args, arg)
+				}
+				return vt, true
+			case tok.text == ")":
+				depth--
+			case tok.text == "," && depth == 0:
+				vt.args = append(vt.args, arg)
+				arg = nil
+				continue
+			}
+		}
+		arg = append(arg, tok)
+	}
+	return vt, true
+}
+
+// getVirtualTable returns the description of table in db if it is a virtual
+// table, or nil if it is an ordinary table.
+func getVirtualTable(db queryer, table string) (*virtualTable, error) {
+	rows, err := db.Query("SELECT sql FROM sqlite_master WHERE type='table' AND name=? COLLATE NOCASE", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var createSQL string
+	if rows.Next() {
+		if err := rows.Scan(&createSQL); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	vt, ok := parseVirtualTable(createSQL)
+	if !ok {
+		return nil, nil
+	}
+	return &vt, nil
+}
+
+// isFullText reports whether vt is an FTS3, FTS4 or FTS5 full-text table.
+func (vt *virtualTable) isFullText() bool {
+	return vt != nil && (vt.module == "fts3" || vt.module == "fts4" || vt.module == "fts5")
+}
+
+// isRtree reports whether vt is an R*Tree table.
+func (vt *virtualTable) isRtree() bool {
+	return vt != nil && (vt.module == "rtree" || vt.module == "rtree_i32")
+}
+
+// rtreeColumns returns the columns of an R*Tree table that make up its index: the
+// id, which is the rowid, followed by the minimum and maximum of each dimension.
+// Auxiliary columns, declared with a leading "+", are left out.
+func (vt *virtualTable) rtreeColumns() []string {
+	var columns []string
+	for _, arg := range vt.args {
+		if len(arg) > 0 && arg[0].text != "+" {
+			columns = append(columns, arg[0].name())
+		}
+	}
+	return columns
+}
+
+// option returns the value of the option name=value among vt's arguments, as
+// FTS tables take them, with any quotes removed. ok is false if there is no
+// such option.
+func (vt *virtualTable) option(name string) (value string, ok bool) {
+	for _, arg := range vt.args {
+		if len(arg) >= 2 && arg[0].is(name) && arg[1].text == "=" {
+			if len(arg) == 2 {
+				return "", true
+			}
+			return arg[2].name(), true
+		}
+	}
+	return "", false
+}
+
+// isContentless reports whether vt is a contentless full-text table, which
+// indexes the values inserted into it without storing them, so that they can't
+// be read back and copied.
+func (vt *virtualTable) isContentless() bool {
+	if !vt.isFullText() {
+		return false
+	}
+	content, ok := vt.option("content")
+	return ok && content == ""
+}
+
+// virtualCopyColumns adjusts the columns that copying oldTable into newTable
+// inserts and the expressions it selects for them, as worked out from their
+// common columns, for when they are virtual tables.
+//
+// A full-text table's rows are identified by their rowid, which isn't one of its
+// columns, so it is copied too; inserting the user-facing columns is all it takes
+// for the new table to build its index. An R*Tree table's id and coordinates are
+// copied by position, since they mean the same whatever they are called, and
+// only if both tables have the same number of dimensions.
+func virtualCopyColumns(oldVirtual, newVirtual *virtualTable, insertColumns, selectColumns []string) ([]string, []string, error) {
+	switch {
+	case oldVirtual.isFullText() && newVirtual.isFullText():
+		insertColumns = append([]string{"rowid"}, insertColumns...)
+		selectColumns = append([]string{"rowid"}, selectColumns...)
+
+	case oldVirtual.isRtree() && newVirtual.isRtree():
+		oldCoords, newCoords := oldVirtual.rtreeColumns(), newVirtual.rtreeColumns()
+		if len(oldCoords) != len(newCoords) {
+			return nil, nil, fmt.Errorf("R*Tree table has %d dimensions but its new definition has %d, so its coordinates can't be copied",
+				(len(oldCoords)-1)/2, (len(newCoords)-1)/2)
+		}
+		// Auxiliary columns are still copied by name
+		var inserts, selects []string
+		for i, col := range insertColumns {
+			if !slices.ContainsFunc(newCoords, func(c string) bool { return sameName(c, col) }) {
+				inserts = append(inserts, col)
+				selects = append(selects, selectColumns[i])
+			}
+		}
+		insertColumns = append(newCoords, inserts...)
+		selectColumns = append(oldCoords, selects...)
+	}
+	return insertColumns, selectColumns, nil
+}
+
+// shadowTablesQuery selects the names of the shadow tables of db's virtual
+// tables: the ordinary tables a virtual table's module creates to store its
+// data, such as the docs_data and docs_idx tables of an FTS5 table docs. They
+// belong to the virtual table, and are never read or written directly.
+const shadowTablesQuery = "SELECT name FROM pragma_table_list WHERE schema='main' AND type='shadow'"
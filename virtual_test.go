@@ -0,0 +1,233 @@
+package autosqlite
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFullTextTables(t *testing.T) {
+	for _, module := range []string{"fts4", "fts5"} {
+		t.Run(module, func(t *testing.T) {
+			oldSchema := "CREATE TABLE notes (id INTEGER PRIMARY KEY, title TEXT);\nCREATE VIRTUAL TABLE docs USING " + module + "(title, body);"
+			newSchema := "CREATE TABLE notes (id INTEGER PRIMARY KEY, title TEXT, pinned INTEGER);\nCREATE VIRTUAL TABLE docs USING " + module + "(title, body, tags);"
+			requireModule(t, module)
+
+			for _, rowByRow := range []bool{false, true} {
+				dbPath := tempDBPath(t)
+				db, err := Open(oldSchema, dbPath)
+				if err != nil {
+					t.Fatalf("failed to create db: %v", err)
+				}
+				if _, err := db.Exec(`INSERT INTO docs (rowid, title, body) VALUES (10, 'sqlite', 'full text search'), (20, 'go', 'a programming language');
+					INSERT INTO notes (title) VALUES ('n')`); err != nil {
+					t.Fatalf("failed to insert: %v", err)
+				}
+				db.Close()
+
+				opts := DefaultOptions()
+				if rowByRow {
+					opts.OnConflict = func(string, map[string]interface{}, error) {}
+				}
+				var report MigrationReport
+				opts.Report = &report
+				db, err = OpenWithOptions(newSchema, dbPath, opts)
+				if err != nil {
+					t.Fatalf("migration failed: %v", err)
+				}
+
+				tables, err := GetTables(db)
+				if err != nil {
+					t.Fatalf("failed to get tables: %v", err)
+				}
+				if !slices.Equal(tables, []string{"docs", "notes"}) {
+					t.Errorf("expected only docs and notes, got %v", tables)
+				}
+				if report.Stats.RowsCopied != 3 {
+					t.Errorf("expected 3 rows copied, got %d", report.Stats.RowsCopied)
+				}
+
+				var rowid int
+				if err := db.QueryRow("SELECT rowid FROM docs WHERE docs MATCH 'programming'").Scan(&rowid); err != nil || rowid != 20 {
+					t.Errorf("expected the index to find row 20, got %d, %v", rowid, err)
+				}
+				var count int
+				if err := db.QueryRow("SELECT COUNT(*) FROM docs WHERE tags IS NULL").Scan(&count); err != nil || count != 2 {
+					t.Errorf("expected 2 rows with no tags, got %d, %v", count, err)
+				}
+				db.Close()
+			}
+		})
+	}
+}
+
+func TestShadowTables(t *testing.T) {
+	for _, module := range []string{"fts4", "fts5"} {
+		t.Run(module, func(t *testing.T) {
+			requireModule(t, module)
+			oldSchema := "CREATE VIRTUAL TABLE docs USING " + module + "(body);"
+			newSchema := "CREATE VIRTUAL TABLE docs USING " + module + "(body, title);\nCREATE TABLE notes (id INTEGER PRIMARY KEY);"
+
+			for _, inPlace := range []bool{false, true} {
+				dbPath := tempDBPath(t)
+				db, err := Open(oldSchema, dbPath)
+				if err != nil {
+					t.Fatalf("failed to create db: %v", err)
+				}
+				if _, err := db.Exec("INSERT INTO docs (rowid, body) VALUES (3, 'three'), (7, 'seven')"); err != nil {
+					t.Fatalf("failed to insert: %v", err)
+				}
+				dump, err := DumpSchema(db)
+				if err != nil {
+					t.Fatalf("failed to dump schema: %v", err)
+				}
+				db.Close()
+				if strings.Contains(dump, "docs_") {
+					t.Errorf("expected no shadow tables in the dump, got %s", dump)
+				}
+				if _, err := ValidateSchema(dump); err != nil {
+					t.Errorf("expected the dump to be a valid schema: %v", err)
+				}
+				if !SchemasEqual(oldSchema, dbPath) {
+					t.Error("expected the database to match its schema")
+				}
+
+				opts := DefaultOptions()
+				opts.RebuildInPlace = inPlace
+				var report MigrationReport
+				opts.Report = &report
+				db, err = OpenWithOptions(newSchema, dbPath, opts)
+				if err != nil {
+					t.Fatalf("migration failed: %v", err)
+				}
+				if report.Stats.RowsCopied != 2 {
+					t.Errorf("expected only the 2 rows of docs to be copied, got %d", report.Stats.RowsCopied)
+				}
+				// The content shadow table holds each row once
+				var count int
+				if err := db.QueryRow("SELECT COUNT(*) FROM docs_content").Scan(&count); err != nil || count != 2 {
+					t.Errorf("expected 2 rows of content, got %d, %v", count, err)
+				}
+				var rowid int
+				if err := db.QueryRow("SELECT rowid FROM docs WHERE docs MATCH 'seven'").Scan(&rowid); err != nil || rowid != 7 {
+					t.Errorf("expected the index to find row 7, got %d, %v", rowid, err)
+				}
+				db.Close()
+				if !SchemasEqual(newSchema, dbPath) {
+					t.Error("expected the migrated database to match the new schema")
+				}
+			}
+		})
+	}
+}
+
+func TestRtreeTables(t *testing.T) {
+	const oldSchema = "CREATE VIRTUAL TABLE places USING rtree(id, minX, maxX, minY, maxY);"
+	// Coordinates are renamed, which doesn't change what they are
+	const newSchema = "CREATE VIRTUAL TABLE places USING rtree(id, x0, x1, y0, y1, +label);"
+
+	for _, inPlace := range []bool{false, true} {
+		dbPath := tempDBPath(t)
+		db, err := Open(oldSchema, dbPath)
+		if err != nil {
+			t.Fatalf("failed to create db: %v", err)
+		}
+		if _, err := db.Exec("INSERT INTO places VALUES (4, 0, 1, 0, 1), (9, 10, 20, 30, 40)"); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		db.Close()
+
+		opts := DefaultOptions()
+		opts.RebuildInPlace = inPlace
+		db, err = OpenWithOptions(newSchema, dbPath, opts)
+		if err != nil {
+			t.Fatalf("migration failed: %v", err)
+		}
+		tables, err := GetTables(db)
+		if err != nil || !slices.Equal(tables, []string{"places"}) {
+			t.Errorf("expected only places, got %v, %v", tables, err)
+		}
+		var id int
+		if err := db.QueryRow("SELECT id FROM places WHERE x0 >= 5 AND y1 <= 50").Scan(&id); err != nil || id != 9 {
+			t.Errorf("expected to find place 9, got %d, %v", id, err)
+		}
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM places_rowid").Scan(&count); err != nil || count != 2 {
+			t.Errorf("expected 2 entries in the index, got %d, %v", count, err)
+		}
+
+		// Adding a dimension leaves nothing to copy into it
+		_, err = OpenWithOptions("CREATE VIRTUAL TABLE places USING rtree(id, x0, x1, y0, y1, z0, z1);", dbPath, opts)
+		if err == nil || !strings.Contains(err.Error(), "2 dimensions but its new definition has 3") {
+			t.Errorf("expected a dimension mismatch error, got %v", err)
+		}
+		db.Close()
+	}
+}
+
+func TestContentlessFullTextTable(t *testing.T) {
+	const schema = "CREATE VIRTUAL TABLE docs USING fts4(content='', body);"
+	dbPath := tempDBPath(t)
+	db, err := Open(schema, dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO docs (docid, body) VALUES (1, 'not stored')"); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	db.Close()
+
+	db, err = Open(strings.Replace(schema, "body", "body, title", 1), dbPath)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM docs WHERE docs MATCH 'stored'").Scan(&count); err != nil || count != 0 {
+		t.Errorf("expected a contentless table to start empty, got %d rows, %v", count, err)
+	}
+}
+
+func TestParseVirtualTable(t *testing.T) {
+	vt, ok := parseVirtualTable(`CREATE VIRTUAL TABLE IF NOT EXISTS "docs" USING FTS5(title, body, content='notes', tokenize = "porter unicode61")`)
+	if !ok {
+		t.Fatal("expected a virtual table")
+	}
+	if vt.module != "fts5" || len(vt.args) != 4 {
+		t.Errorf("expected fts5 with 4 arguments, got %q with %d", vt.module, len(vt.args))
+	}
+	if content, ok := vt.option("content"); !ok || content != "notes" {
+		t.Errorf("expected content=notes, got %q, %v", content, ok)
+	}
+	if tokenizer, ok := vt.option("TOKENIZE"); !ok || tokenizer != "porter unicode61" {
+		t.Errorf("expected the tokenizer, got %q, %v", tokenizer, ok)
+	}
+	if vt.isContentless() {
+		t.Error("expected an external content table not to be contentless")
+	}
+	vt, _ = parseVirtualTable("CREATE VIRTUAL TABLE places USING rtree_i32(id, minX, maxX, +label)")
+	if !vt.isRtree() || !slices.Equal(vt.rtreeColumns(), []string{"id", "minX", "maxX"}) {
+		t.Errorf("expected an rtree with 3 index columns, got %q with %v", vt.module, vt.rtreeColumns())
+	}
+	if _, ok := parseVirtualTable("CREATE TABLE docs (title)"); ok {
+		t.Error("expected an ordinary table not to be a virtual table")
+	}
+}
+
+// requireModule skips the test if SQLite was built without the virtual table
+// module, as FTS5 is unless the sqlite_fts5 build tag is given.
+func requireModule(t *testing.T, module string) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE VIRTUAL TABLE probe USING " + module + "(x)"); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			t.Skipf("SQLite has no %s module", module)
+		}
+		t.Fatalf("failed to create %s table: %v", module, err)
+	}
+}